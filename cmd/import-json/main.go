@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
 
 	"github.com/niazlv/sport-plus-LCT/internal/importer"
 )
@@ -10,12 +11,20 @@ import (
 func main() {
 	// Путь до папки с JSON файлом и изображениями
 	var folderPath string
+	var apiURL string
+	var apiKey string
 	flag.StringVar(&folderPath, "path", ".", "Path to the folder containing main_images.json and images")
+	// "http://sport-plus.sorewa.ru:8080/v1"
+	flag.StringVar(&apiURL, "url", "http://localhost:8080/v1", "Base URL of the API")
+	flag.StringVar(&apiKey, "api-key", os.Getenv("SPORT_PLUS_API_KEY"), "API key with exercise:write and upload:write scopes (default $SPORT_PLUS_API_KEY)")
 	flag.Parse()
 
+	if apiKey == "" {
+		log.Fatal("API key is required: pass -api-key or set SPORT_PLUS_API_KEY")
+	}
+
 	jsonFilePath := folderPath + "/main_images.json"
-	// "http://sport-plus.sorewa.ru:8080/v1"
-	err := importer.ImportExercisesFromJSON(jsonFilePath, "http://localhost:8080/v1")
+	err := importer.ImportExercisesFromJSON(jsonFilePath, apiURL, apiKey)
 	if err != nil {
 		log.Fatal("Error importing courses: ", err)
 	}
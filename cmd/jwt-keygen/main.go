@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Создает новый ключ подписи JWT в JWT_KEYS_DIR. Ротация: создать ключ, перезапустить
+// сервер (подписывать начнет новый ключ), а старый после истечения токенов заменить
+// его публичной частью или удалить.
+func main() {
+	var dir, alg, kid string
+	flag.StringVar(&dir, "dir", "./keys", "Directory with JWT keys (JWT_KEYS_DIR)")
+	flag.StringVar(&alg, "alg", "EdDSA", "Key algorithm: EdDSA or RS256")
+	flag.StringVar(&kid, "kid", time.Now().Format("2006-01-02"), "Key id, used as file name")
+	flag.Parse()
+
+	var private crypto.PrivateKey
+	var err error
+	switch alg {
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		log.Fatalf("unsupported alg %q, use EdDSA or RS256", alg)
+	}
+	if err != nil {
+		log.Fatal("Error generating key: ", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		log.Fatal("Error encoding key: ", err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Fatal(err)
+	}
+	path := filepath.Join(dir, kid+".pem")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Created %s key %s", alg, path)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+)
+
+// Разовая миграция: находит пользователей с паролем в открытом виде и хеширует его bcrypt
+func main() {
+	var dryRun bool
+	flag.BoolVar(&dryRun, "dry-run", false, "Only list users with unhashed passwords, don't change anything")
+	flag.Parse()
+
+	if _, err := database.InitDB(); err != nil {
+		log.Fatal("db can't be init: ", err)
+	}
+
+	users, err := database.FindAllUsers()
+	if err != nil {
+		log.Fatal("Error loading users: ", err)
+	}
+
+	found, migrated := 0, 0
+	for _, user := range users {
+		if user.Password == "" || auth.IsPasswordHashed(user.Password) {
+			continue
+		}
+		found++
+		log.Printf("user %d (%s) has unhashed password", user.Id, user.Login)
+
+		if dryRun {
+			continue
+		}
+
+		hashed, err := auth.HashPassword(user.Password)
+		if err != nil {
+			log.Fatalf("Error hashing password of user %d: %v", user.Id, err)
+		}
+		if err := database.UpdateUserPassword(user.Id, hashed); err != nil {
+			log.Fatalf("Error updating password of user %d: %v", user.Id, err)
+		}
+		migrated++
+	}
+
+	log.Printf("Found %d users with unhashed passwords, migrated %d", found, migrated)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+)
+
+// Назначает роль пользователю напрямую в базе. Нужна, чтобы завести первого
+// администратора: дальше роли меняются через /v1/admin/users
+func main() {
+	var login, roleName string
+	flag.StringVar(&login, "login", "", "User login")
+	flag.StringVar(&roleName, "role", "admin", "Role: client, trainer or admin")
+	flag.Parse()
+
+	role := -1
+	for _, r := range []int{database.RoleClient, database.RoleTrainer, database.RoleAdmin} {
+		if database.RoleName(r) == roleName {
+			role = r
+		}
+	}
+	if login == "" || role < 0 {
+		flag.Usage()
+		log.Fatal("login and valid role are required")
+	}
+
+	if _, err := database.InitDB(); err != nil {
+		log.Fatal("db can't be init: ", err)
+	}
+
+	user, err := database.FindUserByLogin(login)
+	if err != nil {
+		log.Fatal("Error loading user: ", err)
+	}
+	if user == nil {
+		log.Fatalf("user %q not found", login)
+	}
+
+	if err := database.UpdateUserRole(user.Id, role); err != nil {
+		log.Fatal("Error updating role: ", err)
+	}
+	log.Printf("user %d (%s) is now %s", user.Id, user.Login, roleName)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
 	"github.com/niazlv/sport-plus-LCT/internal/api/chat"
 	"github.com/niazlv/sport-plus-LCT/internal/routes"
 	swagger "github.com/num30/gin-swagger-ui"
@@ -16,6 +18,9 @@ func main() {
 	app.Use(gin.Logger())
 	app.Use(cors.Default())
 
+	// juju/errors (Unauthorized, Forbidden, NotFound) отдаем с правильными кодами
+	tonic.SetErrorHook(auth.ErrorHook)
+
 	// Create a new Fizz instance from the Gin engine.
 	f := fizz.NewFromEngine(app)
 
@@ -0,0 +1,196 @@
+// Package activity разбирает файлы тренировок с часов и трекеров (GPX, TCX,
+// Garmin FIT) в трек и считает по нему итоги: дистанцию, время, набор высоты,
+// пульс и темп.
+package activity
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Форматы файлов
+const (
+	FormatGPX = "gpx"
+	FormatTCX = "tcx"
+	FormatFIT = "fit"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown activity file format, expected GPX, TCX or FIT")
+	ErrNoTrack       = errors.New("activity file has no track points with time")
+)
+
+// Point - точка трека. Координат нет у тренировок в зале, высоты и пульса -
+// у многих устройств.
+type Point struct {
+	Time      time.Time `json:"time"`
+	Lat       *float64  `json:"lat,omitempty"`
+	Lon       *float64  `json:"lon,omitempty"`
+	Elevation *float64  `json:"ele,omitempty"` // метры
+	HeartRate int       `json:"hr,omitempty"`
+	// Distance - пройденная дистанция по данным устройства, метры
+	Distance *float64 `json:"dist,omitempty"`
+}
+
+// Activity - разобранная тренировка с итогами
+type Activity struct {
+	Format        string
+	Sport         string
+	Points        []Point
+	StartedAt     time.Time
+	Duration      time.Duration
+	Distance      float64 // метры
+	ElevationGain float64 // метры
+	AvgHeartRate  int
+	MaxHeartRate  int
+	Pace          float64 // секунд на километр
+}
+
+// Parse определяет формат по расширению файла, а если оно незнакомо - по
+// содержимому, и разбирает тренировку
+func Parse(filename string, data []byte) (*Activity, error) {
+	var points []Point
+	var sport string
+	var err error
+
+	format := detectFormat(filename, data)
+	switch format {
+	case FormatGPX:
+		points, sport, err = parseGPX(data)
+	case FormatTCX:
+		points, sport, err = parseTCX(data)
+	case FormatFIT:
+		points, sport, err = parseFIT(data)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Точки без времени для итогов бесполезны
+	timed := points[:0]
+	for _, point := range points {
+		if !point.Time.IsZero() {
+			timed = append(timed, point)
+		}
+	}
+	if len(timed) == 0 {
+		return nil, ErrNoTrack
+	}
+
+	activity := &Activity{Format: format, Sport: sport, Points: timed}
+	activity.summarize()
+	return activity, nil
+}
+
+func detectFormat(filename string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gpx":
+		return FormatGPX
+	case ".tcx":
+		return FormatTCX
+	case ".fit":
+		return FormatFIT
+	}
+
+	if len(data) >= 12 && string(data[8:12]) == ".FIT" {
+		return FormatFIT
+	}
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	switch {
+	case bytes.Contains(head, []byte("<gpx")):
+		return FormatGPX
+	case bytes.Contains(head, []byte("TrainingCenterDatabase")):
+		return FormatTCX
+	}
+	return ""
+}
+
+// Перепады высоты меньше порога считаем шумом GPS и барометра
+const elevationThreshold = 3.0
+
+func (a *Activity) summarize() {
+	first, last := a.Points[0], a.Points[len(a.Points)-1]
+	a.StartedAt = first.Time.UTC()
+	a.Duration = last.Time.Sub(first.Time)
+
+	var deviceDistance, gpsDistance float64
+	var prev *Point
+	var reference *float64
+	var hrSum, hrCount int
+	for i := range a.Points {
+		point := &a.Points[i]
+
+		if point.Distance != nil && *point.Distance > deviceDistance {
+			deviceDistance = *point.Distance
+		}
+		if prev != nil && prev.Lat != nil && point.Lat != nil {
+			gpsDistance += haversine(*prev.Lat, *prev.Lon, *point.Lat, *point.Lon)
+		}
+		if point.Lat != nil {
+			prev = point
+		}
+
+		if point.Elevation != nil {
+			switch {
+			case reference == nil:
+				reference = point.Elevation
+			case *point.Elevation-*reference >= elevationThreshold:
+				a.ElevationGain += *point.Elevation - *reference
+				reference = point.Elevation
+			case *reference-*point.Elevation >= elevationThreshold:
+				reference = point.Elevation
+			}
+		}
+
+		if point.HeartRate > 0 {
+			hrSum += point.HeartRate
+			hrCount++
+			if point.HeartRate > a.MaxHeartRate {
+				a.MaxHeartRate = point.HeartRate
+			}
+		}
+	}
+
+	// Дистанция с устройства точнее: учитывает шагомер и сглаживание трека
+	a.Distance = deviceDistance
+	if a.Distance == 0 {
+		a.Distance = gpsDistance
+	}
+	a.Distance = math.Round(a.Distance)
+	a.ElevationGain = math.Round(a.ElevationGain)
+	if hrCount > 0 {
+		a.AvgHeartRate = int(math.Round(float64(hrSum) / float64(hrCount)))
+	}
+	if a.Distance > 0 {
+		a.Pace = math.Round(a.Duration.Seconds() / (a.Distance / 1000))
+	}
+}
+
+const earthRadius = 6371000.0
+
+// haversine - расстояние между точками по поверхности Земли, метры
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func parseTime(raw string) time.Time {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(raw))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
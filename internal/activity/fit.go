@@ -0,0 +1,232 @@
+package activity
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Минимальный декодер Garmin FIT: читаются только сообщения record (точки
+// трека) и session (вид спорта). Описание формата - FIT SDK, "FIT File Types
+// and Protocol".
+
+const (
+	fitMesgSession = 18
+	fitMesgRecord  = 20
+
+	fitFieldTimestamp = 253
+
+	// Поля record
+	fitRecordLat              = 0
+	fitRecordLon              = 1
+	fitRecordAltitude         = 2
+	fitRecordHeartRate        = 3
+	fitRecordDistance         = 5
+	fitRecordEnhancedAltitude = 78
+
+	// Поле session
+	fitSessionSport = 5
+)
+
+// Время в FIT считается от 1989-12-31 00:00:00 UTC
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+var fitSports = map[uint64]string{
+	1:  "running",
+	2:  "cycling",
+	5:  "swimming",
+	11: "walking",
+	17: "hiking",
+}
+
+var errFITTruncated = errors.New("invalid FIT: file is truncated")
+
+type fitField struct {
+	num      uint8
+	size     int
+	baseType uint8
+}
+
+type fitDefinition struct {
+	global       uint16
+	order        binary.ByteOrder
+	fields       []fitField
+	devFieldSize int
+}
+
+func parseFIT(data []byte) ([]Point, string, error) {
+	if len(data) < 12 || string(data[8:12]) != ".FIT" {
+		return nil, "", errors.New("invalid FIT: bad header")
+	}
+	headerSize := int(data[0])
+	dataSize := int(binary.LittleEndian.Uint32(data[4:8]))
+	if headerSize < 12 || headerSize+dataSize > len(data) {
+		return nil, "", errFITTruncated
+	}
+
+	var points []Point
+	var sport string
+	var lastTimestamp uint32
+	definitions := map[uint8]*fitDefinition{}
+
+	pos, end := headerSize, headerSize+dataSize
+	for pos < end {
+		header := data[pos]
+		pos++
+
+		var local uint8
+		var timestamp uint32
+		compressed := false
+		switch {
+		case header&0x80 != 0:
+			// Сжатый заголовок: младшие 5 бит времени относительно предыдущей метки
+			local = (header >> 5) & 0x03
+			offset := uint32(header & 0x1F)
+			timestamp = lastTimestamp&^0x1F | offset
+			if offset < lastTimestamp&0x1F {
+				timestamp += 0x20
+			}
+			compressed = true
+		case header&0x40 != 0:
+			definition, size, err := parseFITDefinition(data[pos:end], header&0x20 != 0)
+			if err != nil {
+				return nil, "", err
+			}
+			definitions[header&0x0F] = definition
+			pos += size
+			continue
+		default:
+			local = header & 0x0F
+		}
+
+		definition, ok := definitions[local]
+		if !ok {
+			return nil, "", fmt.Errorf("invalid FIT: data message for undefined local type %d", local)
+		}
+
+		values := map[uint8]uint64{}
+		for _, field := range definition.fields {
+			if pos+field.size > end {
+				return nil, "", errFITTruncated
+			}
+			if value, ok := fitValue(data[pos:pos+field.size], field.baseType, definition.order); ok {
+				values[field.num] = value
+			}
+			pos += field.size
+		}
+		pos += definition.devFieldSize
+		if pos > end {
+			return nil, "", errFITTruncated
+		}
+
+		if value, ok := values[fitFieldTimestamp]; ok {
+			timestamp = uint32(value)
+			lastTimestamp = timestamp
+		} else if compressed {
+			lastTimestamp = timestamp
+		}
+
+		switch definition.global {
+		case fitMesgRecord:
+			points = append(points, fitRecordPoint(values, timestamp))
+		case fitMesgSession:
+			if value, ok := values[fitSessionSport]; ok && sport == "" {
+				sport = fitSports[value]
+			}
+		}
+	}
+	return points, sport, nil
+}
+
+// parseFITDefinition разбирает сообщение-определение, возвращает его и длину в байтах
+func parseFITDefinition(data []byte, hasDevFields bool) (*fitDefinition, int, error) {
+	if len(data) < 5 {
+		return nil, 0, errFITTruncated
+	}
+	definition := &fitDefinition{order: binary.LittleEndian}
+	if data[1] == 1 {
+		definition.order = binary.BigEndian
+	}
+	definition.global = definition.order.Uint16(data[2:4])
+
+	count := int(data[4])
+	size := 5 + 3*count
+	if len(data) < size {
+		return nil, 0, errFITTruncated
+	}
+	for i := 0; i < count; i++ {
+		field := data[5+3*i : 8+3*i]
+		definition.fields = append(definition.fields, fitField{num: field[0], size: int(field[1]), baseType: field[2]})
+	}
+
+	if hasDevFields {
+		if len(data) < size+1 {
+			return nil, 0, errFITTruncated
+		}
+		devCount := int(data[size])
+		size += 1 + 3*devCount
+		if len(data) < size {
+			return nil, 0, errFITTruncated
+		}
+		for i := 0; i < devCount; i++ {
+			definition.devFieldSize += int(data[size-3*devCount+3*i+1])
+		}
+	}
+	return definition, size, nil
+}
+
+// fitValue читает целое поле размером 1, 2 или 4 байта. Второе значение false,
+// если поле другого размера или в нем записано "нет данных".
+func fitValue(raw []byte, baseType uint8, order binary.ByteOrder) (uint64, bool) {
+	var value, invalid uint64
+	switch len(raw) {
+	case 1:
+		value, invalid = uint64(raw[0]), 0xFF
+	case 2:
+		value, invalid = uint64(order.Uint16(raw)), 0xFFFF
+	case 4:
+		value, invalid = uint64(order.Uint32(raw)), 0xFFFFFFFF
+	default:
+		return 0, false
+	}
+
+	switch baseType & 0x1F {
+	case 1, 3, 5: // sint8, sint16, sint32: "нет данных" - максимальное положительное
+		invalid >>= 1
+	case 10, 11, 12: // uint8z, uint16z, uint32z: "нет данных" - ноль
+		invalid = 0
+	}
+	return value, value != invalid
+}
+
+func fitRecordPoint(values map[uint8]uint64, timestamp uint32) Point {
+	point := Point{Time: fitEpoch.Add(time.Duration(timestamp) * time.Second)}
+
+	lat, okLat := values[fitRecordLat]
+	lon, okLon := values[fitRecordLon]
+	if okLat && okLon {
+		// Координаты в "полукругах": 2^31 соответствует 180 градусам
+		latDeg := float64(int32(uint32(lat))) * 180 / (1 << 31)
+		lonDeg := float64(int32(uint32(lon))) * 180 / (1 << 31)
+		point.Lat, point.Lon = &latDeg, &lonDeg
+	}
+
+	altitude, ok := values[fitRecordEnhancedAltitude]
+	if !ok {
+		altitude, ok = values[fitRecordAltitude]
+	}
+	if ok {
+		elevation := float64(altitude)/5 - 500
+		point.Elevation = &elevation
+	}
+
+	if distance, ok := values[fitRecordDistance]; ok {
+		meters := float64(distance) / 100
+		point.Distance = &meters
+	}
+	if hr, ok := values[fitRecordHeartRate]; ok {
+		point.HeartRate = int(hr)
+	}
+	return point
+}
@@ -0,0 +1,122 @@
+package activity
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Теги без пространства имен: encoding/xml тогда сопоставляет только локальные
+// имена, и файлы разных устройств (gpxtpx:hr, ns3:TPX...) читаются одинаково.
+
+type gpxFile struct {
+	Tracks []struct {
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []struct {
+				Lat       float64  `xml:"lat,attr"`
+				Lon       float64  `xml:"lon,attr"`
+				Elevation *float64 `xml:"ele"`
+				Time      string   `xml:"time"`
+				HeartRate int      `xml:"extensions>TrackPointExtension>hr"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+func parseGPX(data []byte) ([]Point, string, error) {
+	var file gpxFile
+	if err := decodeXML(data, &file); err != nil {
+		return nil, "", fmt.Errorf("invalid GPX: %w", err)
+	}
+
+	var points []Point
+	var sport string
+	for _, track := range file.Tracks {
+		if sport == "" {
+			sport = strings.ToLower(track.Type)
+		}
+		for _, segment := range track.Segments {
+			for _, trkpt := range segment.Points {
+				lat, lon := trkpt.Lat, trkpt.Lon
+				points = append(points, Point{
+					Time:      parseTime(trkpt.Time),
+					Lat:       &lat,
+					Lon:       &lon,
+					Elevation: trkpt.Elevation,
+					HeartRate: trkpt.HeartRate,
+				})
+			}
+		}
+	}
+	return points, sport, nil
+}
+
+type tcxFile struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Laps  []struct {
+			Tracks []struct {
+				Points []struct {
+					Time     string `xml:"Time"`
+					Position *struct {
+						Lat float64 `xml:"LatitudeDegrees"`
+						Lon float64 `xml:"LongitudeDegrees"`
+					} `xml:"Position"`
+					Altitude  *float64 `xml:"AltitudeMeters"`
+					Distance  *float64 `xml:"DistanceMeters"`
+					HeartRate *struct {
+						Value int `xml:"Value"`
+					} `xml:"HeartRateBpm"`
+				} `xml:"Trackpoint"`
+			} `xml:"Track"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+func parseTCX(data []byte) ([]Point, string, error) {
+	var file tcxFile
+	if err := decodeXML(data, &file); err != nil {
+		return nil, "", fmt.Errorf("invalid TCX: %w", err)
+	}
+
+	var points []Point
+	var sport string
+	for _, activity := range file.Activities {
+		if sport == "" {
+			sport = strings.ToLower(activity.Sport)
+		}
+		for _, lap := range activity.Laps {
+			for _, track := range lap.Tracks {
+				for _, trackpoint := range track.Points {
+					point := Point{
+						Time:      parseTime(trackpoint.Time),
+						Elevation: trackpoint.Altitude,
+						Distance:  trackpoint.Distance,
+					}
+					if trackpoint.Position != nil {
+						lat, lon := trackpoint.Position.Lat, trackpoint.Position.Lon
+						point.Lat, point.Lon = &lat, &lon
+					}
+					if trackpoint.HeartRate != nil {
+						point.HeartRate = trackpoint.HeartRate.Value
+					}
+					points = append(points, point)
+				}
+			}
+		}
+	}
+	return points, sport, nil
+}
+
+func decodeXML(data []byte, v interface{}) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// Некоторые устройства пишут в заголовке кодировку, отличную от UTF-8,
+	// но сами данные в файлах тренировок - ASCII
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return decoder.Decode(v)
+}
@@ -0,0 +1,264 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"github.com/wI2L/fizz"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+func Setup(rg *fizz.RouterGroup) {
+	api := rg.Group("admin", "Admin", "Administration endpoints, admin role only")
+
+	adminOnly := auth.RequireRole(database.RoleAdmin)
+	api.GET("/users", []fizz.OperationOption{fizz.Summary("Search users"), auth.BearerAuth}, auth.WithAuth, adminOnly, tonic.Handler(getUsers, 200))
+	api.GET("/users/:id", []fizz.OperationOption{fizz.Summary("Get user by ID"), auth.BearerAuth}, auth.WithAuth, adminOnly, tonic.Handler(getUser, 200))
+	api.PUT("/users/:id/role", []fizz.OperationOption{fizz.Summary("Change user role"), auth.BearerAuth}, auth.WithAuth, adminOnly, tonic.Handler(putUserRole, 200))
+	api.POST("/users/:id/suspend", []fizz.OperationOption{fizz.Summary("Suspend user and sign out all sessions"), auth.BearerAuth}, auth.WithAuth, adminOnly, tonic.Handler(postSuspendUser, 200))
+	api.POST("/users/:id/unsuspend", []fizz.OperationOption{fizz.Summary("Unsuspend user"), auth.BearerAuth}, auth.WithAuth, adminOnly, tonic.Handler(postUnsuspendUser, 200))
+	api.POST("/users/:id/password-reset", []fizz.OperationOption{fizz.Summary("Force password reset, sends reset link to user email"), auth.BearerAuth}, auth.WithAuth, adminOnly, tonic.Handler(postPasswordReset, 200))
+
+	api.POST("/measurement-types", []fizz.OperationOption{fizz.Summary("Register custom measurement type"), auth.BearerAuth}, auth.WithAuth, adminOnly, tonic.Handler(postMeasurementType, 201))
+	api.PUT("/measurement-types/:name", []fizz.OperationOption{fizz.Summary("Update custom measurement type title, range and aggregation"), auth.BearerAuth}, auth.WithAuth, adminOnly, tonic.Handler(putMeasurementType, 200))
+	api.DELETE("/measurement-types/:name", []fizz.OperationOption{fizz.Summary("Delete custom measurement type without measurements"), auth.BearerAuth}, auth.WithAuth, adminOnly, tonic.Handler(deleteMeasurementType, 200))
+}
+
+// UserOutput - пользователь глазами администратора, без пароля и личных данных профиля
+type UserOutput struct {
+	Id                    int        `json:"id"`
+	Login                 string     `json:"login"`
+	Email                 string     `json:"email"`
+	EmailVerified         bool       `json:"emailVerified"`
+	Name                  string     `json:"name"`
+	Role                  int        `json:"role"`
+	RoleName              string     `json:"roleName"`
+	SuspendedAt           *time.Time `json:"suspendedAt"`
+	SuspendReason         string     `json:"suspendReason"`
+	PasswordResetRequired bool       `json:"passwordResetRequired"`
+}
+
+func newUserOutput(user *database.User) UserOutput {
+	return UserOutput{
+		Id:                    user.Id,
+		Login:                 user.Login,
+		Email:                 user.Email,
+		EmailVerified:         user.EmailVerified,
+		Name:                  user.Name,
+		Role:                  user.Role,
+		RoleName:              database.RoleName(user.Role),
+		SuspendedAt:           user.SuspendedAt,
+		SuspendReason:         user.SuspendReason,
+		PasswordResetRequired: user.PasswordResetRequired,
+	}
+}
+
+type getUsersInput struct {
+	Query     string `query:"q"`         // подстрока логина, имени или почты
+	Role      string `query:"role"`      // 0, 1 или 2
+	Suspended string `query:"suspended"` // true или false
+	Page      int    `query:"page" default:"1"`
+	PerPage   int    `query:"per_page" default:"20"`
+}
+
+type getUsersOutput struct {
+	Users   []UserOutput `json:"users"`
+	Total   int64        `json:"total"`
+	Page    int          `json:"page"`
+	PerPage int          `json:"per_page"`
+}
+
+func getUsers(c *gin.Context, in *getUsersInput) (*getUsersOutput, error) {
+	if in.Page < 1 {
+		in.Page = 1
+	}
+	if in.PerPage < 1 {
+		in.PerPage = defaultPerPage
+	}
+	if in.PerPage > maxPerPage {
+		in.PerPage = maxPerPage
+	}
+
+	filter := database.UserFilter{
+		Query:  in.Query,
+		Offset: (in.Page - 1) * in.PerPage,
+		Limit:  in.PerPage,
+	}
+	if in.Role != "" {
+		role, err := strconv.Atoi(in.Role)
+		if err != nil || database.RoleName(role) == "" {
+			return nil, errors.BadRequestf("invalid role")
+		}
+		filter.Role = &role
+	}
+	if in.Suspended != "" {
+		suspended, err := strconv.ParseBool(in.Suspended)
+		if err != nil {
+			return nil, errors.BadRequestf("invalid suspended")
+		}
+		filter.Suspended = &suspended
+	}
+
+	users, total, err := database.SearchUsers(filter)
+	if err != nil {
+		log.Println("ERROR getUsers(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	out := &getUsersOutput{
+		Users:   make([]UserOutput, 0, len(users)),
+		Total:   total,
+		Page:    in.Page,
+		PerPage: in.PerPage,
+	}
+	for i := range users {
+		out.Users = append(out.Users, newUserOutput(&users[i]))
+	}
+	return out, nil
+}
+
+type userIDInput struct {
+	ID int `path:"id" validate:"required"`
+}
+
+type userOutput struct {
+	User UserOutput `json:"user"`
+}
+
+// findUser находит пользователя по ID из пути
+func findUser(id int) (*database.User, error) {
+	User, err := database.FindUserByID(id)
+	if err != nil {
+		log.Println("ERROR admin findUser(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if User == nil {
+		return nil, errors.NotFoundf("user %d", id)
+	}
+	return User, nil
+}
+
+// checkNotSelf не дает администратору заблокировать или разжаловать самого себя
+func checkNotSelf(c *gin.Context, userID int) error {
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	if current.Id == userID {
+		return errors.BadRequestf("you can't do this with your own account")
+	}
+	return nil
+}
+
+func getUser(c *gin.Context, in *userIDInput) (*userOutput, error) {
+	User, err := findUser(in.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &userOutput{User: newUserOutput(User)}, nil
+}
+
+type putUserRoleInput struct {
+	ID   int `path:"id" validate:"required"`
+	Role int `json:"role"`
+}
+
+func putUserRole(c *gin.Context, in *putUserRoleInput) (*userOutput, error) {
+	if database.RoleName(in.Role) == "" {
+		return nil, errors.BadRequestf("invalid role")
+	}
+	if err := checkNotSelf(c, in.ID); err != nil {
+		return nil, err
+	}
+
+	if err := database.UpdateUserRole(in.ID, in.Role); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.NotFoundf("user %d", in.ID)
+		}
+		log.Println("ERROR putUserRole(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	User, err := findUser(in.ID)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("admin: user %d role changed to %s", User.Id, database.RoleName(User.Role))
+	return &userOutput{User: newUserOutput(User)}, nil
+}
+
+type postSuspendUserInput struct {
+	ID     int    `path:"id" validate:"required"`
+	Reason string `json:"reason"`
+}
+
+func postSuspendUser(c *gin.Context, in *postSuspendUserInput) (*userOutput, error) {
+	if err := checkNotSelf(c, in.ID); err != nil {
+		return nil, err
+	}
+
+	if err := database.SuspendUser(in.ID, in.Reason); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.NotFoundf("user %d", in.ID)
+		}
+		log.Println("ERROR postSuspendUser(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	User, err := findUser(in.ID)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("admin: user %d suspended", User.Id)
+	return &userOutput{User: newUserOutput(User)}, nil
+}
+
+func postUnsuspendUser(c *gin.Context, in *userIDInput) (*userOutput, error) {
+	if err := database.UnsuspendUser(in.ID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.NotFoundf("user %d", in.ID)
+		}
+		log.Println("ERROR postUnsuspendUser(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	User, err := findUser(in.ID)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("admin: user %d unsuspended", User.Id)
+	return &userOutput{User: newUserOutput(User)}, nil
+}
+
+type statusOutput struct {
+	Status string `json:"status"`
+}
+
+func postPasswordReset(c *gin.Context, in *userIDInput) (*statusOutput, error) {
+	User, err := findUser(in.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := auth.ForcePasswordReset(User); err != nil {
+		if errors.IsBadRequest(err) {
+			return nil, err
+		}
+		log.Println("ERROR postPasswordReset(): ", err)
+		return nil, fmt.Errorf("password reset failed")
+	}
+	log.Printf("admin: password reset forced for user %d", User.Id)
+	return &statusOutput{Status: "password reset link sent"}, nil
+}
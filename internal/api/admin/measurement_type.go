@@ -0,0 +1,144 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"gorm.io/gorm"
+)
+
+var measurementTypeName = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+// MeasurementTypeFields - изменяемые поля пользовательского типа замера
+type MeasurementTypeFields struct {
+	Title       string  `json:"title" validate:"required"`
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	Aggregation string  `json:"aggregation" validate:"required"` // last, sum, avg, max или min
+}
+
+func (in *MeasurementTypeFields) validate() error {
+	if in.Min >= in.Max {
+		return errors.BadRequestf("min must be less than max")
+	}
+	if !database.ValidAggregation(in.Aggregation) {
+		return errors.BadRequestf("invalid aggregation: %s", in.Aggregation)
+	}
+	return nil
+}
+
+type postMeasurementTypeInput struct {
+	Name string `json:"name" validate:"required"` // латиница в нижнем регистре, цифры и _
+	Unit string `json:"unit" validate:"required"` // метрическая единица, в ней хранятся замеры
+	MeasurementTypeFields
+}
+
+type measurementTypeOutput struct {
+	Type database.MeasurementType `json:"type"`
+}
+
+func postMeasurementType(c *gin.Context, in *postMeasurementTypeInput) (*measurementTypeOutput, error) {
+	if !measurementTypeName.MatchString(in.Name) {
+		return nil, errors.BadRequestf("name must match %s", measurementTypeName)
+	}
+	if in.Unit == "" {
+		return nil, errors.BadRequestf("unit is required")
+	}
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+
+	existing, err := database.FindMeasurementType(in.Name)
+	if err != nil {
+		log.Println("ERROR postMeasurementType(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if existing != nil {
+		return nil, errors.BadRequestf("measurement type %s already exists", in.Name)
+	}
+
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	created, err := database.CreateMeasurementType(&database.MeasurementType{
+		Name:        in.Name,
+		Title:       in.Title,
+		Unit:        in.Unit,
+		Min:         in.Min,
+		Max:         in.Max,
+		Aggregation: in.Aggregation,
+		CreatedBy:   current.Id,
+	})
+	if err != nil {
+		log.Println("ERROR postMeasurementType(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	log.Printf("admin: measurement type %s registered by user %d", created.Name, current.Id)
+	return &measurementTypeOutput{Type: *created}, nil
+}
+
+type putMeasurementTypeInput struct {
+	Name string `path:"name" validate:"required"`
+	MeasurementTypeFields
+}
+
+func putMeasurementType(c *gin.Context, in *putMeasurementTypeInput) (*measurementTypeOutput, error) {
+	if database.IsBuiltinMeasurementType(in.Name) {
+		return nil, errors.BadRequestf("built-in measurement type can't be changed")
+	}
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+
+	measurementType := &database.MeasurementType{
+		Name:        in.Name,
+		Title:       in.Title,
+		Min:         in.Min,
+		Max:         in.Max,
+		Aggregation: in.Aggregation,
+	}
+	if err := database.UpdateMeasurementType(measurementType); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.NotFoundf("measurement type %s", in.Name)
+		}
+		log.Println("ERROR putMeasurementType(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	updated, err := database.FindMeasurementType(in.Name)
+	if err != nil || updated == nil {
+		log.Println("ERROR putMeasurementType(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &measurementTypeOutput{Type: *updated}, nil
+}
+
+type measurementTypeNameInput struct {
+	Name string `path:"name" validate:"required"`
+}
+
+func deleteMeasurementType(c *gin.Context, in *measurementTypeNameInput) (*statusOutput, error) {
+	if database.IsBuiltinMeasurementType(in.Name) {
+		return nil, errors.BadRequestf("built-in measurement type can't be deleted")
+	}
+
+	deleted, err := database.DeleteMeasurementType(in.Name)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.NotFoundf("measurement type %s", in.Name)
+		}
+		log.Println("ERROR deleteMeasurementType(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if !deleted {
+		return nil, errors.BadRequestf("measurement type %s already has measurements", in.Name)
+	}
+	log.Printf("admin: measurement type %s deleted", in.Name)
+	return &statusOutput{Status: "measurement type deleted"}, nil
+}
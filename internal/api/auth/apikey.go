@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/juju/errors"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	apiKeyPrefix = "spk_"
+)
+
+// apiKeyGroups - группы маршрутов /v1/<group>, доступ к которым можно выдать ключу.
+// auth сюда не входит: ключ не может выпускать другие ключи и менять учетку.
+var apiKeyGroups = []string{"user", "course", "calendar", "upload", "chat", "exercise", "review"}
+
+func validAPIKeyScope(scope string) bool {
+	for _, group := range apiKeyGroups {
+		if scope == group+":read" || scope == group+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredScope определяет нужный ключу scope по маршруту: GET - read, остальное - write
+func requiredScope(c *gin.Context) string {
+	path := strings.TrimPrefix(c.FullPath(), "/v1/")
+	group := strings.SplitN(path, "/", 2)[0]
+	action := "write"
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		action = "read"
+	}
+	return group + ":" + action
+}
+
+// authenticateAPIKey проверяет ключ из X-API-Key и возвращает его владельца
+func authenticateAPIKey(c *gin.Context, rawKey string) (*database.APIKey, *database.User, int, error) {
+	key, err := database.FindAPIKeyByHash(hashToken(rawKey))
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, fmt.Errorf("DATABASE ERROR")
+	}
+	if key == nil || !key.IsActive() {
+		return nil, nil, http.StatusUnauthorized, fmt.Errorf("invalid api key")
+	}
+
+	scope := requiredScope(c)
+	if !key.HasScope(scope) {
+		return nil, nil, http.StatusForbidden, fmt.Errorf("api key has no scope %s", scope)
+	}
+
+	User, err := database.FindUserByID(key.UserID)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, fmt.Errorf("DATABASE ERROR")
+	}
+	if User == nil {
+		return nil, nil, http.StatusUnauthorized, fmt.Errorf("user with this api key not found")
+	}
+	if User.IsSuspended() {
+		return nil, nil, http.StatusForbidden, fmt.Errorf("account is suspended")
+	}
+
+	if err := database.TouchAPIKey(key.Id); err != nil {
+		log.Println("ERROR authenticateAPIKey(): ", err)
+	}
+	return key, User, 0, nil
+}
+
+// withAPIKey - ветка WithAuth для заголовка X-API-Key. Claims собираются в том же
+// виде, что и у JWT, чтобы обработчики не различали способ входа.
+func withAPIKey(c *gin.Context, rawKey string) {
+	key, User, code, err := authenticateAPIKey(c, rawKey)
+	if err != nil {
+		c.JSON(code, gin.H{"error": err.Error()})
+		c.Abort()
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"id":         float64(User.Id),
+		"login":      User.Login,
+		"exp":        float64(time.Now().Add(accessTokenTTL).Unix()),
+		"api_key_id": float64(key.Id),
+	}
+	c.Set("claims", claims)
+	c.Set("userID", User.Id)
+	c.Set("user", User)
+	c.Set("apiKey", key)
+	c.Next()
+}
+
+type apiKeysOutput struct {
+	Keys []database.APIKey `json:"keys"`
+}
+
+func getAPIKeys(c *gin.Context) (*apiKeysOutput, error) {
+	User, err := CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := database.FindAPIKeysByUserID(User.Id)
+	if err != nil {
+		log.Println("ERROR getAPIKeys(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &apiKeysOutput{Keys: keys}, nil
+}
+
+type postAPIKeyInput struct {
+	Name          string   `json:"name" validate:"required"`
+	Scopes        []string `json:"scopes" validate:"required"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 - бессрочный
+}
+
+type postAPIKeyOutput struct {
+	Key    string          `json:"key"` // показывается один раз
+	APIKey database.APIKey `json:"api_key"`
+}
+
+func postAPIKey(c *gin.Context, in *postAPIKeyInput) (*postAPIKeyOutput, error) {
+	User, err := CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	if in.Name == "" {
+		return nil, errors.BadRequestf("name can't be null")
+	}
+	if len(in.Scopes) == 0 {
+		return nil, errors.BadRequestf("at least one scope is required")
+	}
+	for _, scope := range in.Scopes {
+		if !validAPIKeyScope(scope) {
+			return nil, errors.BadRequestf("invalid scope: %s", scope)
+		}
+	}
+	if in.ExpiresInDays < 0 {
+		return nil, errors.BadRequestf("expires_in_days can't be negative")
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	rawKey := apiKeyPrefix + secret
+
+	key := database.APIKey{
+		UserID:  User.Id,
+		Name:    in.Name,
+		Prefix:  rawKey[:len(apiKeyPrefix)+8],
+		KeyHash: hashToken(rawKey),
+		Scopes:  strings.Join(in.Scopes, ","),
+	}
+	if in.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, in.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if _, err := database.CreateAPIKey(&key); err != nil {
+		log.Println("ERROR postAPIKey(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	return &postAPIKeyOutput{Key: rawKey, APIKey: key}, nil
+}
+
+type deleteAPIKeyInput struct {
+	ID int `path:"id" validate:"required"`
+}
+
+func deleteAPIKey(c *gin.Context, in *deleteAPIKeyInput) (*statusOutput, error) {
+	User, err := CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.RevokeAPIKey(in.ID, User.Id); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.NotFoundf("api key")
+		}
+		log.Println("ERROR deleteAPIKey(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &statusOutput{Status: "api key revoked"}, nil
+}
@@ -1,16 +1,17 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/juju/errors"
 	"github.com/loopfz/gadgeto/tonic"
 	"github.com/niazlv/sport-plus-LCT/internal/config"
 	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/mailer"
 	"github.com/wI2L/fizz"
 	"github.com/wI2L/fizz/openapi"
 	"golang.org/x/crypto/bcrypt"
@@ -22,8 +23,6 @@ import (
 
 var db *gorm.DB
 
-var secretKey = []byte("my-secret-key-public")
-
 type UserClaims struct {
 	Exp   int64  `json:"exp"`
 	ID    int    `json:"id"`
@@ -61,28 +60,13 @@ func ExtractClaims(claims jwt.MapClaims) (*UserClaims, error) {
 
 // ExtractClaims извлекает claims из JWT токена
 func ExtractClaimsFromJWT(tokenStr string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
-	})
-	if err != nil || !token.Valid {
-		return nil, errors.New("invalid token")
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, errors.New("cannot extract claims")
-	}
-
-	return claims, nil
+	return ParseToken(tokenStr)
 }
 
 // IsValidToken проверяет валидность токена
 func IsValidToken(tokenStr string) (bool, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
-	})
-	if err != nil || !token.Valid {
-		return false, errors.New("invalid token")
+	if _, err := ParseToken(tokenStr); err != nil {
+		return false, err
 	}
 
 	return true, nil
@@ -93,6 +77,12 @@ func HashPassword(password string) (string, error) {
 	return string(bytes), err
 }
 
+// IsPasswordHashed проверяет, что в поле пароля лежит bcrypt-хеш, а не открытый пароль
+func IsPasswordHashed(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
+
 func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
@@ -110,9 +100,14 @@ func Setup(rg *fizz.RouterGroup) {
 		log.Fatal("Load config error!", err)
 	}
 
-	// reload secretKey from config, if he exist's
-	if cfg.JWTSecret != "" {
-		secretKey = []byte(cfg.JWTSecret)
+	signingKeys, err = loadSigningKeys(cfg)
+	if err != nil {
+		log.Fatal("JWT keys error! ", err)
+	}
+
+	mailSender = mailer.New(cfg)
+	if cfg.PublicURL != "" {
+		publicURL = strings.TrimSuffix(cfg.PublicURL, "/")
 	}
 
 	// Create a sub-group for auth routes
@@ -120,8 +115,31 @@ func Setup(rg *fizz.RouterGroup) {
 
 	// Define routes
 	api.GET("", []fizz.OperationOption{fizz.Summary("Check auth status"), BearerAuth}, WithAuth, tonic.Handler(getGet, 200))
-	api.GET("/signin", []fizz.OperationOption{fizz.Summary("Sign in")}, tonic.Handler(getSignin, 200))
+	api.GET("/signin", []fizz.OperationOption{fizz.Summary("Sign in (deprecated, use POST /auth/signin)"), fizz.Deprecated(true)}, tonic.Handler(getSignin, 200))
+	api.POST("/signin", []fizz.OperationOption{fizz.Summary("Sign in")}, tonic.Handler(postSignin, 200))
 	api.POST("/signup", []fizz.OperationOption{fizz.Summary("Sign up")}, tonic.Handler(postSignup, 200))
+	api.POST("/refresh", []fizz.OperationOption{fizz.Summary("Exchange refresh token for a new token pair")}, tonic.Handler(postRefresh, 200))
+	api.POST("/logout", []fizz.OperationOption{fizz.Summary("Revoke current token"), BearerAuth}, WithAuth, tonic.Handler(postLogout, 200))
+	api.GET("/sessions", []fizz.OperationOption{fizz.Summary("List active sessions (devices)"), BearerAuth}, WithAuth, tonic.Handler(getSessions, 200))
+	api.DELETE("/sessions/:id", []fizz.OperationOption{fizz.Summary("Revoke session, signs the device out"), BearerAuth}, WithAuth, tonic.Handler(deleteSession, 200))
+	api.POST("/password/forgot", []fizz.OperationOption{fizz.Summary("Send password reset link to email")}, tonic.Handler(postPasswordForgot, 200))
+	api.POST("/password/reset", []fizz.OperationOption{fizz.Summary("Set new password by reset token")}, tonic.Handler(postPasswordReset, 200))
+	api.POST("/verify", []fizz.OperationOption{fizz.Summary("Verify email by token")}, tonic.Handler(postVerify, 200))
+	api.POST("/verify/resend", []fizz.OperationOption{fizz.Summary("Send email verification link again"), BearerAuth}, WithAuth, tonic.Handler(postVerifyResend, 200))
+
+	twoFactor := RequireRole(database.RoleTrainer, database.RoleAdmin)
+	api.POST("/2fa/enroll", []fizz.OperationOption{fizz.Summary("Start TOTP enrollment, returns provisioning URI"), BearerAuth}, WithAuth, twoFactor, tonic.Handler(postTwoFactorEnroll, 200))
+	api.POST("/2fa/confirm", []fizz.OperationOption{fizz.Summary("Confirm TOTP enrollment with a code, returns recovery codes"), BearerAuth}, WithAuth, twoFactor, tonic.Handler(postTwoFactorConfirm, 200))
+	api.POST("/2fa/disable", []fizz.OperationOption{fizz.Summary("Disable TOTP two-factor authentication"), BearerAuth}, WithAuth, tonic.Handler(postTwoFactorDisable, 200))
+	api.POST("/2fa/verify", []fizz.OperationOption{fizz.Summary("Exchange challenge token and TOTP code for tokens")}, tonic.Handler(postTwoFactorVerify, 200))
+
+	// Ключи выпускаются только под JWT: сам ключ до /auth не достает, см. apiKeyGroups
+	trainerOrAdmin := RequireRole(database.RoleTrainer, database.RoleAdmin)
+	api.GET("/keys", []fizz.OperationOption{fizz.Summary("List own API keys"), BearerAuth}, WithAuth, trainerOrAdmin, tonic.Handler(getAPIKeys, 200))
+	api.POST("/keys", []fizz.OperationOption{fizz.Summary("Create API key, the key is shown only once"), BearerAuth}, WithAuth, trainerOrAdmin, tonic.Handler(postAPIKey, 201))
+	api.DELETE("/keys/:id", []fizz.OperationOption{fizz.Summary("Revoke API key"), BearerAuth}, WithAuth, trainerOrAdmin, tonic.Handler(deleteAPIKey, 200))
+
+	go cleanupExpiredTokens()
 }
 
 type getGetOutput struct {
@@ -134,50 +152,100 @@ func getGet(c *gin.Context) (*getGetOutput, error) {
 	}, nil
 }
 
-type getSigninOutput struct {
-	Token string         `json:"token"`
-	User  *database.User `json:"user"`
-}
-
 type getSigninInput struct {
 	Login    string `json:"login" query:"login" validate:"required"`
 	Password string `json:"password" query:"password" validate:"required"`
 }
 
-func getSignin(c *gin.Context, in *getSigninInput) (*getSigninOutput, error) {
-	passwd := in.Password
-	login := in.Login
+// getSignin принимает пароль в query, он оседает в логах прокси и истории браузера.
+// Оставлен для старых клиентов, используйте POST /auth/signin. Будет удален.
+func getSignin(c *gin.Context, in *getSigninInput) (*tokenOutput, error) {
+	c.Header("Deprecation", "true")
+	c.Header("Link", `</v1/auth/signin>; rel="successor-version"`)
+
+	return signin(in.Login, in.Password, clientFrom(c))
+}
+
+type postSigninInput struct {
+	Login    string `json:"login" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+func postSignin(c *gin.Context, in *postSigninInput) (*tokenOutput, error) {
+	return signin(in.Login, in.Password, clientFrom(c))
+}
+
+// signin проверяет логин и пароль и выдает токены.
+// Неудачные попытки считаются по логину и по IP, см. lockout.go
+func signin(login string, passwd string, client clientInfo) (*tokenOutput, error) {
+	ip := client.IP
 	if passwd == "" || login == "" {
 		return nil, errors.BadRequestf("login or password can't be null")
 	}
-	log.Println("login: ", login)
-	log.Println("passwd: ", passwd)
+
+	if err := checkLockout(login, ip); err != nil {
+		if _, ok := err.(*LockedError); ok {
+			return nil, err
+		}
+		log.Println("ERROR signin(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
 
 	User, err := database.FindUserByLogin(login)
 	if err != nil {
-		log.Println("ERROR getSignin(): ", err)
+		log.Println("ERROR signin(): ", err)
 		// c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE ERROR"})
 		return nil, fmt.Errorf("DATABASE ERROR")
 	}
 
-	if User.Login == login && (CheckPasswordHash(passwd, User.Password) || passwd == User.Password) {
-		token := createToken(User)
-		return &getSigninOutput{
-			Token: token,
-			User:  User,
-		}, nil
+	if User == nil || User.Login != login {
+		registerFailure(login, ip)
+		return nil, errors.Unauthorizedf("user with this login and password not found!")
+	}
+
+	if IsPasswordHashed(User.Password) {
+		if CheckPasswordHash(passwd, User.Password) {
+			resetLoginFailures(login)
+			return finishSignin(User, client)
+		}
+		registerFailure(login, ip)
+		return nil, errors.Unauthorizedf("user with this login and password not found!")
 	}
 
+	// Старые записи с паролем в открытом виде: сверяем и сразу перехешируем
+	if subtle.ConstantTimeCompare([]byte(passwd), []byte(User.Password)) == 1 {
+		if err := rehashPassword(User, passwd); err != nil {
+			log.Println("ERROR signin(), rehash password: ", err)
+			return nil, fmt.Errorf("Password hashing error!")
+		}
+		resetLoginFailures(login)
+		return finishSignin(User, client)
+	}
+
+	registerFailure(login, ip)
 	return nil, errors.Unauthorizedf("user with this login and password not found!")
 }
 
+func rehashPassword(user *database.User, passwd string) error {
+	hashed, err := HashPassword(passwd)
+	if err != nil {
+		return err
+	}
+	if err := database.UpdateUserPassword(user.Id, hashed); err != nil {
+		return err
+	}
+	user.Password = hashed
+	log.Println("signin(): legacy plaintext password rehashed for user ", user.Id)
+	return nil
+}
+
 type postSignupInput struct {
 	Login    string `json:"login" body:"login" validate:"required"`
 	Password string `json:"password" body:"password" validate:"required"`
-	Role     int    `json:"role" body:"role" default:"0"`
+	Email    string `json:"email" body:"email"`
 }
 
-func postSignup(c *gin.Context, in *postSignupInput) (*getSigninOutput, error) {
+func postSignup(c *gin.Context, in *postSignupInput) (*tokenOutput, error) {
 	if (*in == postSignupInput{}) {
 		return nil, errors.BadRequestf("credencials can't be null")
 	}
@@ -196,6 +264,21 @@ func postSignup(c *gin.Context, in *postSignupInput) (*getSigninOutput, error) {
 	if User != nil && User.Login == in.Login {
 		return nil, errors.BadRequestf("user with this login is already created")
 	}
+
+	if in.Email != "" {
+		if in.Email, err = NormalizeEmail(in.Email); err != nil {
+			return nil, err
+		}
+		User, err = database.FindUserByEmail(in.Email)
+		if err != nil {
+			log.Println("ERROR postSignup(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if User != nil {
+			return nil, errors.BadRequestf("user with this email is already created")
+		}
+	}
+
 	PasswordHashed, err := HashPassword(in.Password)
 
 	if err != nil {
@@ -206,37 +289,32 @@ func postSignup(c *gin.Context, in *postSignupInput) (*getSigninOutput, error) {
 	user := database.User{
 		Login:    in.Login,
 		Password: PasswordHashed,
-		Role:     in.Role, //1 - trainer ,0 - User
+		Email:    in.Email,
+		// Роль от клиента не принимаем: все регистрируются клиентами,
+		// тренером пользователя делает администратор через /admin/users
+		Role: database.RoleClient,
 	}
 
 	_, err = database.CreateUser(&user)
 	if err != nil {
 		return nil, errors.BadRequestf(err.Error())
 	}
-	log.Println("User", user)
-	token := createToken(&user)
-	return &getSigninOutput{
-		User:  &user,
-		Token: token,
-	}, nil
-}
-
-// Создание JWT-токена
-func createToken(user *database.User) string {
-	claims := jwt.MapClaims{
-		"login": user.Login,
-		"id":    user.Id,
-		"exp":   time.Now().Add(time.Hour * 24 * 180).Unix(), // Токен действителен 180 дней(пол года)
+	if user.Email != "" {
+		if err := sendVerificationEmail(&user); err != nil {
+			log.Println("ERROR postSignup(), send verification email: ", err)
+		}
 	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, _ := token.SignedString(secretKey)
-
-	return signedToken
+	return issueTokens(&user, clientFrom(c))
 }
 
 // Middleware для проверки токена
 func WithAuth(c *gin.Context) {
+	// Сервисные клиенты (импорт, интеграции) приходят с ключом вместо JWT
+	if apiKey := c.GetHeader(apiKeyHeader); apiKey != "" {
+		withAPIKey(c, apiKey)
+		return
+	}
+
 	tokenString := c.GetHeader("Authorization")
 	if tokenString == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorizated"})
@@ -254,27 +332,41 @@ func WithAuth(c *gin.Context) {
 	// Извлекаем сам токен
 	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
-	})
-	if err != nil || !token.Valid {
+	claims, err := ParseToken(tokenString)
+	if err == errTokenRevoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "token is revoked"})
+		c.Abort()
+		return
+	}
+	if err == errInvalidToken {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "token is expired"})
 		c.Abort()
 		return
 	}
+	if err != nil {
+		log.Println("ERROR WithAuth(): ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE ERROR"})
+		c.Abort()
+		return
+	}
 
 	// достаем значения
-	claims := token.Claims.(jwt.MapClaims)
-	log.Println(claims)
 	User, err := database.FindUserByID(int(claims["id"].(float64)))
-	log.Println(User)
 	if err != nil {
 		log.Println("ERROR WithAuth(): ", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE ERROR"})
+		c.Abort()
+		return
+	}
+	if User != nil && User.IsSuspended() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is suspended"})
+		c.Abort()
+		return
 	}
 	if User != nil {
 		c.Set("claims", claims)
 		c.Set("userID", int(claims["id"].(float64)))
+		c.Set("user", User)
 		c.Next()
 		return
 	}
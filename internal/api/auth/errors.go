@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+)
+
+// ErrorHook переводит ошибки juju/errors в HTTP коды, остальное отдаем как раньше
+func ErrorHook(c *gin.Context, e error) (int, interface{}) {
+	code, body := http.StatusBadRequest, gin.H{"error": e.Error()}
+	if locked, ok := e.(*LockedError); ok {
+		c.Header("Retry-After", strconv.Itoa(locked.retryAfterSeconds()))
+		return http.StatusTooManyRequests, body
+	}
+	switch {
+	case errors.IsUnauthorized(e):
+		code = http.StatusUnauthorized
+	case errors.IsForbidden(e):
+		code = http.StatusForbidden
+	case errors.IsNotFound(e):
+		code = http.StatusNotFound
+	case errors.IsAlreadyExists(e):
+		code = http.StatusConflict
+	}
+	return code, body
+}
+
+// AbortWithError отдает ошибку так же, как tonic, для обработчиков без tonic
+// (выгрузка файлов)
+func AbortWithError(c *gin.Context, e error) {
+	code, body := ErrorHook(c, e)
+	c.AbortWithStatusJSON(code, body)
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+	"github.com/niazlv/sport-plus-LCT/internal/jwtkeys"
+	"github.com/wI2L/fizz"
+)
+
+// defaultSecrets - секреты из кода и примеров конфигурации, вне dev с ними не стартуем
+var defaultSecrets = []string{"", "my-secret-key-public", "my-super-secret-key"}
+
+// signingKeys подписывают и проверяют все токены: доступа и токены-вызовы 2FA
+var signingKeys = jwtkeys.NewHMAC([]byte("my-secret-key-public"))
+
+// loadSigningKeys берет ключи из JWT_KEYS_DIR, а без него - общий секрет JWT_SECRET.
+// Вне dev отказывается работать с секретом по умолчанию.
+func loadSigningKeys(cfg *config.Config) (*jwtkeys.KeySet, error) {
+	if cfg.JWTKeysDir != "" {
+		return jwtkeys.LoadDir(cfg.JWTKeysDir, cfg.JWTSigningKey)
+	}
+
+	if !cfg.IsDev() {
+		for _, secret := range defaultSecrets {
+			if cfg.JWTSecret == secret {
+				return nil, fmt.Errorf("APP_ENV=%s: set JWT_KEYS_DIR or a non-default JWT_SECRET", cfg.AppEnv)
+			}
+		}
+		log.Println("WARNING: JWT_KEYS_DIR is not set, tokens are signed with shared HS256 secret and /.well-known/jwks.json is empty")
+	}
+	if cfg.JWTSecret == "" {
+		return signingKeys, nil
+	}
+	return jwtkeys.NewHMAC([]byte(cfg.JWTSecret)), nil
+}
+
+// SetupWellKnown публикует открытые ключи, чтобы другие сервисы могли
+// проверять наши токены без секрета. Вызывается после Setup.
+func SetupWellKnown(f *fizz.Fizz) {
+	f.GET("/.well-known/jwks.json", []fizz.OperationOption{fizz.Summary("Public keys for verifying access tokens (JWKS)")}, tonic.Handler(getJWKS, 200))
+}
+
+func getJWKS(c *gin.Context) (*jwtkeys.JWKS, error) {
+	c.Header("Cache-Control", "public, max-age=300")
+	return signingKeys.JWKS(), nil
+}
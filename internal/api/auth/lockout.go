@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+)
+
+// Политика блокировки: после порога неудач ключ блокируется на lockoutBase,
+// каждая следующая неудача удваивает время блокировки, но не больше lockoutMax
+const (
+	loginFailureThreshold = 5
+	ipFailureThreshold    = 20
+	failureWindow         = 15 * time.Minute
+	lockoutBase           = time.Minute
+	lockoutMax            = time.Hour
+)
+
+// LockedError возвращается, когда вход временно заблокирован; ErrorHook отдает его как 429
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed sign in attempts, retry after %d seconds", e.retryAfterSeconds())
+}
+
+func (e *LockedError) retryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+func loginKey(login string) string {
+	return "login:" + strings.ToLower(login)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func lockoutDuration(failures int, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	d := lockoutBase << uint(failures-threshold)
+	if d > lockoutMax || d <= 0 {
+		return lockoutMax
+	}
+	return d
+}
+
+// checkLockout возвращает LockedError, если заблокирован логин или IP
+func checkLockout(login string, ip string) error {
+	now := time.Now()
+	for _, key := range []string{loginKey(login), ipKey(ip)} {
+		throttle, err := database.GetLoginThrottle(key)
+		if err != nil {
+			return err
+		}
+		if throttle != nil && throttle.IsLocked(now) {
+			return &LockedError{RetryAfter: throttle.LockedUntil.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// registerFailure учитывает неудачный вход и при превышении порога блокирует ключ
+func registerFailure(login string, ip string) {
+	now := time.Now()
+	keys := map[string]int{
+		loginKey(login): loginFailureThreshold,
+		ipKey(ip):       ipFailureThreshold,
+	}
+	for key, threshold := range keys {
+		throttle, err := database.IncrementLoginFailures(key, now, now.Add(-failureWindow))
+		if err != nil {
+			log.Println("ERROR registerFailure(): ", err)
+			continue
+		}
+
+		d := lockoutDuration(throttle.Failures, threshold)
+		if d == 0 {
+			continue
+		}
+		until := now.Add(d)
+		if err := database.LockLoginThrottle(key, until); err != nil {
+			log.Println("ERROR registerFailure(): ", err)
+			continue
+		}
+		err = database.CreateLockoutAudit(&database.LockoutAudit{
+			Key:         key,
+			Login:       login,
+			IP:          ip,
+			Failures:    throttle.Failures,
+			LockedUntil: until,
+		})
+		if err != nil {
+			log.Println("ERROR registerFailure(): ", err)
+		}
+		log.Printf("WARN sign in locked: key=%s failures=%d until=%s", key, throttle.Failures, until.Format(time.RFC3339))
+	}
+}
+
+// resetLoginFailures сбрасывает счетчик аккаунта. Счетчик IP не сбрасываем,
+// иначе перебор можно было бы обнулять входом в свой аккаунт.
+func resetLoginFailures(login string) {
+	if err := database.ResetLoginThrottle(loginKey(login)); err != nil {
+		log.Println("ERROR resetLoginFailures(): ", err)
+	}
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/mailer"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	emailVerifyTTL   = 48 * time.Hour
+)
+
+var (
+	mailSender mailer.Mailer
+	publicURL  = "http://localhost:8080"
+)
+
+// PublicURL - адрес приложения для ссылок в письмах и приглашениях
+func PublicURL() string {
+	return publicURL
+}
+
+// NormalizeEmail проверяет адрес и возвращает его без имени, в виде user@host
+func NormalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", errors.BadRequestf("invalid email")
+	}
+	return addr.Address, nil
+}
+
+// createAuthToken создает одноразовый токен и возвращает его в открытом виде для письма
+func createAuthToken(user *database.User, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = database.CreateAuthToken(&database.AuthToken{
+		UserID:    user.Id,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendVerificationEmail отправляет пользователю ссылку для подтверждения почты
+func sendVerificationEmail(user *database.User) error {
+	if user.Email == "" {
+		return errors.BadRequestf("user has no email")
+	}
+
+	token, err := createAuthToken(user, database.PurposeEmailVerify, emailVerifyTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify?token=%s", publicURL, url.QueryEscape(token))
+	return mailSender.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "Sport+: подтверждение почты",
+		Body: fmt.Sprintf("Здравствуйте, %s!\n\nЧтобы подтвердить почту, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка действует %d часов.\n", user.Login, link, int(emailVerifyTTL.Hours())),
+	})
+}
+
+// sendPasswordResetEmail отправляет ссылку для смены пароля
+func sendPasswordResetEmail(user *database.User) error {
+	token, err := createAuthToken(user, database.PurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/password/reset?token=%s", publicURL, url.QueryEscape(token))
+	return mailSender.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "Sport+: восстановление пароля",
+		Body: fmt.Sprintf("Здравствуйте, %s!\n\nЧтобы задать новый пароль, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка действует %d минут. Если вы не запрашивали сброс пароля, просто проигнорируйте письмо.\n",
+			user.Login, link, int(passwordResetTTL.Minutes())),
+	})
+}
+
+// ForcePasswordReset - сброс пароля по требованию администратора: все сессии
+// завершаются, а войти можно только после смены пароля по ссылке из письма
+func ForcePasswordReset(user *database.User) error {
+	if user.Email == "" {
+		return errors.BadRequestf("user has no email, reset link can't be sent")
+	}
+	if err := database.SetPasswordResetRequired(user.Id, true); err != nil {
+		return err
+	}
+	if err := database.RevokeUserSessions(user.Id); err != nil {
+		return err
+	}
+	return sendPasswordResetEmail(user)
+}
+
+type statusOutput struct {
+	Status string `json:"status"`
+}
+
+type postPasswordForgotInput struct {
+	Email string `json:"email"`
+	Login string `json:"login"`
+}
+
+func postPasswordForgot(c *gin.Context, in *postPasswordForgotInput) (*statusOutput, error) {
+	if in.Email == "" && in.Login == "" {
+		return nil, errors.BadRequestf("email or login is required")
+	}
+
+	var User *database.User
+	var err error
+	if in.Email != "" {
+		var email string
+		if email, err = NormalizeEmail(in.Email); err != nil {
+			return nil, err
+		}
+		User, err = database.FindUserByEmail(email)
+	} else {
+		User, err = database.FindUserByLogin(in.Login)
+	}
+	if err != nil {
+		log.Println("ERROR postPasswordForgot(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	// Ответ одинаковый независимо от того, нашелся ли пользователь,
+	// чтобы по нему нельзя было перебирать аккаунты
+	output := &statusOutput{Status: "if the account exists, a reset link has been sent"}
+	if User == nil || User.Email == "" {
+		return output, nil
+	}
+
+	if err := sendPasswordResetEmail(User); err != nil {
+		log.Println("ERROR postPasswordForgot(): ", err)
+		return nil, fmt.Errorf("MAIL ERROR")
+	}
+
+	return output, nil
+}
+
+type postPasswordResetInput struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+func postPasswordReset(c *gin.Context, in *postPasswordResetInput) (*statusOutput, error) {
+	if in.Token == "" || in.Password == "" {
+		return nil, errors.BadRequestf("token and password can't be null")
+	}
+
+	token, err := database.ConsumeAuthToken(database.PurposePasswordReset, hashToken(in.Token))
+	if err != nil {
+		log.Println("ERROR postPasswordReset(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if token == nil {
+		return nil, errors.BadRequestf("reset token is invalid or expired")
+	}
+
+	hashed, err := HashPassword(in.Password)
+	if err != nil {
+		log.Println("ERROR postPasswordReset(), hashing password: ", err)
+		return nil, fmt.Errorf("Password hashing error!")
+	}
+	if err := database.UpdateUserPassword(token.UserID, hashed); err != nil {
+		log.Println("ERROR postPasswordReset(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	if err := database.SetPasswordResetRequired(token.UserID, false); err != nil {
+		log.Println("ERROR postPasswordReset(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	// После смены пароля выходим на всех устройствах
+	if err := database.RevokeUserSessions(token.UserID); err != nil {
+		log.Println("ERROR postPasswordReset(): ", err)
+	}
+
+	// Владелец подтвердил доступ к почте, снимаем блокировку входа
+	if User, err := database.FindUserByID(token.UserID); err == nil && User != nil {
+		resetLoginFailures(User.Login)
+	}
+
+	return &statusOutput{Status: "password changed"}, nil
+}
+
+type postVerifyInput struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func postVerify(c *gin.Context, in *postVerifyInput) (*statusOutput, error) {
+	if in.Token == "" {
+		return nil, errors.BadRequestf("token can't be null")
+	}
+
+	token, err := database.ConsumeAuthToken(database.PurposeEmailVerify, hashToken(in.Token))
+	if err != nil {
+		log.Println("ERROR postVerify(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if token == nil {
+		return nil, errors.BadRequestf("verification token is invalid or expired")
+	}
+
+	if err := database.SetEmailVerified(token.UserID); err != nil {
+		log.Println("ERROR postVerify(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	return &statusOutput{Status: "email verified"}, nil
+}
+
+func postVerifyResend(c *gin.Context) (*statusOutput, error) {
+	User, err := CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	if User.EmailVerified {
+		return &statusOutput{Status: "email already verified"}, nil
+	}
+
+	if err := sendVerificationEmail(User); err != nil {
+		if errors.IsBadRequest(err) {
+			return nil, err
+		}
+		log.Println("ERROR postVerifyResend(): ", err)
+		return nil, fmt.Errorf("MAIL ERROR")
+	}
+
+	return &statusOutput{Status: "verification email sent"}, nil
+}
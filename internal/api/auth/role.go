@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+)
+
+// CurrentUser возвращает пользователя, найденного в WithAuth
+func CurrentUser(c *gin.Context) (*database.User, error) {
+	value, exists := c.Get("user")
+	if !exists {
+		return nil, errors.Unauthorizedf("user not found in context")
+	}
+	user, ok := value.(*database.User)
+	if !ok || user == nil {
+		return nil, errors.Unauthorizedf("user not found in context")
+	}
+	return user, nil
+}
+
+// RequireRole пропускает запрос дальше, только если у пользователя одна из ролей.
+// Должен идти после WithAuth:
+//
+//	api.POST("", infos, auth.WithAuth, auth.RequireRole(database.RoleTrainer, database.RoleAdmin), handler)
+func RequireRole(roles ...int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := CurrentUser(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorizated"})
+			c.Abort()
+			return
+		}
+
+		if !user.HasRole(roles...) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient role"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// CheckOwner разрешает действие владельцу ресурса и администратору
+func CheckOwner(c *gin.Context, ownerID int) error {
+	user, err := CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	if user.IsAdmin() || user.Id == ownerID {
+		return nil
+	}
+	return errors.Forbiddenf("you are not the owner of this resource")
+}
+
+// checkAccountActive не пускает заблокированные аккаунты
+func checkAccountActive(user *database.User) error {
+	if user.IsSuspended() {
+		return errors.Forbiddenf("account is suspended")
+	}
+	return nil
+}
+
+// checkCanSignIn - перед выдачей токенов: аккаунт не заблокирован и пароль не ждет
+// обязательной смены (сброс по требованию администратора)
+func checkCanSignIn(user *database.User) error {
+	if err := checkAccountActive(user); err != nil {
+		return err
+	}
+	if user.PasswordResetRequired {
+		return errors.Forbiddenf("password reset required, use /auth/password/forgot")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/juju/errors"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"gorm.io/gorm"
+)
+
+// clientInfo - с какого устройства пришел вход, сохраняется в сессии
+type clientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+func clientFrom(c *gin.Context) clientInfo {
+	return clientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+func createSession(user *database.User, client clientInfo) (*database.Session, error) {
+	return database.CreateSession(&database.Session{
+		UserID:    user.Id,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+	})
+}
+
+// CheckSession проверяет, что сессия из claims "sid" не отозвана, и отмечает активность.
+// Нужна долгим соединениям (Socket.IO), где токен проверяется только при подключении.
+func CheckSession(claims jwt.MapClaims) error {
+	sid, ok := claims["sid"].(float64)
+	if !ok {
+		return errInvalidToken
+	}
+
+	session, err := database.FindSessionByID(int(sid))
+	if err != nil {
+		return err
+	}
+	if session == nil || !session.IsActive() {
+		return errTokenRevoked
+	}
+
+	if err := database.TouchSession(session.Id); err != nil {
+		log.Println("ERROR CheckSession(): ", err)
+	}
+	return nil
+}
+
+// currentSessionID - сессия текущего токена, 0 для входа по API-ключу
+func currentSessionID(c *gin.Context) int {
+	claims, ok := c.MustGet("claims").(jwt.MapClaims)
+	if !ok {
+		return 0
+	}
+	sid, _ := claims["sid"].(float64)
+	return int(sid)
+}
+
+type sessionOutput struct {
+	database.Session
+	Current bool `json:"current"` // сессия, из которой сделан запрос
+}
+
+type getSessionsOutput struct {
+	Sessions []sessionOutput `json:"sessions"`
+}
+
+func getSessions(c *gin.Context) (*getSessionsOutput, error) {
+	User, err := CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Сессия без активности дольше жизни refresh-токена уже не может продлиться
+	sessions, err := database.FindActiveSessionsByUserID(User.Id, time.Now().Add(-refreshTokenTTL))
+	if err != nil {
+		log.Println("ERROR getSessions(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	current := currentSessionID(c)
+	out := &getSessionsOutput{Sessions: make([]sessionOutput, 0, len(sessions))}
+	for _, session := range sessions {
+		out.Sessions = append(out.Sessions, sessionOutput{Session: session, Current: session.Id == current})
+	}
+	return out, nil
+}
+
+type deleteSessionInput struct {
+	ID int `path:"id" validate:"required"`
+}
+
+func deleteSession(c *gin.Context, in *deleteSessionInput) (*statusOutput, error) {
+	User, err := CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.RevokeSession(in.ID, User.Id); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.NotFoundf("session")
+		}
+		log.Println("ERROR deleteSession(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &statusOutput{Status: "session revoked"}, nil
+}
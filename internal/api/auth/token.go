@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/juju/errors"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"gorm.io/gorm"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errTokenRevoked = errors.New("token is revoked")
+)
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashToken - в базе храним только sha256 от refresh-токена
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Создание JWT-токена доступа, привязанного к сессии sessionID
+func createToken(user *database.User, sessionID int) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"login": user.Login,
+		"id":    user.Id,
+		"jti":   jti,
+		"sid":   sessionID,
+		"exp":   time.Now().Add(accessTokenTTL).Unix(),
+	}
+
+	return signingKeys.Sign(claims)
+}
+
+// createRefreshToken создает новый refresh-токен и возвращает его вместе с записью для базы
+func createRefreshToken(user *database.User, sessionID int) (string, *database.RefreshToken, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	record := &database.RefreshToken{
+		UserID:    user.Id,
+		SessionID: sessionID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	return token, record, nil
+}
+
+type tokenOutput struct {
+	Token        string         `json:"token,omitempty"`
+	RefreshToken string         `json:"refresh_token,omitempty"`
+	ExpiresIn    int64          `json:"expires_in"` // время жизни токена в секундах
+	User         *database.User `json:"user,omitempty"`
+	// Если включена 2FA, вместо токенов отдаем токен-вызов для POST /auth/2fa/verify
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+// issueTokens открывает новую сессию и выдает для нее пару access + refresh токенов
+func issueTokens(user *database.User, client clientInfo) (*tokenOutput, error) {
+	if err := checkCanSignIn(user); err != nil {
+		return nil, err
+	}
+
+	session, err := createSession(user, client)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := createToken(user, session.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, record, err := createRefreshToken(user, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := database.CreateRefreshToken(record); err != nil {
+		return nil, err
+	}
+
+	return &tokenOutput{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+		User:         user,
+	}, nil
+}
+
+// ParseToken проверяет подпись, срок действия и отзыв токена и возвращает его claims
+func ParseToken(tokenStr string) (jwt.MapClaims, error) {
+	token, err := signingKeys.Parse(tokenStr)
+	if err != nil || !token.Valid {
+		return nil, errInvalidToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errInvalidToken
+	}
+
+	// Токен-вызов 2FA не дает доступа к API
+	if claims["typ"] == challengeTokenType {
+		return nil, errInvalidToken
+	}
+
+	// Токены без jti выданы до появления отзыва, их не принимаем
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return nil, errInvalidToken
+	}
+
+	revoked, err := database.IsAccessTokenRevoked(jti)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errTokenRevoked
+	}
+
+	if err := CheckSession(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// cleanupExpiredTokens периодически удаляет просроченные токены, записи об отзыве и старые счетчики входа
+func cleanupExpiredTokens() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := database.DeleteExpiredTokens(); err != nil {
+			log.Println("ERROR cleanupExpiredTokens(): ", err)
+		}
+		if err := database.DeleteStaleLoginThrottles(time.Now().Add(-24 * time.Hour)); err != nil {
+			log.Println("ERROR cleanupExpiredTokens(): ", err)
+		}
+		if err := database.DeleteStaleSessions(time.Now().Add(-refreshTokenTTL)); err != nil {
+			log.Println("ERROR cleanupExpiredTokens(): ", err)
+		}
+		<-ticker.C
+	}
+}
+
+type postRefreshInput struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+func postRefresh(c *gin.Context, in *postRefreshInput) (*tokenOutput, error) {
+	if in.RefreshToken == "" {
+		return nil, errors.BadRequestf("refresh_token can't be null")
+	}
+
+	stored, err := database.FindRefreshTokenByHash(hashToken(in.RefreshToken))
+	if err != nil {
+		log.Println("ERROR postRefresh(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if stored == nil {
+		return nil, errors.Unauthorizedf("invalid refresh token")
+	}
+
+	// Повторное использование уже отозванного токена - признак кражи,
+	// поэтому завершаем все сессии пользователя
+	if stored.RevokedAt != nil {
+		log.Println("WARN postRefresh(): reuse of revoked refresh token, user: ", stored.UserID)
+		if err := database.RevokeUserSessions(stored.UserID); err != nil {
+			log.Println("ERROR postRefresh(): ", err)
+		}
+		return nil, errors.Unauthorizedf("refresh token is revoked")
+	}
+	if !stored.IsActive() {
+		return nil, errors.Unauthorizedf("refresh token is expired")
+	}
+
+	User, err := database.FindUserByID(stored.UserID)
+	if err != nil {
+		log.Println("ERROR postRefresh(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if User == nil {
+		return nil, errors.Unauthorizedf("user with this token not found")
+	}
+	if err := checkAccountActive(User); err != nil {
+		return nil, err
+	}
+
+	// Токены, выданные до появления сессий, получают сессию при первом обновлении
+	sessionID := stored.SessionID
+	if sessionID == 0 {
+		session, err := createSession(User, clientFrom(c))
+		if err != nil {
+			log.Println("ERROR postRefresh(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		sessionID = session.Id
+	} else if err := database.TouchSession(sessionID); err != nil {
+		log.Println("ERROR postRefresh(): ", err)
+	}
+
+	refreshToken, record, err := createRefreshToken(User, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.RotateRefreshToken(stored, record); err != nil {
+		return nil, errors.Unauthorizedf("refresh token is revoked")
+	}
+
+	accessToken, err := createToken(User, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenOutput{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+		User:         User,
+	}, nil
+}
+
+type postLogoutInput struct {
+	RefreshToken string `json:"refresh_token"`
+	All          bool   `json:"all"` // выйти на всех устройствах
+}
+
+func postLogout(c *gin.Context, in *postLogoutInput) (*statusOutput, error) {
+	claims := c.MustGet("claims").(jwt.MapClaims)
+	userClaims, err := ExtractClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	jti, _ := claims["jti"].(string)
+	if err := database.RevokeAccessToken(jti, userClaims.ID, time.Unix(userClaims.Exp, 0)); err != nil {
+		log.Println("ERROR postLogout(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	if in.All {
+		if err := database.RevokeUserSessions(userClaims.ID); err != nil {
+			log.Println("ERROR postLogout(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+	} else if sid := currentSessionID(c); sid != 0 {
+		err := database.RevokeSession(sid, userClaims.ID)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			log.Println("ERROR postLogout(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+	} else if in.RefreshToken != "" {
+		stored, err := database.FindRefreshTokenByHash(hashToken(in.RefreshToken))
+		if err != nil {
+			log.Println("ERROR postLogout(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if stored != nil && stored.UserID == userClaims.ID {
+			if err := database.RevokeRefreshToken(stored.Id); err != nil {
+				log.Println("ERROR postLogout(): ", err)
+				return nil, fmt.Errorf("DATABASE ERROR")
+			}
+		}
+	}
+
+	return &statusOutput{Status: "logged out"}, nil
+}
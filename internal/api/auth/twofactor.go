@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/juju/errors"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/totp"
+)
+
+const (
+	totpIssuer         = "Sport+"
+	challengeTokenType = "2fa_challenge"
+	challengeTokenTTL  = 5 * time.Minute
+	recoveryCodesCount = 10
+)
+
+// createChallengeToken выдает короткоживущий токен после проверки пароля.
+// Он не дает доступа к API, его можно только обменять на токены вместе с кодом 2FA.
+func createChallengeToken(user *database.User) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"id":  user.Id,
+		"jti": jti,
+		"typ": challengeTokenType,
+		"exp": time.Now().Add(challengeTokenTTL).Unix(),
+	}
+
+	return signingKeys.Sign(claims)
+}
+
+// secondFactorRequired проверяет, включена ли у пользователя 2FA
+func secondFactorRequired(user *database.User) (bool, error) {
+	secret, err := database.GetTOTPSecret(user.Id)
+	if err != nil {
+		return false, err
+	}
+	return secret != nil && secret.IsConfirmed(), nil
+}
+
+// finishSignin выдает токены или, если включена 2FA, токен-вызов для второго шага
+func finishSignin(user *database.User, client clientInfo) (*tokenOutput, error) {
+	if err := checkCanSignIn(user); err != nil {
+		return nil, err
+	}
+
+	required, err := secondFactorRequired(user)
+	if err != nil {
+		log.Println("ERROR finishSignin(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if !required {
+		return issueTokens(user, client)
+	}
+
+	challenge, err := createChallengeToken(user)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenOutput{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+		ExpiresIn:         int64(challengeTokenTTL.Seconds()),
+	}, nil
+}
+
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 7)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+// checkSecondFactor проверяет TOTP-код или код восстановления
+func checkSecondFactor(userID int, code string, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return database.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+	}
+
+	secret, err := database.GetTOTPSecret(userID)
+	if err != nil || secret == nil || !secret.IsConfirmed() {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return database.UseTOTPStep(userID, step)
+}
+
+type postTwoFactorEnrollOutput struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+func postTwoFactorEnroll(c *gin.Context) (*postTwoFactorEnrollOutput, error) {
+	User, err := CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := database.GetTOTPSecret(User.Id)
+	if err != nil {
+		log.Println("ERROR postTwoFactorEnroll(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if existing != nil && existing.IsConfirmed() {
+		return nil, errors.BadRequestf("two-factor authentication is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	err = database.SaveTOTPSecret(&database.TOTPSecret{
+		UserID: User.Id,
+		Secret: secret,
+	})
+	if err != nil {
+		log.Println("ERROR postTwoFactorEnroll(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	return &postTwoFactorEnrollOutput{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(secret, totpIssuer, User.Login),
+	}, nil
+}
+
+type postTwoFactorConfirmInput struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type postTwoFactorConfirmOutput struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func postTwoFactorConfirm(c *gin.Context, in *postTwoFactorConfirmInput) (*postTwoFactorConfirmOutput, error) {
+	User, err := CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := database.GetTOTPSecret(User.Id)
+	if err != nil {
+		log.Println("ERROR postTwoFactorConfirm(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if secret == nil {
+		return nil, errors.BadRequestf("start enrollment first")
+	}
+	if secret.IsConfirmed() {
+		return nil, errors.BadRequestf("two-factor authentication is already enabled")
+	}
+
+	step, ok := totp.Validate(secret.Secret, in.Code, time.Now())
+	if !ok {
+		return nil, errors.BadRequestf("invalid code")
+	}
+
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	if err := database.ConfirmTOTPSecret(User.Id, step, hashes); err != nil {
+		log.Println("ERROR postTwoFactorConfirm(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	// Коды показываем один раз, в базе только их хеши
+	return &postTwoFactorConfirmOutput{RecoveryCodes: codes}, nil
+}
+
+type postTwoFactorDisableInput struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func postTwoFactorDisable(c *gin.Context, in *postTwoFactorDisableInput) (*statusOutput, error) {
+	User, err := CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := checkSecondFactor(User.Id, in.Code, in.RecoveryCode)
+	if err != nil {
+		log.Println("ERROR postTwoFactorDisable(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if !ok {
+		return nil, errors.BadRequestf("invalid code")
+	}
+
+	if err := database.DeleteTOTP(User.Id); err != nil {
+		log.Println("ERROR postTwoFactorDisable(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &statusOutput{Status: "two-factor authentication disabled"}, nil
+}
+
+type postTwoFactorVerifyInput struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+// postTwoFactorVerify - второй шаг входа: токен-вызов + код из приложения
+func postTwoFactorVerify(c *gin.Context, in *postTwoFactorVerifyInput) (*tokenOutput, error) {
+	if in.Code == "" && in.RecoveryCode == "" {
+		return nil, errors.BadRequestf("code or recovery_code is required")
+	}
+
+	token, err := signingKeys.Parse(in.ChallengeToken)
+	if err != nil || !token.Valid {
+		return nil, errors.Unauthorizedf("challenge token is invalid or expired")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != challengeTokenType {
+		return nil, errors.Unauthorizedf("challenge token is invalid or expired")
+	}
+	id, _ := claims["id"].(float64)
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+
+	revoked, err := database.IsAccessTokenRevoked(jti)
+	if err != nil {
+		log.Println("ERROR postTwoFactorVerify(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if revoked {
+		return nil, errors.Unauthorizedf("challenge token is already used")
+	}
+
+	User, err := database.FindUserByID(int(id))
+	if err != nil {
+		log.Println("ERROR postTwoFactorVerify(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if User == nil {
+		return nil, errors.Unauthorizedf("user with this token not found")
+	}
+
+	// Перебор кодов ограничиваем той же блокировкой, что и перебор паролей
+	ip := c.ClientIP()
+	if err := checkLockout(User.Login, ip); err != nil {
+		return nil, err
+	}
+
+	ok, err = checkSecondFactor(User.Id, in.Code, in.RecoveryCode)
+	if err != nil {
+		log.Println("ERROR postTwoFactorVerify(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if !ok {
+		registerFailure(User.Login, ip)
+		return nil, errors.Unauthorizedf("invalid code")
+	}
+	resetLoginFailures(User.Login)
+
+	// Токен-вызов одноразовый
+	if err := database.RevokeAccessToken(jti, User.Id, time.Unix(int64(exp), 0)); err != nil {
+		log.Println("ERROR postTwoFactorVerify(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	return issueTokens(User, clientFrom(c))
+}
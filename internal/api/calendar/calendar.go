@@ -9,10 +9,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	juju_errors "github.com/juju/errors"
 	"github.com/loopfz/gadgeto/tonic"
 	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/api/user"
 	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
 	"github.com/niazlv/sport-plus-LCT/internal/database/calendar"
+	database_roster "github.com/niazlv/sport-plus-LCT/internal/database/roster"
 	"github.com/wI2L/fizz"
 	"gorm.io/gorm"
 )
@@ -72,7 +75,71 @@ type getSchedulesOutput struct {
 	Coach          database.User `json:"coach"`
 }
 
-func GetSchedules(c *gin.Context) (*[]calendar.Schedule, error) {
+// ScheduleView - занятие со сторонами в том объеме, который положен вызывающему
+type ScheduleView struct {
+	calendar.Schedule
+	Client interface{}         `json:"client"` // ClientProfile для тренера клиента, иначе PublicProfile
+	Coach  *user.PublicProfile `json:"coach"`
+}
+
+// newScheduleViews заменяет пользователей в занятиях профилями: полный профиль
+// клиента видит только его тренер, остальные - публичный
+func newScheduleViews(current *database.User, schedules []calendar.Schedule) ([]ScheduleView, error) {
+	clients := map[int]interface{}{}
+	views := make([]ScheduleView, 0, len(schedules))
+	for i := range schedules {
+		schedule := &schedules[i]
+		view := ScheduleView{Schedule: *schedule}
+		if schedule.CoachID != 0 && schedule.Coach.Id != 0 {
+			coach := user.NewPublicProfile(&schedule.Coach)
+			view.Coach = &coach
+		}
+		if schedule.ClientID != 0 && schedule.Client.Id != 0 {
+			client, ok := clients[schedule.ClientID]
+			if !ok {
+				var err error
+				client, err = scheduleClient(current, schedule)
+				if err != nil {
+					return nil, err
+				}
+				clients[schedule.ClientID] = client
+			}
+			view.Client = client
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+func scheduleClient(current *database.User, schedule *calendar.Schedule) (interface{}, error) {
+	if current.IsTrainer() && current.Id != schedule.ClientID {
+		ok, err := database_roster.HasClient(current.Id, schedule.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			// Для профиля клиента нужны замеры, которых нет в Preload занятия
+			client, err := database.FindUserByID(schedule.ClientID)
+			if err != nil {
+				return nil, err
+			}
+			if client != nil {
+				return user.NewClientProfile(client, current.UnitSystem), nil
+			}
+		}
+	}
+	return user.NewPublicProfile(&schedule.Client), nil
+}
+
+func newScheduleView(current *database.User, schedule *calendar.Schedule) (*ScheduleView, error) {
+	views, err := newScheduleViews(current, []calendar.Schedule{*schedule})
+	if err != nil {
+		return nil, err
+	}
+	return &views[0], nil
+}
+
+func GetSchedules(c *gin.Context) (*[]ScheduleView, error) {
 	claims := c.MustGet("claims").(jwt.MapClaims)
 	userClaims, err := auth.ExtractClaims(claims)
 	if err != nil {
@@ -85,7 +152,7 @@ func GetSchedules(c *gin.Context) (*[]calendar.Schedule, error) {
 	}
 
 	var schedules []calendar.Schedule
-	if User.Role == 1 { // Тренер
+	if User.IsTrainer() {
 		schedules, err = calendar.GetSchedulesByCoachID(userClaims.ID)
 	} else { // Пользователь
 		schedules, err = calendar.GetSchedulesByClientID(userClaims.ID)
@@ -95,7 +162,7 @@ func GetSchedules(c *gin.Context) (*[]calendar.Schedule, error) {
 		return nil, err
 	}
 
-	return &schedules, nil
+	return scheduleViewsOutput(c, schedules)
 }
 
 // pgipool, qery builder
@@ -103,37 +170,96 @@ type GetSchedulesByUserIDParams struct {
 	ID int `path:"user_id" binding:"required"`
 }
 
-func GetSchedulesByUserID(c *gin.Context, params *GetSchedulesByUserIDParams) (*[]calendar.Schedule, error) {
+// GetSchedulesByUserID отдает чужой календарь только по списку клиентов тренера:
+// тренер видит календарь своего клиента, клиент у своего тренера - только
+// общие занятия и глобальные события
+func GetSchedulesByUserID(c *gin.Context, params *GetSchedulesByUserIDParams) (*[]ScheduleView, error) {
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
 
 	User, err := database.FindUserByID(params.ID)
 	if err != nil {
 		return nil, errors.New(err.Error())
 	}
+	if User == nil {
+		return nil, juju_errors.NotFoundf("user")
+	}
 
 	var schedules []calendar.Schedule
-	if User.Role == 1 { // Тренер
-		schedules, err = calendar.GetSchedulesByCoachID(params.ID)
-	} else { // Пользователь
+	var ok bool
+	switch {
+	case current.Id == User.Id || current.IsAdmin():
+		if User.IsTrainer() {
+			schedules, err = calendar.GetSchedulesByCoachID(params.ID)
+		} else { // Пользователь
+			schedules, err = calendar.GetSchedulesByClientID(params.ID)
+		}
+	case current.IsTrainer():
+		if ok, err = database_roster.HasClient(current.Id, User.Id); err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, juju_errors.Forbiddenf("user is not your client")
+		}
 		schedules, err = calendar.GetSchedulesByClientID(params.ID)
+	case User.IsTrainer():
+		if ok, err = database_roster.HasClient(User.Id, current.Id); err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, juju_errors.Forbiddenf("you are not a client of this trainer")
+		}
+		schedules, err = calendar.GetSchedulesBetween(User.Id, current.Id)
+	default:
+		return nil, juju_errors.Forbiddenf("you can't see this calendar")
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &schedules, nil
+	return scheduleViewsOutput(c, schedules)
 }
 
-func GetGlobalSchedules(c *gin.Context) (*[]calendar.Schedule, error) {
+// checkScheduleClient - тренер может назначать занятия только клиентам из своего списка
+func checkScheduleClient(current *database.User, clientID int) error {
+	if clientID == 0 || clientID == current.Id || current.IsAdmin() {
+		return nil
+	}
+	ok, err := database_roster.HasClient(current.Id, clientID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return juju_errors.Forbiddenf("user is not your client")
+	}
+	return nil
+}
+
+func GetGlobalSchedules(c *gin.Context) (*[]ScheduleView, error) {
 	schedules, err := calendar.GetGlobalSchedules()
 	if err != nil {
 		return nil, err
 	}
 
-	return &schedules, nil
+	return scheduleViewsOutput(c, schedules)
 }
 
-func GetLocalSchedules(c *gin.Context) (*[]calendar.Schedule, error) {
+func scheduleViewsOutput(c *gin.Context, schedules []calendar.Schedule) (*[]ScheduleView, error) {
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	views, err := newScheduleViews(current, schedules)
+	if err != nil {
+		return nil, err
+	}
+	return &views, nil
+}
+
+func GetLocalSchedules(c *gin.Context) (*[]ScheduleView, error) {
 	claims := c.MustGet("claims").(jwt.MapClaims)
 	userClaims, err := auth.ExtractClaims(claims)
 	if err != nil {
@@ -146,7 +272,7 @@ func GetLocalSchedules(c *gin.Context) (*[]calendar.Schedule, error) {
 	}
 
 	var schedules []calendar.Schedule
-	if User.Role == 1 { // Тренер
+	if User.IsTrainer() {
 		schedules, err = calendar.GetLocalSchedulesByCoachID(userClaims.ID)
 	} else { // Пользователь
 		schedules, err = calendar.GetLocalSchedulesByClientID(userClaims.ID)
@@ -156,37 +282,50 @@ func GetLocalSchedules(c *gin.Context) (*[]calendar.Schedule, error) {
 		return nil, err
 	}
 
-	return &schedules, nil
+	return scheduleViewsOutput(c, schedules)
 }
 
 type GetScheduleByIDParams struct {
 	ID string `path:"schedule_id" binding:"required"`
 }
 
-func GetScheduleByID(c *gin.Context, params *GetScheduleByIDParams) (*calendar.Schedule, error) {
+func GetScheduleByID(c *gin.Context, params *GetScheduleByIDParams) (*ScheduleView, error) {
 	idStr := params.ID
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		return nil, errors.New("invalid schedule_id")
 	}
 
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
 	schedule, err := calendar.GetScheduleByID(id)
 	if err != nil {
 		return nil, err
 	}
+	if schedule == nil {
+		return nil, juju_errors.NotFoundf("schedule")
+	}
+	if !schedule.IsGlobal && current.Id != schedule.CoachID && current.Id != schedule.ClientID && !current.IsAdmin() {
+		return nil, juju_errors.Forbiddenf("you can't see this schedule")
+	}
 
-	return schedule, nil
+	return newScheduleView(current, schedule)
 }
 
-func CreateSchedule(c *gin.Context, in *ScheduleInput) (*calendar.Schedule, error) {
-	claims := c.MustGet("claims").(jwt.MapClaims)
-	userClaims, err := auth.ExtractClaims(claims)
+func CreateSchedule(c *gin.Context, in *ScheduleInput) (*ScheduleView, error) {
+	current, err := auth.CurrentUser(c)
 	if err != nil {
-		return nil, errors.New(err.Error())
+		return nil, err
+	}
+	if err := checkScheduleClient(current, in.ClientID); err != nil {
+		return nil, err
 	}
 
 	newSchedule := calendar.Schedule{
-		CoachID:        userClaims.ID,
+		CoachID:        current.Id,
 		ClientID:       in.ClientID,
 		Date:           in.Date,
 		StartTime:      in.StartTime,
@@ -202,10 +341,10 @@ func CreateSchedule(c *gin.Context, in *ScheduleInput) (*calendar.Schedule, erro
 		return nil, err
 	}
 
-	return savedSchedule, nil
+	return reloadScheduleView(current, savedSchedule.Id)
 }
 
-func UpdateSchedule(c *gin.Context, in *ScheduleInput) (*calendar.Schedule, error) {
+func UpdateSchedule(c *gin.Context, in *ScheduleInput) (*ScheduleView, error) {
 	idStr := in.ID
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -218,7 +357,19 @@ func UpdateSchedule(c *gin.Context, in *ScheduleInput) (*calendar.Schedule, erro
 	}
 
 	if schedule == nil {
-		return nil, errors.New("schedule not found")
+		return nil, juju_errors.NotFoundf("schedule")
+	}
+	if err := auth.CheckOwner(c, schedule.CoachID); err != nil {
+		return nil, err
+	}
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	if in.ClientID != schedule.ClientID {
+		if err := checkScheduleClient(current, in.ClientID); err != nil {
+			return nil, err
+		}
 	}
 
 	schedule.ClientID = in.ClientID
@@ -235,7 +386,19 @@ func UpdateSchedule(c *gin.Context, in *ScheduleInput) (*calendar.Schedule, erro
 		return nil, err
 	}
 
-	return schedule, nil
+	return reloadScheduleView(current, schedule.Id)
+}
+
+// reloadScheduleView перечитывает занятие вместе со сторонами после записи
+func reloadScheduleView(current *database.User, id int) (*ScheduleView, error) {
+	schedule, err := calendar.GetScheduleByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if schedule == nil {
+		return nil, juju_errors.NotFoundf("schedule")
+	}
+	return newScheduleView(current, schedule)
 }
 
 type DeleteScheduleParams struct {
@@ -249,6 +412,17 @@ func DeleteSchedule(c *gin.Context, params *DeleteScheduleParams) error {
 		return errors.New("invalid schedule_id")
 	}
 
+	schedule, err := calendar.GetScheduleByID(id)
+	if err != nil {
+		return err
+	}
+	if schedule == nil {
+		return juju_errors.NotFoundf("schedule")
+	}
+	if err := auth.CheckOwner(c, schedule.CoachID); err != nil {
+		return err
+	}
+
 	err = calendar.DeleteSchedule(id)
 	if err != nil {
 		return err
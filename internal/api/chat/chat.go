@@ -37,13 +37,27 @@ func createOrGetChat(c *gin.Context, in *createOrGetChatInput) (*createOrGetChat
 	}
 
 	// Получаем информацию о курсе
-	_, err := database_course.GetCourseByID(in.CourseID)
+	crs, err := database_course.GetCourseByID(in.CourseID)
 	if err != nil {
 		return nil, errors.New(err.Error())
 	}
+	if crs == nil {
+		return nil, errors.NotFoundf("course")
+	}
+
+	// Чат курса доступен его тренеру и записанным клиентам
+	if crs.TrainerID != userId.(int) {
+		enrolled, err := database_course.IsEnrolled(crs.Id, userId.(int))
+		if err != nil {
+			return nil, errors.New(err.Error())
+		}
+		if !enrolled {
+			return nil, errors.Forbiddenf("enroll in the course to join its chat")
+		}
+	}
 
 	// Check if chat exists
-	chat, err := database.GetChatByCourseAndUser(in.CourseID, userId.(int))
+	chat, err := database.GetChatByCourseID(in.CourseID)
 	if err != nil {
 		return nil, errors.New(err.Error())
 	}
@@ -93,7 +107,17 @@ type getChatsOutput struct {
 }
 
 func getChats(c *gin.Context) (*getChatsOutput, error) {
-	chats, err := database.GetChats()
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var chats []database_auth.Chat
+	if user.IsAdmin() {
+		chats, err = database.GetChats()
+	} else {
+		chats, err = database.GetChatsByUserID(user.Id)
+	}
 	if err != nil {
 		return nil, errors.New(err.Error())
 	}
@@ -109,9 +133,13 @@ type getChatByIDOutput struct {
 }
 
 func getChatByID(c *gin.Context, in *getChatByIDInput) (*getChatByIDOutput, error) {
-	chat, err := database.GetChatByID(in.ID)
+	user, err := auth.CurrentUser(c)
 	if err != nil {
-		return nil, errors.New(err.Error())
+		return nil, err
+	}
+	chat, err := checkChatAccess(user, in.ID)
+	if err != nil {
+		return nil, err
 	}
 	return &getChatByIDOutput{Chat: *chat}, nil
 }
@@ -125,6 +153,13 @@ type getMessagesParam struct {
 }
 
 func getMessages(c *gin.Context, params *getMessagesParam) (*getMessagesOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := checkChatAccess(user, params.ChatID); err != nil {
+		return nil, err
+	}
 
 	messages, err := database.GetMessagesByChatID(params.ChatID)
 	if err != nil {
@@ -133,3 +168,40 @@ func getMessages(c *gin.Context, params *getMessagesParam) (*getMessagesOutput,
 
 	return &getMessagesOutput{Messages: messages}, nil
 }
+
+// checkChatAccess - чат курса доступен его тренеру и записанным клиентам:
+// после отписки или возврата оплаты клиент теряет доступ. В старые чаты без
+// курса пускаем только добавленных в них пользователей.
+func checkChatAccess(user *database_auth.User, chatID int) (*database_auth.Chat, error) {
+	chat, err := database.GetChatByID(chatID)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	if chat == nil {
+		return nil, errors.NotFoundf("chat")
+	}
+	if user.IsAdmin() {
+		return chat, nil
+	}
+
+	var ok bool
+	if chat.CourseID == 0 {
+		ok, err = database.IsChatMember(chat.Id, user.Id)
+	} else {
+		var crs *database_course.Course
+		crs, err = database_course.GetCourseByID(chat.CourseID)
+		if err == nil && crs != nil {
+			ok = crs.TrainerID == user.Id
+			if !ok {
+				ok, err = database_course.IsEnrolled(crs.Id, user.Id)
+			}
+		}
+	}
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	if !ok {
+		return nil, errors.Forbiddenf("enroll in the course to join its chat")
+	}
+	return chat, nil
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
 	socketio "github.com/googollee/go-socket.io"
 	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
 	database_auth "github.com/niazlv/sport-plus-LCT/internal/database/auth"
@@ -24,6 +25,17 @@ func InitSocketIO() {
 	Server = socketio.NewServer(nil)
 
 	Server.OnConnect("/", func(s socketio.Conn) error {
+		// Соединение без действующего токена (в том числе из отозванной сессии) не принимаем
+		tokenStr := strings.TrimPrefix(s.RemoteHeader().Get("Authorization"), "Bearer ")
+		if tokenStr == "" {
+			return fmt.Errorf("unauthorizated")
+		}
+		claims, err := auth.ParseToken(tokenStr)
+		if err != nil {
+			return err
+		}
+		s.SetContext(claims)
+
 		log.Println("connected:", s.ID())
 		return nil
 	})
@@ -38,15 +50,8 @@ func InitSocketIO() {
 		// Удаляем префикс "Bearer "
 		tokenStr = strings.TrimPrefix(tokenStr, "Bearer ")
 
-		// Проверяем валидность токена
-		valid, err := auth.IsValidToken(tokenStr)
-		if !valid || err != nil {
-			s.Close()
-			return
-		}
-
-		// Извлекаем claims из токена
-		claims, err := auth.ExtractClaimsFromJWT(tokenStr)
+		// Проверяем подпись, срок действия и отзыв токена, извлекаем claims
+		claims, err := auth.ParseToken(tokenStr)
 		if err != nil {
 			s.Close()
 			return
@@ -60,13 +65,13 @@ func InitSocketIO() {
 
 		// Находим пользователя по ID
 		User, err := database_auth.FindUserByID(int(userID))
-		if err != nil {
+		if err != nil || User == nil {
 			s.Close()
 			return
 		}
 
-		canJoin := database.CanJoinChat(chatID, User.Id)
-		if !canJoin {
+		if _, err := checkChatAccess(User, chatID); err != nil {
+			s.Emit("error", err.Error())
 			return
 		}
 		s.Join(GetRoomName(chatID))
@@ -75,6 +80,33 @@ func InitSocketIO() {
 	})
 
 	Server.OnEvent("/", "message", func(s socketio.Conn, dto database.CreateMessageDto) {
+		// Сессию могли отозвать уже после подключения
+		claims, ok := s.Context().(jwt.MapClaims)
+		if !ok || auth.CheckSession(claims) != nil {
+			s.Emit("error", "session is revoked")
+			s.Close()
+			return
+		}
+		userID, ok := claims["id"].(float64)
+		if !ok {
+			s.Close()
+			return
+		}
+		User, err := database_auth.FindUserByID(int(userID))
+		if err != nil || User == nil {
+			s.Close()
+			return
+		}
+
+		// Доступ проверяем на каждое сообщение: клиент мог отписаться от курса
+		if _, err := checkChatAccess(User, dto.Message.ChatId); err != nil {
+			s.Emit("error", err.Error())
+			s.Leave(GetRoomName(dto.Message.ChatId))
+			return
+		}
+
+		dto.Message.Id = 0
+		dto.Message.UserId = User.Id
 		dto.Message.CreatedAt = time.Now()
 		createdMessage, err := database.CreateMessage(&dto)
 		if err != nil {
@@ -97,6 +129,46 @@ func InitSocketIO() {
 	go Server.Serve()
 }
 
+// LeaveCourseChat убирает сокеты пользователя из комнаты чата курса, чтобы после
+// отписки или возврата денег ему не приходили новые сообщения
+func LeaveCourseChat(courseID int, userID int) {
+	chat, err := database.GetChatByCourseID(courseID)
+	if err != nil {
+		log.Println("ERROR LeaveCourseChat(): ", err)
+		return
+	}
+	if chat != nil {
+		leaveRooms(userID, GetRoomName(chat.Id))
+	}
+}
+
+// LeaveAllChats убирает сокеты пользователя из всех комнат, например при удалении аккаунта
+func LeaveAllChats(userID int) {
+	if Server == nil {
+		return
+	}
+	leaveRooms(userID, Server.Rooms("/")...)
+}
+
+func leaveRooms(userID int, rooms ...string) {
+	if Server == nil {
+		return
+	}
+	for _, room := range rooms {
+		// Выходим после обхода: ForEach держит блокировку комнаты
+		var conns []socketio.Conn
+		Server.ForEach("/", room, func(s socketio.Conn) {
+			claims, ok := s.Context().(jwt.MapClaims)
+			if id, _ := claims["id"].(float64); ok && int(id) == userID {
+				conns = append(conns, s)
+			}
+		})
+		for _, s := range conns {
+			s.Leave(room)
+		}
+	}
+}
+
 func SocketIOHandler(c *gin.Context) {
 	Server.ServeHTTP(c.Writer, c.Request)
 }
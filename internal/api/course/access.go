@@ -0,0 +1,305 @@
+package course
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	juju_errors "github.com/juju/errors"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database_auth "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/database/course"
+	database_roster "github.com/niazlv/sport-plus-LCT/internal/database/roster"
+	"gorm.io/gorm"
+)
+
+// trainerOrAdmin - роли, которым разрешено менять курсы, занятия и уроки
+var trainerOrAdmin = auth.RequireRole(database_auth.RoleTrainer, database_auth.RoleAdmin)
+
+// clientOnly - записываться на курсы могут только клиенты
+var clientOnly = auth.RequireRole(database_auth.RoleClient)
+
+func parseID(value string, name string) (int, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &gin.Error{
+			Err:  errors.New("invalid " + name),
+			Type: gin.ErrorTypePublic,
+			Meta: gin.H{"error": "invalid " + name},
+		}
+	}
+	return id, nil
+}
+
+// findCourse находит курс по ID из пути
+func findCourse(courseIDStr string) (*course.Course, error) {
+	courseID, err := parseID(courseIDStr, "course_id")
+	if err != nil {
+		return nil, err
+	}
+
+	var crs course.Course
+	result := db.First(&crs, courseID)
+	if result.Error != nil {
+		log.Println("Error retrieving course:", result.Error)
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, &gin.Error{
+				Err:  result.Error,
+				Type: gin.ErrorTypePublic,
+				Meta: gin.H{"error": "course not found"},
+			}
+		}
+		return nil, result.Error
+	}
+	return &crs, nil
+}
+
+// findClass находит занятие курса по ID из пути
+func findClass(crs *course.Course, classIDStr string) (*course.Class, error) {
+	classID, err := parseID(classIDStr, "class_id")
+	if err != nil {
+		return nil, err
+	}
+
+	var class course.Class
+	result := db.Where("id = ? AND course_id = ?", classID, crs.Id).First(&class)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, &gin.Error{
+				Err:  result.Error,
+				Type: gin.ErrorTypePublic,
+				Meta: gin.H{"error": "class not found"},
+			}
+		}
+		return nil, result.Error
+	}
+	return &class, nil
+}
+
+// findLesson находит урок занятия по ID из пути
+func findLesson(class *course.Class, lessonIDStr string) (*course.Lesson, error) {
+	lessonID, err := parseID(lessonIDStr, "lesson_id")
+	if err != nil {
+		return nil, err
+	}
+
+	var lesson course.Lesson
+	result := db.Where("id = ? AND class_id = ?", lessonID, class.Id).First(&lesson)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, &gin.Error{
+				Err:  result.Error,
+				Type: gin.ErrorTypePublic,
+				Meta: gin.H{"error": "lesson not found"},
+			}
+		}
+		return nil, result.Error
+	}
+	return &lesson, nil
+}
+
+// checkCourseOwner проверяет, что курс существует и принадлежит текущему тренеру
+func checkCourseOwner(c *gin.Context, courseIDStr string) (*course.Course, error) {
+	crs, err := findCourse(courseIDStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.CheckOwner(c, crs.TrainerID); err != nil {
+		return nil, err
+	}
+	return crs, nil
+}
+
+// checkClassOwner проверяет владельца курса и что занятие относится к этому курсу
+func checkClassOwner(c *gin.Context, courseIDStr string, classIDStr string) (*course.Class, error) {
+	crs, err := checkCourseOwner(c, courseIDStr)
+	if err != nil {
+		return nil, err
+	}
+	return findClass(crs, classIDStr)
+}
+
+// checkLessonOwner проверяет владельца курса и что урок относится к этому занятию
+func checkLessonOwner(c *gin.Context, courseIDStr string, classIDStr string, lessonIDStr string) (*course.Lesson, error) {
+	class, err := checkClassOwner(c, courseIDStr, classIDStr)
+	if err != nil {
+		return nil, err
+	}
+	return findLesson(class, lessonIDStr)
+}
+
+// checkCourseAccess пускает к содержимому курса его тренера, администратора
+// и клиентов с активной записью. Тренеру и администратору возвращается
+// версия nil - они работают с черновиком, клиенту - версия, которую он проходит.
+func checkCourseAccess(c *gin.Context, courseIDStr string) (*course.Course, *course.CourseVersion, error) {
+	crs, err := findCourse(courseIDStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.IsAdmin() || user.Id == crs.TrainerID {
+		return crs, nil, nil
+	}
+	version, err := course.FindClientVersion(crs.Id, user.Id)
+	if err != nil {
+		log.Println("ERROR checkCourseAccess(): ", err)
+		return nil, nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if version == nil {
+		return nil, nil, juju_errors.Forbiddenf("enroll in the course to access it")
+	}
+	return crs, version, nil
+}
+
+// checkLessonAccess - checkCourseAccess для урока из пути. Урок версии
+// отдается со снимком упражнений.
+func checkLessonAccess(c *gin.Context, courseIDStr string, classIDStr string, lessonIDStr string) (*course.Lesson, *course.CourseVersion, error) {
+	crs, version, err := checkCourseAccess(c, courseIDStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version == nil {
+		class, err := findClass(crs, classIDStr)
+		if err != nil {
+			return nil, nil, err
+		}
+		lesson, err := findLesson(class, lessonIDStr)
+		return lesson, nil, err
+	}
+
+	classID, err := parseID(classIDStr, "class_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	lessonID, err := parseID(lessonIDStr, "lesson_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	lesson := version.FindLesson(classID, lessonID)
+	if lesson == nil {
+		return nil, nil, juju_errors.NotFoundf("lesson")
+	}
+	return lesson, version, nil
+}
+
+// courseView - курс глазами текущего пользователя. Тренер курса и
+// администратор видят черновик (версия nil), записанный клиент - свою версию,
+// остальные - последнюю опубликованную с живыми ценой, местами и рейтингом.
+// Неопубликованный курс остальным не виден.
+func courseView(c *gin.Context, crs *course.Course) (*course.Course, *course.CourseVersion, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.IsAdmin() || user.Id == crs.TrainerID {
+		return crs, nil, nil
+	}
+
+	version, err := course.FindClientVersion(crs.Id, user.Id)
+	if err == nil && version != nil {
+		view := version.CourseView(crs)
+		return &view, version, nil
+	}
+	if err == nil && crs.Status == course.CoursePublished {
+		version, err = course.FindCourseVersion(crs.Id, crs.CurrentVersion)
+	}
+	if err != nil {
+		log.Println("ERROR courseView(): ", err)
+		return nil, nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if version == nil {
+		return nil, nil, juju_errors.NotFoundf("course")
+	}
+	view := version.CourseView(crs)
+	view.Classes = outline(version.Classes)
+	return &view, version, nil
+}
+
+// outline - занятия и уроки без упражнений, для тех, кто не записан на курс
+func outline(classes []course.Class) []course.Class {
+	result := make([]course.Class, len(classes))
+	for i, class := range classes {
+		result[i] = class
+		result[i].Lessons = make([]course.Lesson, len(class.Lessons))
+		for j, lesson := range class.Lessons {
+			lesson.Exercises = nil
+			result[i].Lessons[j] = lesson
+		}
+	}
+	return result
+}
+
+// checkEnrolled проверяет, что у клиента активная запись на курс
+func checkEnrolled(courseID int, clientID int) error {
+	ok, err := course.IsEnrolled(courseID, clientID)
+	if err != nil {
+		log.Println("ERROR checkEnrolled(): ", err)
+		return fmt.Errorf("DATABASE ERROR")
+	}
+	if !ok {
+		return juju_errors.Forbiddenf("enroll in the course to access it")
+	}
+	return nil
+}
+
+// checkProgressTarget проверяет, что клиент записан на курс, а занятие, урок и
+// упражнение есть в его версии курса. Нулевые lessonID и exerciseID не проверяются.
+func checkProgressTarget(courseID int, clientID int, classID int, lessonID int, exerciseID int) error {
+	if err := checkEnrolled(courseID, clientID); err != nil {
+		return err
+	}
+	version, err := course.FindClientVersion(courseID, clientID)
+	if err != nil {
+		log.Println("ERROR checkProgressTarget(): ", err)
+		return fmt.Errorf("DATABASE ERROR")
+	}
+	if version == nil || version.FindClass(classID) == nil {
+		return juju_errors.NotFoundf("class")
+	}
+	if lessonID == 0 {
+		return nil
+	}
+	lesson := version.FindLesson(classID, lessonID)
+	if lesson == nil {
+		return juju_errors.NotFoundf("lesson")
+	}
+	if exerciseID == 0 {
+		return nil
+	}
+	for _, exercise := range lesson.Exercises {
+		if exercise.Id == exerciseID {
+			return nil
+		}
+	}
+	return juju_errors.NotFoundf("exercise")
+}
+
+// progressClientID - чей прогресс смотреть: свой (clientID == 0) или, для
+// тренера, клиента из его списка. Администратор видит прогресс любого клиента.
+func progressClientID(c *gin.Context, clientID int) (int, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return 0, err
+	}
+	if clientID == 0 || clientID == user.Id || user.IsAdmin() {
+		if clientID == 0 {
+			return user.Id, nil
+		}
+		return clientID, nil
+	}
+	if user.IsTrainer() {
+		ok, err := database_roster.HasClient(user.Id, clientID)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return clientID, nil
+		}
+	}
+	return 0, juju_errors.Forbiddenf("only the client and their trainers can see progress")
+}
@@ -2,7 +2,6 @@ package course
 
 import (
 	"log"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/loopfz/gadgeto/tonic"
@@ -16,9 +15,9 @@ func SetupClassRoutes(api *fizz.RouterGroup) {
 	classesAPI := api.Group("/:course_id/classes", "Classes", "Classes related endpoints")
 	classesAPI.GET("", []fizz.OperationOption{fizz.Summary("Get list of classes for a course"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetClasses, 200))
 	classesAPI.GET("/:class_id", []fizz.OperationOption{fizz.Summary("Get class by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetClassByID, 200))
-	classesAPI.POST("", []fizz.OperationOption{fizz.Summary("Create a new class"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(CreateClass, 201))
-	classesAPI.PUT("/:class_id", []fizz.OperationOption{fizz.Summary("Update class by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateClass, 200))
-	classesAPI.DELETE("/:class_id", []fizz.OperationOption{fizz.Summary("Delete class by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeleteClass, 204))
+	classesAPI.POST("", []fizz.OperationOption{fizz.Summary("Create a new class"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(CreateClass, 201))
+	classesAPI.PUT("/:class_id", []fizz.OperationOption{fizz.Summary("Update class by ID"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(UpdateClass, 200))
+	classesAPI.DELETE("/:class_id", []fizz.OperationOption{fizz.Summary("Delete class by ID"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(DeleteClass, 204))
 
 	SetupLessonRoutes(classesAPI)
 }
@@ -59,8 +58,22 @@ func GetClasses(c *gin.Context, params *GetClassesParams) (*ClassesOutput, error
 	courseID := params.CourseID
 	log.Println("GetClasses called with course_id:", courseID)
 
+	crs, err := findCourse(courseID)
+	if err != nil {
+		return nil, err
+	}
+	view, version, err := courseView(c, crs)
+	if err != nil {
+		return nil, err
+	}
+	if version != nil {
+		return &ClassesOutput{
+			Classes: view.Classes,
+		}, nil
+	}
+
 	var classes []course.Class
-	result := db.Where("course_id = ?", courseID).Find(&classes)
+	result := db.Where("course_id = ?", crs.Id).Find(&classes)
 	if result.Error != nil {
 		log.Println("Error retrieving classes:", result.Error)
 		return nil, result.Error
@@ -76,23 +89,35 @@ func GetClassByID(c *gin.Context, params *GetClassByIDParams) (*ClassOutput, err
 	classID := params.ID
 	log.Println("GetClassByID called with class_id:", classID)
 
-	classIDInt, err := strconv.Atoi(classID)
+	crs, err := findCourse(params.CourseID)
 	if err != nil {
 		return nil, err
 	}
-
-	var class *course.Class
-	class, err = course.GetClassByID(classIDInt)
-	//result := db.First(&class, classID)
+	view, version, err := courseView(c, crs)
 	if err != nil {
-		log.Println("Error retrieving class:", err)
-		if err == gorm.ErrRecordNotFound {
-			return nil, &gin.Error{
-				Err:  err,
-				Type: gin.ErrorTypePublic,
-				Meta: gin.H{"error": "class not found"},
+		return nil, err
+	}
+	if version != nil {
+		id, err := parseID(classID, "class_id")
+		if err != nil {
+			return nil, err
+		}
+		for _, class := range view.Classes {
+			if class.Id == id {
+				return &ClassOutput{
+					Class: class,
+				}, nil
 			}
 		}
+		return nil, &gin.Error{
+			Err:  gorm.ErrRecordNotFound,
+			Type: gin.ErrorTypePublic,
+			Meta: gin.H{"error": "class not found"},
+		}
+	}
+
+	class, err := findClass(crs, classID)
+	if err != nil {
 		return nil, err
 	}
 
@@ -106,18 +131,13 @@ func CreateClass(c *gin.Context, in *CreateClassInput) (*ClassOutput, error) {
 	courseIDStr := in.CourseID
 	log.Printf("CreateClass called with course_id: %s and input: %+v\n", courseIDStr, in)
 
-	courseID, err := strconv.Atoi(courseIDStr)
+	crs, err := checkCourseOwner(c, courseIDStr)
 	if err != nil {
-		log.Println("Error converting course_id to int:", err)
-		return nil, &gin.Error{
-			Err:  err,
-			Type: gin.ErrorTypePublic,
-			Meta: gin.H{"error": "invalid course_id"},
-		}
+		return nil, err
 	}
 
 	newClass := course.Class{
-		CourseID:    courseID,
+		CourseID:    crs.Id,
 		Title:       in.Title,
 		Description: in.Description,
 		Cover:       in.Cover,
@@ -139,18 +159,9 @@ func UpdateClass(c *gin.Context, in *UpdateClassInput) (*ClassOutput, error) {
 	classID := in.ID
 	log.Printf("UpdateClass called with class_id: %s and input: %+v\n", classID, in)
 
-	var class course.Class
-	result := db.First(&class, classID)
-	if result.Error != nil {
-		log.Println("Error retrieving class:", result.Error)
-		if result.Error == gorm.ErrRecordNotFound {
-			return nil, &gin.Error{
-				Err:  result.Error,
-				Type: gin.ErrorTypePublic,
-				Meta: gin.H{"error": "class not found"},
-			}
-		}
-		return nil, result.Error
+	class, err := checkClassOwner(c, in.CourseID, classID)
+	if err != nil {
+		return nil, err
 	}
 
 	if in.Title != "" {
@@ -163,7 +174,7 @@ func UpdateClass(c *gin.Context, in *UpdateClassInput) (*ClassOutput, error) {
 		class.Cover = in.Cover
 	}
 
-	result = db.Save(&class)
+	result := db.Save(class)
 	if result.Error != nil {
 		log.Println("Error updating class:", result.Error)
 		return nil, result.Error
@@ -171,7 +182,7 @@ func UpdateClass(c *gin.Context, in *UpdateClassInput) (*ClassOutput, error) {
 
 	log.Printf("Updated class: %+v\n", class)
 	return &ClassOutput{
-		Class: class,
+		Class: *class,
 	}, nil
 }
 
@@ -179,6 +190,10 @@ func DeleteClass(c *gin.Context) error {
 	classID := c.Param("class_id")
 	log.Println("DeleteClass called with class_id:", classID)
 
+	if _, err := checkClassOwner(c, c.Param("course_id"), classID); err != nil {
+		return err
+	}
+
 	result := db.Delete(&course.Class{}, classID)
 	if result.Error != nil {
 		log.Println("Error deleting class:", result.Error)
@@ -2,7 +2,6 @@ package course
 
 import (
 	"log"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/loopfz/gadgeto/tonic"
@@ -14,10 +13,10 @@ import (
 
 func SetupClassImageRoutes(api *fizz.RouterGroup) {
 	imagesAPI := api.Group("/lessons/:lesson_id/images", "Images for lesson", "Images for lesson related endpoints")
-	imagesAPI.POST("", []fizz.OperationOption{fizz.Summary("Create a new image for lesson"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(CreateClassImage, 201))
+	imagesAPI.POST("", []fizz.OperationOption{fizz.Summary("Create a new image for lesson"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(CreateClassImage, 201))
 	imagesAPI.GET("/:image_id", []fizz.OperationOption{fizz.Summary("Get image by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetClassImageByID, 200))
-	imagesAPI.PUT("/:image_id", []fizz.OperationOption{fizz.Summary("Update image by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateClassImage, 200))
-	imagesAPI.DELETE("/:image_id", []fizz.OperationOption{fizz.Summary("Delete image by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeleteClassImage, 204))
+	imagesAPI.PUT("/:image_id", []fizz.OperationOption{fizz.Summary("Update image by ID"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(UpdateClassImage, 200))
+	imagesAPI.DELETE("/:image_id", []fizz.OperationOption{fizz.Summary("Delete image by ID"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(DeleteClassImage, 204))
 }
 
 type ClassImageOutput struct {
@@ -60,18 +59,13 @@ func CreateClassImage(c *gin.Context, in *CreateClassImageInput) (*ClassImageOut
 	lessonIDStr := in.LessonID
 	log.Printf("CreateClassImage called with lesson_id: %s and input: %+v\n", lessonIDStr, in)
 
-	lessonID, err := strconv.Atoi(lessonIDStr)
+	lesson, err := checkLessonOwner(c, in.CourseID, in.ClassID, lessonIDStr)
 	if err != nil {
-		log.Println("Error converting lesson_id to int:", err)
-		return nil, &gin.Error{
-			Err:  err,
-			Type: gin.ErrorTypePublic,
-			Meta: gin.H{"error": "invalid lesson_id"},
-		}
+		return nil, err
 	}
 
 	newClassImage := course.ClassImage{
-		LessonID: lessonID,
+		LessonID: lesson.Id,
 		Image:    in.Image,
 	}
 
@@ -91,8 +85,30 @@ func GetClassImageByID(c *gin.Context, params *GetClassImageByIDParams) (*ClassI
 	imageID := params.ID
 	log.Println("GetClassImageByID called with image_id:", imageID)
 
+	lesson, version, err := checkLessonAccess(c, params.CourseID, params.ClassID, params.LessonID)
+	if err != nil {
+		return nil, err
+	}
+	if version != nil {
+		id, err := parseID(imageID, "image_id")
+		if err != nil {
+			return nil, err
+		}
+		image := version.FindImage(lesson.Id, id)
+		if image == nil {
+			return nil, &gin.Error{
+				Err:  gorm.ErrRecordNotFound,
+				Type: gin.ErrorTypePublic,
+				Meta: gin.H{"error": "class image not found"},
+			}
+		}
+		return &ClassImageOutput{
+			ClassImage: *image,
+		}, nil
+	}
+
 	var classImage course.ClassImage
-	result := db.First(&classImage, imageID)
+	result := db.Where("lesson_id = ?", lesson.Id).First(&classImage, imageID)
 	if result.Error != nil {
 		log.Println("Error retrieving class image:", result.Error)
 		if result.Error == gorm.ErrRecordNotFound {
@@ -115,8 +131,13 @@ func UpdateClassImage(c *gin.Context, in *UpdateClassImageInput) (*ClassImageOut
 	imageID := in.ID
 	log.Printf("UpdateClassImage called with image_id: %s and input: %+v\n", imageID, in)
 
+	lesson, err := checkLessonOwner(c, in.CourseID, in.ClassID, in.LessonID)
+	if err != nil {
+		return nil, err
+	}
+
 	var classImage course.ClassImage
-	result := db.First(&classImage, imageID)
+	result := db.Where("lesson_id = ?", lesson.Id).First(&classImage, imageID)
 	if result.Error != nil {
 		log.Println("Error retrieving class image:", result.Error)
 		if result.Error == gorm.ErrRecordNotFound {
@@ -149,7 +170,12 @@ func DeleteClassImage(c *gin.Context) error {
 	imageID := c.Param("image_id")
 	log.Println("DeleteClassImage called with image_id:", imageID)
 
-	result := db.Delete(&course.ClassImage{}, imageID)
+	lesson, err := checkLessonOwner(c, c.Param("course_id"), c.Param("class_id"), c.Param("lesson_id"))
+	if err != nil {
+		return err
+	}
+
+	result := db.Where("lesson_id = ?", lesson.Id).Delete(&course.ClassImage{}, imageID)
 	if result.Error != nil {
 		log.Println("Error deleting class image:", result.Error)
 		return result.Error
@@ -2,12 +2,13 @@ package course
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	juju_errors "github.com/juju/errors"
 	"github.com/loopfz/gadgeto/tonic"
 	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
 	"github.com/niazlv/sport-plus-LCT/internal/database/course"
@@ -27,18 +28,24 @@ func Setup(rg *fizz.RouterGroup) {
 		log.Fatal("db courses can't be init: ", err)
 	}
 
-	api.GET("", []fizz.OperationOption{fizz.Summary("Get list of courses"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetCourses, 200))
+	api.GET("", []fizz.OperationOption{fizz.Summary("Search course catalog with filters, sorting and cursor pagination"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetCourses, 200))
 	api.GET("/:course_id", []fizz.OperationOption{fizz.Summary("Get course by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetCourseByID, 200))
-	api.POST("", []fizz.OperationOption{fizz.Summary("Create a new course"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(CreateCourse, 201))
-	api.PUT("/:course_id", []fizz.OperationOption{fizz.Summary("Update course by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateCourse, 200))
+	api.POST("", []fizz.OperationOption{fizz.Summary("Create a new course"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(CreateCourse, 201))
+	api.PUT("/:course_id", []fizz.OperationOption{fizz.Summary("Update course by ID"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(UpdateCourse, 200))
 
-	api.GET("/progress", []fizz.OperationOption{fizz.Summary("Get full client progress"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetFullClientProgress, 200))
-	api.GET("/progress/:course_id", []fizz.OperationOption{fizz.Summary("Get course progress by course ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetCourseProgress, 200))
+	api.GET("/progress", []fizz.OperationOption{fizz.Summary("Get full client progress, ?client_id= for trainer's clients"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetFullClientProgress, 200))
+	api.GET("/progress/:course_id", []fizz.OperationOption{fizz.Summary("Get course progress by course ID, ?client_id= for trainer's clients"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetCourseProgress, 200))
 	api.PUT("/progress/:course_id", []fizz.OperationOption{fizz.Summary("Update course progress by course ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateCourseProgress, 200))
 	api.PUT("/progress/:course_id/class/:class_id", []fizz.OperationOption{fizz.Summary("Update class progress by class ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateClassProgress, 200))
 	api.PUT("/progress/:course_id/class/:class_id/lesson/:lesson_id", []fizz.OperationOption{fizz.Summary("Update lesson progress by lesson ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateLessonProgress, 200))
 	api.PUT("/progress/:course_id/class/:class_id/lesson/:lesson_id/exercise/:exercise_id", []fizz.OperationOption{fizz.Summary("Update exercise progress by exercise ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateExerciseProgress, 200))
 
+	api.GET("/enrollments", []fizz.OperationOption{fizz.Summary("Get my enrollments"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetMyEnrollments, 200))
+	api.POST("/:course_id/enroll", []fizz.OperationOption{fizz.Summary("Enroll in course, joins waitlist when course is full"), auth.BearerAuth}, auth.WithAuth, clientOnly, tonic.Handler(EnrollCourse, 201))
+	api.DELETE("/:course_id/enroll", []fizz.OperationOption{fizz.Summary("Unenroll from course or leave waitlist"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UnenrollCourse, 200))
+	api.GET("/:course_id/enrollments", []fizz.OperationOption{fizz.Summary("Get course participants and waitlist"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(GetCourseEnrollments, 200))
+
+	SetupVersionRoutes(api)
 	SetupClassRoutes(api)
 }
 
@@ -46,30 +53,129 @@ type CourseOutput struct {
 	Course course.Course `json:"course"`
 }
 
-type CoursesOutput struct {
-	Courses []course.Course `json:"courses"`
-}
-
 type GetCourseByIDParams struct {
 	ID string `path:"course_id" binding:"required"`
 }
 
-func GetCourses(c *gin.Context) (*CoursesOutput, error) {
-	log.Println("GetCourses called")
+const (
+	defaultCoursesLimit = 20
+	maxCoursesLimit     = 100
+)
 
-	var courses []course.Course
-	result := db.Find(&courses)
-	if result.Error != nil {
-		log.Println("Error retrieving courses:", result.Error)
-		return nil, result.Error
+type GetCoursesParams struct {
+	Query         string `query:"q"` // полнотекстовый поиск по названию и описанию
+	Direction     string `query:"direction"`
+	Difficulty    string `query:"difficulty"`
+	MinDifficulty string `query:"min_difficulty"` // по difficulty_numeric
+	MaxDifficulty string `query:"max_difficulty"`
+	MinCost       string `query:"min_cost"`
+	MaxCost       string `query:"max_cost"`
+	TrainerID     int    `query:"trainer_id"` // тренеру по своим курсам показывает и неопубликованные
+	MinRating     string `query:"min_rating"`
+	RequiredTools string `query:"required_tools"`
+	Sort          string `query:"sort"`   // newest, relevance, rating, popularity, cost_asc, cost_desc, difficulty_asc, difficulty_desc
+	Cursor        string `query:"cursor"` // next_cursor из предыдущего ответа
+	Limit         int    `query:"limit" default:"20"`
+}
+
+type GetCoursesOutput struct {
+	Courses    []course.Course     `json:"courses"`
+	Total      int64               `json:"total"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+	Facets     course.CourseFacets `json:"facets"`
+}
+
+func GetCourses(c *gin.Context, params *GetCoursesParams) (*GetCoursesOutput, error) {
+	if params.Limit < 1 {
+		params.Limit = defaultCoursesLimit
+	}
+	if params.Limit > maxCoursesLimit {
+		params.Limit = maxCoursesLimit
+	}
+	if params.Sort != "" && !course.ValidCourseSort(params.Sort) {
+		return nil, juju_errors.BadRequestf("invalid sort: %s", params.Sort)
+	}
+	if params.Sort == course.SortRelevance && params.Query == "" {
+		return nil, juju_errors.BadRequestf("sort by relevance requires q")
+	}
+
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := course.CourseFilter{
+		Query:         params.Query,
+		Direction:     params.Direction,
+		Difficulty:    params.Difficulty,
+		TrainerID:     params.TrainerID,
+		RequiredTools: params.RequiredTools,
+		Sort:          params.Sort,
+		Cursor:        params.Cursor,
+		Limit:         params.Limit,
+		// Черновики тренера видны ему и администратору
+		Unpublished: params.TrainerID != 0 && (params.TrainerID == user.Id || user.IsAdmin()),
+	}
+	if filter.MinDifficulty, err = parseIntParam("min_difficulty", params.MinDifficulty); err != nil {
+		return nil, err
+	}
+	if filter.MaxDifficulty, err = parseIntParam("max_difficulty", params.MaxDifficulty); err != nil {
+		return nil, err
+	}
+	if filter.MinCost, err = parseFloatParam("min_cost", params.MinCost); err != nil {
+		return nil, err
+	}
+	if filter.MaxCost, err = parseFloatParam("max_cost", params.MaxCost); err != nil {
+		return nil, err
+	}
+	if filter.MinRating, err = parseFloatParam("min_rating", params.MinRating); err != nil {
+		return nil, err
+	}
+
+	page, err := course.SearchCourses(filter)
+	if err != nil {
+		if err == course.ErrInvalidCursor {
+			return nil, juju_errors.BadRequestf("invalid cursor")
+		}
+		log.Println("ERROR GetCourses(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
 	}
 
-	log.Printf("Retrieved courses: %+v\n", courses)
-	return &CoursesOutput{
-		Courses: courses,
+	if page.Courses == nil {
+		page.Courses = []course.Course{}
+	}
+	return &GetCoursesOutput{
+		Courses:    page.Courses,
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+		Facets:     page.Facets,
 	}, nil
 }
 
+// parseIntParam разбирает необязательный целый параметр запроса
+func parseIntParam(name string, value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, juju_errors.BadRequestf("invalid %s", name)
+	}
+	return &number, nil
+}
+
+// parseFloatParam разбирает необязательный дробный параметр запроса
+func parseFloatParam(name string, value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, juju_errors.BadRequestf("invalid %s", name)
+	}
+	return &number, nil
+}
+
 func GetCourseByID(c *gin.Context, params *GetCourseByIDParams) (*CourseOutput, error) {
 	idStr := params.ID
 	log.Println("GetCourseByID called with ID:", idStr)
@@ -84,23 +190,28 @@ func GetCourseByID(c *gin.Context, params *GetCourseByIDParams) (*CourseOutput,
 		}
 	}
 
-	var course *course.Course
-	course, err = database_course.GetCourseByID(id)
+	crs, err := database_course.GetCourseByID(id)
 	if err != nil {
 		log.Println("Error retrieving course:", err)
-		if err == gorm.ErrRecordNotFound {
-			return nil, &gin.Error{
-				Err:  err,
-				Type: gin.ErrorTypePublic,
-				Meta: gin.H{"error": "course not found"},
-			}
+		return nil, err
+	}
+	if crs == nil {
+		return nil, &gin.Error{
+			Err:  gorm.ErrRecordNotFound,
+			Type: gin.ErrorTypePublic,
+			Meta: gin.H{"error": "course not found"},
 		}
+	}
+
+	// Тренер видит черновик, клиенты - опубликованную версию
+	view, _, err := courseView(c, crs)
+	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("Retrieved course: %+v\n", course)
+	log.Printf("Retrieved course: %+v\n", view)
 	return &CourseOutput{
-		Course: *course,
+		Course: *view,
 	}, nil
 }
 
@@ -112,14 +223,27 @@ type CreateCourseInput struct {
 	Direction         string  `json:"direction"`
 	TrainerID         int     `json:"trainer_id"`
 	Cost              float64 `json:"cost"`
-	ParticipantsCount int     `json:"participants_count"`
-	Rating            float64 `json:"rating"`
+	Capacity          int     `json:"capacity"` // 0 - без ограничений
 	RequiredTools     string  `json:"required_tools"`
 }
 
 func CreateCourse(c *gin.Context, in *CreateCourseInput) (*CourseOutput, error) {
 	log.Printf("CreateCourse called with input: %+v\n", in)
 
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.Capacity < 0 {
+		return nil, juju_errors.BadRequestf("capacity can't be negative")
+	}
+
+	// Тренер создает курс только от своего имени, администратор может указать тренера
+	if !user.IsAdmin() || in.TrainerID == 0 {
+		in.TrainerID = user.Id
+	}
+
 	newCourse := course.Course{
 		Title:             in.Title,
 		Description:       in.Description,
@@ -128,9 +252,9 @@ func CreateCourse(c *gin.Context, in *CreateCourseInput) (*CourseOutput, error)
 		Direction:         in.Direction,
 		TrainerID:         in.TrainerID,
 		Cost:              in.Cost,
-		ParticipantsCount: in.ParticipantsCount,
-		Rating:            in.Rating,
+		Capacity:          in.Capacity,
 		RequiredTools:     in.RequiredTools,
+		Status:            course.CourseDraft, // в каталоге курс появится после публикации
 	}
 
 	result := db.Create(&newCourse)
@@ -154,8 +278,7 @@ type UpdateCourseInput struct {
 	Direction         string  `json:"direction"`
 	TrainerID         int     `json:"trainer_id"`
 	Cost              float64 `json:"cost"`
-	ParticipantsCount int     `json:"participants_count"`
-	Rating            float64 `json:"rating"`
+	Capacity          *int    `json:"capacity"` // 0 - снять ограничение
 	RequiredTools     string  `json:"required_tools"`
 }
 
@@ -163,26 +286,14 @@ func UpdateCourse(c *gin.Context, in *UpdateCourseInput) (*CourseOutput, error)
 	id := in.ID
 	log.Printf("UpdateCourse called with ID: %s and input: %+v\n", id, in)
 
-	var course course.Course
-	// result := db.First(&course, id)
-
-	idInt, err := strconv.Atoi(id)
+	courseOwned, err := checkCourseOwner(c, id)
 	if err != nil {
 		return nil, err
 	}
+	course := *courseOwned
 
-	courses, err := database_course.GetCourseByID(idInt)
-	course = *courses
-	if err != nil {
-		log.Println("Error retrieving course:", err)
-		if err == gorm.ErrRecordNotFound {
-			return nil, &gin.Error{
-				Err:  err,
-				Type: gin.ErrorTypePublic,
-				Meta: gin.H{"error": "course not found"},
-			}
-		}
-		return nil, err
+	if in.Capacity != nil && *in.Capacity < 0 {
+		return nil, juju_errors.BadRequestf("capacity can't be negative")
 	}
 
 	if in.Title != "" {
@@ -200,27 +311,46 @@ func UpdateCourse(c *gin.Context, in *UpdateCourseInput) (*CourseOutput, error)
 	if in.Direction != "" {
 		course.Direction = in.Direction
 	}
-	if in.TrainerID != 0 {
+	// Передать курс другому тренеру может только администратор
+	if in.TrainerID != 0 && in.TrainerID != course.TrainerID {
+		user, err := auth.CurrentUser(c)
+		if err != nil {
+			return nil, err
+		}
+		if !user.IsAdmin() {
+			return nil, juju_errors.Forbiddenf("only admin can change trainer_id")
+		}
 		course.TrainerID = in.TrainerID
 	}
 	if in.Cost != 0 {
 		course.Cost = in.Cost
 	}
-	if in.ParticipantsCount != 0 {
-		course.ParticipantsCount = in.ParticipantsCount
-	}
-	if in.Rating != 0 {
-		course.Rating = in.Rating
-	}
 	if in.RequiredTools != "" {
 		course.RequiredTools = in.RequiredTools
 	}
 
-	result := db.Save(&course)
+	// Число участников и вместимость меняются вместе с записями, см. SetCourseCapacity,
+	// рейтинг - вместе с отзывами, статус и версия - публикацией. Записанные
+	// клиенты видят описание курса из своей версии.
+	result := db.Omit("ParticipantsCount", "Capacity", "Rating", "ReviewsCount", "Status", "CurrentVersion", "PublishedAt").Save(&course)
 	if result.Error != nil {
 		log.Println("Error updating course:", result.Error)
 		return nil, result.Error
 	}
+	if in.Capacity != nil && *in.Capacity != course.Capacity {
+		promoted, err := database_course.SetCourseCapacity(course.Id, *in.Capacity)
+		if err != nil {
+			log.Println("ERROR UpdateCourse(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		startProgress(promoted)
+		updated, err := database_course.GetCourseByID(course.Id)
+		if err != nil {
+			log.Println("ERROR UpdateCourse(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		course = *updated
+	}
 
 	log.Printf("Updated course: %+v\n", course)
 	return &CourseOutput{
@@ -231,6 +361,7 @@ func UpdateCourse(c *gin.Context, in *UpdateCourseInput) (*CourseOutput, error)
 // Endpoint для получения прогресса курса
 type GetCourseProgressParams struct {
 	CourseID string `path:"course_id" binding:"required"`
+	ClientID int    `query:"client_id"` // для тренера: прогресс клиента из его списка
 }
 
 func GetCourseProgress(c *gin.Context, params *GetCourseProgressParams) (*course.CourseStatus, error) {
@@ -243,41 +374,22 @@ func GetCourseProgress(c *gin.Context, params *GetCourseProgressParams) (*course
 		}
 	}
 
-	claims := c.MustGet("claims").(jwt.MapClaims)
-	userClaims, err := auth.ExtractClaims(claims)
+	clientID, err := progressClientID(c, params.ClientID)
 	if err != nil {
-		return nil, errors.New(err.Error())
+		return nil, err
 	}
 
-	progress, err := course.GetClientProgressByClientAndCourseID(userClaims.ID, courseID)
-	if err != nil && err != gorm.ErrRecordNotFound {
+	if err := checkEnrolled(courseID, clientID); err != nil {
 		return nil, err
 	}
 
-	if progress == nil {
-		// Создаем новую запись прогресса, если не существует
-		newProgress := course.ClientProgress{
-			ClientID: userClaims.ID,
-			Courses: []course.CourseStatus{
-				{
-					ClientID: userClaims.ID,
-					CourseID: courseID,
-					Status:   course.StatusNotStarted,
-					Classes:  []course.ClassStatus{},
-				},
-			},
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		progress, err = course.CreateClientProgress(&newProgress)
-		if err != nil {
-			return nil, err
-		}
+	// Статусы заводятся при записи на курс, здесь дозаводятся только
+	// занятия и уроки, добавленные позже
+	progress, err := course.EnsureClientProgress(clientID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Ensure the structure is fully populated for this specific course
-	database_course.EnsureFullStructure(userClaims.ID, courseID, progress)
-
 	for _, courseStatus := range progress.Courses {
 		if courseStatus.CourseID == courseID {
 			return &courseStatus, nil
@@ -310,6 +422,11 @@ func UpdateCourseProgress(c *gin.Context, in *UpdateCourseProgressInput) (*cours
 		return nil, errors.New(err.Error())
 	}
 
+	// Прогресс отмечают только по курсам, на которые клиент записан
+	if err := checkEnrolled(courseID, userClaims.ID); err != nil {
+		return nil, err
+	}
+
 	err = course.UpdateCourseStatus(userClaims.ID, courseID, in.Status)
 	if err != nil {
 		return nil, err
@@ -352,36 +469,22 @@ type UpdateExerciseProgressInput struct {
 	ExerciseID string `path:"exercise_id"`
 }
 
-func GetFullClientProgress(c *gin.Context) (*course.ClientProgress, error) {
-	claims := c.MustGet("claims").(jwt.MapClaims)
-	userClaims, err := auth.ExtractClaims(claims)
+type GetFullClientProgressParams struct {
+	ClientID int `query:"client_id"` // для тренера: прогресс клиента из его списка
+}
+
+func GetFullClientProgress(c *gin.Context, params *GetFullClientProgressParams) (*course.ClientProgress, error) {
+	clientID, err := progressClientID(c, params.ClientID)
 	if err != nil {
-		return nil, errors.New(err.Error())
+		return nil, err
 	}
 
-	progress, err := course.GetClientProgressByClientID(userClaims.ID)
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			newProgress := course.ClientProgress{
-				ClientID:  userClaims.ID,
-				Courses:   []course.CourseStatus{},
-				CreatedAt: time.Now(),
-				UpdatedAt: time.Now(),
-			}
-			progress, err = course.CreateClientProgress(&newProgress)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			return nil, err
-		}
+	// Новые статусы заводятся только по курсам, на которые клиент записан
+	progress, err := course.EnsureClientProgress(clientID)
+	if err != nil {
+		return nil, err
 	}
 
-	database_course.EnsureFullClientStructure(userClaims.ID, progress)
-
-	// Логирование прогресса перед возвратом (для отладки)
-	// log.Println("Progress: ", progress)
-
 	return progress, nil
 }
 
@@ -410,6 +513,11 @@ func UpdateClassProgress(c *gin.Context, in *UpdateClassProgressInput) (*course.
 		return nil, errors.New(err.Error())
 	}
 
+	// Прогресс отмечают только по курсам, на которые клиент записан, и по его версии курса
+	if err := checkProgressTarget(courseID, userClaims.ID, classID, 0, 0); err != nil {
+		return nil, err
+	}
+
 	err = course.UpdateClassStatus(userClaims.ID, courseID, classID, in.Status)
 	if err != nil {
 		return nil, err
@@ -427,6 +535,11 @@ func UpdateClassProgress(c *gin.Context, in *UpdateClassProgressInput) (*course.
 }
 
 func UpdateLessonProgress(c *gin.Context, in *UpdateLessonProgressInput) (*course.ClientProgress, error) {
+	courseID, err := parseID(in.CourseID, "course_id")
+	if err != nil {
+		return nil, err
+	}
+
 	classID, err := strconv.Atoi(in.ClassID)
 	if err != nil {
 		return nil, &gin.Error{
@@ -451,23 +564,38 @@ func UpdateLessonProgress(c *gin.Context, in *UpdateLessonProgressInput) (*cours
 		return nil, errors.New(err.Error())
 	}
 
-	err = course.UpdateLessonStatus(userClaims.ID, classID, lessonID, in.Status)
+	// Прогресс отмечают только по курсам, на которые клиент записан, и по его версии курса
+	if err := checkProgressTarget(courseID, userClaims.ID, classID, lessonID, 0); err != nil {
+		return nil, err
+	}
+
+	err = course.UpdateLessonStatus(userClaims.ID, courseID, classID, lessonID, in.Status)
 	if err != nil {
 		return nil, err
 	}
 
-	progress, err := course.GetClientProgressByClientAndCourseID(userClaims.ID, classID)
+	progress, err := course.GetClientProgressByClientAndCourseID(userClaims.ID, courseID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Ensure the structure is fully populated for this specific course
-	database_course.EnsureFullStructure(userClaims.ID, classID, progress)
+	database_course.EnsureFullStructure(userClaims.ID, courseID, progress)
 
 	return progress, nil
 }
 
 func UpdateExerciseProgress(c *gin.Context, in *UpdateExerciseProgressInput) (*course.ClientProgress, error) {
+	courseID, err := parseID(in.CourseID, "course_id")
+	if err != nil {
+		return nil, err
+	}
+
+	classID, err := parseID(in.ClassID, "class_id")
+	if err != nil {
+		return nil, err
+	}
+
 	lessonID, err := strconv.Atoi(in.LessonID)
 	if err != nil {
 		return nil, &gin.Error{
@@ -492,18 +620,23 @@ func UpdateExerciseProgress(c *gin.Context, in *UpdateExerciseProgressInput) (*c
 		return nil, errors.New(err.Error())
 	}
 
-	err = course.UpdateExerciseStatus(userClaims.ID, lessonID, exerciseID, in.Status)
+	// Прогресс отмечают только по курсам, на которые клиент записан, и по его версии курса
+	if err := checkProgressTarget(courseID, userClaims.ID, classID, lessonID, exerciseID); err != nil {
+		return nil, err
+	}
+
+	err = course.UpdateExerciseStatus(userClaims.ID, courseID, classID, lessonID, exerciseID, in.Status)
 	if err != nil {
 		return nil, err
 	}
 
-	progress, err := course.GetClientProgressByClientAndCourseID(userClaims.ID, lessonID)
+	progress, err := course.GetClientProgressByClientAndCourseID(userClaims.ID, courseID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Ensure the structure is fully populated for this specific course
-	database_course.EnsureFullStructure(userClaims.ID, lessonID, progress)
+	database_course.EnsureFullStructure(userClaims.ID, courseID, progress)
 
 	return progress, nil
 }
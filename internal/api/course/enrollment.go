@@ -0,0 +1,116 @@
+package course
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	juju_errors "github.com/juju/errors"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/api/chat"
+	"github.com/niazlv/sport-plus-LCT/internal/database/course"
+)
+
+type EnrollmentOutput struct {
+	Enrollment course.Enrollment `json:"enrollment"`
+}
+
+type EnrollmentsOutput struct {
+	Enrollments []course.Enrollment `json:"enrollments"`
+}
+
+type EnrollmentParams struct {
+	CourseID string `path:"course_id" binding:"required"`
+}
+
+func EnrollCourse(c *gin.Context, params *EnrollmentParams) (*EnrollmentOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	crs, err := findCourse(params.CourseID)
+	if err != nil {
+		return nil, err
+	}
+	// На платный курс записывает оплата заказа
+	if crs.Cost > 0 {
+		return nil, juju_errors.BadRequestf("course is paid, use POST /v1/payments/checkout")
+	}
+
+	enrollment, err := course.Enroll(crs.Id, user.Id)
+	if err != nil {
+		if err == course.ErrAlreadyEnrolled {
+			return nil, juju_errors.NewAlreadyExists(nil, err.Error())
+		}
+		if err == course.ErrCourseNotPublished {
+			return nil, juju_errors.BadRequestf("%s", err.Error())
+		}
+		log.Println("ERROR EnrollCourse(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	startProgress([]course.Enrollment{*enrollment})
+	return &EnrollmentOutput{Enrollment: *enrollment}, nil
+}
+
+func UnenrollCourse(c *gin.Context, params *EnrollmentParams) (*EnrollmentOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	crs, err := findCourse(params.CourseID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Прогресс остается: при повторной записи клиент продолжит с того же места
+	cancelled, promoted, err := course.Unenroll(crs.Id, user.Id)
+	if err != nil {
+		if err == course.ErrNotEnrolled {
+			return nil, juju_errors.NotFoundf("enrollment")
+		}
+		log.Println("ERROR UnenrollCourse(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	startProgress(promoted)
+	chat.LeaveCourseChat(crs.Id, user.Id)
+	return &EnrollmentOutput{Enrollment: *cancelled}, nil
+}
+
+func GetMyEnrollments(c *gin.Context) (*EnrollmentsOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	enrollments, err := course.FindEnrollmentsByClientID(user.Id)
+	if err != nil {
+		log.Println("ERROR GetMyEnrollments(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &EnrollmentsOutput{Enrollments: enrollments}, nil
+}
+
+func GetCourseEnrollments(c *gin.Context, params *EnrollmentParams) (*EnrollmentsOutput, error) {
+	crs, err := checkCourseOwner(c, params.CourseID)
+	if err != nil {
+		return nil, err
+	}
+	enrollments, err := course.FindEnrollmentsByCourseID(crs.Id)
+	if err != nil {
+		log.Println("ERROR GetCourseEnrollments(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &EnrollmentsOutput{Enrollments: enrollments}, nil
+}
+
+// startProgress заводит статусы прогресса для активированных записей.
+// Ошибка не отменяет запись: статусы дозаведутся при первом чтении прогресса.
+func startProgress(enrollments []course.Enrollment) {
+	for _, enrollment := range enrollments {
+		if enrollment.Status != course.EnrollmentActive {
+			continue
+		}
+		if _, err := course.EnsureClientProgress(enrollment.ClientID); err != nil {
+			log.Println("ERROR startProgress(): ", err)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package course
 
 import (
 	"log"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/loopfz/gadgeto/tonic"
@@ -17,9 +16,9 @@ func SetupLessonRoutes(api *fizz.RouterGroup) {
 	lessonsAPI := api.Group("/:class_id/lessons", "Lessons", "Lessons related endpoints")
 	lessonsAPI.GET("", []fizz.OperationOption{fizz.Summary("Get list of lessons for a class"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetLessons, 200))
 	lessonsAPI.GET("/:lesson_id", []fizz.OperationOption{fizz.Summary("Get lesson by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetLessonByID, 200))
-	lessonsAPI.POST("", []fizz.OperationOption{fizz.Summary("Create a new lesson"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(CreateLesson, 201))
-	lessonsAPI.PUT("/:lesson_id", []fizz.OperationOption{fizz.Summary("Update lesson by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateLesson, 200))
-	lessonsAPI.DELETE("/:lesson_id", []fizz.OperationOption{fizz.Summary("Delete lesson by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeleteLesson, 204))
+	lessonsAPI.POST("", []fizz.OperationOption{fizz.Summary("Create a new lesson"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(CreateLesson, 201))
+	lessonsAPI.PUT("/:lesson_id", []fizz.OperationOption{fizz.Summary("Update lesson by ID"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(UpdateLesson, 200))
+	lessonsAPI.DELETE("/:lesson_id", []fizz.OperationOption{fizz.Summary("Delete lesson by ID"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(DeleteLesson, 204))
 
 	SetupClassImageRoutes(lessonsAPI)
 }
@@ -66,8 +65,34 @@ func GetLessons(c *gin.Context, params *GetLessonsParams) (*LessonsOutput, error
 	classID := params.ClassID
 	log.Println("GetLessons called with class_id:", classID)
 
+	crs, version, err := checkCourseAccess(c, params.CourseID)
+	if err != nil {
+		return nil, err
+	}
+	if version != nil {
+		id, err := parseID(classID, "class_id")
+		if err != nil {
+			return nil, err
+		}
+		class := version.FindClass(id)
+		if class == nil {
+			return nil, &gin.Error{
+				Err:  gorm.ErrRecordNotFound,
+				Type: gin.ErrorTypePublic,
+				Meta: gin.H{"error": "class not found"},
+			}
+		}
+		return &LessonsOutput{
+			Lessons: class.Lessons,
+		}, nil
+	}
+	class, err := findClass(crs, classID)
+	if err != nil {
+		return nil, err
+	}
+
 	var lessons []course.Lesson
-	result := db.Preload("Exercise.Photos").Where("class_id = ?", classID).Find(&lessons)
+	result := db.Preload("Exercises.Exercise.Photos").Where("class_id = ?", class.Id).Find(&lessons)
 	if result.Error != nil {
 		log.Println("Error retrieving lessons:", result.Error)
 		return nil, result.Error
@@ -83,8 +108,18 @@ func GetLessonByID(c *gin.Context, params *GetLessonByIDParams) (*LessonOutput,
 	lessonID := params.ID
 	log.Println("GetLessonByID called with lesson_id:", lessonID)
 
+	found, version, err := checkLessonAccess(c, params.CourseID, params.ClassID, lessonID)
+	if err != nil {
+		return nil, err
+	}
+	if version != nil {
+		return &LessonOutput{
+			Lesson: *found,
+		}, nil
+	}
+
 	var lesson course.Lesson
-	result := db.Preload("Exercise.Photos").First(&lesson, lessonID)
+	result := db.Preload("Exercises.Exercise.Photos").First(&lesson, found.Id)
 	if result.Error != nil {
 		log.Println("Error retrieving lesson:", result.Error)
 		if result.Error == gorm.ErrRecordNotFound {
@@ -107,27 +142,9 @@ func CreateLesson(c *gin.Context, in *CreateLessonInput) (*LessonOutput, error)
 	classIDStr := in.ClassID
 	log.Printf("CreateLesson called with class_id: %s and input: %+v\n", classIDStr, in)
 
-	classID, err := strconv.Atoi(classIDStr)
+	class, err := checkClassOwner(c, in.CourseID, classIDStr)
 	if err != nil {
-		log.Println("Error converting class_id to int:", err)
-		return nil, &gin.Error{
-			Err:  err,
-			Type: gin.ErrorTypePublic,
-			Meta: gin.H{"error": "invalid class_id"},
-		}
-	}
-
-	courseIDStr := in.CourseID
-	log.Printf("CreateLesson called with course_id: %s and input: %+v\n", courseIDStr, in)
-
-	courseID, err := strconv.Atoi(courseIDStr)
-	if err != nil {
-		log.Println("Error converting course_id to int:", err)
-		return nil, &gin.Error{
-			Err:  err,
-			Type: gin.ErrorTypePublic,
-			Meta: gin.H{"error": "invalid course_id"},
-		}
+		return nil, err
 	}
 
 	var exercises []course.LessonExercise
@@ -148,8 +165,8 @@ func CreateLesson(c *gin.Context, in *CreateLessonInput) (*LessonOutput, error)
 	}
 
 	newLesson := course.Lesson{
-		CourseID:        courseID,
-		ClassID:         classID,
+		CourseID:        class.CourseID,
+		ClassID:         class.Id,
 		DurationSeconds: in.DurationSeconds,
 		Exercises:       exercises,
 	}
@@ -170,18 +187,9 @@ func UpdateLesson(c *gin.Context, in *UpdateLessonInput) (*LessonOutput, error)
 	lessonID := in.ID
 	log.Printf("UpdateLesson called with lesson_id: %s and input: %+v\n", lessonID, in)
 
-	var lesson course.Lesson
-	result := db.First(&lesson, lessonID)
-	if result.Error != nil {
-		log.Println("Error retrieving lesson:", result.Error)
-		if result.Error == gorm.ErrRecordNotFound {
-			return nil, &gin.Error{
-				Err:  result.Error,
-				Type: gin.ErrorTypePublic,
-				Meta: gin.H{"error": "lesson not found"},
-			}
-		}
-		return nil, result.Error
+	lesson, err := checkLessonOwner(c, in.CourseID, in.ClassID, lessonID)
+	if err != nil {
+		return nil, err
 	}
 
 	if in.DurationSeconds != 0 {
@@ -204,15 +212,15 @@ func UpdateLesson(c *gin.Context, in *UpdateLessonInput) (*LessonOutput, error)
 		lesson.Exercises = exercises
 	}
 
-	result = db.Save(&lesson)
-	if result.Error != nil {
-		log.Println("Error updating lesson:", result.Error)
-		return nil, result.Error
+	// Меняется только черновик, клиенты увидят урок после публикации
+	if err := course.UpdateLesson(lesson); err != nil {
+		log.Println("Error updating lesson:", err)
+		return nil, err
 	}
 
 	log.Printf("Updated lesson: %+v\n", lesson)
 	return &LessonOutput{
-		Lesson: lesson,
+		Lesson: *lesson,
 	}, nil
 }
 
@@ -220,6 +228,10 @@ func DeleteLesson(c *gin.Context) error {
 	lessonID := c.Param("lesson_id")
 	log.Println("DeleteLesson called with lesson_id:", lessonID)
 
+	if _, err := checkLessonOwner(c, c.Param("course_id"), c.Param("class_id"), lessonID); err != nil {
+		return err
+	}
+
 	result := db.Delete(&course.Lesson{}, lessonID)
 	if result.Error != nil {
 		log.Println("Error deleting lesson:", result.Error)
@@ -0,0 +1,131 @@
+package course
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	juju_errors "github.com/juju/errors"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/database/course"
+	"github.com/wI2L/fizz"
+)
+
+func SetupVersionRoutes(api *fizz.RouterGroup) {
+	api.POST("/:course_id/publish", []fizz.OperationOption{fizz.Summary("Publish course draft as a new version"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(PublishCourse, 200))
+	api.POST("/:course_id/unpublish", []fizz.OperationOption{fizz.Summary("Remove course from catalog, enrolled clients keep their version"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(UnpublishCourse, 200))
+	api.GET("/:course_id/versions", []fizz.OperationOption{fizz.Summary("Get published versions of course"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(GetCourseVersions, 200))
+	api.GET("/:course_id/versions/:version", []fizz.OperationOption{fizz.Summary("Get published version with classes and lessons"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(GetCourseVersion, 200))
+	api.POST("/:course_id/upgrade", []fizz.OperationOption{fizz.Summary("Move my enrollment to the latest course version, progress is carried over"), auth.BearerAuth}, auth.WithAuth, clientOnly, tonic.Handler(UpgradeCourseVersion, 200))
+}
+
+type VersionOutput struct {
+	Version course.CourseVersion `json:"version"`
+}
+
+type VersionsOutput struct {
+	Versions []course.CourseVersion `json:"versions"`
+}
+
+type VersionParams struct {
+	CourseID string `path:"course_id" binding:"required"`
+	Version  int    `path:"version" binding:"required"`
+}
+
+// PublishCourse публикует черновик: записанные клиенты остаются на своих
+// версиях, новые записи получают эту
+func PublishCourse(c *gin.Context, params *EnrollmentParams) (*VersionOutput, error) {
+	crs, err := checkCourseOwner(c, params.CourseID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := course.PublishCourse(crs.Id, user.Id)
+	if err != nil {
+		if err == course.ErrEmptyCourse {
+			return nil, juju_errors.BadRequestf("%s", err.Error())
+		}
+		log.Println("ERROR PublishCourse(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	log.Printf("course %d published as version %d\n", crs.Id, version.Version)
+	return &VersionOutput{Version: *version}, nil
+}
+
+func UnpublishCourse(c *gin.Context, params *EnrollmentParams) (*CourseOutput, error) {
+	crs, err := checkCourseOwner(c, params.CourseID)
+	if err != nil {
+		return nil, err
+	}
+	if err := course.UnpublishCourse(crs.Id); err != nil {
+		if err == course.ErrCourseNotPublished {
+			return nil, juju_errors.BadRequestf("%s", err.Error())
+		}
+		log.Println("ERROR UnpublishCourse(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	crs.Status = course.CourseUnpublished
+	return &CourseOutput{Course: *crs}, nil
+}
+
+func GetCourseVersions(c *gin.Context, params *EnrollmentParams) (*VersionsOutput, error) {
+	crs, err := checkCourseOwner(c, params.CourseID)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := course.FindCourseVersions(crs.Id)
+	if err != nil {
+		log.Println("ERROR GetCourseVersions(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &VersionsOutput{Versions: versions}, nil
+}
+
+func GetCourseVersion(c *gin.Context, params *VersionParams) (*VersionOutput, error) {
+	crs, err := checkCourseOwner(c, params.CourseID)
+	if err != nil {
+		return nil, err
+	}
+	version, err := course.FindCourseVersion(crs.Id, params.Version)
+	if err != nil {
+		log.Println("ERROR GetCourseVersion(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if version == nil {
+		return nil, juju_errors.NotFoundf("version")
+	}
+	return &VersionOutput{Version: *version}, nil
+}
+
+// UpgradeCourseVersion переводит клиента на последнюю версию курса по его
+// желанию. Пройденное в сохранившихся уроках и упражнениях остается.
+func UpgradeCourseVersion(c *gin.Context, params *EnrollmentParams) (*EnrollmentOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	crs, err := findCourse(params.CourseID)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment, err := course.UpgradeEnrollment(crs.Id, user.Id)
+	if err != nil {
+		switch err {
+		case course.ErrNotEnrolled:
+			return nil, juju_errors.NotFoundf("enrollment")
+		case course.ErrNoUpgrade:
+			return nil, juju_errors.BadRequestf("%s", err.Error())
+		}
+		log.Println("ERROR UpgradeCourseVersion(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	// Дозаводим статусы для новых занятий и уроков
+	startProgress([]course.Enrollment{*enrollment})
+	return &EnrollmentOutput{Enrollment: *enrollment}, nil
+}
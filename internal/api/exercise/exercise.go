@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/loopfz/gadgeto/tonic"
 	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database_auth "github.com/niazlv/sport-plus-LCT/internal/database/auth"
 	"github.com/niazlv/sport-plus-LCT/internal/database/exercise"
 	exercise_class "github.com/niazlv/sport-plus-LCT/internal/database/exercise"
 	"github.com/wI2L/fizz"
@@ -16,6 +17,9 @@ import (
 
 var db *gorm.DB
 
+// trainerOrAdmin - роли, которым разрешено менять каталог упражнений
+var trainerOrAdmin = auth.RequireRole(database_auth.RoleTrainer, database_auth.RoleAdmin)
+
 func Setup(rg *fizz.RouterGroup) {
 	api := rg.Group("exercise", "Exercise", "Exercise related endpoints")
 
@@ -28,9 +32,9 @@ func Setup(rg *fizz.RouterGroup) {
 	api.GET("", []fizz.OperationOption{fizz.Summary("Get list of exercises"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetExercises, 200))
 	api.GET("/:exercise_id", []fizz.OperationOption{fizz.Summary("Get exercise by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetExerciseByID, 200))
 	api.GET("/filter", []fizz.OperationOption{fizz.Summary("Filter exercises"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(FilterExercises, 200))
-	api.POST("", []fizz.OperationOption{fizz.Summary("Create a new exercise"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(CreateExercise, 201))
-	api.PUT("/:exercise_id", []fizz.OperationOption{fizz.Summary("Update exercise by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateExercise, 200))
-	api.DELETE("/:exercise_id", []fizz.OperationOption{fizz.Summary("Delete exercise by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeleteExercise, 204))
+	api.POST("", []fizz.OperationOption{fizz.Summary("Create a new exercise"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(CreateExercise, 201))
+	api.PUT("/:exercise_id", []fizz.OperationOption{fizz.Summary("Update exercise by ID"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(UpdateExercise, 200))
+	api.DELETE("/:exercise_id", []fizz.OperationOption{fizz.Summary("Delete exercise by ID"), auth.BearerAuth}, auth.WithAuth, trainerOrAdmin, tonic.Handler(DeleteExercise, 204))
 }
 
 type ExerciseOutput struct {
@@ -143,6 +147,11 @@ type CreateExerciseInput struct {
 func CreateExercise(c *gin.Context, in *CreateExerciseInput) (*ExerciseOutput, error) {
 	log.Printf("CreateExercise called with input: %+v\n", in)
 
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
 	photos := make([]exercise.Photo, len(in.Photos))
 	for i, url := range in.Photos {
 		photos[i] = exercise.Photo{URL: url}
@@ -158,6 +167,7 @@ func CreateExercise(c *gin.Context, in *CreateExerciseInput) (*ExerciseOutput, e
 		Difficulty:       in.Difficulty,
 		Photos:           photos,
 		Duration:         in.Duration,
+		AuthorID:         user.Id,
 	}
 
 	result := db.Create(&newExercise)
@@ -203,6 +213,11 @@ func UpdateExercise(c *gin.Context, in *UpdateExerciseInput) (*ExerciseOutput, e
 		return nil, result.Error
 	}
 
+	// Тренер может менять только свои упражнения, администратор - любые
+	if err := auth.CheckOwner(c, exercise.AuthorID); err != nil {
+		return nil, err
+	}
+
 	if in.OriginalUri != "" {
 		exercise.OriginalUri = in.OriginalUri
 	}
@@ -265,7 +280,25 @@ func DeleteExercise(c *gin.Context, params *DeleteExerciseParams) error {
 		}
 	}
 
-	result := db.Delete(&exercise.Exercise{}, id)
+	var existing exercise.Exercise
+	result := db.First(&existing, id)
+	if result.Error != nil {
+		log.Println("Error retrieving exercise:", result.Error)
+		if result.Error == gorm.ErrRecordNotFound {
+			return gin.Error{
+				Err:  result.Error,
+				Type: gin.ErrorTypePublic,
+				Meta: gin.H{"error": "exercise not found"},
+			}
+		}
+		return result.Error
+	}
+
+	if err := auth.CheckOwner(c, existing.AuthorID); err != nil {
+		return err
+	}
+
+	result = db.Delete(&exercise.Exercise{}, id)
 	if result.Error != nil {
 		log.Println("Error deleting exercise:", result.Error)
 		return result.Error
@@ -0,0 +1,464 @@
+package payment
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/api/chat"
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+	database_auth "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	database_course "github.com/niazlv/sport-plus-LCT/internal/database/course"
+	database_payment "github.com/niazlv/sport-plus-LCT/internal/database/payment"
+	payment_provider "github.com/niazlv/sport-plus-LCT/internal/payment"
+	"github.com/wI2L/fizz"
+)
+
+var provider payment_provider.PaymentProvider
+
+func Setup(rg *fizz.RouterGroup) {
+	api := rg.Group("payments", "Payments", "Course checkout, refunds and receipts")
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatal("config can't be loaded: ", err)
+	}
+	if _, err := database_payment.InitDB(); err != nil {
+		log.Fatal("db payments can't be init: ", err)
+	}
+	provider, err = payment_provider.New(cfg)
+	if err != nil {
+		log.Fatal("payment provider can't be init: ", err)
+	}
+	go retryUnfulfilledOrders()
+
+	api.POST("/checkout", []fizz.OperationOption{fizz.Summary("Create order for paid course and get payment page URL"), auth.BearerAuth}, auth.WithAuth, auth.RequireRole(database_auth.RoleClient), tonic.Handler(postCheckout, 201))
+	api.POST("/webhook", []fizz.OperationOption{fizz.Summary("Payment provider notification")}, tonic.Handler(postWebhook, 200))
+	api.GET("/orders", []fizz.OperationOption{fizz.Summary("Get my orders"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(getOrders, 200))
+	api.GET("/orders/:id", []fizz.OperationOption{fizz.Summary("Get order with receipts, refreshes pending payment status"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(getOrder, 200))
+	api.POST("/orders/:id/refund", []fizz.OperationOption{fizz.Summary("Refund paid order and unenroll client, course trainer or admin"), auth.BearerAuth}, auth.WithAuth, auth.RequireRole(database_auth.RoleTrainer, database_auth.RoleAdmin), tonic.Handler(postRefund, 200))
+	api.GET("/receipts/:id", []fizz.OperationOption{fizz.Summary("Download receipt"), auth.BearerAuth}, auth.WithAuth, getReceipt)
+}
+
+type OrderOutput struct {
+	Order    database_payment.Order     `json:"order"`
+	Receipts []database_payment.Receipt `json:"receipts"`
+}
+
+func newOrderOutput(order *database_payment.Order) (*OrderOutput, error) {
+	receipts, err := database_payment.FindReceiptsByOrderID(order.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &OrderOutput{Order: *order, Receipts: receipts}, nil
+}
+
+type CheckoutInput struct {
+	CourseID int `json:"courseId" validate:"required"`
+}
+
+// postCheckout создает заказ и платеж у провайдера. Клиента нужно
+// перенаправить на order.confirmationUrl, запись на курс появится после
+// подтверждения оплаты.
+func postCheckout(c *gin.Context, in *CheckoutInput) (*OrderOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	crs, err := database_course.GetCourseByID(in.CourseID)
+	if err != nil {
+		log.Println("ERROR postCheckout(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if crs == nil {
+		return nil, errors.NotFoundf("course")
+	}
+	if crs.Status != database_course.CoursePublished {
+		return nil, errors.BadRequestf("%s", database_course.ErrCourseNotPublished.Error())
+	}
+	// Название в платеже и чеке - из опубликованной версии, а не из черновика
+	if crs, err = database_course.PublishedCard(crs); err != nil {
+		log.Println("ERROR postCheckout(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	amount := payment_provider.ToMinor(crs.Cost)
+	if amount <= 0 {
+		return nil, errors.BadRequestf("course is free, enroll at POST /v1/course/%d/enroll", crs.Id)
+	}
+
+	enrollment, err := database_course.FindEnrollment(crs.Id, user.Id)
+	if err != nil {
+		log.Println("ERROR postCheckout(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if enrollment != nil {
+		return nil, errors.NewAlreadyExists(nil, database_course.ErrAlreadyEnrolled.Error())
+	}
+	// В лист ожидания за деньги не записываем
+	if crs.Capacity > 0 && crs.ParticipantsCount >= crs.Capacity {
+		return nil, errors.BadRequestf("course is full")
+	}
+
+	// Повторный checkout возвращает начатый заказ, если цена не менялась
+	pending, err := database_payment.FindPendingOrder(user.Id, crs.Id)
+	if err != nil {
+		log.Println("ERROR postCheckout(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if pending != nil {
+		if pending.Amount == amount && pending.Provider == provider.Name() && pending.PaymentID != "" {
+			return newOrderOutput(pending)
+		}
+		cancelOrder(pending)
+	}
+
+	order, err := database_payment.CreateOrder(&database_payment.Order{
+		UserID:   user.Id,
+		CourseID: crs.Id,
+		Amount:   amount,
+		Currency: payment_provider.CurrencyRUB,
+		Status:   database_payment.OrderPending,
+		Provider: provider.Name(),
+	})
+	if err != nil {
+		log.Println("ERROR postCheckout(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	payment, err := provider.CreatePayment(&payment_provider.CreateRequest{
+		OrderID:        order.ID,
+		Amount:         order.Amount,
+		Currency:       order.Currency,
+		Description:    fmt.Sprintf("Курс «%s», заказ %d", crs.Title, order.ID),
+		ReturnURL:      fmt.Sprintf("%s/courses/%d?order=%d", auth.PublicURL(), crs.Id, order.ID),
+		IdempotenceKey: fmt.Sprintf("order-%d", order.ID),
+	})
+	if err != nil {
+		log.Println("ERROR postCheckout(), provider: ", err)
+		cancelOrder(order)
+		return nil, fmt.Errorf("PAYMENT PROVIDER ERROR")
+	}
+	if err := database_payment.SetOrderPayment(order, payment.ID, payment.ConfirmationURL); err != nil {
+		log.Println("ERROR postCheckout(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if err := applyPayment(order, payment); err != nil {
+		log.Println("ERROR postCheckout(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return newOrderOutput(order)
+}
+
+type webhookOutput struct {
+	Status string `json:"status"`
+}
+
+// postWebhook принимает уведомление провайдера. Статус платежа
+// перечитывается у провайдера, поэтому поддельное уведомление ничего не изменит.
+func postWebhook(c *gin.Context) (*webhookOutput, error) {
+	paymentID, err := provider.ParseWebhook(c.Request)
+	if err != nil {
+		return nil, errors.BadRequestf("%s", err.Error())
+	}
+
+	order, err := database_payment.FindOrderByPaymentID(provider.Name(), paymentID)
+	if err != nil {
+		log.Println("ERROR postWebhook(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	// На неизвестный платеж отвечаем 200, иначе провайдер будет повторять уведомление
+	if order == nil {
+		log.Println("postWebhook(): unknown payment ", paymentID)
+		return &webhookOutput{Status: "ignored"}, nil
+	}
+
+	if err := syncOrder(order); err != nil {
+		log.Println("ERROR postWebhook(): ", err)
+		return nil, fmt.Errorf("PAYMENT PROVIDER ERROR")
+	}
+	return &webhookOutput{Status: "ok"}, nil
+}
+
+type OrdersOutput struct {
+	Orders []database_payment.Order `json:"orders"`
+}
+
+func getOrders(c *gin.Context) (*OrdersOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := database_payment.FindOrdersByUserID(user.Id)
+	if err != nil {
+		log.Println("ERROR getOrders(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &OrdersOutput{Orders: orders}, nil
+}
+
+type OrderIDInput struct {
+	ID int `path:"id" validate:"required"`
+}
+
+// getOrder отдает заказ покупателю или администратору. Неоплаченный заказ
+// сверяется с провайдером: клиент возвращается со страницы оплаты раньше
+// уведомления, а уведомление может и потеряться. Оплаченный без записи на
+// курс дозаписывается.
+func getOrder(c *gin.Context, in *OrderIDInput) (*OrderOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	order, err := database_payment.FindOrderByID(in.ID)
+	if err != nil {
+		log.Println("ERROR getOrder(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if order == nil || (order.UserID != user.Id && !user.IsAdmin()) {
+		return nil, errors.NotFoundf("order")
+	}
+
+	if order.Provider == provider.Name() {
+		if err := syncOrder(order); err != nil {
+			log.Println("ERROR getOrder(), sync: ", err)
+		}
+	}
+	return newOrderOutput(order)
+}
+
+type RefundInput struct {
+	ID     int    `path:"id" validate:"required"`
+	Reason string `json:"reason"`
+}
+
+func postRefund(c *gin.Context, in *RefundInput) (*OrderOutput, error) {
+	order, err := database_payment.FindOrderByID(in.ID)
+	if err != nil {
+		log.Println("ERROR postRefund(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if order == nil {
+		return nil, errors.NotFoundf("order")
+	}
+	crs, err := database_course.GetCourseByID(order.CourseID)
+	if err != nil {
+		log.Println("ERROR postRefund(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	trainerID := 0
+	if crs != nil {
+		trainerID = crs.TrainerID
+	}
+	if err := auth.CheckOwner(c, trainerID); err != nil {
+		return nil, err
+	}
+
+	if order.Status != database_payment.OrderPaid {
+		return nil, errors.BadRequestf("only paid orders can be refunded, order is %s", order.Status)
+	}
+	if order.Provider != provider.Name() {
+		return nil, errors.BadRequestf("order was paid with %s, refund it there", order.Provider)
+	}
+	if err := refundOrder(order, strings.TrimSpace(in.Reason)); err != nil {
+		log.Println("ERROR postRefund(): ", err)
+		return nil, fmt.Errorf("PAYMENT PROVIDER ERROR")
+	}
+	return newOrderOutput(order)
+}
+
+// syncOrder перечитывает платеж у провайдера и применяет его статус.
+// Оплаченный заказ, по которому клиента не записали, дозаписывается.
+func syncOrder(order *database_payment.Order) error {
+	if order.Status == database_payment.OrderPaid && order.FulfilledAt == nil {
+		return fulfillOrder(order)
+	}
+	if order.Status != database_payment.OrderPending || order.PaymentID == "" {
+		return nil
+	}
+	payment, err := provider.GetPayment(order.PaymentID)
+	if err != nil {
+		return err
+	}
+	return applyPayment(order, payment)
+}
+
+// retryUnfulfilledOrders дозаписывает клиентов по заказам, оплаченным до
+// перезапуска, если запись тогда не удалась
+func retryUnfulfilledOrders() {
+	orders, err := database_payment.FindUnfulfilledOrders(provider.Name())
+	if err != nil {
+		log.Println("ERROR retryUnfulfilledOrders(): ", err)
+		return
+	}
+	for i := range orders {
+		if err := fulfillOrder(&orders[i]); err != nil {
+			log.Println("ERROR retryUnfulfilledOrders(), order ", orders[i].ID, ": ", err)
+		}
+	}
+}
+
+func applyPayment(order *database_payment.Order, payment *payment_provider.Payment) error {
+	switch payment.Status {
+	case payment_provider.StatusSucceeded:
+		return completeOrder(order)
+	case payment_provider.StatusCanceled:
+		cancelOrder(order)
+	}
+	return nil
+}
+
+func cancelOrder(order *database_payment.Order) {
+	_, err := database_payment.TransitionOrder(order, database_payment.OrderPending, database_payment.OrderCanceled,
+		map[string]interface{}{"canceled_at": time.Now(), "confirmation_url": ""})
+	if err != nil {
+		log.Println("ERROR cancelOrder(): ", err)
+	}
+}
+
+// completeOrder отмечает заказ оплаченным и записывает клиента
+func completeOrder(order *database_payment.Order) error {
+	_, err := database_payment.TransitionOrder(order, database_payment.OrderPending, database_payment.OrderPaid,
+		map[string]interface{}{"paid_at": time.Now(), "confirmation_url": ""})
+	if err != nil {
+		return err
+	}
+	if order.Status != database_payment.OrderPaid || order.FulfilledAt != nil {
+		return nil
+	}
+	return fulfillOrder(order)
+}
+
+// fulfillOrder выдает чек и записывает клиента на оплаченный курс. Если места
+// кончились, пока клиент платил, деньги сразу возвращаются. При ошибке заказ
+// остается оплаченным без FulfilledAt, и повтор начнется с того же места.
+func fulfillOrder(order *database_payment.Order) error {
+	issued, err := database_payment.HasReceipt(order.ID, database_payment.ReceiptPayment)
+	if err != nil {
+		return err
+	}
+	if !issued {
+		if err := issueReceipt(order, database_payment.ReceiptPayment, order.PaymentID); err != nil {
+			return err
+		}
+	}
+
+	enrollment, err := database_course.Enroll(order.CourseID, order.UserID)
+	switch {
+	case err == database_course.ErrAlreadyEnrolled:
+		// Клиента уже записали вручную или прошлой попыткой, оплата остается за ним
+	case err == database_course.ErrCourseNotPublished:
+		// Курс сняли с каталога, пока клиент платил
+		return refundOrder(order, err.Error())
+	case err != nil:
+		return err
+	case enrollment.Status == database_course.EnrollmentWaitlisted:
+		return refundOrder(order, "course is full")
+	}
+	if err := database_payment.MarkOrderFulfilled(order); err != nil {
+		return err
+	}
+
+	if _, err := database_course.EnsureClientProgress(order.UserID); err != nil {
+		log.Println("ERROR fulfillOrder(), progress: ", err)
+	}
+	return nil
+}
+
+// refundOrder возвращает деньги, закрывает запись на курс и выдает чек возврата
+func refundOrder(order *database_payment.Order, reason string) error {
+	refund, err := provider.Refund(order.PaymentID, order.Amount, order.Currency, fmt.Sprintf("refund-%d", order.ID))
+	if err != nil {
+		return err
+	}
+	// Возврат в ЮKassa может быть в обработке, но отменить его уже нельзя
+	changed, err := database_payment.TransitionOrder(order, database_payment.OrderPaid, database_payment.OrderRefunded,
+		map[string]interface{}{"refund_id": refund.ID, "refund_reason": reason, "refunded_at": time.Now()})
+	if err != nil || !changed {
+		return err
+	}
+
+	if _, _, err := database_course.Unenroll(order.CourseID, order.UserID); err != nil && err != database_course.ErrNotEnrolled {
+		return err
+	}
+	chat.LeaveCourseChat(order.CourseID, order.UserID)
+	return issueReceipt(order, database_payment.ReceiptRefund, refund.ID)
+}
+
+func issueReceipt(order *database_payment.Order, kind string, paymentID string) error {
+	receipt := &database_payment.Receipt{
+		OrderID:   order.ID,
+		UserID:    order.UserID,
+		Kind:      kind,
+		Amount:    order.Amount,
+		Currency:  order.Currency,
+		Provider:  order.Provider,
+		PaymentID: paymentID,
+	}
+	if user, err := database_auth.FindUserByID(order.UserID); err == nil && user != nil {
+		receipt.BuyerName = user.Name
+		receipt.BuyerEmail = user.Email
+	}
+	if crs, err := database_course.GetCourseByID(order.CourseID); err == nil && crs != nil {
+		if card, err := database_course.PublishedCard(crs); err == nil {
+			crs = card
+		}
+		receipt.Description = fmt.Sprintf("Курс «%s»", crs.Title)
+	}
+	_, err := database_payment.CreateReceipt(receipt)
+	return err
+}
+
+// getReceipt отдает чек текстовым файлом. Обычный gin-обработчик: tonic
+// дописал бы ответ после файла.
+func getReceipt(c *gin.Context) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		auth.AbortWithError(c, err)
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		auth.AbortWithError(c, errors.BadRequestf("invalid receipt id"))
+		return
+	}
+	receipt, err := database_payment.FindReceiptByID(id)
+	if err != nil {
+		log.Println("ERROR getReceipt(): ", err)
+		auth.AbortWithError(c, fmt.Errorf("DATABASE ERROR"))
+		return
+	}
+	if receipt == nil || (receipt.UserID != user.Id && !user.IsAdmin()) {
+		auth.AbortWithError(c, errors.NotFoundf("receipt"))
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="receipt-%s.txt"`, receipt.Number()))
+	c.Data(200, "text/plain; charset=utf-8", []byte(renderReceipt(receipt)))
+}
+
+func renderReceipt(receipt *database_payment.Receipt) string {
+	title := "Кассовый чек"
+	if receipt.Kind == database_payment.ReceiptRefund {
+		title = "Чек возврата"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Sport+\n%s № %s\n\n", title, receipt.Number())
+	fmt.Fprintf(&b, "Дата:        %s\n", receipt.CreatedAt.Format("02.01.2006 15:04 MST"))
+	fmt.Fprintf(&b, "Заказ:       %d\n", receipt.OrderID)
+	fmt.Fprintf(&b, "Покупатель:  %s", receipt.BuyerName)
+	if receipt.BuyerEmail != "" {
+		fmt.Fprintf(&b, " <%s>", receipt.BuyerEmail)
+	}
+	fmt.Fprintf(&b, "\nУслуга:      %s\n", receipt.Description)
+	fmt.Fprintf(&b, "Сумма:       %s %s\n", payment_provider.FormatAmount(receipt.Amount), receipt.Currency)
+	fmt.Fprintf(&b, "Провайдер:   %s, операция %s\n", receipt.Provider, receipt.PaymentID)
+	return b.String()
+}
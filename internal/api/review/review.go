@@ -2,15 +2,20 @@ package review
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	juju_errors "github.com/juju/errors"
 	"github.com/loopfz/gadgeto/tonic"
 	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database_auth "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	database_course "github.com/niazlv/sport-plus-LCT/internal/database/course"
 	"github.com/niazlv/sport-plus-LCT/internal/database/review"
 	database_review "github.com/niazlv/sport-plus-LCT/internal/database/review"
+	database_roster "github.com/niazlv/sport-plus-LCT/internal/database/roster"
 	"github.com/wI2L/fizz"
 	"gorm.io/gorm"
 )
@@ -28,7 +33,7 @@ func Setup(rg *fizz.RouterGroup) {
 
 	api.GET("/:review_id", []fizz.OperationOption{fizz.Summary("Get review by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetReviewByID, 200))
 	api.GET("/class/:class_id", []fizz.OperationOption{fizz.Summary("Get reviews by Class ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetReviewsByClassID, 200))
-	api.POST("", []fizz.OperationOption{fizz.Summary("Create a new review"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(CreateReview, 201))
+	api.POST("", []fizz.OperationOption{fizz.Summary("Create a new review"), auth.BearerAuth}, auth.WithAuth, auth.RequireRole(database_auth.RoleClient), tonic.Handler(CreateReview, 201))
 	api.PUT("/:review_id", []fizz.OperationOption{fizz.Summary("Update review by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateReview, 200))
 	api.DELETE("/:review_id", []fizz.OperationOption{fizz.Summary("Delete review by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeleteReview, 204))
 }
@@ -94,7 +99,7 @@ func GetReviewsByClassID(c *gin.Context, params *GetReviewsByClassIDParams) (*Re
 type CreateReviewInput struct {
 	ClassID int `json:"class_id"`
 	//ClientID         int    `json:"client_id" binding:"required"`
-	TrainerID        int    `json:"trainer_id"`
+	TrainerID        int    `json:"trainer_id"` // для отзыва о занятии берется из курса
 	DifficultyRating int    `json:"difficulty_rating"`
 	WellBeingRating  int    `json:"well_being_rating"`
 	OverallRating    int    `json:"overall_rating"`
@@ -109,10 +114,70 @@ func CreateReview(c *gin.Context, in *CreateReviewInput) (*ReviewOutput, error)
 		return nil, errors.New(err.Error())
 	}
 
+	if in.OverallRating == 0 {
+		return nil, juju_errors.BadRequestf("overall_rating is required")
+	}
+	if err := checkRatings(in.DifficultyRating, in.WellBeingRating, in.OverallRating); err != nil {
+		return nil, err
+	}
+
+	// Отзыв о занятии относится к его курсу и тренеру курса: от них считаются рейтинги
+	courseID, trainerID := 0, in.TrainerID
+	if in.ClassID != 0 {
+		class, err := database_course.GetClassByID(in.ClassID)
+		if err != nil {
+			log.Println("ERROR CreateReview(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if class == nil {
+			return nil, juju_errors.NotFoundf("class")
+		}
+		crs, err := database_course.GetCourseByID(class.CourseID)
+		if err != nil {
+			log.Println("ERROR CreateReview(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if crs == nil {
+			return nil, juju_errors.NotFoundf("course")
+		}
+		courseID, trainerID = crs.Id, crs.TrainerID
+	} else {
+		trainer, err := database_auth.FindUserByID(in.TrainerID)
+		if err != nil {
+			log.Println("ERROR CreateReview(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if trainer == nil || !trainer.IsTrainer() {
+			return nil, juju_errors.NotFoundf("trainer")
+		}
+	}
+
+	// Оценивать можно только то, что клиент сам проходил
+	if trainerID == userClaims.ID {
+		return nil, juju_errors.Forbiddenf("you can't review yourself")
+	}
+	var ok bool
+	if courseID != 0 {
+		ok, err = database_course.HasTakenCourse(courseID, userClaims.ID)
+	} else {
+		ok, err = database_roster.HasTrained(trainerID, userClaims.ID)
+	}
+	if err != nil {
+		log.Println("ERROR CreateReview(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if !ok {
+		if courseID != 0 {
+			return nil, juju_errors.Forbiddenf("enroll in the course to review it")
+		}
+		return nil, juju_errors.Forbiddenf("only clients of the trainer can review them")
+	}
+
 	newReview := review.Review{
 		ClassID:          in.ClassID,
+		CourseID:         courseID,
 		ClientID:         userClaims.ID,
-		TrainerID:        in.TrainerID,
+		TrainerID:        trainerID,
 		DifficultyRating: in.DifficultyRating,
 		WellBeingRating:  in.WellBeingRating,
 		OverallRating:    in.OverallRating,
@@ -121,6 +186,9 @@ func CreateReview(c *gin.Context, in *CreateReviewInput) (*ReviewOutput, error)
 
 	result, err := review.CreateReview(&newReview)
 	if err != nil {
+		if err == review.ErrReviewExists {
+			return nil, juju_errors.NewAlreadyExists(nil, err.Error())
+		}
 		return nil, err
 	}
 
@@ -152,6 +220,22 @@ func UpdateReview(c *gin.Context, in *UpdateReviewInput) (*ReviewOutput, error)
 	if err != nil {
 		return nil, err
 	}
+	if review == nil {
+		return nil, gin.Error{
+			Err:  gorm.ErrRecordNotFound,
+			Type: gin.ErrorTypePublic,
+			Meta: gin.H{"error": "review not found"},
+		}
+	}
+
+	// Менять отзыв может только его автор
+	if err := auth.CheckOwner(c, review.ClientID); err != nil {
+		return nil, err
+	}
+
+	if err := checkRatings(in.DifficultyRating, in.WellBeingRating, in.OverallRating); err != nil {
+		return nil, err
+	}
 
 	if in.DifficultyRating != 0 {
 		review.DifficultyRating = in.DifficultyRating
@@ -191,6 +275,23 @@ func DeleteReview(c *gin.Context, params *DeleteReviewParams) error {
 		}
 	}
 
+	existing, err := review.GetReviewByID(id)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return gin.Error{
+			Err:  gorm.ErrRecordNotFound,
+			Type: gin.ErrorTypePublic,
+			Meta: gin.H{"error": "review not found"},
+		}
+	}
+
+	// Удалить отзыв может автор или администратор
+	if err := auth.CheckOwner(c, existing.ClientID); err != nil {
+		return err
+	}
+
 	err = review.DeleteReview(id)
 	if err != nil {
 		return err
@@ -198,3 +299,13 @@ func DeleteReview(c *gin.Context, params *DeleteReviewParams) error {
 
 	return nil
 }
+
+// checkRatings проверяет, что оценки в шкале от 1 до 5. 0 - оценка не указана.
+func checkRatings(ratings ...int) error {
+	for _, rating := range ratings {
+		if rating < 0 || rating > 5 {
+			return juju_errors.BadRequestf("ratings must be from 1 to 5")
+		}
+	}
+	return nil
+}
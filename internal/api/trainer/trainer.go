@@ -0,0 +1,185 @@
+package trainer
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/api/user"
+	database_trainer "github.com/niazlv/sport-plus-LCT/internal/database/trainer"
+	"github.com/wI2L/fizz"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+func Setup(rg *fizz.RouterGroup) {
+	api := rg.Group("trainers", "Trainers", "Trainer directory and public profiles")
+
+	if _, err := database_trainer.InitDB(); err != nil {
+		log.Fatal("db trainers can't be init: ", err)
+	}
+
+	api.GET("", []fizz.OperationOption{fizz.Summary("Search trainers by gym, specialization, course direction, rating and price"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(getTrainers, 200))
+	api.GET("/:id", []fizz.OperationOption{fizz.Summary("Get trainer public profile with reviews summary and courses"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(getTrainer, 200))
+}
+
+// CourseBrief - курс в карточке тренера, без занятий
+type CourseBrief struct {
+	Id                int     `json:"id"`
+	Title             string  `json:"title"`
+	Direction         string  `json:"direction"`
+	Difficulty        string  `json:"difficulty"`
+	Cost              float64 `json:"cost"`
+	Rating            float64 `json:"rating"`
+	ReviewsCount      int     `json:"reviewsCount"`
+	ParticipantsCount int     `json:"participantsCount"`
+}
+
+// TrainerCard - публичный профиль тренера в каталоге
+type TrainerCard struct {
+	user.PublicProfile
+	GymName        string                 `json:"gymName"`
+	Specialization string                 `json:"specialization"`
+	SessionPrice   float64                `json:"sessionPrice"`
+	Stats          database_trainer.Stats `json:"stats"`
+	Courses        []CourseBrief          `json:"courses"`
+}
+
+func newTrainerCard(t *database_trainer.Trainer) TrainerCard {
+	card := TrainerCard{
+		PublicProfile:  user.NewPublicProfile(&t.User),
+		GymName:        t.User.GymName,
+		Specialization: t.User.Specialization,
+		SessionPrice:   t.User.SessionPrice,
+		Stats:          t.Stats,
+		Courses:        make([]CourseBrief, 0, len(t.Courses)),
+	}
+	for _, c := range t.Courses {
+		card.Courses = append(card.Courses, CourseBrief{
+			Id:                c.Id,
+			Title:             c.Title,
+			Direction:         c.Direction,
+			Difficulty:        c.Difficulty,
+			Cost:              c.Cost,
+			Rating:            c.Rating,
+			ReviewsCount:      c.ReviewsCount,
+			ParticipantsCount: c.ParticipantsCount,
+		})
+	}
+	return card
+}
+
+type getTrainersInput struct {
+	Gym            string `query:"gym"`            // подстрока названия зала
+	Specialization string `query:"specialization"` // подстрока специализации
+	Direction      string `query:"direction"`      // направление курса тренера
+	MinRating      string `query:"min_rating"`
+	MinPrice       string `query:"min_price"` // цена персонального занятия
+	MaxPrice       string `query:"max_price"`
+	Sort           string `query:"sort"` // rating (по умолчанию) или popularity
+	Page           int    `query:"page" default:"1"`
+	PerPage        int    `query:"per_page" default:"20"`
+}
+
+type getTrainersOutput struct {
+	Trainers []TrainerCard `json:"trainers"`
+	Total    int64         `json:"total"`
+	Page     int           `json:"page"`
+	PerPage  int           `json:"per_page"`
+}
+
+func getTrainers(c *gin.Context, in *getTrainersInput) (*getTrainersOutput, error) {
+	if in.Page < 1 {
+		in.Page = 1
+	}
+	if in.PerPage < 1 {
+		in.PerPage = defaultPerPage
+	}
+	if in.PerPage > maxPerPage {
+		in.PerPage = maxPerPage
+	}
+
+	filter := database_trainer.Filter{
+		Gym:            in.Gym,
+		Specialization: in.Specialization,
+		Direction:      in.Direction,
+		Sort:           in.Sort,
+		Offset:         (in.Page - 1) * in.PerPage,
+		Limit:          in.PerPage,
+	}
+	switch in.Sort {
+	case "", database_trainer.SortRating, database_trainer.SortPopularity:
+	default:
+		return nil, errors.BadRequestf("invalid sort, expected %s or %s", database_trainer.SortRating, database_trainer.SortPopularity)
+	}
+
+	var err error
+	if filter.MinRating, err = parseNumber("min_rating", in.MinRating); err != nil {
+		return nil, err
+	}
+	if filter.MinPrice, err = parseNumber("min_price", in.MinPrice); err != nil {
+		return nil, err
+	}
+	if filter.MaxPrice, err = parseNumber("max_price", in.MaxPrice); err != nil {
+		return nil, err
+	}
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		return nil, errors.BadRequestf("min_price is greater than max_price")
+	}
+
+	trainers, total, err := database_trainer.Search(filter)
+	if err != nil {
+		log.Println("ERROR getTrainers(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	out := &getTrainersOutput{
+		Trainers: make([]TrainerCard, 0, len(trainers)),
+		Total:    total,
+		Page:     in.Page,
+		PerPage:  in.PerPage,
+	}
+	for i := range trainers {
+		out.Trainers = append(out.Trainers, newTrainerCard(&trainers[i]))
+	}
+	return out, nil
+}
+
+// parseNumber разбирает необязательный числовой параметр запроса
+func parseNumber(name string, value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return nil, errors.BadRequestf("invalid %s", name)
+	}
+	return &number, nil
+}
+
+type getTrainerInput struct {
+	ID int `path:"id" validate:"required"`
+}
+
+type getTrainerOutput struct {
+	Trainer TrainerCard `json:"trainer"`
+}
+
+func getTrainer(c *gin.Context, in *getTrainerInput) (*getTrainerOutput, error) {
+	trainer, err := database_trainer.FindTrainer(in.ID)
+	if err != nil {
+		log.Println("ERROR getTrainer(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if trainer == nil {
+		return nil, errors.NotFoundf("trainer")
+	}
+	return &getTrainerOutput{Trainer: newTrainerCard(trainer)}, nil
+}
@@ -1,7 +1,11 @@
 package upload
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log"
+	"mime/multipart"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -9,32 +13,74 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/loopfz/gadgeto/tonic"
 	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/upload"
 	"github.com/wI2L/fizz"
 )
 
 func SetupUploadRoutes(api *fizz.RouterGroup) {
+	if _, err := database.InitDB(); err != nil {
+		log.Fatal("db uploads can't be init: ", err)
+	}
+
 	api.POST("/upload", []fizz.OperationOption{fizz.Summary("Upload a file"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UploadFile, 201))
 }
 
-func UploadFile(c *gin.Context) (map[string]string, error) {
-	file, err := c.FormFile("file")
+// SaveFile сохраняет файл в ./uploads/<subdir> под уникальным именем и
+// запоминает владельца. Возвращает запись с публичным URL.
+func SaveFile(c *gin.Context, file *multipart.FileHeader, subdir string) (*database.File, error) {
+	user, err := auth.CurrentUser(c)
 	if err != nil {
 		return nil, err
 	}
 
-	uploadDir := "./uploads"
+	uploadDir := filepath.Join("./uploads", subdir)
 	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
 		return nil, err
 	}
 
-	filePath := filepath.Join(uploadDir, file.Filename)
+	// Префикс не дает разным пользователям перезаписать файлы друг друга
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	name := hex.EncodeToString(prefix) + "_" + filepath.Base(file.Filename)
+
+	filePath := filepath.Join(uploadDir, name)
 	if err := c.SaveUploadedFile(file, filePath); err != nil {
 		return nil, err
 	}
 
-	fileURL := fmt.Sprintf("http://%s/uploads/%s", c.Request.Host, url.PathEscape(file.Filename))
+	urlPath := url.PathEscape(name)
+	if subdir != "" {
+		urlPath = subdir + "/" + urlPath
+	}
+	record, err := database.CreateFile(&database.File{
+		UserID: user.Id,
+		Name:   file.Filename,
+		Path:   filePath,
+		URL:    fmt.Sprintf("http://%s/uploads/%s", c.Request.Host, urlPath),
+		Size:   file.Size,
+	})
+	if err != nil {
+		log.Println("ERROR SaveFile(): ", err)
+		os.Remove(filePath)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return record, nil
+}
+
+func UploadFile(c *gin.Context) (map[string]string, error) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := SaveFile(c, file, "")
+	if err != nil {
+		return nil, err
+	}
 
 	return map[string]string{
-		"url": fileURL,
+		"url": record.URL,
 	}, nil
 }
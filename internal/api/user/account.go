@@ -0,0 +1,145 @@
+package user
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/api/chat"
+	"github.com/niazlv/sport-plus-LCT/internal/database/account"
+	"gorm.io/gorm"
+)
+
+// GetExport отдает ZIP со всеми данными пользователя: JSON по разделам
+// и загруженные им файлы в каталоге files/. Обычный gin-обработчик: tonic
+// дописал бы ответ после архива.
+func GetExport(c *gin.Context) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		auth.AbortWithError(c, err)
+		return
+	}
+
+	data, err := account.CollectUserData(User.Id)
+	if err != nil {
+		log.Println("ERROR GetExport(): ", err)
+		auth.AbortWithError(c, fmt.Errorf("DATABASE ERROR"))
+		return
+	}
+	data.User.Password = ""
+
+	sections := []struct {
+		name  string
+		value interface{}
+	}{
+		{"profile.json", data.User},
+		{"measurements.json", data.Measurements},
+		{"trains.json", data.Trains},
+		{"progress.json", gin.H{"progress": data.Progress, "course_status": data.CourseStatus}},
+		{"schedules.json", data.Schedules},
+		{"reviews.json", data.Reviews},
+		{"messages.json", data.Messages},
+		{"files.json", data.Files},
+		{"metrics.json", data.Metrics},
+		{"goals.json", data.Goals},
+		{"imports.json", data.Imports},
+		{"roster.json", data.Roster},
+		{"enrollments.json", data.Enrollments},
+		{"orders.json", data.Orders},
+		{"receipts.json", data.Receipts},
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="sport-plus-export-%d.zip"`, User.Id))
+	c.Status(200)
+
+	// Заголовки уже отправлены: при ошибке остается только оборвать архив
+	archive := zip.NewWriter(c.Writer)
+	for _, section := range sections {
+		w, err := archive.Create(section.name)
+		if err != nil {
+			log.Println("ERROR GetExport(): ", err)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(section.value); err != nil {
+			log.Println("ERROR GetExport(): ", err)
+			return
+		}
+	}
+
+	for _, file := range data.Files {
+		if err := addFileToZip(archive, fmt.Sprintf("files/%d_%s", file.Id, filepath.Base(file.Name)), file.Path); err != nil {
+			// Файл могли удалить с диска вручную, выгрузку из-за этого не прерываем
+			log.Println("ERROR GetExport(), file ", file.Id, ": ", err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Println("ERROR GetExport(): ", err)
+	}
+}
+
+func addFileToZip(archive *zip.Writer, name string, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+type DeleteUserInput struct {
+	Password string `json:"password" validate:"required"`
+}
+
+type DeleteUserOutput struct {
+	Status string `json:"status"`
+}
+
+// DeleteUser удаляет аккаунт: личные данные стираются, а то, на что ссылаются
+// другие пользователи, обезличивается. Требует повторного ввода пароля.
+func DeleteUser(c *gin.Context, in *DeleteUserInput) (*DeleteUserOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	if in.Password == "" || !auth.CheckPasswordHash(in.Password, User.Password) {
+		return nil, errors.Forbiddenf("invalid password")
+	}
+
+	paths, err := account.DeleteUserData(User.Id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.NotFoundf("user")
+		}
+		log.Println("ERROR DeleteUser(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	chat.LeaveAllChats(User.Id)
+
+	// Файлы удаляем после фиксации транзакции: откатить удаление с диска нельзя
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Println("ERROR DeleteUser(), remove file: ", err)
+		}
+	}
+
+	log.Println("user deleted: ", User.Id)
+	return &DeleteUserOutput{Status: "user deleted"}, nil
+}
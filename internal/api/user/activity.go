@@ -0,0 +1,170 @@
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/niazlv/sport-plus-LCT/internal/activity"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+)
+
+// Файлы с часов редко больше нескольких мегабайт, FIT за марафон - около 1 МБ
+const maxActivityFileSize = 25 << 20
+
+type UploadTrainOutput struct {
+	Train database.Train `json:"train"`
+}
+
+// UploadTrain импортирует тренировку из файла GPX, TCX или FIT (поле формы
+// file). Тренер указывается полем trainerId, без него тренировка считается
+// самостоятельной. Повторная загрузка той же тренировки отклоняется с 409.
+func UploadTrain(c *gin.Context) (*UploadTrainOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, errors.BadRequestf("file is required")
+	}
+	if file.Size > maxActivityFileSize {
+		return nil, errors.BadRequestf("file is too large, max %d MB", maxActivityFileSize>>20)
+	}
+
+	trainerID := user.Id
+	if raw := c.PostForm("trainerId"); raw != "" {
+		trainerID, err = strconv.Atoi(raw)
+		if err != nil {
+			return nil, errors.BadRequestf("invalid trainerId")
+		}
+		trainer, err := database.FindUserByID(trainerID)
+		if err != nil {
+			log.Println("ERROR UploadTrain(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if trainer == nil || !trainer.IsTrainer() {
+			return nil, errors.NotFoundf("trainer")
+		}
+		if err := checkTrainParties(user, trainerID, user.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxActivityFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	existing, err := database.FindTrainByFileHash(user.Id, hash)
+	if err != nil {
+		log.Println("ERROR UploadTrain(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if existing != nil {
+		return nil, errors.NewAlreadyExists(nil, fmt.Sprintf("this file was already imported as train %d", existing.ID))
+	}
+
+	parsed, err := activity.Parse(file.Filename, data)
+	if err != nil {
+		return nil, errors.BadRequestf("%s", err.Error())
+	}
+
+	existing, err = database.FindDuplicateTrain(user.Id, parsed.StartedAt, parsed.Distance)
+	if err != nil {
+		log.Println("ERROR UploadTrain(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if existing != nil {
+		return nil, errors.NewAlreadyExists(nil, fmt.Sprintf("the same activity was already imported as train %d", existing.ID))
+	}
+
+	startedAt := parsed.StartedAt
+	train := &database.Train{
+		UserID:          user.Id,
+		Date:            startedAt.Format(time.RFC3339),
+		TrainerID:       trainerID,
+		ClientID:        user.Id,
+		Duration:        parsed.Duration.Round(time.Second).String(),
+		Distance:        parsed.Distance,
+		Source:          parsed.Format,
+		Sport:           parsed.Sport,
+		StartedAt:       &startedAt,
+		DurationSeconds: int(parsed.Duration.Seconds()),
+		ElevationGain:   parsed.ElevationGain,
+		AvgHeartRate:    parsed.AvgHeartRate,
+		MaxHeartRate:    parsed.MaxHeartRate,
+		Pace:            parsed.Pace,
+		FileHash:        hash,
+	}
+	createdTrain, err := database.CreateTrainWithTrack(train, parsed.Points)
+	if err != nil {
+		log.Println("ERROR UploadTrain(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	refreshGoals(createdTrain.ClientID)
+	return &UploadTrainOutput{Train: *createdTrain}, nil
+}
+
+type GetTrainTrackInput struct {
+	ID int `path:"id" binding:"required"`
+}
+
+type GetTrainTrackOutput struct {
+	TrainID int              `json:"trainId"`
+	Points  []activity.Point `json:"points"`
+}
+
+// GetTrainTrack отдает точки трека для карты: клиенту, его тренеру и администратору
+func GetTrainTrack(c *gin.Context, in *GetTrainTrackInput) (*GetTrainTrackOutput, error) {
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	train, err := database.FindTrainByID(in.ID)
+	if err != nil {
+		log.Println("ERROR GetTrainTrack(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if train == nil {
+		return nil, errors.NotFoundf("train")
+	}
+
+	allowed := current.Id == train.UserID || current.Id == train.TrainerID
+	if !allowed {
+		allowed, err = canViewClient(current, train.ClientID)
+		if err != nil {
+			log.Println("ERROR GetTrainTrack(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+	}
+	if !allowed {
+		return nil, errors.Forbiddenf("you can't view this train")
+	}
+
+	track, err := database.FindTrainTrack(train.ID)
+	if err != nil {
+		log.Println("ERROR GetTrainTrack(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if track == nil {
+		return nil, errors.NotFoundf("track of manually added train")
+	}
+	return &GetTrainTrackOutput{TrainID: train.ID, Points: track.Points}, nil
+}
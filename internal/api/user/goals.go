@@ -0,0 +1,366 @@
+package user
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	database_course "github.com/niazlv/sport-plus-LCT/internal/database/course"
+	database_goal "github.com/niazlv/sport-plus-LCT/internal/database/goal"
+	"github.com/niazlv/sport-plus-LCT/internal/units"
+)
+
+// Цель отстает, если прогресс меньше ожидаемого к этому дню больше чем на atRiskMargin
+const atRiskMargin = 0.1
+
+// goalUnits - единицы хранения показателя цели
+var goalUnits = map[string]string{
+	database_goal.TypeLoseWeight:     "kg",
+	database_goal.TypeGainMuscle:     "kg",
+	database_goal.TypeRunDistance:    "km",
+	database_goal.TypeAttendSessions: "sessions",
+}
+
+// cumulativeGoal - цель на накопление: Target считается от Baseline, а не абсолютным значением
+func cumulativeGoal(goalType string) bool {
+	return goalType == database_goal.TypeRunDistance || goalType == database_goal.TypeAttendSessions
+}
+
+// goalValue - текущее значение показателя цели: последний вес, суммарная
+// дистанция бега и ходьбы или число тренировок и завершенных уроков курсов
+func goalValue(userID int, goalType string) (float64, bool, error) {
+	switch goalType {
+	case database_goal.TypeLoseWeight, database_goal.TypeGainMuscle:
+		weight, err := database.FindLatestMeasurement(userID, database.TypeWeight)
+		if err != nil || weight == nil {
+			return 0, false, err
+		}
+		return weight.Value, true, nil
+	case database_goal.TypeRunDistance:
+		_, distance, err := database.TrainTotals(userID)
+		return distance / 1000, err == nil, err
+	case database_goal.TypeAttendSessions:
+		trains, _, err := database.TrainTotals(userID)
+		if err != nil {
+			return 0, false, err
+		}
+		lessons, err := database_course.CountCompletedLessons(userID)
+		if err != nil {
+			return 0, false, err
+		}
+		return float64(trains) + float64(lessons), true, nil
+	}
+	return 0, false, fmt.Errorf("unknown goal type %s", goalType)
+}
+
+// evaluateGoal пересчитывает прогресс и статус цели по текущему значению.
+// Достигнутая цель так и остается достигнутой.
+func evaluateGoal(goal *database_goal.Goal, current float64, now time.Time) {
+	goal.Current = current
+	if goal.Status == database_goal.StatusAchieved {
+		return
+	}
+
+	var progress float64
+	switch {
+	case cumulativeGoal(goal.Type):
+		progress = (current - goal.Baseline) / goal.Target
+	case goal.Target != goal.Baseline:
+		progress = (current - goal.Baseline) / (goal.Target - goal.Baseline)
+	}
+	goal.Progress = math.Max(0, math.Min(1, math.Round(progress*1000)/1000))
+
+	if goal.Progress >= 1 {
+		goal.Status = database_goal.StatusAchieved
+		goal.AchievedAt = &now
+		return
+	}
+
+	// Ожидаем равномерного движения от старта к сроку
+	expected := 1.0
+	if total := goal.Deadline.Sub(goal.StartedAt); total > 0 && now.Before(goal.Deadline) {
+		expected = float64(now.Sub(goal.StartedAt)) / float64(total)
+	}
+	if goal.Progress+atRiskMargin >= expected {
+		goal.Status = database_goal.StatusOnTrack
+	} else {
+		goal.Status = database_goal.StatusAtRisk
+	}
+}
+
+// refreshGoal пересчитывает цель и сохраняет, если что-то изменилось
+func refreshGoal(goal *database_goal.Goal) error {
+	if goal.Status == database_goal.StatusAchieved {
+		return nil
+	}
+	current, ok, err := goalValue(goal.UserID, goal.Type)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		current = goal.Current
+	}
+
+	previous := *goal
+	evaluateGoal(goal, current, time.Now())
+	if goal.Current == previous.Current && goal.Progress == previous.Progress && goal.Status == previous.Status {
+		return nil
+	}
+	return database_goal.SaveProgress(goal, previous.Status)
+}
+
+// refreshGoals пересчитывает цели пользователя после новых замеров или тренировок
+func refreshGoals(userID int) {
+	goals, err := database_goal.FindGoalsByUserID(userID)
+	if err != nil {
+		log.Println("ERROR refreshGoals(): ", err)
+		return
+	}
+	for i := range goals {
+		if err := refreshGoal(&goals[i]); err != nil {
+			log.Println("ERROR refreshGoals(): goal ", goals[i].ID, ": ", err)
+		}
+	}
+}
+
+// displayGoal переводит весовые и беговые цели в систему единиц system
+func displayGoal(goal database_goal.Goal, system string) database_goal.Goal {
+	for _, value := range []*float64{&goal.Target, &goal.Baseline, &goal.Current} {
+		*value = units.FromMetric(*value, goal.Unit, system)
+	}
+	goal.Unit = units.Unit(goal.Unit, system)
+	return goal
+}
+
+type GoalsOutput struct {
+	UserID int                  `json:"userId"`
+	Goals  []database_goal.Goal `json:"goals"`
+}
+
+func GetGoals(c *gin.Context) (*GoalsOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	return userGoals(User.Id, User.UnitSystem)
+}
+
+type GetUserGoalsInput struct {
+	ID int `path:"id" validate:"required"`
+}
+
+// GetUserGoals - цели клиента со статусами и историей для его тренера
+func GetUserGoals(c *gin.Context, in *GetUserGoalsInput) (*GoalsOutput, error) {
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := canViewClient(current, in.ID)
+	if err != nil {
+		log.Println("ERROR GetUserGoals(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if !ok {
+		return nil, errors.Forbiddenf("only the user and their trainers can see goals")
+	}
+	return userGoals(in.ID, current.UnitSystem)
+}
+
+func userGoals(userID int, system string) (*GoalsOutput, error) {
+	refreshGoals(userID)
+
+	goals, err := database_goal.FindGoalsByUserID(userID)
+	if err != nil {
+		log.Println("ERROR userGoals(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	out := &GoalsOutput{UserID: userID, Goals: make([]database_goal.Goal, 0, len(goals))}
+	for _, goal := range goals {
+		out.Goals = append(out.Goals, displayGoal(goal, system))
+	}
+	return out, nil
+}
+
+type GoalOutput struct {
+	Goal database_goal.Goal `json:"goal"`
+}
+
+type GoalIDInput struct {
+	ID int `path:"id" validate:"required"`
+}
+
+// findOwnGoal возвращает цель, только если она принадлежит пользователю
+func findOwnGoal(userID int, id int) (*database_goal.Goal, error) {
+	goal, err := database_goal.FindGoalByID(id)
+	if err != nil {
+		log.Println("ERROR findOwnGoal(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if goal == nil || goal.UserID != userID {
+		return nil, errors.NotFoundf("goal")
+	}
+	return goal, nil
+}
+
+func GetGoal(c *gin.Context, in *GoalIDInput) (*GoalOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	goal, err := findOwnGoal(User.Id, in.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := refreshGoal(goal); err != nil {
+		log.Println("ERROR GetGoal(): ", err)
+	}
+	return &GoalOutput{Goal: displayGoal(*goal, User.UnitSystem)}, nil
+}
+
+type PostGoalInput struct {
+	Type     string    `json:"type" validate:"required"` // lose_weight, gain_muscle, run_distance или attend_sessions
+	Title    string    `json:"title"`
+	Target   float64   `json:"target" validate:"required"` // вес, дистанция в единицах пользователя или число занятий
+	Deadline time.Time `json:"deadline" validate:"required"`
+}
+
+// goalTarget переводит цель из единиц пользователя и проверяет ее относительно исходного значения
+func goalTarget(goalType string, target float64, baseline float64, system string) (float64, error) {
+	if target <= 0 {
+		return 0, errors.BadRequestf("target must be positive")
+	}
+	target, _ = units.ToMetric(target, units.Unit(goalUnits[goalType], system), goalUnits[goalType])
+
+	switch goalType {
+	case database_goal.TypeLoseWeight:
+		if target >= baseline {
+			return 0, errors.BadRequestf("target weight must be less than current weight")
+		}
+	case database_goal.TypeGainMuscle:
+		if target <= baseline {
+			return 0, errors.BadRequestf("target weight must be greater than current weight")
+		}
+	}
+	return target, nil
+}
+
+func PostGoal(c *gin.Context, in *PostGoalInput) (*GoalOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	unit, ok := goalUnits[in.Type]
+	if !ok {
+		return nil, errors.BadRequestf("invalid goal type: %s", in.Type)
+	}
+	now := time.Now()
+	if !in.Deadline.After(now) {
+		return nil, errors.BadRequestf("deadline must be in the future")
+	}
+
+	baseline, ok, err := goalValue(User.Id, in.Type)
+	if err != nil {
+		log.Println("ERROR PostGoal(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if !ok {
+		return nil, errors.BadRequestf("add a weight measurement before setting a weight goal")
+	}
+	target, err := goalTarget(in.Type, in.Target, baseline, User.UnitSystem)
+	if err != nil {
+		return nil, err
+	}
+
+	goal := &database_goal.Goal{
+		UserID:    User.Id,
+		Type:      in.Type,
+		Title:     in.Title,
+		Unit:      unit,
+		Target:    target,
+		Baseline:  baseline,
+		StartedAt: now,
+		Deadline:  in.Deadline,
+	}
+	evaluateGoal(goal, baseline, now)
+	if _, err := database_goal.CreateGoal(goal); err != nil {
+		log.Println("ERROR PostGoal(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	// Первая запись истории - статус при создании
+	if err := database_goal.SaveProgress(goal, ""); err != nil {
+		log.Println("ERROR PostGoal(): ", err)
+	}
+	return &GoalOutput{Goal: displayGoal(*goal, User.UnitSystem)}, nil
+}
+
+type PutGoalInput struct {
+	ID       int       `path:"id" validate:"required"`
+	Title    string    `json:"title"`
+	Target   float64   `json:"target"`   // если не передан, остается прежним
+	Deadline time.Time `json:"deadline"` // если не передан, остается прежним
+}
+
+func PutGoal(c *gin.Context, in *PutGoalInput) (*GoalOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	goal, err := findOwnGoal(User.Id, in.ID)
+	if err != nil {
+		return nil, err
+	}
+	if goal.Status == database_goal.StatusAchieved {
+		return nil, errors.BadRequestf("goal is already achieved, create a new one")
+	}
+
+	if in.Title != "" {
+		goal.Title = in.Title
+	}
+	if in.Target != 0 {
+		if goal.Target, err = goalTarget(goal.Type, in.Target, goal.Baseline, User.UnitSystem); err != nil {
+			return nil, err
+		}
+	}
+	if !in.Deadline.IsZero() {
+		if !in.Deadline.After(goal.StartedAt) {
+			return nil, errors.BadRequestf("deadline must be after the goal start")
+		}
+		goal.Deadline = in.Deadline
+	}
+
+	if err := database_goal.UpdateGoal(goal); err != nil {
+		log.Println("ERROR PutGoal(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	// Новая цель или срок могут поменять прогресс и статус
+	if err := refreshGoal(goal); err != nil {
+		log.Println("ERROR PutGoal(): ", err)
+	}
+	return &GoalOutput{Goal: displayGoal(*goal, User.UnitSystem)}, nil
+}
+
+type DeleteGoalOutput struct {
+	Status string `json:"status"`
+}
+
+func DeleteGoal(c *gin.Context, in *GoalIDInput) (*DeleteGoalOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := findOwnGoal(User.Id, in.ID); err != nil {
+		return nil, err
+	}
+
+	if err := database_goal.DeleteGoal(in.ID); err != nil {
+		log.Println("ERROR DeleteGoal(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &DeleteGoalOutput{Status: "goal deleted"}, nil
+}
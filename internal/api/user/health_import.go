@@ -0,0 +1,287 @@
+package user
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	database_healthimport "github.com/niazlv/sport-plus-LCT/internal/database/healthimport"
+	"github.com/niazlv/sport-plus-LCT/internal/healthimport"
+)
+
+// Выгрузка Apple Health за несколько лет занимает сотни мегабайт даже в zip
+const maxHealthExportSize = 1 << 30
+
+// Замеры сохраняются пачками, после каждой обновляется прогресс задачи
+const healthImportBatchSize = 500
+
+type HealthImportOutput struct {
+	Job database_healthimport.ImportJob `json:"job"`
+}
+
+// PostHealthImport принимает выгрузку (поле формы file) и запускает импорт в
+// фоне. Ход импорта и отчет о пропущенных записях - в GET /user/import/health/:id.
+func PostHealthImport(c *gin.Context) (*HealthImportOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, errors.BadRequestf("file is required")
+	}
+	if file.Size > maxHealthExportSize {
+		return nil, errors.BadRequestf("file is too large, max %d MB", maxHealthExportSize>>20)
+	}
+
+	active, err := database_healthimport.FindActiveJob(user.Id)
+	if err != nil {
+		log.Println("ERROR PostHealthImport(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if active != nil {
+		return nil, errors.NewAlreadyExists(nil, fmt.Sprintf("import %d is still running, wait for it to finish", active.ID))
+	}
+
+	// Файл нужен горутине импорта после ответа, а временные файлы multipart
+	// gin удаляет по завершении запроса
+	tmp, err := os.CreateTemp("", "health-import-*"+filepath.Ext(file.Filename))
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	if err := c.SaveUploadedFile(file, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	job, err := database_healthimport.CreateJob(&database_healthimport.ImportJob{
+		UserID:   user.Id,
+		FileName: filepath.Base(file.Filename),
+		Status:   database_healthimport.StatusPending,
+	})
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Println("ERROR PostHealthImport(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	go runHealthImport(*job, tmp.Name())
+	return &HealthImportOutput{Job: *job}, nil
+}
+
+// runHealthImport разбирает выгрузку и сохраняет новые замеры через
+// SaveMeasurements. Повторы (в том числе импортированные раньше) и значения
+// вне диапазона типа попадают в отчет.
+func runHealthImport(job database_healthimport.ImportJob, path string) {
+	defer os.Remove(path)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("ERROR runHealthImport(): ", r)
+			finishHealthImport(&job, nil, "internal error")
+		}
+	}()
+
+	job.Status = database_healthimport.StatusParsing
+	saveHealthImport(&job)
+
+	report := healthimport.NewReport()
+	source, records, err := healthimport.ParseFile(path, report)
+	if err != nil {
+		finishHealthImport(&job, report, err.Error())
+		return
+	}
+
+	byType := map[string][]healthimport.Record{}
+	for _, record := range records {
+		byType[record.Type] = append(byType[record.Type], record)
+	}
+	types := make([]string, 0, len(byType))
+	for measurementType := range byType {
+		types = append(types, measurementType)
+	}
+	sort.Strings(types)
+
+	job.Source = source
+	job.Status = database_healthimport.StatusImporting
+	job.Total = len(records) + report.Total()
+	job.Processed = report.Total()
+	job.Skipped = report.Total()
+	job.Progress = progressOf(job.Processed, job.Total)
+	saveHealthImport(&job)
+
+	var changed []string
+	for _, measurementType := range types {
+		imported, err := importMeasurements(&job, measurementType, byType[measurementType], report)
+		if err != nil {
+			log.Println("ERROR runHealthImport(): ", err)
+			finishHealthImport(&job, report, "DATABASE ERROR")
+			return
+		}
+		if imported > 0 {
+			changed = append(changed, measurementType)
+		}
+	}
+
+	// Метрики и цели пересчитываем один раз на тип, а не на каждый замер
+	for _, measurementType := range changed {
+		measurementChanged(job.UserID, measurementType)
+	}
+	finishHealthImport(&job, report, "")
+}
+
+// importMeasurements сохраняет замеры одного типа, возвращает число новых
+func importMeasurements(job *database_healthimport.ImportJob, typeName string, records []healthimport.Record, report *healthimport.Report) (int, error) {
+	measurementType, err := database.FindMeasurementType(typeName)
+	if err != nil {
+		return 0, err
+	}
+	if measurementType == nil {
+		for _, record := range records {
+			report.Skip(typeName, healthimport.ReasonUnsupportedType, record.MeasuredAt)
+		}
+		job.Processed += len(records)
+		job.Skipped += len(records)
+		return 0, nil
+	}
+
+	from, to := records[0].MeasuredAt, records[0].MeasuredAt
+	for _, record := range records {
+		if record.MeasuredAt.Before(from) {
+			from = record.MeasuredAt
+		}
+		if record.MeasuredAt.After(to) {
+			to = record.MeasuredAt
+		}
+	}
+	existing, err := database.FindMeasurements(job.UserID, typeName, from, to.Add(time.Second))
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, measurement := range existing {
+		seen[healthimport.Record{Type: typeName, Value: measurement.Value, MeasuredAt: measurement.MeasuredAt}.Key()] = true
+	}
+
+	imported := 0
+	batch := make([]database.Measurement, 0, healthImportBatchSize)
+	flush := func(processed int) error {
+		if err := database.SaveMeasurements(batch, job.UserID, typeName); err != nil {
+			return err
+		}
+		imported += len(batch)
+		job.Imported += len(batch)
+		job.Processed += processed
+		job.Progress = progressOf(job.Processed, job.Total)
+		batch = batch[:0]
+		saveHealthImport(job)
+		return nil
+	}
+
+	processed := 0
+	for _, record := range records {
+		processed++
+		switch {
+		case measurementType.CheckValue(record.Value) != nil:
+			report.Skip(typeName, healthimport.ReasonOutOfRange, record.MeasuredAt)
+			job.Skipped++
+		case seen[record.Key()]:
+			report.Skip(typeName, healthimport.ReasonDuplicate, record.MeasuredAt)
+			job.Skipped++
+		default:
+			batch = append(batch, database.Measurement{
+				Value:      record.Value,
+				Unit:       measurementType.Unit,
+				MeasuredAt: record.MeasuredAt,
+			})
+		}
+		if len(batch) == healthImportBatchSize {
+			if err := flush(processed); err != nil {
+				return imported, err
+			}
+			processed = 0
+		}
+	}
+	if err := flush(processed); err != nil {
+		return imported, err
+	}
+	return imported, nil
+}
+
+func progressOf(processed int, total int) float64 {
+	if total == 0 {
+		return 1
+	}
+	return float64(processed) / float64(total)
+}
+
+func saveHealthImport(job *database_healthimport.ImportJob) {
+	if err := database_healthimport.SaveJob(job); err != nil {
+		log.Println("ERROR saveHealthImport(): ", err)
+	}
+}
+
+// finishHealthImport завершает задачу: с пустым message - успешно
+func finishHealthImport(job *database_healthimport.ImportJob, report *healthimport.Report, message string) {
+	now := time.Now()
+	job.FinishedAt = &now
+	if report != nil {
+		job.Report = report.Groups()
+	}
+	if message != "" {
+		job.Status = database_healthimport.StatusFailed
+		job.Error = message
+	} else {
+		job.Status = database_healthimport.StatusDone
+		job.Processed = job.Total
+		job.Progress = 1
+	}
+	saveHealthImport(job)
+}
+
+type HealthImportsOutput struct {
+	Jobs []database_healthimport.ImportJob `json:"jobs"`
+}
+
+func GetHealthImports(c *gin.Context) (*HealthImportsOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := database_healthimport.FindJobsByUserID(user.Id)
+	if err != nil {
+		log.Println("ERROR GetHealthImports(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &HealthImportsOutput{Jobs: jobs}, nil
+}
+
+type GetHealthImportInput struct {
+	ID int `path:"id" binding:"required"`
+}
+
+func GetHealthImport(c *gin.Context, in *GetHealthImportInput) (*HealthImportOutput, error) {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := database_healthimport.FindJobByID(in.ID)
+	if err != nil {
+		log.Println("ERROR GetHealthImport(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if job == nil || job.UserID != user.Id {
+		return nil, errors.NotFoundf("import")
+	}
+	return &HealthImportOutput{Job: *job}, nil
+}
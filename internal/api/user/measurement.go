@@ -0,0 +1,381 @@
+package user
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/units"
+)
+
+// Замеры хранятся в метрических единицах типа (database.MeasurementType.Unit).
+// Значения от пользователя переводятся в них, а в ответах - в систему единиц
+// пользователя (User.UnitSystem).
+
+// findMeasurementType возвращает тип из реестра или BadRequest, если такого нет
+func findMeasurementType(name string) (*database.MeasurementType, error) {
+	measurementType, err := database.FindMeasurementType(name)
+	if err != nil {
+		log.Println("ERROR findMeasurementType(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if measurementType == nil {
+		return nil, errors.BadRequestf("invalid type: %s", name)
+	}
+	return measurementType, nil
+}
+
+// toStoredValue переводит значение в единицы хранения и проверяет диапазон.
+// Без unit значение считается введенным в системе единиц пользователя.
+func toStoredValue(measurementType *database.MeasurementType, value float64, unit string, system string) (float64, error) {
+	if unit == "" {
+		unit = units.Unit(measurementType.Unit, system)
+	}
+	stored, ok := units.ToMetric(value, unit, measurementType.Unit)
+	if !ok {
+		return 0, errors.BadRequestf("unsupported unit %q for %s, use %q or %q", unit, measurementType.Name,
+			measurementType.Unit, units.Unit(measurementType.Unit, units.Imperial))
+	}
+	if err := measurementType.CheckValue(stored); err != nil {
+		return 0, errors.BadRequestf("%s", err)
+	}
+	return stored, nil
+}
+
+// normalizeMeasurements переводит замеры из онбординга в единицы хранения
+func normalizeMeasurements(measurements []database.Measurement, typeName string, system string) error {
+	if len(measurements) == 0 {
+		return nil
+	}
+	measurementType, err := findMeasurementType(typeName)
+	if err != nil {
+		return err
+	}
+	for i := range measurements {
+		value, err := toStoredValue(measurementType, measurements[i].Value, measurements[i].Unit, system)
+		if err != nil {
+			return err
+		}
+		measurements[i].Value = value
+		measurements[i].Unit = measurementType.Unit
+	}
+	return nil
+}
+
+// measurementChanged пересчитывает то, что зависит от замеров: показатели и цели
+func measurementChanged(userID int, measurementType string) {
+	if affectsMetrics(measurementType) {
+		updateMetrics(userID, measurementType)
+	}
+	if measurementType == database.TypeWeight {
+		refreshGoals(userID)
+	}
+}
+
+// displayMeasurement переводит замер в систему единиц system
+func displayMeasurement(measurement database.Measurement, system string) database.Measurement {
+	measurement.Value = units.FromMetric(measurement.Value, measurement.Unit, system)
+	measurement.Unit = units.Unit(measurement.Unit, system)
+	return measurement
+}
+
+func displayMeasurements(measurements []database.Measurement, system string) []database.Measurement {
+	if measurements == nil {
+		return nil
+	}
+	out := make([]database.Measurement, 0, len(measurements))
+	for _, measurement := range measurements {
+		out = append(out, displayMeasurement(measurement, system))
+	}
+	return out
+}
+
+type MeasurementTypeOutput struct {
+	database.MeasurementType
+	DisplayUnit string `json:"displayUnit"` // единица в системе пользователя
+}
+
+type GetMeasurementTypesOutput struct {
+	UnitSystem string                  `json:"unitSystem"`
+	Types      []MeasurementTypeOutput `json:"types"`
+}
+
+func GetMeasurementTypes(c *gin.Context) (*GetMeasurementTypesOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := database.FindMeasurementTypes()
+	if err != nil {
+		log.Println("ERROR GetMeasurementTypes(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	out := &GetMeasurementTypesOutput{UnitSystem: User.UnitSystem, Types: make([]MeasurementTypeOutput, 0, len(types))}
+	for _, measurementType := range types {
+		out.Types = append(out.Types, MeasurementTypeOutput{
+			MeasurementType: measurementType,
+			DisplayUnit:     units.Unit(measurementType.Unit, User.UnitSystem),
+		})
+	}
+	return out, nil
+}
+
+type putUnitsInput struct {
+	UnitSystem string `json:"unitSystem" validate:"required"` // metric или imperial
+}
+
+type putUnitsOutput struct {
+	UnitSystem string `json:"unitSystem"`
+}
+
+func putUnits(c *gin.Context, in *putUnitsInput) (*putUnitsOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	if !units.Valid(in.UnitSystem) {
+		return nil, errors.BadRequestf("unitSystem must be %s or %s", units.Metric, units.Imperial)
+	}
+
+	if err := database.UpdateUnitSystem(User.Id, in.UnitSystem); err != nil {
+		log.Println("ERROR putUnits(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &putUnitsOutput{UnitSystem: in.UnitSystem}, nil
+}
+
+type AddMeasurementInput struct {
+	Type       string    `json:"type" binding:"required"`
+	Value      float64   `json:"value" binding:"required"`
+	Unit       string    `json:"unit"`       // метрическая или имперская единица типа, по умолчанию - из настроек пользователя
+	MeasuredAt time.Time `json:"measuredAt"` // RFC3339, по умолчанию - время запроса
+}
+
+type AddMeasurementOutput struct {
+	Measurement database.Measurement `json:"measurement"`
+}
+
+func AddMeasurement(c *gin.Context, in *AddMeasurementInput) (*AddMeasurementOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate input
+	measurementType, err := findMeasurementType(in.Type)
+	if err != nil {
+		return nil, err
+	}
+	value, err := toStoredValue(measurementType, in.Value, in.Unit, User.UnitSystem)
+	if err != nil {
+		return nil, err
+	}
+
+	measurement := &database.Measurement{
+		UserID:     User.Id,
+		Type:       measurementType.Name,
+		Value:      value,
+		Unit:       measurementType.Unit,
+		MeasuredAt: in.MeasuredAt,
+	}
+	createdMeasurement, err := database.AddMeasurement(measurement)
+	if err != nil {
+		log.Println("ERROR AddMeasurement(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	measurementChanged(User.Id, createdMeasurement.Type)
+	return &AddMeasurementOutput{
+		Measurement: displayMeasurement(*createdMeasurement, User.UnitSystem),
+	}, nil
+}
+
+type UpdateMeasurementInput struct {
+	ID         int       `json:"id" path:"id" binding:"required"`
+	Value      float64   `json:"value" binding:"required"`
+	Unit       string    `json:"unit"`
+	MeasuredAt time.Time `json:"measuredAt"` // если не передан, остается прежним
+}
+
+type UpdateMeasurementOutput struct {
+	Measurement database.Measurement `json:"measurement"`
+}
+
+// findOwnMeasurement возвращает замер, только если он принадлежит пользователю
+func findOwnMeasurement(userID int, id int) (*database.Measurement, error) {
+	measurement, err := database.FindMeasurementByID(id)
+	if err != nil {
+		log.Println("ERROR findOwnMeasurement(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if measurement == nil || measurement.UserID != userID {
+		return nil, errors.NotFoundf("measurement")
+	}
+	return measurement, nil
+}
+
+func UpdateMeasurement(c *gin.Context, in *UpdateMeasurementInput) (*UpdateMeasurementOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	measurement, err := findOwnMeasurement(User.Id, in.ID)
+	if err != nil {
+		return nil, err
+	}
+	measurementType, err := findMeasurementType(measurement.Type)
+	if err != nil {
+		return nil, err
+	}
+	value, err := toStoredValue(measurementType, in.Value, in.Unit, User.UnitSystem)
+	if err != nil {
+		return nil, err
+	}
+
+	measurement.Value = value
+	measurement.Unit = measurementType.Unit
+	if !in.MeasuredAt.IsZero() {
+		measurement.MeasuredAt = in.MeasuredAt
+	}
+	updatedMeasurement, err := database.UpdateMeasurement(measurement)
+	if err != nil {
+		log.Println("ERROR UpdateMeasurement(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	measurementChanged(User.Id, updatedMeasurement.Type)
+	return &UpdateMeasurementOutput{
+		Measurement: displayMeasurement(*updatedMeasurement, User.UnitSystem),
+	}, nil
+}
+
+type DeleteMeasurementInput struct {
+	ID int `json:"id" path:"id" binding:"required"`
+}
+
+type DeleteMeasurementOutput struct {
+	Status string `json:"status"`
+}
+
+func DeleteMeasurement(c *gin.Context, in *DeleteMeasurementInput) (*DeleteMeasurementOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	measurement, err := findOwnMeasurement(User.Id, in.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.DeleteMeasurement(in.ID); err != nil {
+		log.Println("ERROR DeleteMeasurement(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	measurementChanged(User.Id, measurement.Type)
+	return &DeleteMeasurementOutput{
+		Status: "measurement deleted successfully",
+	}, nil
+}
+
+type GetMeasurementsInput struct {
+	Type   string `query:"type" validate:"required"`
+	From   string `query:"from"`   // RFC3339 или 2006-01-02, по умолчанию - год назад
+	To     string `query:"to"`     // не включительно; дата без времени - включительно, по умолчанию - сейчас
+	Bucket string `query:"bucket"` // day, week или month; без него возвращаются сами замеры
+}
+
+type GetMeasurementsOutput struct {
+	Type         string                       `json:"type"`
+	Unit         string                       `json:"unit"`
+	Aggregation  string                       `json:"aggregation"` // как получено buckets[].value
+	From         time.Time                    `json:"from"`
+	To           time.Time                    `json:"to"`
+	Bucket       string                       `json:"bucket,omitempty"`
+	Buckets      []database.MeasurementBucket `json:"buckets,omitempty"`
+	Measurements []database.Measurement       `json:"measurements,omitempty"`
+}
+
+// GetMeasurements - история замеров одного типа за период, целиком или по интервалам
+func GetMeasurements(c *gin.Context, in *GetMeasurementsInput) (*GetMeasurementsOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	measurementType, err := findMeasurementType(in.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	from, err := parseRangeTime(in.From, now.AddDate(-1, 0, 0), false)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseRangeTime(in.To, now, true)
+	if err != nil {
+		return nil, err
+	}
+	if !from.Before(to) {
+		return nil, errors.BadRequestf("from must be before to")
+	}
+
+	out := &GetMeasurementsOutput{
+		Type:        measurementType.Name,
+		Unit:        units.Unit(measurementType.Unit, User.UnitSystem),
+		Aggregation: measurementType.Aggregation,
+		From:        from,
+		To:          to,
+		Bucket:      in.Bucket,
+	}
+
+	switch in.Bucket {
+	case "":
+		measurements, err := database.FindMeasurements(User.Id, measurementType.Name, from, to)
+		if err != nil {
+			log.Println("ERROR GetMeasurements(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		out.Measurements = displayMeasurements(measurements, User.UnitSystem)
+	case database.BucketDay, database.BucketWeek, database.BucketMonth:
+		buckets, err := database.AggregateMeasurements(User.Id, measurementType, from, to, in.Bucket)
+		if err != nil {
+			log.Println("ERROR GetMeasurements(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		for i := range buckets {
+			displayBucket(&buckets[i], measurementType.Unit, User.UnitSystem)
+		}
+		out.Buckets = buckets
+	default:
+		return nil, errors.BadRequestf("invalid bucket: %s, use day, week or month", in.Bucket)
+	}
+	return out, nil
+}
+
+// displayBucket переводит сводку интервала в систему единиц system
+func displayBucket(bucket *database.MeasurementBucket, unit string, system string) {
+	for _, value := range []*float64{&bucket.Min, &bucket.Max, &bucket.Avg, &bucket.Last, &bucket.Sum, &bucket.Value} {
+		*value = units.FromMetric(*value, unit, system)
+	}
+}
+
+// parseRangeTime разбирает границу периода. Дата без времени в конце периода
+// означает весь этот день.
+func parseRangeTime(raw string, def time.Time, end bool) (time.Time, error) {
+	if raw == "" {
+		return def, nil
+	}
+	t, ok := database.ParseMeasurementTime(raw)
+	if !ok {
+		return time.Time{}, errors.BadRequestf("invalid time: %s", raw)
+	}
+	if end && len(raw) == len("2006-01-02") {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
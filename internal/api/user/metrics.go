@@ -0,0 +1,196 @@
+package user
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	database_metrics "github.com/niazlv/sport-plus-LCT/internal/database/metrics"
+	database_roster "github.com/niazlv/sport-plus-LCT/internal/database/roster"
+	"github.com/niazlv/sport-plus-LCT/internal/metrics"
+)
+
+// metricsTriggerProfile - пересчет после изменения профиля (пол, возраст, активность)
+const metricsTriggerProfile = "profile"
+
+// affectsMetrics - замеры этих типов входят в расчет показателей
+func affectsMetrics(measurementType string) bool {
+	switch measurementType {
+	case database.TypeHeight, database.TypeWeight, database.TypeRestingHR:
+		return true
+	}
+	return false
+}
+
+// computeMetrics считает показатели по профилю и последним замерам
+func computeMetrics(user *database.User) (metrics.Result, error) {
+	in := metrics.Input{
+		Gender:        user.Gender,
+		Age:           user.Age,
+		ActivityLevel: user.ActivityLevel,
+	}
+	for measurementType, value := range map[string]*float64{
+		database.TypeHeight:    &in.HeightCm,
+		database.TypeWeight:    &in.WeightKg,
+		database.TypeRestingHR: &in.RestingHR,
+	} {
+		measurement, err := database.FindLatestMeasurement(user.Id, measurementType)
+		if err != nil {
+			return metrics.Result{}, err
+		}
+		if measurement != nil {
+			*value = measurement.Value
+		}
+	}
+	return metrics.Compute(in), nil
+}
+
+// updateMetrics пересчитывает показатели и сохраняет снимок, если они изменились.
+// Ошибки только пишутся в лог: замер или профиль к этому моменту уже сохранены.
+func updateMetrics(userID int, trigger string) {
+	User, err := database.FindUserByID(userID)
+	if err != nil || User == nil {
+		log.Println("ERROR updateMetrics(): user ", userID, ": ", err)
+		return
+	}
+	result, err := computeMetrics(User)
+	if err != nil {
+		log.Println("ERROR updateMetrics(): ", err)
+		return
+	}
+
+	snapshot := &database_metrics.MetricSnapshot{
+		UserID:        User.Id,
+		ComputedAt:    time.Now(),
+		Trigger:       trigger,
+		BMI:           result.BMI,
+		BMR:           result.BMR,
+		TDEE:          result.TDEE,
+		MaxHR:         result.MaxHR,
+		RestingHR:     result.RestingHR,
+		ActivityLevel: User.ActivityLevel,
+	}
+	last, err := database_metrics.FindLastSnapshot(User.Id)
+	if err != nil {
+		log.Println("ERROR updateMetrics(): ", err)
+		return
+	}
+	if last != nil && last.SameValues(snapshot) {
+		return
+	}
+	if _, err := database_metrics.CreateSnapshot(snapshot); err != nil {
+		log.Println("ERROR updateMetrics(): ", err)
+	}
+}
+
+type GetMetricsOutput struct {
+	Metrics metrics.Result `json:"metrics"`
+}
+
+// GetMetrics - текущие показатели, считаются по последним замерам
+func GetMetrics(c *gin.Context) (*GetMetricsOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := computeMetrics(User)
+	if err != nil {
+		log.Println("ERROR GetMetrics(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &GetMetricsOutput{Metrics: result}, nil
+}
+
+type GetMetricsHistoryInput struct {
+	From string `query:"from"` // RFC3339 или 2006-01-02, по умолчанию - год назад
+	To   string `query:"to"`   // по умолчанию - сейчас
+}
+
+type GetMetricsHistoryOutput struct {
+	UserID  int                               `json:"userId"`
+	History []database_metrics.MetricSnapshot `json:"history"`
+}
+
+func GetMetricsHistory(c *gin.Context, in *GetMetricsHistoryInput) (*GetMetricsHistoryOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	return metricsHistory(User, in.From, in.To, false, false)
+}
+
+type GetUserMetricsHistoryInput struct {
+	ID   int    `path:"id" validate:"required"`
+	From string `query:"from"`
+	To   string `query:"to"`
+}
+
+// GetUserMetricsHistory - динамика показателей клиента для его тренера.
+// Если клиент скрыл рост, вес или возраст, показатели, из которых их можно
+// вычислить, не отдаются.
+func GetUserMetricsHistory(c *gin.Context, in *GetUserMetricsHistoryInput) (*GetMetricsHistoryOutput, error) {
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	User, err := database.FindUserByID(in.ID)
+	if err != nil {
+		log.Println("ERROR GetUserMetricsHistory(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if User == nil {
+		return nil, errors.NotFoundf("user")
+	}
+
+	if current.Id == User.Id || current.IsAdmin() {
+		return metricsHistory(User, in.From, in.To, false, false)
+	}
+	if current.IsTrainer() {
+		ok, err := database_roster.HasClient(current.Id, User.Id)
+		if err != nil {
+			log.Println("ERROR GetUserMetricsHistory(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if ok {
+			privacy := User.Privacy
+			return metricsHistory(User, in.From, in.To, privacy.HideWeight || privacy.HideHeight, privacy.HideAge)
+		}
+	}
+	return nil, errors.Forbiddenf("only the user and their trainers can see metrics")
+}
+
+// metricsHistory отдает снимки показателей. hideBody убирает ИМТ и расход
+// калорий (по ним вычисляются рост и вес), hideAge - максимальный пульс
+// (220 - возраст) и расход калорий, в формулу которого входит возраст.
+func metricsHistory(user *database.User, rawFrom string, rawTo string, hideBody bool, hideAge bool) (*GetMetricsHistoryOutput, error) {
+	now := time.Now()
+	from, err := parseRangeTime(rawFrom, now.AddDate(-1, 0, 0), false)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseRangeTime(rawTo, now, true)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := database_metrics.FindSnapshots(user.Id, from, to)
+	if err != nil {
+		log.Println("ERROR metricsHistory(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	for i := range snapshots {
+		if hideBody {
+			snapshots[i].BMI, snapshots[i].BMR, snapshots[i].TDEE = nil, nil, nil
+		}
+		if hideAge {
+			snapshots[i].MaxHR, snapshots[i].BMR, snapshots[i].TDEE = nil, nil, nil
+		}
+	}
+	return &GetMetricsHistoryOutput{UserID: user.Id, History: snapshots}, nil
+}
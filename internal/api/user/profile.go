@@ -0,0 +1,162 @@
+package user
+
+import (
+	"time"
+
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+)
+
+// Представления профиля. database.User наружу не отдаем: кто что видит,
+// решает GetUserByID (свой профиль, профиль своего клиента или публичный).
+
+// PublicProfile - то, что видит любой авторизованный пользователь
+type PublicProfile struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Icon        string `json:"icon"`
+	About       string `json:"about"`
+	Achivements string `json:"achivements"`
+	Role        string `json:"role,omitempty"` // только у тренеров
+}
+
+// ClientProfile - профиль клиента для его тренера, с учетом настроек приватности
+type ClientProfile struct {
+	PublicProfile
+	Gender           string                 `json:"gender"`
+	Age              *int                   `json:"age,omitempty"`
+	Height           []database.Measurement `json:"height,omitempty"`
+	Weight           []database.Measurement `json:"weight,omitempty"`
+	Goals            string                 `json:"goals"`
+	Experience       string                 `json:"experience"`
+	GymMember        bool                   `json:"gymMember"`
+	Beginner         bool                   `json:"beginner"`
+	GymName          string                 `json:"gymName"`
+	HealthConditions string                 `json:"healthConditions"`
+}
+
+// TrainView - тренировка со сторонами в публичном виде
+type TrainView struct {
+	ID        int           `json:"id"`
+	Date      string        `json:"date"`
+	TrainerID int           `json:"trainerId"`
+	ClientID  int           `json:"clientId"`
+	Duration  string        `json:"duration"`
+	Trainer   PublicProfile `json:"trainer"`
+	Client    PublicProfile `json:"client"`
+}
+
+// SelfProfile - полный профиль владельца (и администратора)
+type SelfProfile struct {
+	Id               int                      `json:"id"`
+	Login            string                   `json:"login"`
+	Email            string                   `json:"email"`
+	EmailVerified    bool                     `json:"emailVerified"`
+	Role             int                      `json:"role"`
+	Name             string                   `json:"name"`
+	Icon             string                   `json:"icon"`
+	About            string                   `json:"about"`
+	Achivements      string                   `json:"achivements"`
+	Gender           string                   `json:"gender"`
+	Age              int                      `json:"age"`
+	Height           []database.Measurement   `json:"height"`
+	Weight           []database.Measurement   `json:"weight"`
+	Water            []database.Measurement   `json:"water"`
+	Trains           []TrainView              `json:"trains"`
+	Goals            string                   `json:"goals"`
+	Experience       string                   `json:"experience"`
+	GymMember        bool                     `json:"gymMember"`
+	Beginner         bool                     `json:"beginner"`
+	GymName          string                   `json:"gymName"`
+	HealthConditions string                   `json:"healthConditions"`
+	Privacy          database.PrivacySettings `json:"privacy"`
+	UnitSystem       string                   `json:"unitSystem"`
+	ActivityLevel    string                   `json:"activityLevel"`
+	Specialization   string                   `json:"specialization,omitempty"`
+	SessionPrice     float64                  `json:"sessionPrice,omitempty"`
+	SuspendedAt      *time.Time               `json:"suspendedAt,omitempty"`
+}
+
+func NewPublicProfile(user *database.User) PublicProfile {
+	profile := PublicProfile{
+		Id:          user.Id,
+		Name:        user.Name,
+		Icon:        user.Icon,
+		About:       user.About,
+		Achivements: user.Achivements,
+	}
+	if user.IsTrainer() {
+		profile.Role = database.RoleName(user.Role)
+	}
+	return profile
+}
+
+// NewClientProfile - профиль клиента, замеры в системе единиц тренера system
+func NewClientProfile(user *database.User, system string) ClientProfile {
+	profile := ClientProfile{
+		PublicProfile:    NewPublicProfile(user),
+		Gender:           user.Gender,
+		Goals:            user.Goals,
+		Experience:       user.Experience,
+		GymMember:        user.GymMember,
+		Beginner:         user.Beginner,
+		GymName:          user.GymName,
+		HealthConditions: user.HealthConditions,
+	}
+	if !user.Privacy.HideAge {
+		age := user.Age
+		profile.Age = &age
+	}
+	if !user.Privacy.HideHeight {
+		profile.Height = displayMeasurements(user.Height, system)
+	}
+	if !user.Privacy.HideWeight {
+		profile.Weight = displayMeasurements(user.Weight, system)
+	}
+	return profile
+}
+
+func NewSelfProfile(user *database.User) SelfProfile {
+	trains := make([]TrainView, 0, len(user.Trains))
+	for i := range user.Trains {
+		train := &user.Trains[i]
+		trains = append(trains, TrainView{
+			ID:        train.ID,
+			Date:      train.Date,
+			TrainerID: train.TrainerID,
+			ClientID:  train.ClientID,
+			Duration:  train.Duration,
+			Trainer:   NewPublicProfile(&train.Trainer),
+			Client:    NewPublicProfile(&train.Client),
+		})
+	}
+
+	return SelfProfile{
+		Id:               user.Id,
+		Login:            user.Login,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		Role:             user.Role,
+		Name:             user.Name,
+		Icon:             user.Icon,
+		About:            user.About,
+		Achivements:      user.Achivements,
+		Gender:           user.Gender,
+		Age:              user.Age,
+		Height:           displayMeasurements(user.Height, user.UnitSystem),
+		Weight:           displayMeasurements(user.Weight, user.UnitSystem),
+		Water:            displayMeasurements(user.Water, user.UnitSystem),
+		Trains:           trains,
+		Goals:            user.Goals,
+		Experience:       user.Experience,
+		GymMember:        user.GymMember,
+		Beginner:         user.Beginner,
+		GymName:          user.GymName,
+		HealthConditions: user.HealthConditions,
+		Privacy:          user.Privacy,
+		UnitSystem:       user.UnitSystem,
+		ActivityLevel:    user.ActivityLevel,
+		Specialization:   user.Specialization,
+		SessionPrice:     user.SessionPrice,
+		SuspendedAt:      user.SuspendedAt,
+	}
+}
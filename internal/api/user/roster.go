@@ -0,0 +1,441 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/errors"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	database_course "github.com/niazlv/sport-plus-LCT/internal/database/course"
+	database_roster "github.com/niazlv/sport-plus-LCT/internal/database/roster"
+	"github.com/wI2L/fizz"
+)
+
+// Срок действия приглашения, если тренер не указал свой
+const (
+	defaultInviteTTL = 14 * 24 * time.Hour
+	maxInviteTTL     = 90 * 24 * time.Hour
+)
+
+// setupRoster - эндпоинты тренера: клиенты и приглашения. Принимают и
+// отклоняют приглашения клиенты, поэтому роль проверяется не на всей группе.
+func setupRoster(rg *fizz.RouterGroup) {
+	api := rg.Group("trainer", "Trainer", "Trainer's clients and invitations")
+	trainerOnly := auth.RequireRole(database.RoleTrainer)
+
+	api.GET("/clients", []fizz.OperationOption{fizz.Summary("Your clients with latest measurements and course progress"), auth.BearerAuth}, auth.WithAuth, trainerOnly, tonic.Handler(GetTrainerClients, 200))
+	api.DELETE("/clients/:id", []fizz.OperationOption{fizz.Summary("End relationship with a client"), auth.BearerAuth}, auth.WithAuth, trainerOnly, tonic.Handler(DeleteTrainerClient, 200))
+	api.GET("/invites", []fizz.OperationOption{fizz.Summary("List your invitations"), auth.BearerAuth}, auth.WithAuth, trainerOnly, tonic.Handler(GetTrainerInvites, 200))
+	api.POST("/invites", []fizz.OperationOption{fizz.Summary("Invite a client by ID, or create an invite link without clientId"), auth.BearerAuth}, auth.WithAuth, trainerOnly, tonic.Handler(PostTrainerInvite, 201))
+	api.DELETE("/invites/:code", []fizz.OperationOption{fizz.Summary("Revoke an invitation"), auth.BearerAuth}, auth.WithAuth, trainerOnly, tonic.Handler(DeleteTrainerInvite, 200))
+	api.GET("/invites/:code", []fizz.OperationOption{fizz.Summary("View an invitation by code"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetInvite, 200))
+	api.POST("/invites/:code/accept", []fizz.OperationOption{fizz.Summary("Accept an invitation and become the trainer's client"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(AcceptInvite, 200))
+	api.POST("/invites/:code/decline", []fizz.OperationOption{fizz.Summary("Decline a personal invitation"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeclineInvite, 200))
+}
+
+func generateInviteCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)), nil
+}
+
+// InviteView - приглашение со ссылкой и тренером в публичном виде
+type InviteView struct {
+	database_roster.Invite
+	Link    string        `json:"link"`
+	Trainer PublicProfile `json:"trainer"`
+}
+
+func newInviteView(invite *database_roster.Invite, trainer *database.User) InviteView {
+	view := InviteView{
+		Invite: *invite,
+		Link:   fmt.Sprintf("%s/invite/%s", auth.PublicURL(), invite.Code),
+	}
+	if trainer != nil {
+		view.Trainer = NewPublicProfile(trainer)
+	}
+	return view
+}
+
+// canViewClient - себе и администратору можно все, тренеру - только клиентов из своего списка
+func canViewClient(current *database.User, clientID int) (bool, error) {
+	if current.Id == clientID || current.IsAdmin() {
+		return true, nil
+	}
+	if !current.IsTrainer() {
+		return false, nil
+	}
+	return database_roster.HasClient(current.Id, clientID)
+}
+
+// RosterClient - клиент в списке тренера
+type RosterClient struct {
+	Relationship       database_roster.Relationship            `json:"relationship"`
+	Client             PublicProfile                           `json:"client"`
+	LatestMeasurements []database.Measurement                  `json:"latestMeasurements"`
+	Courses            []database_course.CourseProgressSummary `json:"courses"`
+}
+
+type GetTrainerClientsOutput struct {
+	Clients []RosterClient `json:"clients"`
+}
+
+func GetTrainerClients(c *gin.Context) (*GetTrainerClientsOutput, error) {
+	trainer, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	relationships, err := database_roster.FindClients(trainer.Id)
+	if err != nil {
+		log.Println("ERROR GetTrainerClients(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+
+	clients := make([]RosterClient, 0, len(relationships))
+	for _, relationship := range relationships {
+		client, err := database.FindUserByID(relationship.ClientID)
+		if err != nil {
+			log.Println("ERROR GetTrainerClients(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if client == nil {
+			continue
+		}
+		latest, err := database.FindLatestMeasurements(client.Id)
+		if err != nil {
+			log.Println("ERROR GetTrainerClients(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		courses, err := database_course.ClientCourseSummaries(client.Id)
+		if err != nil {
+			log.Println("ERROR GetTrainerClients(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+
+		// Скрытые клиентом поля не показываем и здесь, как в ClientProfile
+		visible := make([]database.Measurement, 0, len(latest))
+		for _, measurement := range latest {
+			if measurement.Type == database.TypeWeight && client.Privacy.HideWeight ||
+				measurement.Type == database.TypeHeight && client.Privacy.HideHeight {
+				continue
+			}
+			visible = append(visible, displayMeasurement(measurement, trainer.UnitSystem))
+		}
+
+		clients = append(clients, RosterClient{
+			Relationship:       relationship,
+			Client:             NewPublicProfile(client),
+			LatestMeasurements: visible,
+			Courses:            courses,
+		})
+	}
+	return &GetTrainerClientsOutput{Clients: clients}, nil
+}
+
+type RelationshipIDInput struct {
+	ID int `path:"id" binding:"required"`
+}
+
+type RelationshipOutput struct {
+	Relationship database_roster.Relationship `json:"relationship"`
+}
+
+// DeleteTrainerClient - тренер завершает занятия с клиентом, id - ID клиента
+func DeleteTrainerClient(c *gin.Context, in *RelationshipIDInput) (*RelationshipOutput, error) {
+	trainer, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	return endRelationship(trainer.Id, in.ID, trainer.Id)
+}
+
+// DeleteUserTrainer - клиент уходит от тренера, id - ID тренера
+func DeleteUserTrainer(c *gin.Context, in *RelationshipIDInput) (*RelationshipOutput, error) {
+	client, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	return endRelationship(in.ID, client.Id, client.Id)
+}
+
+func endRelationship(trainerID int, clientID int, endedBy int) (*RelationshipOutput, error) {
+	relationship, err := database_roster.FindActiveRelationship(trainerID, clientID)
+	if err != nil {
+		log.Println("ERROR endRelationship(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if relationship == nil {
+		return nil, errors.NotFoundf("relationship")
+	}
+	if err := database_roster.EndRelationship(relationship, endedBy); err != nil {
+		log.Println("ERROR endRelationship(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &RelationshipOutput{Relationship: *relationship}, nil
+}
+
+// UserTrainer - тренер клиента
+type UserTrainer struct {
+	Relationship database_roster.Relationship `json:"relationship"`
+	Trainer      PublicProfile                `json:"trainer"`
+}
+
+type GetUserTrainersOutput struct {
+	Trainers []UserTrainer `json:"trainers"`
+}
+
+func GetUserTrainers(c *gin.Context) (*GetUserTrainersOutput, error) {
+	client, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	relationships, err := database_roster.FindTrainers(client.Id)
+	if err != nil {
+		log.Println("ERROR GetUserTrainers(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	trainers := make([]UserTrainer, 0, len(relationships))
+	for _, relationship := range relationships {
+		trainer, err := database.FindUserByID(relationship.TrainerID)
+		if err != nil {
+			log.Println("ERROR GetUserTrainers(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if trainer == nil {
+			continue
+		}
+		trainers = append(trainers, UserTrainer{Relationship: relationship, Trainer: NewPublicProfile(trainer)})
+	}
+	return &GetUserTrainersOutput{Trainers: trainers}, nil
+}
+
+type InvitesOutput struct {
+	Invites []InviteView `json:"invites"`
+}
+
+func GetTrainerInvites(c *gin.Context) (*InvitesOutput, error) {
+	trainer, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	invites, err := database_roster.FindInvitesByTrainerID(trainer.Id)
+	if err != nil {
+		log.Println("ERROR GetTrainerInvites(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	views := make([]InviteView, 0, len(invites))
+	for i := range invites {
+		views = append(views, newInviteView(&invites[i], trainer))
+	}
+	return &InvitesOutput{Invites: views}, nil
+}
+
+// GetUserInvites - действующие личные приглашения текущего пользователя
+func GetUserInvites(c *gin.Context) (*InvitesOutput, error) {
+	client, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	invites, err := database_roster.FindPendingInvitesForClient(client.Id)
+	if err != nil {
+		log.Println("ERROR GetUserInvites(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	views := make([]InviteView, 0, len(invites))
+	for i := range invites {
+		trainer, err := database.FindUserByID(invites[i].TrainerID)
+		if err != nil {
+			log.Println("ERROR GetUserInvites(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		views = append(views, newInviteView(&invites[i], trainer))
+	}
+	return &InvitesOutput{Invites: views}, nil
+}
+
+type PostTrainerInviteInput struct {
+	ClientID int    `json:"clientId"` // без него создается ссылка для любого клиента
+	Message  string `json:"message"`
+	TTLDays  int    `json:"ttlDays"` // по умолчанию 14 дней, не больше 90
+}
+
+type InviteOutput struct {
+	Invite InviteView `json:"invite"`
+}
+
+func PostTrainerInvite(c *gin.Context, in *PostTrainerInviteInput) (*InviteOutput, error) {
+	trainer, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := defaultInviteTTL
+	if in.TTLDays != 0 {
+		ttl = time.Duration(in.TTLDays) * 24 * time.Hour
+		if ttl < 0 || ttl > maxInviteTTL {
+			return nil, errors.BadRequestf("ttlDays must be between 1 and %d", int(maxInviteTTL.Hours()/24))
+		}
+	}
+
+	if in.ClientID != 0 {
+		if in.ClientID == trainer.Id {
+			return nil, errors.BadRequestf("you can't invite yourself")
+		}
+		client, err := database.FindUserByID(in.ClientID)
+		if err != nil {
+			log.Println("ERROR PostTrainerInvite(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if client == nil {
+			return nil, errors.NotFoundf("client")
+		}
+		ok, err := database_roster.HasClient(trainer.Id, client.Id)
+		if err != nil {
+			log.Println("ERROR PostTrainerInvite(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if ok {
+			return nil, errors.NewAlreadyExists(nil, "user is already your client")
+		}
+	}
+
+	code, err := generateInviteCode()
+	if err != nil {
+		return nil, err
+	}
+	invite, err := database_roster.CreateInvite(&database_roster.Invite{
+		TrainerID: trainer.Id,
+		ClientID:  in.ClientID,
+		Code:      code,
+		Message:   in.Message,
+		Status:    database_roster.InviteStatusPending,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		log.Println("ERROR PostTrainerInvite(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &InviteOutput{Invite: newInviteView(invite, trainer)}, nil
+}
+
+type InviteCodeInput struct {
+	Code string `path:"code" binding:"required"`
+}
+
+// findInvite ищет приглашение, доступное текущему пользователю: тренеру-автору,
+// адресату личного приглашения или любому по ссылке
+func findInvite(current *database.User, code string) (*database_roster.Invite, error) {
+	invite, err := database_roster.FindInviteByCode(strings.ToLower(strings.TrimSpace(code)))
+	if err != nil {
+		log.Println("ERROR findInvite(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if invite == nil || invite.Personal() && invite.ClientID != current.Id && invite.TrainerID != current.Id {
+		return nil, errors.NotFoundf("invite")
+	}
+	return invite, nil
+}
+
+func GetInvite(c *gin.Context, in *InviteCodeInput) (*InviteOutput, error) {
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	invite, err := findInvite(current, in.Code)
+	if err != nil {
+		return nil, err
+	}
+	trainer, err := database.FindUserByID(invite.TrainerID)
+	if err != nil {
+		log.Println("ERROR GetInvite(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &InviteOutput{Invite: newInviteView(invite, trainer)}, nil
+}
+
+func AcceptInvite(c *gin.Context, in *InviteCodeInput) (*RelationshipOutput, error) {
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	invite, err := findInvite(current, in.Code)
+	if err != nil {
+		return nil, err
+	}
+	if invite.TrainerID == current.Id {
+		return nil, errors.BadRequestf("you can't accept your own invite")
+	}
+
+	relationship, err := database_roster.AcceptInvite(invite, current.Id)
+	switch {
+	case err == database_roster.ErrInviteNotPending:
+		return nil, errors.BadRequestf("%s", err.Error())
+	case err == database_roster.ErrAlreadyClient:
+		return nil, errors.NewAlreadyExists(nil, err.Error())
+	case err != nil:
+		log.Println("ERROR AcceptInvite(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &RelationshipOutput{Relationship: *relationship}, nil
+}
+
+func DeclineInvite(c *gin.Context, in *InviteCodeInput) (*InviteOutput, error) {
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	invite, err := findInvite(current, in.Code)
+	if err != nil {
+		return nil, err
+	}
+	if invite.ClientID != current.Id {
+		return nil, errors.BadRequestf("only personal invites can be declined, just ignore the link")
+	}
+	if err := database_roster.DeclineInvite(invite); err != nil {
+		if err == database_roster.ErrInviteNotPending {
+			return nil, errors.BadRequestf("%s", err.Error())
+		}
+		log.Println("ERROR DeclineInvite(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &InviteOutput{Invite: newInviteView(invite, nil)}, nil
+}
+
+func DeleteTrainerInvite(c *gin.Context, in *InviteCodeInput) (*InviteOutput, error) {
+	trainer, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	invite, err := findInvite(trainer, in.Code)
+	if err != nil {
+		return nil, err
+	}
+	if invite.TrainerID != trainer.Id {
+		return nil, errors.NotFoundf("invite")
+	}
+	if err := database_roster.RevokeInvite(invite); err != nil {
+		if err == database_roster.ErrInviteNotPending {
+			return nil, errors.BadRequestf("%s", err.Error())
+		}
+		log.Println("ERROR DeleteTrainerInvite(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &InviteOutput{Invite: newInviteView(invite, trainer)}, nil
+}
@@ -2,16 +2,21 @@ package user
 
 import (
 	"fmt"
-	"net/url"
-	"os"
-	"path/filepath"
+	"log"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 	"github.com/juju/errors"
 	"github.com/loopfz/gadgeto/tonic"
 	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/api/upload"
+	"github.com/niazlv/sport-plus-LCT/internal/database/account"
 	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	database_goal "github.com/niazlv/sport-plus-LCT/internal/database/goal"
+	database_healthimport "github.com/niazlv/sport-plus-LCT/internal/database/healthimport"
+	database_metrics "github.com/niazlv/sport-plus-LCT/internal/database/metrics"
+	database_roster "github.com/niazlv/sport-plus-LCT/internal/database/roster"
+	"github.com/niazlv/sport-plus-LCT/internal/metrics"
 	"github.com/wI2L/fizz"
 	"gorm.io/gorm"
 )
@@ -26,23 +31,68 @@ type JWTAuthSecurityType struct {
 func Setup(rg *fizz.RouterGroup) {
 	api := rg.Group("user", "User", "User related endpoints")
 
+	if _, err := account.InitDB(); err != nil {
+		log.Fatal("db account can't be init: ", err)
+	}
+	if _, err := database_metrics.InitDB(); err != nil {
+		log.Fatal("db metrics can't be init: ", err)
+	}
+	if _, err := database_goal.InitDB(); err != nil {
+		log.Fatal("db goal can't be init: ", err)
+	}
+	if _, err := database_healthimport.InitDB(); err != nil {
+		log.Fatal("db health import can't be init: ", err)
+	}
+	if _, err := database_roster.InitDB(); err != nil {
+		log.Fatal("db roster can't be init: ", err)
+	}
+
+	setupRoster(rg)
+
 	_ = api
 	api.GET("", []fizz.OperationOption{fizz.Summary("Return Your User"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetUser, 200))
+	api.DELETE("", []fizz.OperationOption{fizz.Summary("Delete your account and personal data, body: {\"password\": \"...\"}"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeleteUser, 200))
+	api.GET("/export", []fizz.OperationOption{fizz.Summary("Export all your data as ZIP archive"), auth.BearerAuth}, auth.WithAuth, GetExport)
 	api.GET("/:id", []fizz.OperationOption{fizz.Summary("Return User by ID"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetUserByID, 200))
+	api.GET("/:id/goals", []fizz.OperationOption{fizz.Summary("Goals of your client with status history, for trainers"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetUserGoals, 200))
+	api.GET("/:id/metrics/history", []fizz.OperationOption{fizz.Summary("Metrics history of your client, for trainers"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetUserMetricsHistory, 200))
+	api.GET("/trainers", []fizz.OperationOption{fizz.Summary("Your trainers"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetUserTrainers, 200))
+	api.DELETE("/trainers/:id", []fizz.OperationOption{fizz.Summary("Stop training with a trainer"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeleteUserTrainer, 200))
+	api.GET("/invites", []fizz.OperationOption{fizz.Summary("Pending invitations from trainers"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetUserInvites, 200))
+	api.PUT("/privacy", []fizz.OperationOption{fizz.Summary("Choose which profile fields your trainers can see"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(putPrivacy, 200))
 	api.PUT("/onboarding", []fizz.OperationOption{fizz.Summary("Update User data after onboarding"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(putOnboarding, 200))
 	api.POST("/upload/icon", []fizz.OperationOption{fizz.Summary("Upload user icon"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UploadUserIcon, 201))
 
+	api.PUT("/units", []fizz.OperationOption{fizz.Summary("Choose metric or imperial units for measurements"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(putUnits, 200))
+
+	api.GET("/metrics", []fizz.OperationOption{fizz.Summary("Current BMI, BMR, TDEE and Karvonen heart rate zones"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetMetrics, 200))
+	api.GET("/metrics/history", []fizz.OperationOption{fizz.Summary("Metrics history, recorded on every change"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetMetricsHistory, 200))
+
+	api.GET("/goals", []fizz.OperationOption{fizz.Summary("List your goals with progress and status"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetGoals, 200))
+	api.POST("/goals", []fizz.OperationOption{fizz.Summary("Create a goal: lose_weight, gain_muscle, run_distance or attend_sessions"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(PostGoal, 201))
+	api.GET("/goals/:id", []fizz.OperationOption{fizz.Summary("Get a goal"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetGoal, 200))
+	api.PUT("/goals/:id", []fizz.OperationOption{fizz.Summary("Update goal title, target or deadline"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(PutGoal, 200))
+	api.DELETE("/goals/:id", []fizz.OperationOption{fizz.Summary("Delete a goal"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeleteGoal, 200))
+
+	api.GET("/measurements/types", []fizz.OperationOption{fizz.Summary("List measurement types with units, ranges and aggregation"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetMeasurementTypes, 200))
+	api.GET("/measurements", []fizz.OperationOption{fizz.Summary("Measurement history, ?type=weight&from=&to=&bucket=week for min/max/avg/last per bucket"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetMeasurements, 200))
 	api.POST("/measurements", []fizz.OperationOption{fizz.Summary("Add a new measurement"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(AddMeasurement, 201))
 	api.PUT("/measurements/:id", []fizz.OperationOption{fizz.Summary("Update a measurement"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateMeasurement, 200))
 	api.DELETE("/measurements/:id", []fizz.OperationOption{fizz.Summary("Delete a measurement"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeleteMeasurement, 204))
 
+	api.GET("/import/health", []fizz.OperationOption{fizz.Summary("List your Apple Health and Google Fit imports"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetHealthImports, 200))
+	api.POST("/import/health", []fizz.OperationOption{fizz.Summary("Import measurements from Apple Health export zip or Google Takeout Fit JSON, form field: file"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(PostHealthImport, 202))
+	api.GET("/import/health/:id", []fizz.OperationOption{fizz.Summary("Import progress and report of skipped records"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetHealthImport, 200))
+
 	api.POST("/trains", []fizz.OperationOption{fizz.Summary("Add a new train"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(AddTrain, 201))
 	api.PUT("/trains/:id", []fizz.OperationOption{fizz.Summary("Update a train"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UpdateTrain, 200))
+	api.POST("/trains/upload", []fizz.OperationOption{fizz.Summary("Import a train from GPX, TCX or FIT file, form fields: file, trainerId"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(UploadTrain, 201))
+	api.GET("/trains/:id/track", []fizz.OperationOption{fizz.Summary("Track points of an imported train for map rendering"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(GetTrainTrack, 200))
 	api.DELETE("/trains/:id", []fizz.OperationOption{fizz.Summary("Delete a train"), auth.BearerAuth}, auth.WithAuth, tonic.Handler(DeleteTrain, 204))
 }
 
 type GetUserOutput struct {
-	User database.User `json:"user"`
+	User SelfProfile `json:"user"`
 }
 
 func GetUser(c *gin.Context) (*GetUserOutput, error) {
@@ -57,8 +107,11 @@ func GetUser(c *gin.Context) (*GetUserOutput, error) {
 	if err != nil {
 		return nil, errors.New(err.Error())
 	}
+	if User == nil {
+		return nil, errors.NotFoundf("user")
+	}
 	return &GetUserOutput{
-		User: *User,
+		User: NewSelfProfile(User),
 	}, nil
 }
 
@@ -66,14 +119,59 @@ type GetUserByIDInput struct {
 	ID int `json:"id" path:"id" validate:"required" binding:"required"`
 }
 
-func GetUserByID(c *gin.Context, in *GetUserByIDInput) (*GetUserOutput, error) {
+type GetUserByIDOutput struct {
+	View string      `json:"view"` // self, client или public
+	User interface{} `json:"user"` // SelfProfile, ClientProfile или PublicProfile
+}
+
+// GetUserByID отдает профиль в том объеме, который положен вызывающему:
+// себе и администратору - полный, тренеру своего клиента - ClientProfile,
+// остальным - публичный
+func GetUserByID(c *gin.Context, in *GetUserByIDInput) (*GetUserByIDOutput, error) {
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
 	User, err := database.FindUserByID(in.ID)
 	if err != nil {
 		return nil, errors.New(err.Error())
 	}
-	return &GetUserOutput{
-		User: *User,
-	}, nil
+	if User == nil {
+		return nil, errors.NotFoundf("user")
+	}
+
+	if current.Id == User.Id || current.IsAdmin() {
+		return &GetUserByIDOutput{View: "self", User: NewSelfProfile(User)}, nil
+	}
+	if current.IsTrainer() {
+		ok, err := database_roster.HasClient(current.Id, User.Id)
+		if err != nil {
+			log.Println("ERROR GetUserByID(): ", err)
+			return nil, fmt.Errorf("DATABASE ERROR")
+		}
+		if ok {
+			return &GetUserByIDOutput{View: "client", User: NewClientProfile(User, current.UnitSystem)}, nil
+		}
+	}
+	return &GetUserByIDOutput{View: "public", User: NewPublicProfile(User)}, nil
+}
+
+type putPrivacyOutput struct {
+	Privacy database.PrivacySettings `json:"privacy"`
+}
+
+func putPrivacy(c *gin.Context, in *database.PrivacySettings) (*putPrivacyOutput, error) {
+	User, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.UpdatePrivacySettings(User.Id, *in); err != nil {
+		log.Println("ERROR putPrivacy(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	return &putPrivacyOutput{Privacy: *in}, nil
 }
 
 type putOnboardingOutput struct {
@@ -88,7 +186,31 @@ func putOnboarding(c *gin.Context, in *database.User) (*putOnboardingOutput, err
 		return nil, errors.New(err.Error())
 	}
 
-	// Преобразуем входные данные в структуру User
+	current, err := auth.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.ActivityLevel != "" && !metrics.ValidActivityLevel(in.ActivityLevel) {
+		return nil, errors.BadRequestf("invalid activityLevel: %s", in.ActivityLevel)
+	}
+	if in.SessionPrice < 0 {
+		return nil, errors.BadRequestf("sessionPrice can't be negative")
+	}
+
+	// Замеры приходят в единицах пользователя, храним в метрических
+	if err := normalizeMeasurements(in.Height, database.TypeHeight, current.UnitSystem); err != nil {
+		return nil, err
+	}
+	if err := normalizeMeasurements(in.Weight, database.TypeWeight, current.UnitSystem); err != nil {
+		return nil, err
+	}
+	if err := normalizeMeasurements(in.Water, database.TypeWater, current.UnitSystem); err != nil {
+		return nil, err
+	}
+
+	// Преобразуем входные данные в структуру User.
+	// Роль здесь не меняется, ее назначает администратор через /admin/users
 	user := database.User{
 		Id:               userClaims.ID,
 		Gender:           in.Gender,
@@ -100,12 +222,14 @@ func putOnboarding(c *gin.Context, in *database.User) (*putOnboardingOutput, err
 		Beginner:         in.Beginner,
 		GymName:          in.GymName,
 		HealthConditions: in.HealthConditions,
-		Role:             in.Role,
 		Name:             in.Name,
 		Icon:             in.Icon,
 		About:            in.About,
 		Achivements:      in.Achivements,
 		Age:              in.Age,
+		ActivityLevel:    in.ActivityLevel,
+		Specialization:   in.Specialization,
+		SessionPrice:     in.SessionPrice,
 	}
 
 	// Обновляем пользователя в базе данных
@@ -135,6 +259,8 @@ func putOnboarding(c *gin.Context, in *database.User) (*putOnboardingOutput, err
 		return nil, err
 	}
 
+	updateMetrics(user.Id, metricsTriggerProfile)
+
 	return &putOnboardingOutput{Status: "user updated successfully"}, nil
 }
 
@@ -155,17 +281,11 @@ func UploadUserIcon(c *gin.Context) (*UploadUserIconOutput, error) {
 		return nil, err
 	}
 
-	uploadDir := "./uploads/icons"
-	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
-		return nil, err
-	}
-
-	filePath := filepath.Join(uploadDir, file.Filename)
-	if err := c.SaveUploadedFile(file, filePath); err != nil {
+	record, err := upload.SaveFile(c, file, "icons")
+	if err != nil {
 		return nil, err
 	}
-
-	iconURL := fmt.Sprintf("http://%s/uploads/icons/%s", c.Request.Host, url.PathEscape(file.Filename))
+	iconURL := record.URL
 
 	// Обновляем иконку пользователя в базе данных
 	user, err := database.FindUserByID(userClaims.ID)
@@ -183,121 +303,13 @@ func UploadUserIcon(c *gin.Context) (*UploadUserIconOutput, error) {
 	}, nil
 }
 
-type AddMeasurementInput struct {
-	// UserID int    `json:"userId" binding:"required"`
-	Date  string `json:"date" binding:"required"`
-	Value string `json:"value" binding:"required"`
-	Type  string `json:"type" binding:"required"`
-}
-
-func (input *AddMeasurementInput) Validate() error {
-	for _, validType := range database.ValidTypesMeasurement {
-		if input.Type == validType {
-			return nil
-		}
-	}
-	return fmt.Errorf("invalid type: %s", input.Type)
-}
-
-type AddMeasurementOutput struct {
-	Measurement database.Measurement `json:"measurement"`
-}
-
-func AddMeasurement(c *gin.Context, in *AddMeasurementInput) (*AddMeasurementOutput, error) {
-	claims := c.MustGet("claims").(jwt.MapClaims)
-
-	// Validate input
-	if err := in.Validate(); err != nil {
-		return nil, err
-	}
-
-	userClaims, err := auth.ExtractClaims(claims)
-	if err != nil {
-		return nil, errors.New(err.Error())
-	}
-
-	User, err := database.FindUserByID(userClaims.ID)
-	if err != nil {
-		return nil, errors.New(err.Error())
-	}
-
-	measurement := &database.Measurement{
-		UserID: User.Id,
-		Date:   in.Date,
-		Value:  in.Value,
-		Type:   in.Type,
-	}
-	createdMeasurement, err := database.AddMeasurement(measurement)
-	if err != nil {
-		return nil, err
-	}
-	return &AddMeasurementOutput{
-		Measurement: *createdMeasurement,
-	}, nil
-}
-
-type UpdateMeasurementInput struct {
-	ID int `json:"id" path:"id" binding:"required"`
-	// UserID int    `json:"userId" binding:"required"`
-	Date  string `json:"date" binding:"required"`
-	Value string `json:"value" binding:"required"`
-}
-
-type UpdateMeasurementOutput struct {
-	Measurement database.Measurement `json:"measurement"`
-}
-
-func UpdateMeasurement(c *gin.Context, in *UpdateMeasurementInput) (*UpdateMeasurementOutput, error) {
-	claims := c.MustGet("claims").(jwt.MapClaims)
-
-	userClaims, err := auth.ExtractClaims(claims)
-	if err != nil {
-		return nil, errors.New(err.Error())
-	}
-
-	User, err := database.FindUserByID(userClaims.ID)
-	if err != nil {
-		return nil, errors.New(err.Error())
-	}
-
-	measurement := &database.Measurement{
-		ID:     in.ID,
-		UserID: User.Id,
-		Date:   in.Date,
-		Value:  in.Value,
-	}
-	updatedMeasurement, err := database.UpdateMeasurement(measurement)
-	if err != nil {
-		return nil, err
-	}
-	return &UpdateMeasurementOutput{
-		Measurement: *updatedMeasurement,
-	}, nil
-}
-
-type DeleteMeasurementInput struct {
-	ID int `json:"id" path:"id" binding:"required"`
-}
-
-type DeleteMeasurementOutput struct {
-	Status string `json:"status"`
-}
-
-func DeleteMeasurement(c *gin.Context, in *DeleteMeasurementInput) (*DeleteMeasurementOutput, error) {
-	if err := database.DeleteMeasurement(in.ID); err != nil {
-		return nil, err
-	}
-	return &DeleteMeasurementOutput{
-		Status: "measurement deleted successfully",
-	}, nil
-}
-
 type AddTrainInput struct {
 	// UserID    int    `json:"userId" binding:"required"`
-	Date      string `json:"date" binding:"required"`
-	TrainerID int    `json:"trainerId" binding:"required"`
-	ClientID  int    `json:"clientId" binding:"required"`
-	Duration  string `json:"duration" binding:"required"`
+	Date      string  `json:"date" binding:"required"`
+	TrainerID int     `json:"trainerId" binding:"required"`
+	ClientID  int     `json:"clientId" binding:"required"`
+	Duration  string  `json:"duration" binding:"required"`
+	Distance  float64 `json:"distance"` // метры
 }
 
 type AddTrainOutput struct {
@@ -317,17 +329,23 @@ func AddTrain(c *gin.Context, in *AddTrainInput) (*AddTrainOutput, error) {
 		return nil, errors.New(err.Error())
 	}
 
+	if err := checkTrainParties(User, in.TrainerID, in.ClientID); err != nil {
+		return nil, err
+	}
+
 	train := &database.Train{
 		UserID:    User.Id,
 		Date:      in.Date,
 		TrainerID: in.TrainerID,
 		ClientID:  in.ClientID,
 		Duration:  in.Duration,
+		Distance:  in.Distance,
 	}
 	createdTrain, err := database.AddTrain(train)
 	if err != nil {
 		return nil, err
 	}
+	refreshGoals(createdTrain.ClientID)
 	return &AddTrainOutput{
 		Train: *createdTrain,
 	}, nil
@@ -336,10 +354,11 @@ func AddTrain(c *gin.Context, in *AddTrainInput) (*AddTrainOutput, error) {
 type UpdateTrainInput struct {
 	ID int `json:"id" path:"id" binding:"required"`
 	// UserID    int    `json:"userId" binding:"required"`
-	Date      string `json:"date" binding:"required"`
-	TrainerID int    `json:"trainerId" binding:"required"`
-	ClientID  int    `json:"clientId" binding:"required"`
-	Duration  string `json:"duration" binding:"required"`
+	Date      string  `json:"date" binding:"required"`
+	TrainerID int     `json:"trainerId" binding:"required"`
+	ClientID  int     `json:"clientId" binding:"required"`
+	Duration  string  `json:"duration" binding:"required"`
+	Distance  float64 `json:"distance"` // метры
 }
 
 type UpdateTrainOutput struct {
@@ -359,18 +378,31 @@ func UpdateTrain(c *gin.Context, in *UpdateTrainInput) (*UpdateTrainOutput, erro
 		return nil, errors.New(err.Error())
 	}
 
-	train := &database.Train{
-		ID:        in.ID,
-		UserID:    User.Id,
-		Date:      in.Date,
-		TrainerID: in.TrainerID,
-		ClientID:  in.ClientID,
-		Duration:  in.Duration,
+	train, err := database.FindTrainByID(in.ID)
+	if err != nil {
+		log.Println("ERROR UpdateTrain(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if train == nil || !canEditTrain(User, train) {
+		return nil, errors.NotFoundf("train")
+	}
+	// Прежних участников не перепроверяем: тренировка остается за ними и после расставания
+	if in.TrainerID != train.TrainerID || in.ClientID != train.ClientID {
+		if err := checkTrainParties(User, in.TrainerID, in.ClientID); err != nil {
+			return nil, err
+		}
 	}
+	// Автор и поля импорта (трек, пульс, набор высоты) остаются как были
+	train.Date = in.Date
+	train.TrainerID = in.TrainerID
+	train.ClientID = in.ClientID
+	train.Duration = in.Duration
+	train.Distance = in.Distance
 	updatedTrain, err := database.UpdateTrain(train)
 	if err != nil {
 		return nil, err
 	}
+	refreshGoals(updatedTrain.ClientID)
 	return &UpdateTrainOutput{
 		Train: *updatedTrain,
 	}, nil
@@ -385,10 +417,59 @@ type DeleteTrainOutput struct {
 }
 
 func DeleteTrain(c *gin.Context, in *DeleteTrainInput) (*DeleteTrainOutput, error) {
-	if err := database.DeleteTrain(in.ID); err != nil {
+	user, err := auth.CurrentUser(c)
+	if err != nil {
 		return nil, err
 	}
+	train, err := database.FindTrainByID(in.ID)
+	if err != nil {
+		log.Println("ERROR DeleteTrain(): ", err)
+		return nil, fmt.Errorf("DATABASE ERROR")
+	}
+	if train == nil || !canEditTrain(user, train) {
+		return nil, errors.NotFoundf("train")
+	}
+
+	if err := database.DeleteTrain(train.ID); err != nil {
+		return nil, err
+	}
+	refreshGoals(train.ClientID)
 	return &DeleteTrainOutput{
 		Status: "train deleted successfully",
 	}, nil
 }
+
+// canEditTrain - менять и удалять тренировку может ее автор, клиент или администратор
+func canEditTrain(current *database.User, train *database.Train) bool {
+	return current.Id == train.UserID || current.Id == train.ClientID || current.IsAdmin()
+}
+
+// checkTrainParties - записать тренировку может сам клиент или тренер, у
+// которого клиент в списке. Указанный в тренировке тренер тоже должен вести клиента.
+func checkTrainParties(current *database.User, trainerID int, clientID int) error {
+	if current.IsAdmin() {
+		return nil
+	}
+	if current.Id != clientID {
+		ok, err := canViewClient(current, clientID)
+		if err != nil {
+			log.Println("ERROR checkTrainParties(): ", err)
+			return fmt.Errorf("DATABASE ERROR")
+		}
+		if !ok || trainerID != current.Id {
+			return errors.Forbiddenf("train can be recorded only by the client or their trainer")
+		}
+	}
+	if trainerID == clientID {
+		return nil
+	}
+	ok, err := database_roster.HasClient(trainerID, clientID)
+	if err != nil {
+		log.Println("ERROR checkTrainParties(): ", err)
+		return fmt.Errorf("DATABASE ERROR")
+	}
+	if !ok {
+		return errors.Forbiddenf("trainer %d does not train this client", trainerID)
+	}
+	return nil
+}
@@ -12,6 +12,29 @@ type Config struct {
 	DBHost     string
 	DBPort     string
 	JWTSecret  string
+	// AppEnv - среда запуска: "dev", "prod" (по умолчанию) и т.д.
+	AppEnv string
+	// JWTKeysDir - каталог с ключами подписи <kid>.pem (RSA или Ed25519),
+	// JWTSigningKey - kid ключа для подписи, по умолчанию последний по имени
+	JWTKeysDir    string
+	JWTSigningKey string
+
+	// Почта: MailDriver "smtp" или "file" (письма .eml в MailDir)
+	MailDriver   string
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUser     string
+	SMTPPassword string
+	// PublicURL - адрес приложения для ссылок в письмах
+	PublicURL string
+
+	// Оплата: PaymentProvider "yookassa" или "fake" (только для разработки)
+	PaymentProvider   string
+	YooKassaShopID    string
+	YooKassaSecretKey string
+	YooKassaAPIURL    string
 }
 
 // LoadConfig загружает конфигурацию из файла .env
@@ -44,7 +67,36 @@ func LoadConfig() (*Config, error) {
 		DBHost:     os.Getenv("DB_HOST"),
 		DBPort:     os.Getenv("DB_PORT"),
 		JWTSecret:  os.Getenv("JWT_SECRET"),
+
+		AppEnv:        os.Getenv("APP_ENV"),
+		JWTKeysDir:    os.Getenv("JWT_KEYS_DIR"),
+		JWTSigningKey: os.Getenv("JWT_SIGNING_KEY"),
+
+		MailDriver:   os.Getenv("MAIL_DRIVER"),
+		MailFrom:     os.Getenv("MAIL_FROM"),
+		MailDir:      os.Getenv("MAIL_DIR"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     os.Getenv("SMTP_PORT"),
+		SMTPUser:     os.Getenv("SMTP_USER"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		PublicURL:    os.Getenv("PUBLIC_URL"),
+
+		PaymentProvider:   os.Getenv("PAYMENT_PROVIDER"),
+		YooKassaShopID:    os.Getenv("YOOKASSA_SHOP_ID"),
+		YooKassaSecretKey: os.Getenv("YOOKASSA_SECRET_KEY"),
+		YooKassaAPIURL:    os.Getenv("YOOKASSA_API_URL"),
+	}
+
+	// Без APP_ENV считаем запуск боевым: небезопасные значения по умолчанию
+	// включаются только явным APP_ENV=dev
+	if config.AppEnv == "" {
+		config.AppEnv = "prod"
 	}
 
 	return config, nil
 }
+
+// IsDev - локальная разработка, где допустимы небезопасные значения по умолчанию
+func (c *Config) IsDev() bool {
+	return c.AppEnv == "dev"
+}
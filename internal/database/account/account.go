@@ -0,0 +1,295 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+	"github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/database/calendar"
+	"github.com/niazlv/sport-plus-LCT/internal/database/chat"
+	"github.com/niazlv/sport-plus-LCT/internal/database/course"
+	"github.com/niazlv/sport-plus-LCT/internal/database/goal"
+	"github.com/niazlv/sport-plus-LCT/internal/database/healthimport"
+	"github.com/niazlv/sport-plus-LCT/internal/database/metrics"
+	"github.com/niazlv/sport-plus-LCT/internal/database/payment"
+	"github.com/niazlv/sport-plus-LCT/internal/database/review"
+	"github.com/niazlv/sport-plus-LCT/internal/database/roster"
+	"github.com/niazlv/sport-plus-LCT/internal/database/upload"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Пакет собирает данные пользователя из всех пакетов database для выгрузки
+// и удаляет их одной транзакцией. Своих таблиц у пакета нет.
+
+// UserData - все, что хранится о пользователе
+type UserData struct {
+	User         auth.User                `json:"user"`
+	Measurements []auth.Measurement       `json:"measurements"`
+	Trains       []auth.Train             `json:"trains"`
+	Progress     []course.ClientProgress  `json:"progress"`
+	CourseStatus []course.CourseStatus    `json:"course_status"`
+	Schedules    []calendar.Schedule      `json:"schedules"`
+	Reviews      []review.Review          `json:"reviews"`
+	Messages     []chat.Message           `json:"messages"`
+	Files        []upload.File            `json:"files"`
+	Metrics      []metrics.MetricSnapshot `json:"metrics"`
+	Goals        []goal.Goal              `json:"goals"`
+	Imports      []healthimport.ImportJob `json:"imports"`
+	Roster       []roster.Relationship    `json:"roster"`
+	Enrollments  []course.Enrollment      `json:"enrollments"`
+	Orders       []payment.Order          `json:"orders"`
+	Receipts     []payment.Receipt        `json:"receipts"`
+}
+
+// DeletedName - имя, которое получает удаленный (обезличенный) пользователь
+const DeletedName = "Удаленный пользователь"
+
+var db *gorm.DB
+
+func InitDB() (*gorm.DB, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
+		cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort)
+
+	for i := 0; i < 5; i++ {
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			time.Sleep(5 * time.Second)
+		} else {
+			break
+		}
+	}
+
+	if db == nil {
+		return nil, errors.New("failed to connect to database")
+	}
+
+	return db, nil
+}
+
+// CollectUserData читает данные пользователя в одной транзакции, чтобы выгрузка была согласованной
+func CollectUserData(userID int) (*UserData, error) {
+	data := &UserData{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", userID).First(&data.User).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Order("id").Find(&data.Measurements).Error; err != nil {
+			return err
+		}
+		err := tx.Where("user_id = ? OR client_id = ? OR trainer_id = ?", userID, userID, userID).
+			Order("id").Find(&data.Trains).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Preload("Courses.Classes.Lessons.Exercises").Where("client_id = ?", userID).
+			Order("id").Find(&data.Progress).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", userID).Order("id").Find(&data.CourseStatus).Error; err != nil {
+			return err
+		}
+		err = tx.Where("client_id = ? OR coach_id = ?", userID, userID).Order("id").Find(&data.Schedules).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("client_id = ? OR trainer_id = ?", userID, userID).Order("id").Find(&data.Reviews).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Preload("Attachments").Where("user_id = ?", userID).Order("id").Find(&data.Messages).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Order("id").Find(&data.Files).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Order("id").Find(&data.Metrics).Error; err != nil {
+			return err
+		}
+		if err := tx.Preload("History").Where("user_id = ?", userID).Order("id").Find(&data.Goals).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Order("id").Find(&data.Imports).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("trainer_id = ? OR client_id = ?", userID, userID).Order("id").Find(&data.Roster).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", userID).Order("id").Find(&data.Enrollments).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Order("id").Find(&data.Orders).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Order("id").Find(&data.Receipts).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DeleteUserData удаляет личные данные пользователя и обезличивает то, на что
+// ссылаются другие пользователи (строка users, его отзывы, расписания тренера, чеки).
+// Возвращает пути файлов, которые нужно удалить с диска после транзакции.
+func DeleteUserData(userID int) ([]string, error) {
+	var paths []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var files []upload.File
+		if err := tx.Where("user_id = ?", userID).Find(&files).Error; err != nil {
+			return err
+		}
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+
+		// Записи на курсы сначала закрываем, чтобы места достались листам ожидания
+		if err := course.CancelClientEnrollments(tx, userID); err != nil {
+			return err
+		}
+
+		// Прогресс по курсам удаляем снизу вверх, статусы связаны внешними ключами
+		courseStatusIDs := tx.Model(&course.CourseStatus{}).Select("id").Where("client_id = ?", userID)
+		classStatusIDs := tx.Model(&course.ClassStatus{}).Select("id").Where("course_id IN (?)", courseStatusIDs)
+		lessonStatusIDs := tx.Model(&course.LessonStatus{}).Select("id").Where("class_id IN (?)", classStatusIDs)
+		if err := tx.Where("lesson_id IN (?)", lessonStatusIDs).Delete(&course.ExerciseStatus{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("class_id IN (?)", classStatusIDs).Delete(&course.LessonStatus{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("course_id IN (?)", courseStatusIDs).Delete(&course.ClassStatus{}).Error; err != nil {
+			return err
+		}
+
+		// Личные данные удаляем целиком
+		deletes := []struct {
+			model interface{}
+			where string
+		}{
+			{&auth.Measurement{}, "user_id = ?"},
+			{&metrics.MetricSnapshot{}, "user_id = ?"},
+			{&course.CourseStatus{}, "client_id = ?"},
+			{&course.ClientProgress{}, "client_id = ?"},
+			{&course.Enrollment{}, "client_id = ?"},
+			{&calendar.Schedule{}, "client_id = ?"},
+			{&upload.File{}, "user_id = ?"},
+			{&healthimport.ImportJob{}, "user_id = ?"},
+			{&auth.Session{}, "user_id = ?"},
+			{&auth.RefreshToken{}, "user_id = ?"},
+			{&auth.RevokedToken{}, "user_id = ?"},
+			{&auth.AuthToken{}, "user_id = ?"},
+			{&auth.APIKey{}, "user_id = ?"},
+			{&auth.RecoveryCode{}, "user_id = ?"},
+			{&auth.TOTPSecret{}, "user_id = ?"},
+		}
+		for _, d := range deletes {
+			if err := tx.Where(d.where, userID).Delete(d.model).Error; err != nil {
+				return err
+			}
+		}
+		// Связи и приглашения без второй стороны не нужны
+		if err := tx.Where("trainer_id = ? OR client_id = ?", userID, userID).Delete(&roster.Relationship{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("trainer_id = ? OR client_id = ?", userID, userID).Delete(&roster.Invite{}).Error; err != nil {
+			return err
+		}
+		// Удаляем только свои тренировки, записанные для клиентов остаются у них без автора,
+		// а тренер в них указывает на обезличенную строку users
+		trainIDs := tx.Model(&auth.Train{}).Select("id").Where("client_id = ?", userID)
+		if err := tx.Where("train_id IN (?)", trainIDs).Delete(&auth.TrainTrack{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", userID).Delete(&auth.Train{}).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&auth.Train{}).Where("user_id = ?", userID).
+			Update("user_id", 0).Error
+		if err != nil {
+			return err
+		}
+
+		// Цели удаляем вместе с историей статусов
+		goalIDs := tx.Model(&goal.Goal{}).Select("id").Where("user_id = ?", userID)
+		if err := tx.Where("goal_id IN (?)", goalIDs).Delete(&goal.GoalStatusChange{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&goal.Goal{}).Error; err != nil {
+			return err
+		}
+
+		// Сообщения удаляем вместе с вложениями и выходим из всех чатов
+		messageIDs := tx.Model(&chat.Message{}).Select("id").Where("user_id = ?", userID)
+		if err := tx.Where("message_id IN (?)", messageIDs).Delete(&chat.Attachment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&chat.Message{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM chat_users WHERE user_id = ?", userID).Error; err != nil {
+			return err
+		}
+
+		// Заказы и чеки нужны для бухгалтерии, из чеков убираем данные покупателя
+		err = tx.Model(&payment.Receipt{}).Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"buyer_name": DeletedName, "buyer_email": ""}).Error
+		if err != nil {
+			return err
+		}
+
+		// Оценки отзывов остаются в рейтингах курсов, текст удаляем
+		err = tx.Model(&review.Review{}).Where("client_id = ?", userID).Update("comment", "").Error
+		if err != nil {
+			return err
+		}
+
+		// Строку пользователя не удаляем: на нее ссылаются курсы, тренировки и
+		// расписания других людей. Стираем все личные поля и закрываем вход.
+		now := time.Now()
+		result := tx.Model(&auth.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"login":                   fmt.Sprintf("deleted-%d", userID),
+			"password":                "",
+			"email":                   "",
+			"email_verified":          false,
+			"gender":                  "",
+			"goals":                   "",
+			"experience":              "",
+			"gym_member":              false,
+			"beginner":                false,
+			"gym_name":                "",
+			"health_conditions":       "",
+			"name":                    DeletedName,
+			"icon":                    "",
+			"about":                   "",
+			"achivements":             "",
+			"age":                     0,
+			"activity_level":          "",
+			"specialization":          "",
+			"session_price":           0,
+			"suspended_at":            now,
+			"suspend_reason":          "account deleted",
+			"password_reset_required": false,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserFilter - параметры поиска пользователей в админке. Пустые поля не фильтруют.
+type UserFilter struct {
+	Query     string // подстрока логина, имени или почты
+	Role      *int
+	Suspended *bool
+	Offset    int
+	Limit     int
+}
+
+// SearchUsers возвращает страницу пользователей и общее число найденных
+func SearchUsers(filter UserFilter) ([]User, int64, error) {
+	query := db.Model(&User{})
+	if filter.Query != "" {
+		like := "%" + EscapeLike(filter.Query) + "%"
+		query = query.Where("login ILIKE ? OR name ILIKE ? OR email ILIKE ?", like, like, like)
+	}
+	if filter.Role != nil {
+		query = query.Where("role = ?", *filter.Role)
+	}
+	if filter.Suspended != nil {
+		if *filter.Suspended {
+			query = query.Where("suspended_at IS NOT NULL")
+		} else {
+			query = query.Where("suspended_at IS NULL")
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []User
+	result := query.Order("id").Offset(filter.Offset).Limit(filter.Limit).Find(&users)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return users, total, nil
+}
+
+// EscapeLike экранирует спецсимволы LIKE в пользовательском вводе
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func UpdateUserRole(userID int, role int) error {
+	return updateUserFields(userID, map[string]interface{}{"role": role})
+}
+
+// SuspendUser блокирует аккаунт и сразу завершает все его сессии
+func SuspendUser(userID int, reason string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"suspended_at": now, "suspend_reason": reason})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		err := tx.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+	})
+}
+
+func UnsuspendUser(userID int) error {
+	return updateUserFields(userID, map[string]interface{}{"suspended_at": nil, "suspend_reason": ""})
+}
+
+// SetPasswordResetRequired - пока флаг стоит, вход по паролю запрещен
+func SetPasswordResetRequired(userID int, required bool) error {
+	return updateUserFields(userID, map[string]interface{}{"password_reset_required": required})
+}
+
+func updateUserFields(userID int, fields map[string]interface{}) error {
+	result := db.Model(&User{}).Where("id = ?", userID).Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey - ключ для автоматизации (импорт, интеграции). Действует от имени
+// пользователя UserID, но только в пределах своих Scopes.
+type APIKey struct {
+	Id         int        `gorm:"primaryKey" json:"id"`
+	UserID     int        `gorm:"index" json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // начало ключа, чтобы отличать ключи в списке
+	KeyHash    string     `gorm:"uniqueIndex" json:"-"`
+	Scopes     string     `json:"scopes"` // через запятую, например "exercise:write,upload:write"
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (k *APIKey) IsActive() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt)
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range strings.Split(k.Scopes, ",") {
+		if strings.TrimSpace(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func CreateAPIKey(key *APIKey) (*APIKey, error) {
+	result := db.Create(key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return key, nil
+}
+
+func FindAPIKeyByHash(hash string) (*APIKey, error) {
+	var key APIKey
+	result := db.Where("key_hash = ?", hash).First(&key)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &key, nil
+}
+
+func FindAPIKeysByUserID(userID int) ([]APIKey, error) {
+	var keys []APIKey
+	result := db.Where("user_id = ?", userID).Order("id").Find(&keys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return keys, nil
+}
+
+func RevokeAPIKey(id int, userID int) error {
+	result := db.Model(&APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey обновляет время последнего использования не чаще раза в минуту
+func TouchAPIKey(id int) error {
+	now := time.Now()
+	return db.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-time.Minute)).
+		Update("last_used_at", now).Error
+}
@@ -16,59 +16,121 @@ const (
 	TypeWater  = "water"
 )
 
-var ValidTypesMeasurement = []string{TypeHeight, TypeWeight, TypeWater}
+// Роли пользователей, хранятся в User.Role
+const (
+	RoleClient  = 0
+	RoleTrainer = 1
+	RoleAdmin   = 2
+)
+
+var roleNames = map[int]string{
+	RoleClient:  "client",
+	RoleTrainer: "trainer",
+	RoleAdmin:   "admin",
+}
 
-type Measurement struct {
-	ID     int    `gorm:"primaryKey" json:"id"`
-	UserID int    `json:"userId"`
-	Date   string `json:"date" body:"date"`
-	Value  string `json:"value" body:"value"`
-	Type   string `json:"type" body:"type"`
+// RoleName возвращает название роли, для неизвестных ролей - пустую строку
+func RoleName(role int) string {
+	return roleNames[role]
 }
 
 type Train struct {
-	ID        int    `gorm:"primaryKey" json:"id"`
-	UserID    int    `json:"userId"`
-	Date      string `json:"date" body:"date"`
-	TrainerID int    `json:"trainerId" body:"trainerId"`
-	ClientID  int    `json:"clientId" body:"clientId"`
-	Duration  string `json:"duration" body:"duration"`
-	Trainer   User   `json:"trainer,omitempty" gorm:"foreignKey:TrainerID"`
-	Client    User   `json:"client,omitempty" gorm:"foreignKey:ClientID"`
+	ID        int     `gorm:"primaryKey" json:"id"`
+	UserID    int     `json:"userId"`
+	Date      string  `json:"date" body:"date"`
+	TrainerID int     `json:"trainerId" body:"trainerId"`
+	ClientID  int     `json:"clientId" body:"clientId"`
+	Duration  string  `json:"duration" body:"duration"`
+	Distance  float64 `json:"distance" body:"distance"` // метры, для бега и других циклических тренировок
+	Trainer   User    `json:"trainer,omitempty" gorm:"foreignKey:TrainerID"`
+	Client    User    `json:"client,omitempty" gorm:"foreignKey:ClientID"`
+
+	// Заполняются при импорте из файла трекера, у тренировок вручную пустые
+	Source          string     `json:"source" gorm:"default:manual"` // TrainSourceManual или формат файла: gpx, tcx, fit
+	Sport           string     `json:"sport,omitempty"`
+	StartedAt       *time.Time `json:"startedAt,omitempty" gorm:"index"`
+	DurationSeconds int        `json:"durationSeconds,omitempty"`
+	ElevationGain   float64    `json:"elevationGain,omitempty"` // метры
+	AvgHeartRate    int        `json:"avgHeartRate,omitempty"`
+	MaxHeartRate    int        `json:"maxHeartRate,omitempty"`
+	Pace            float64    `json:"pace,omitempty"` // секунд на километр
+	FileHash        string     `json:"-" gorm:"index"` // sha256 загруженного файла
 }
 
 type User struct {
-	Id               int           `gorm:"primaryKey" json:"id" body:"id"`
-	Login            string        `gorm:"unique" json:"login" body:"login"`
-	Password         string        `json:"password" body:"password"`
-	Gender           string        `json:"gender" body:"gender"`
-	Height           []Measurement `json:"height" body:"height" gorm:"foreignKey:UserID"`
-	Weight           []Measurement `json:"weight" body:"weight" gorm:"foreignKey:UserID"`
-	Water            []Measurement `json:"water" body:"water" gorm:"foreignKey:UserID"`
-	Trains           []Train       `json:"trains" body:"trains" gorm:"foreignKey:UserID"`
-	Goals            string        `json:"goals" body:"goals"`
-	Experience       string        `json:"experience" body:"experience"`
-	GymMember        bool          `json:"gymMember" body:"gymMember"`
-	Beginner         bool          `json:"beginner" body:"beginner"`
-	GymName          string        `json:"gymName" body:"gymName"`
-	HealthConditions string        `json:"healthConditions" body:"healthConditions"`
-	Role             int           `json:"role" body:"role"`
-	Name             string        `json:"name" body:"name"`
-	Icon             string        `json:"icon" body:"icon"`
-	About            string        `json:"about"`
-	Achivements      string        `json:"achivements"`
-	Age              int           `json:"age"`
-	Chats            []*Chat       `json:"chats" gorm:"many2many:chat_users"`
+	Id               int             `gorm:"primaryKey" json:"id" body:"id"`
+	Login            string          `gorm:"unique" json:"login" body:"login"`
+	Password         string          `json:"-" body:"password"` // bcrypt-хеш, наружу не отдается
+	Email            string          `gorm:"index:idx_users_email,unique,where:email <> ''" json:"email" body:"email"`
+	EmailVerified    bool            `json:"emailVerified"`
+	Gender           string          `json:"gender" body:"gender"`
+	Height           []Measurement   `json:"height" body:"height" gorm:"foreignKey:UserID"`
+	Weight           []Measurement   `json:"weight" body:"weight" gorm:"foreignKey:UserID"`
+	Water            []Measurement   `json:"water" body:"water" gorm:"foreignKey:UserID"`
+	Trains           []Train         `json:"trains" body:"trains" gorm:"foreignKey:UserID"`
+	Goals            string          `json:"goals" body:"goals"`
+	Experience       string          `json:"experience" body:"experience"`
+	GymMember        bool            `json:"gymMember" body:"gymMember"`
+	Beginner         bool            `json:"beginner" body:"beginner"`
+	GymName          string          `json:"gymName" body:"gymName"`
+	HealthConditions string          `json:"healthConditions" body:"healthConditions"`
+	Role             int             `json:"role" body:"role"` // RoleClient, RoleTrainer или RoleAdmin
+	Name             string          `json:"name" body:"name"`
+	Icon             string          `json:"icon" body:"icon"`
+	About            string          `json:"about"`
+	Achivements      string          `json:"achivements"`
+	Age              int             `json:"age"`
+	Chats            []*Chat         `json:"chats" gorm:"many2many:chat_users"`
+	Privacy          PrivacySettings `json:"privacy" gorm:"embedded;embeddedPrefix:privacy_"`
+	UnitSystem       string          `json:"unitSystem" gorm:"default:metric"`   // units.Metric или units.Imperial
+	ActivityLevel    string          `json:"activityLevel" body:"activityLevel"` // см. metrics.ValidActivityLevel
+	// Для каталога тренеров, у клиентов пустые
+	Specialization string  `json:"specialization" body:"specialization"` // через запятую: силовые, йога, бег
+	SessionPrice   float64 `json:"sessionPrice" body:"sessionPrice"`     // цена персонального занятия, руб.
+	// Меняются только администратором, см. /admin/users
+	SuspendedAt           *time.Time `json:"suspendedAt"`
+	SuspendReason         string     `json:"suspendReason"`
+	PasswordResetRequired bool       `json:"passwordResetRequired"`
+}
+
+// PrivacySettings - какие поля профиля пользователь скрывает от своих тренеров
+type PrivacySettings struct {
+	HideAge    bool `json:"hideAge"`
+	HideWeight bool `json:"hideWeight"`
+	HideHeight bool `json:"hideHeight"`
 }
 
 type Chat struct {
 	Id        int       `gorm:"primaryKey" json:"id"`
 	Name      string    `json:"name"`
+	CourseID  int       `gorm:"index" json:"course_id"` // 0 у старых чатов без курса
 	Users     []*User   `gorm:"many2many:chat_users"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// HasRole проверяет, что у пользователя одна из перечисленных ролей
+func (u *User) HasRole(roles ...int) bool {
+	for _, role := range roles {
+		if u.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) IsTrainer() bool {
+	return u.Role == RoleTrainer
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
 var db *gorm.DB
 
 func InitDB() (*gorm.DB, error) {
@@ -93,7 +155,11 @@ func InitDB() (*gorm.DB, error) {
 		return nil, errors.New("failed to connect to database")
 	}
 
-	err = db.AutoMigrate(&User{}, &Measurement{}, &Train{})
+	if err := migrateLegacyMeasurements(db); err != nil {
+		return nil, err
+	}
+
+	err = db.AutoMigrate(&User{}, &Measurement{}, &MeasurementType{}, &Train{}, &TrainTrack{}, &RefreshToken{}, &RevokedToken{}, &AuthToken{}, &LoginThrottle{}, &LockoutAudit{}, &TOTPSecret{}, &RecoveryCode{}, &APIKey{}, &Session{})
 	if err != nil {
 		return nil, err
 	}
@@ -103,9 +169,9 @@ func InitDB() (*gorm.DB, error) {
 
 func FindUserByLogin(login string) (*User, error) {
 	var user User
-	result := db.Preload("Height", "type = ?", "height").
-		Preload("Weight", "type = ?", "weight").
-		Preload("Water", "type = ?", "water").
+	result := db.Preload("Height", measurementsOfType("height")).
+		Preload("Weight", measurementsOfType("weight")).
+		Preload("Water", measurementsOfType("water")).
 		Preload("Trains.Trainer").
 		Preload("Trains.Client").
 		Where("login = ?", login).First(&user)
@@ -118,6 +184,29 @@ func FindUserByLogin(login string) (*User, error) {
 	return &user, nil
 }
 
+func FindUserByEmail(email string) (*User, error) {
+	var user User
+	result := db.Where("email = ?", email).First(&user)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+func SetEmailVerified(userId int) error {
+	result := db.Model(&User{}).Where("id = ?", userId).Update("email_verified", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func CreateUser(user *User) (*User, error) {
 	result := db.Create(user)
 	if result.Error != nil {
@@ -128,9 +217,9 @@ func CreateUser(user *User) (*User, error) {
 
 func FindUserByID(id int) (*User, error) {
 	var user User
-	result := db.Preload("Height", "type = ?", "height").
-		Preload("Weight", "type = ?", "weight").
-		Preload("Water", "type = ?", "water").
+	result := db.Preload("Height", measurementsOfType("height")).
+		Preload("Weight", measurementsOfType("weight")).
+		Preload("Water", measurementsOfType("water")).
 		Preload("Trains.Trainer").
 		Preload("Trains.Client").
 		Where("id = ?", id).First(&user)
@@ -154,6 +243,71 @@ func UpdateUserName(userId int, newName string) error {
 	return nil
 }
 
+func UpdateUnitSystem(userId int, system string) error {
+	result := db.Model(&User{}).Where("id = ?", userId).Update("unit_system", system)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func UpdatePrivacySettings(userId int, privacy PrivacySettings) error {
+	result := db.Model(&User{}).Where("id = ?", userId).Updates(map[string]interface{}{
+		"privacy_hide_age":    privacy.HideAge,
+		"privacy_hide_weight": privacy.HideWeight,
+		"privacy_hide_height": privacy.HideHeight,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RunningSports - виды спорта импортированных тренировок, дистанция которых
+// идет в беговые цели. У тренировок вручную вида спорта нет, их дистанция тоже учитывается.
+var RunningSports = []string{"running", "walking", "hiking"}
+
+// TrainTotals возвращает число тренировок клиента и суммарную дистанцию бега
+// и ходьбы в метрах. Тренировки, которые клиент записал другим как тренер, не считаются.
+func TrainTotals(clientID int) (count int64, distance float64, err error) {
+	var totals struct {
+		Count    int64
+		Distance float64
+	}
+	err = db.Model(&Train{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(distance) FILTER (WHERE sport IN ? OR sport = '' OR sport IS NULL), 0) AS distance", RunningSports).
+		Where("client_id = ?", clientID).
+		Scan(&totals).Error
+	return totals.Count, totals.Distance, err
+}
+
+func UpdateUserPassword(userId int, passwordHash string) error {
+	result := db.Model(&User{}).Where("id = ?", userId).Update("password", passwordHash)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// FindAllUsers возвращает пользователей без связанных записей (для служебных команд)
+func FindAllUsers() ([]User, error) {
+	var users []User
+	result := db.Order("id").Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return users, nil
+}
+
 func UpdateUser(user *User) error {
 	result := db.Model(&User{}).Where("id = ?", user.Id).Updates(user)
 	if result.Error != nil {
@@ -166,10 +320,8 @@ func UpdateUser(user *User) error {
 	if err := updateMeasurements(user); err != nil {
 		return err
 	}
-	if err := updateTrains(user); err != nil {
-		return err
-	}
 
+	// Тренировки здесь не перезаписываются, ими управляют AddTrain, UpdateTrain и DeleteTrain
 	return nil
 }
 
@@ -213,15 +365,6 @@ func updateWater(user *User) error {
 	return nil
 }
 
-func updateTrains(user *User) error {
-	db.Where("user_id = ?", user.Id).Delete(&Train{})
-	for _, train := range user.Trains {
-		train.UserID = user.Id
-		db.Create(&train)
-	}
-	return nil
-}
-
 func PartialUpdateUser(user *User) error {
 	updates := make(map[string]interface{})
 
@@ -270,6 +413,15 @@ func PartialUpdateUser(user *User) error {
 	if user.Age != 0 {
 		updates["age"] = user.Age
 	}
+	if user.ActivityLevel != "" {
+		updates["activity_level"] = user.ActivityLevel
+	}
+	if user.Specialization != "" {
+		updates["specialization"] = user.Specialization
+	}
+	if user.SessionPrice != 0 {
+		updates["session_price"] = user.SessionPrice
+	}
 
 	result := db.Model(&User{}).Where("id = ?", user.Id).Updates(updates)
 	if result.Error != nil {
@@ -279,37 +431,8 @@ func PartialUpdateUser(user *User) error {
 		return gorm.ErrRecordNotFound
 	}
 
-	if err := updateMeasurements(user); err != nil {
-		return err
-	}
-	if err := updateTrains(user); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func AddMeasurement(measurement *Measurement) (*Measurement, error) {
-	result := db.Create(measurement)
-	if result.Error != nil {
-		return nil, result.Error
-	}
-	return measurement, nil
-}
-
-func UpdateMeasurement(measurement *Measurement) (*Measurement, error) {
-	result := db.Save(measurement)
-	if result.Error != nil {
-		return nil, result.Error
-	}
-	return measurement, nil
-}
-
-func DeleteMeasurement(id int) error {
-	result := db.Delete(&Measurement{}, id)
-	if result.Error != nil {
-		return result.Error
-	}
+	// Замеры и тренировки здесь не перезаписываются: это история, новые значения
+	// добавляются через SaveMeasurements и AddTrain
 	return nil
 }
 
@@ -334,15 +457,18 @@ func UpdateTrain(train *Train) (*Train, error) {
 }
 
 func DeleteTrain(id int) error {
-	result := db.Delete(&Train{}, id)
-	if result.Error != nil {
-		return result.Error
-	}
-	return nil
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&TrainTrack{}, "train_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Train{}, id).Error
+	})
 }
 
 func SaveMeasurements(measurements []Measurement, userID int, measurementType string) error {
 	for _, measurement := range measurements {
+		// Всегда новая запись: ID от клиента не должен перезаписать чужой замер
+		measurement.ID = 0
 		measurement.UserID = userID
 		measurement.Type = measurementType
 
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginThrottle - счетчик неудачных входов по ключу "login:<login>" или "ip:<ip>".
+// Хранится в базе, чтобы блокировка переживала перезапуск.
+type LoginThrottle struct {
+	Key           string     `gorm:"primaryKey" json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// LockoutAudit - запись о блокировке для разбора инцидентов
+type LockoutAudit struct {
+	Id          int       `gorm:"primaryKey" json:"id"`
+	Key         string    `gorm:"index" json:"key"`
+	Login       string    `json:"login"`
+	IP          string    `json:"ip"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (t *LoginThrottle) IsLocked(now time.Time) bool {
+	return t.LockedUntil != nil && now.Before(*t.LockedUntil)
+}
+
+func GetLoginThrottle(key string) (*LoginThrottle, error) {
+	var throttle LoginThrottle
+	result := db.Where("key = ?", key).First(&throttle)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &throttle, nil
+}
+
+// IncrementLoginFailures атомарно увеличивает счетчик неудач. Если последняя неудача
+// была раньше windowStart, счет начинается заново.
+func IncrementLoginFailures(key string, now time.Time, windowStart time.Time) (*LoginThrottle, error) {
+	throttle := LoginThrottle{
+		Key:           key,
+		Failures:      1,
+		LastFailureAt: now,
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":        gorm.Expr("CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END", windowStart),
+			"last_failure_at": now,
+			"updated_at":      now,
+		}),
+	}).Create(&throttle).Error
+	if err != nil {
+		return nil, err
+	}
+	return GetLoginThrottle(key)
+}
+
+func LockLoginThrottle(key string, until time.Time) error {
+	return db.Model(&LoginThrottle{}).Where("key = ?", key).Update("locked_until", until).Error
+}
+
+func ResetLoginThrottle(key string) error {
+	return db.Where("key = ?", key).Delete(&LoginThrottle{}).Error
+}
+
+func CreateLockoutAudit(audit *LockoutAudit) error {
+	return db.Create(audit).Error
+}
+
+// DeleteStaleLoginThrottles удаляет счетчики без блокировки и давно не обновлявшиеся
+func DeleteStaleLoginThrottles(before time.Time) error {
+	return db.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", before, time.Now()).
+		Delete(&LoginThrottle{}).Error
+}
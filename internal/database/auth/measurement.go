@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Measurement - замер пользователя: рост, вес и т.д. Value хранится числом
+// в единицах Unit, MeasuredAt - момент замера.
+type Measurement struct {
+	ID         int       `gorm:"primaryKey" json:"id"`
+	UserID     int       `gorm:"index" json:"userId"`
+	Type       string    `gorm:"index" json:"type" body:"type"`
+	Value      float64   `json:"value" body:"value"`
+	Unit       string    `json:"unit" body:"unit"`
+	MeasuredAt time.Time `gorm:"index" json:"measuredAt" body:"measuredAt"`
+}
+
+// BeforeSave подставляет единицы и время замера, если клиент их не передал
+func (m *Measurement) BeforeSave(tx *gorm.DB) error {
+	if m.Unit == "" {
+		measurementType, err := findMeasurementType(tx.Session(&gorm.Session{NewDB: true}), m.Type)
+		if err != nil {
+			return err
+		}
+		if measurementType != nil {
+			m.Unit = measurementType.Unit
+		}
+	}
+	if m.MeasuredAt.IsZero() {
+		m.MeasuredAt = time.Now()
+	}
+	return nil
+}
+
+// Форматы дат, которые встречались в строковом поле date до перехода на MeasuredAt
+var measurementTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"02.01.2006 15:04",
+	"02.01.2006",
+	"02/01/2006",
+}
+
+// ParseMeasurementTime разбирает дату замера в одном из старых строковых форматов
+func ParseMeasurementTime(raw string) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range measurementTimeLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseMeasurementValue достает число из строки вида "72,5 кг"
+func ParseMeasurementValue(raw string) (float64, bool) {
+	s := strings.TrimSpace(strings.ReplaceAll(raw, ",", "."))
+	end := 0
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.' || end == 0 && s[end] == '-') {
+		end++
+	}
+	value, err := strconv.ParseFloat(s[:end], 64)
+	return value, err == nil
+}
+
+// migrateLegacyMeasurements переводит таблицу со строковыми value и date на
+// числа и время. Старые значения остаются в колонках value_raw и date_raw,
+// строки, которые не удалось разобрать, пишутся в лог.
+func migrateLegacyMeasurements(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&Measurement{}) || migrator.HasColumn(&Measurement{}, "measured_at") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`ALTER TABLE measurements RENAME COLUMN "value" TO value_raw`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`ALTER TABLE measurements RENAME COLUMN "date" TO date_raw`).Error; err != nil {
+			return err
+		}
+		if err := tx.Migrator().AutoMigrate(&Measurement{}); err != nil {
+			return err
+		}
+
+		var rows []struct {
+			ID       int
+			Type     string
+			ValueRaw string
+			DateRaw  string
+		}
+		err := tx.Table("measurements").Select("id, type, value_raw, date_raw").Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		failed := 0
+		for _, row := range rows {
+			value, okValue := ParseMeasurementValue(row.ValueRaw)
+			measuredAt, okTime := ParseMeasurementTime(row.DateRaw)
+			if !okTime {
+				measuredAt = now
+			}
+			if !okValue || !okTime {
+				failed++
+				log.Printf("migrateLegacyMeasurements(): measurement %d: can't parse value %q or date %q", row.ID, row.ValueRaw, row.DateRaw)
+			}
+			err := tx.Table("measurements").Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"value":       value,
+				"unit":        builtinMeasurementTypes[row.Type].Unit,
+				"measured_at": measuredAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("migrateLegacyMeasurements(): migrated %d measurements, %d need manual check", len(rows), failed)
+		return nil
+	})
+}
+
+// measurementsOfType - условие для Preload замеров одного типа в хронологическом порядке
+func measurementsOfType(measurementType string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("type = ?", measurementType).Order("measured_at")
+	}
+}
+
+func FindMeasurementByID(id int) (*Measurement, error) {
+	var measurement Measurement
+	result := db.Where("id = ?", id).First(&measurement)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &measurement, nil
+}
+
+// FindLatestMeasurement возвращает последний по времени замер типа, nil - если замеров нет
+func FindLatestMeasurement(userID int, measurementType string) (*Measurement, error) {
+	var measurement Measurement
+	result := db.Where("user_id = ? AND type = ?", userID, measurementType).Order("measured_at DESC, id DESC").First(&measurement)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &measurement, nil
+}
+
+// FindLatestMeasurements возвращает последний замер каждого типа
+func FindLatestMeasurements(userID int) ([]Measurement, error) {
+	var measurements []Measurement
+	result := db.Raw(`SELECT DISTINCT ON (type) * FROM measurements
+		WHERE user_id = ? ORDER BY type, measured_at DESC, id DESC`, userID).Scan(&measurements)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return measurements, nil
+}
+
+// FindMeasurements возвращает замеры пользователя типа measurementType за [from, to)
+func FindMeasurements(userID int, measurementType string, from, to time.Time) ([]Measurement, error) {
+	var measurements []Measurement
+	result := db.Where("user_id = ? AND type = ? AND measured_at >= ? AND measured_at < ?", userID, measurementType, from, to).
+		Order("measured_at").Find(&measurements)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return measurements, nil
+}
+
+// Размеры интервалов для AggregateMeasurements, значения подходят для date_trunc
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+// MeasurementBucket - сводка замеров за интервал, начинающийся в Start
+type MeasurementBucket struct {
+	Start time.Time `json:"start"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Last  float64   `json:"last"` // последнее по времени значение в интервале
+	Sum   float64   `json:"sum"`
+	Count int       `json:"count"`
+	Value float64   `json:"value"` // итог интервала по правилу агрегации типа
+}
+
+// AggregateMeasurements считает min, max, среднее, последнее значение и сумму по интервалам
+func AggregateMeasurements(userID int, measurementType *MeasurementType, from, to time.Time, bucket string) ([]MeasurementBucket, error) {
+	var buckets []MeasurementBucket
+	err := db.Raw(`
+		SELECT date_trunc(?, measured_at) AS start,
+			MIN(value) AS min,
+			MAX(value) AS max,
+			AVG(value) AS avg,
+			(ARRAY_AGG(value ORDER BY measured_at DESC, id DESC))[1] AS last,
+			SUM(value) AS sum,
+			COUNT(*) AS count
+		FROM measurements
+		WHERE user_id = ? AND type = ? AND measured_at >= ? AND measured_at < ?
+		GROUP BY 1
+		ORDER BY 1`, bucket, userID, measurementType.Name, from, to).Scan(&buckets).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range buckets {
+		buckets[i].Value = measurementType.Aggregate(&buckets[i])
+	}
+	return buckets, nil
+}
+
+func AddMeasurement(measurement *Measurement) (*Measurement, error) {
+	result := db.Create(measurement)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return measurement, nil
+}
+
+func UpdateMeasurement(measurement *Measurement) (*Measurement, error) {
+	result := db.Save(measurement)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return measurement, nil
+}
+
+func DeleteMeasurement(id int) error {
+	result := db.Delete(&Measurement{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// Встроенные типы замеров, вдобавок к TypeHeight, TypeWeight и TypeWater
+const (
+	TypeBodyFat     = "body_fat"
+	TypeWaist       = "waist"
+	TypeChest       = "chest"
+	TypeRestingHR   = "resting_hr"
+	TypeSleep       = "sleep"
+	TypeSteps       = "steps"
+	TypeSystolicBP  = "blood_pressure_systolic"
+	TypeDiastolicBP = "blood_pressure_diastolic"
+)
+
+// Как сводить замеры одного интервала в MeasurementBucket.Value
+const (
+	AggregationLast = "last" // последнее значение - вес, обхваты
+	AggregationSum  = "sum"  // сумма - вода, шаги
+	AggregationAvg  = "avg"  // среднее - пульс, давление
+	AggregationMax  = "max"
+	AggregationMin  = "min"
+)
+
+var validAggregations = map[string]bool{
+	AggregationLast: true,
+	AggregationSum:  true,
+	AggregationAvg:  true,
+	AggregationMax:  true,
+	AggregationMin:  true,
+}
+
+// ValidAggregation проверяет название правила агрегации
+func ValidAggregation(aggregation string) bool {
+	return validAggregations[aggregation]
+}
+
+// MeasurementType - тип замера: единица хранения (метрическая), допустимый
+// диапазон значений и правило агрегации. Встроенные типы описаны в коде,
+// пользовательские регистрирует администратор и они хранятся в базе.
+type MeasurementType struct {
+	Name        string  `gorm:"primaryKey" json:"name"`
+	Title       string  `json:"title"`
+	Unit        string  `json:"unit"`
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	Aggregation string  `json:"aggregation"`
+	Builtin     bool    `gorm:"-" json:"builtin"`
+	CreatedBy   int     `json:"createdBy,omitempty"`
+}
+
+// CheckValue проверяет, что значение в единицах хранения попадает в диапазон
+func (t *MeasurementType) CheckValue(value float64) error {
+	if value < t.Min || value > t.Max {
+		return fmt.Errorf("%s must be between %g and %g %s", t.Name, t.Min, t.Max, t.Unit)
+	}
+	return nil
+}
+
+// Aggregate сводит интервал к одному значению по правилу типа
+func (t *MeasurementType) Aggregate(bucket *MeasurementBucket) float64 {
+	switch t.Aggregation {
+	case AggregationSum:
+		return bucket.Sum
+	case AggregationAvg:
+		return bucket.Avg
+	case AggregationMax:
+		return bucket.Max
+	case AggregationMin:
+		return bucket.Min
+	default:
+		return bucket.Last
+	}
+}
+
+var builtinMeasurementTypes = map[string]MeasurementType{
+	TypeHeight:      {Name: TypeHeight, Title: "Рост", Unit: "cm", Min: 30, Max: 280, Aggregation: AggregationLast},
+	TypeWeight:      {Name: TypeWeight, Title: "Вес", Unit: "kg", Min: 2, Max: 400, Aggregation: AggregationLast},
+	TypeWater:       {Name: TypeWater, Title: "Вода", Unit: "l", Min: 0, Max: 20, Aggregation: AggregationSum},
+	TypeBodyFat:     {Name: TypeBodyFat, Title: "Процент жира", Unit: "%", Min: 2, Max: 75, Aggregation: AggregationLast},
+	TypeWaist:       {Name: TypeWaist, Title: "Обхват талии", Unit: "cm", Min: 30, Max: 250, Aggregation: AggregationLast},
+	TypeChest:       {Name: TypeChest, Title: "Обхват груди", Unit: "cm", Min: 40, Max: 250, Aggregation: AggregationLast},
+	TypeRestingHR:   {Name: TypeRestingHR, Title: "Пульс в покое", Unit: "bpm", Min: 20, Max: 220, Aggregation: AggregationAvg},
+	TypeSleep:       {Name: TypeSleep, Title: "Сон", Unit: "h", Min: 0, Max: 24, Aggregation: AggregationSum},
+	TypeSteps:       {Name: TypeSteps, Title: "Шаги", Unit: "steps", Min: 0, Max: 150000, Aggregation: AggregationSum},
+	TypeSystolicBP:  {Name: TypeSystolicBP, Title: "Давление, систолическое", Unit: "mmHg", Min: 50, Max: 300, Aggregation: AggregationAvg},
+	TypeDiastolicBP: {Name: TypeDiastolicBP, Title: "Давление, диастолическое", Unit: "mmHg", Min: 30, Max: 200, Aggregation: AggregationAvg},
+}
+
+// IsBuiltinMeasurementType - тип описан в коде, изменить или удалить его нельзя
+func IsBuiltinMeasurementType(name string) bool {
+	_, ok := builtinMeasurementTypes[name]
+	return ok
+}
+
+func findMeasurementType(tx *gorm.DB, name string) (*MeasurementType, error) {
+	if builtin, ok := builtinMeasurementTypes[name]; ok {
+		builtin.Builtin = true
+		return &builtin, nil
+	}
+
+	var measurementType MeasurementType
+	result := tx.Where("name = ?", name).First(&measurementType)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &measurementType, nil
+}
+
+// FindMeasurementType ищет тип среди встроенных и зарегистрированных, nil - если такого нет
+func FindMeasurementType(name string) (*MeasurementType, error) {
+	return findMeasurementType(db, name)
+}
+
+// FindMeasurementTypes возвращает все типы: сначала встроенные, затем пользовательские
+func FindMeasurementTypes() ([]MeasurementType, error) {
+	types := make([]MeasurementType, 0, len(builtinMeasurementTypes))
+	for _, builtin := range builtinMeasurementTypes {
+		builtin.Builtin = true
+		types = append(types, builtin)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+
+	var custom []MeasurementType
+	if err := db.Order("name").Find(&custom).Error; err != nil {
+		return nil, err
+	}
+	return append(types, custom...), nil
+}
+
+func CreateMeasurementType(measurementType *MeasurementType) (*MeasurementType, error) {
+	result := db.Create(measurementType)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return measurementType, nil
+}
+
+// UpdateMeasurementType меняет название, диапазон и агрегацию. Единицу не меняем:
+// в ней уже сохранены замеры.
+func UpdateMeasurementType(measurementType *MeasurementType) error {
+	result := db.Model(&MeasurementType{}).Where("name = ?", measurementType.Name).Updates(map[string]interface{}{
+		"title":       measurementType.Title,
+		"min":         measurementType.Min,
+		"max":         measurementType.Max,
+		"aggregation": measurementType.Aggregation,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteMeasurementType удаляет пользовательский тип, если по нему еще нет замеров
+func DeleteMeasurementType(name string) (deleted bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Measurement{}).Where("type = ?", name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		result := tx.Where("name = ?", name).Delete(&MeasurementType{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session - вход пользователя с конкретного устройства. Access- и refresh-токены
+// ссылаются на сессию, отзыв сессии гасит их все.
+type Session struct {
+	Id         int        `gorm:"primaryKey" json:"id"`
+	UserID     int        `gorm:"index" json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `gorm:"index" json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil
+}
+
+func CreateSession(session *Session) (*Session, error) {
+	if session.LastSeenAt.IsZero() {
+		session.LastSeenAt = time.Now()
+	}
+	result := db.Create(session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return session, nil
+}
+
+func FindSessionByID(id int) (*Session, error) {
+	var session Session
+	result := db.Where("id = ?", id).First(&session)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &session, nil
+}
+
+// FindActiveSessionsByUserID возвращает неотозванные сессии, активные после since
+func FindActiveSessionsByUserID(userID int, since time.Time) ([]Session, error) {
+	var sessions []Session
+	result := db.Where("user_id = ? AND revoked_at IS NULL AND last_seen_at > ?", userID, since).
+		Order("last_seen_at DESC").Find(&sessions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return sessions, nil
+}
+
+// TouchSession обновляет время последней активности не чаще раза в минуту
+func TouchSession(id int) error {
+	now := time.Now()
+	return db.Model(&Session{}).
+		Where("id = ? AND last_seen_at < ?", id, now.Add(-time.Minute)).
+		Update("last_seen_at", now).Error
+}
+
+// RevokeSession отзывает сессию пользователя вместе с ее refresh-токенами
+func RevokeSession(id int, userID int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&Session{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+			Update("revoked_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Model(&RefreshToken{}).
+			Where("session_id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", now).Error
+	})
+}
+
+// RevokeUserSessions завершает все сессии пользователя (выход на всех устройствах)
+func RevokeUserSessions(userID int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+	})
+}
+
+// DeleteStaleSessions удаляет сессии, неактивные или отозванные до before
+func DeleteStaleSessions(before time.Time) error {
+	return db.Where("last_seen_at < ? OR revoked_at < ?", before, before).Delete(&Session{}).Error
+}
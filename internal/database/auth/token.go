@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken хранит хеш refresh-токена. Сам токен клиенту отдается один раз
+// и в базе не хранится.
+type RefreshToken struct {
+	Id         int        `gorm:"primaryKey" json:"id"`
+	UserID     int        `gorm:"index" json:"user_id"`
+	SessionID  int        `gorm:"index" json:"session_id"`
+	TokenHash  string     `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy int        `json:"replaced_by"` // ID токена, выданного при ротации
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// RevokedToken - отозванный access-токен (по jti). Запись нужна только
+// до истечения самого токена.
+type RevokedToken struct {
+	Jti       string    `gorm:"primaryKey" json:"jti"`
+	UserID    int       `json:"user_id"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Назначение одноразовых токенов AuthToken
+const (
+	PurposePasswordReset = "password_reset"
+	PurposeEmailVerify   = "email_verify"
+)
+
+// AuthToken - одноразовый токен со сроком действия (сброс пароля, подтверждение почты)
+type AuthToken struct {
+	Id        int        `gorm:"primaryKey" json:"id"`
+	UserID    int        `gorm:"index" json:"user_id"`
+	Purpose   string     `gorm:"index" json:"purpose"`
+	TokenHash string     `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (t *RefreshToken) IsActive() bool {
+	return t.RevokedAt == nil && time.Now().Before(t.ExpiresAt)
+}
+
+func CreateRefreshToken(token *RefreshToken) (*RefreshToken, error) {
+	result := db.Create(token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return token, nil
+}
+
+func FindRefreshTokenByHash(hash string) (*RefreshToken, error) {
+	var token RefreshToken
+	result := db.Where("token_hash = ?", hash).First(&token)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken отзывает старый токен и сохраняет новый в одной транзакции
+func RotateRefreshToken(old *RefreshToken, next *RefreshToken) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", old.Id).
+			Updates(map[string]interface{}{"revoked_at": now, "replaced_by": next.Id})
+		if result.Error != nil {
+			return result.Error
+		}
+		// Токен уже отозван параллельным запросом
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func RevokeRefreshToken(id int) error {
+	return db.Model(&RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+func RevokeAccessToken(jti string, userID int, expiresAt time.Time) error {
+	token := RevokedToken{
+		Jti:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	}
+	return db.Where(RevokedToken{Jti: jti}).FirstOrCreate(&token).Error
+}
+
+func IsAccessTokenRevoked(jti string) (bool, error) {
+	var count int64
+	err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CreateAuthToken сохраняет новый токен и гасит прежние неиспользованные токены
+// того же назначения, чтобы рабочей оставалась только последняя ссылка из письма
+func CreateAuthToken(token *AuthToken) (*AuthToken, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&AuthToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ConsumeAuthToken помечает токен использованным и возвращает его.
+// Возвращает nil, если токена нет, он просрочен или уже использован.
+func ConsumeAuthToken(purpose string, hash string) (*AuthToken, error) {
+	var token AuthToken
+	result := db.Where("token_hash = ? AND purpose = ?", hash, purpose).First(&token)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+
+	now := time.Now()
+	result = db.Model(&AuthToken{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", token.Id, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	token.UsedAt = &now
+	return &token, nil
+}
+
+// DeleteExpiredTokens удаляет просроченные записи, они больше ничего не защищают
+func DeleteExpiredTokens() error {
+	now := time.Now()
+	if err := db.Where("expires_at < ?", now).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("expires_at < ?", now).Delete(&AuthToken{}).Error; err != nil {
+		return err
+	}
+	return db.Where("expires_at < ?", now).Delete(&RefreshToken{}).Error
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/activity"
+	"gorm.io/gorm"
+)
+
+const TrainSourceManual = "manual"
+
+// TrainTrack - трек импортированной тренировки для отрисовки на карте.
+// Хранится отдельно, чтобы списки тренировок не тянули тысячи точек.
+type TrainTrack struct {
+	TrainID int              `gorm:"primaryKey" json:"train_id"`
+	Points  []activity.Point `gorm:"serializer:json" json:"points"`
+}
+
+// CreateTrainWithTrack сохраняет импортированную тренировку вместе с треком
+func CreateTrainWithTrack(train *Train, points []activity.Point) (*Train, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(train).Error; err != nil {
+			return err
+		}
+		return tx.Create(&TrainTrack{TrainID: train.ID, Points: points}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	db.Preload("Trainer").Preload("Client").First(train, train.ID)
+	return train, nil
+}
+
+// FindTrainByID возвращает тренировку или nil, если ее нет
+func FindTrainByID(id int) (*Train, error) {
+	var train Train
+	if err := db.First(&train, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &train, nil
+}
+
+// FindTrainByFileHash ищет тренировку клиента, импортированную из того же файла
+func FindTrainByFileHash(clientID int, hash string) (*Train, error) {
+	var train Train
+	err := db.Where("client_id = ? AND file_hash = ?", clientID, hash).First(&train).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &train, nil
+}
+
+// Одна и та же тренировка, выгруженная разными приложениями, дает файлы с
+// разным содержимым: время старта и дистанция совпадают лишь примерно
+const (
+	duplicateStartWindow    = 2 * time.Minute
+	duplicateDistanceRatio  = 0.02
+	duplicateDistanceMeters = 50.0
+)
+
+// FindDuplicateTrain ищет у клиента импортированную тренировку с тем же
+// временем старта и дистанцией
+func FindDuplicateTrain(clientID int, startedAt time.Time, distance float64) (*Train, error) {
+	var trains []Train
+	err := db.Where("client_id = ? AND started_at BETWEEN ? AND ?", clientID,
+		startedAt.Add(-duplicateStartWindow), startedAt.Add(duplicateStartWindow)).
+		Find(&trains).Error
+	if err != nil {
+		return nil, err
+	}
+	tolerance := math.Max(distance*duplicateDistanceRatio, duplicateDistanceMeters)
+	for i := range trains {
+		if math.Abs(trains[i].Distance-distance) <= tolerance {
+			return &trains[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// FindTrainTrack возвращает трек тренировки или nil, если тренировка введена вручную
+func FindTrainTrack(trainID int) (*TrainTrack, error) {
+	var track TrainTrack
+	if err := db.First(&track, "train_id = ?", trainID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &track, nil
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TOTPSecret - секрет двухфакторной аутентификации пользователя.
+// Пока ConfirmedAt пустой, 2FA не включена и при входе не спрашивается.
+type TOTPSecret struct {
+	UserID       int        `gorm:"primaryKey" json:"user_id"`
+	Secret       string     `json:"-"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	LastUsedStep int64      `json:"-"` // последний принятый шаг, защищает от повтора кода
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// RecoveryCode - одноразовый код восстановления на случай потери телефона
+type RecoveryCode struct {
+	Id        int        `gorm:"primaryKey" json:"id"`
+	UserID    int        `gorm:"index" json:"user_id"`
+	CodeHash  string     `gorm:"uniqueIndex" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (s *TOTPSecret) IsConfirmed() bool {
+	return s.ConfirmedAt != nil
+}
+
+func GetTOTPSecret(userID int) (*TOTPSecret, error) {
+	var secret TOTPSecret
+	result := db.Where("user_id = ?", userID).First(&secret)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &secret, nil
+}
+
+// SaveTOTPSecret создает или заменяет неподтвержденный секрет
+func SaveTOTPSecret(secret *TOTPSecret) error {
+	return db.Save(secret).Error
+}
+
+// ConfirmTOTPSecret включает 2FA и заменяет коды восстановления новыми
+func ConfirmTOTPSecret(userID int, step int64, codeHashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&TOTPSecret{}).Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"confirmed_at": now, "last_used_step": step}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for _, hash := range codeHashes {
+			if err := tx.Create(&RecoveryCode{UserID: userID, CodeHash: hash}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseTOTPStep запоминает принятый шаг. Возвращает false, если этот или более
+// поздний шаг уже был использован (код перехвачен и отправлен повторно).
+func UseTOTPStep(userID int, step int64) (bool, error) {
+	result := db.Model(&TOTPSecret{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// UseRecoveryCode гасит код восстановления. Возвращает false, если кода нет или он использован.
+func UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func CountRecoveryCodes(userID int) (int64, error) {
+	var count int64
+	err := db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// DeleteTOTP выключает 2FA пользователя
+func DeleteTOTP(userID int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&TOTPSecret{}).Error
+	})
+}
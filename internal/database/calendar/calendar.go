@@ -24,8 +24,9 @@ type Schedule struct {
 	IsGlobal       bool      `json:"is_global"` // Глобальное или локальное событие
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
-	Client         auth.User `json:"client"`
-	Coach          auth.User `json:"coach"`
+	// Стороны отдаются только через профили API с учетом приватности
+	Client auth.User `json:"-"`
+	Coach  auth.User `json:"-"`
 }
 
 var db *gorm.DB
@@ -111,6 +112,17 @@ func GetSchedulesByCoachID(coachID int) ([]Schedule, error) {
 	return schedules, nil
 }
 
+// GetSchedulesBetween возвращает занятия тренера с клиентом и глобальные события
+func GetSchedulesBetween(coachID int, clientID int) ([]Schedule, error) {
+	var schedules []Schedule
+	result := db.Preload("Client").Preload("Coach").
+		Where("(coach_id = ? AND client_id = ?) OR is_global = ?", coachID, clientID, true).Find(&schedules)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return schedules, nil
+}
+
 func GetSchedulesByClientID(clientID int) ([]Schedule, error) {
 	var schedules []Schedule
 	result := db.Preload("Client").Preload("Coach").Where("client_id = ? OR is_global = ?", clientID, true).Find(&schedules)
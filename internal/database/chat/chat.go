@@ -70,13 +70,21 @@ type CreateChatFromCourseDto struct {
 }
 
 func CreateChatFromCourse(dto *CreateChatFromCourseDto) (*database_auth.Chat, error) {
-	course := new(course.Course) // get course by id
-
-	chatName := course.Title
+	crs, err := course.GetCourseByID(dto.CourseId)
+	if err != nil {
+		return nil, err
+	}
+	if crs == nil {
+		return nil, fmt.Errorf("course %d not found", dto.CourseId)
+	}
+	if crs, err = course.PublishedCard(crs); err != nil {
+		return nil, err
+	}
 
 	chat := &database_auth.Chat{
-		Name: chatName,
-	} // create chat for dto.UserId and course.TrainerId
+		Name:     crs.Title,
+		CourseID: crs.Id,
+	}
 
 	result := db.Create(chat)
 	if result.Error != nil {
@@ -85,24 +93,22 @@ func CreateChatFromCourse(dto *CreateChatFromCourseDto) (*database_auth.Chat, er
 	return chat, nil
 }
 
-func CanJoinChat(chatId int, userId int) bool {
+// IsChatMember - пользователь добавлен в чат (для старых чатов без курса)
+func IsChatMember(chatId int, userId int) (bool, error) {
 	var count int64
-	err := db.Model(&database_auth.Chat{}).
-		Joins("JOIN chat_users ON chat_users.chat_id = chats.id").
-		Where("chats.id = ? AND chat_users.user_id = ?", chatId, userId).
+	err := db.Table("chat_users").
+		Where("chat_id = ? AND user_id = ?", chatId, userId).
 		Count(&count).Error
-
-	if err != nil {
-		return false
-	}
-
-	return count == 0
+	return count > 0, err
 }
 
 func GetChatByID(id int) (*database_auth.Chat, error) {
 	var chat database_auth.Chat
 	result := db.First(&chat, id)
 	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
 		return nil, result.Error
 	}
 	return &chat, nil
@@ -117,6 +123,20 @@ func GetChats() ([]database_auth.Chat, error) {
 	return chats, nil
 }
 
+// GetChatsByUserID возвращает чаты курсов, которые пользователь ведет или
+// проходит, и старые чаты без курса, в которые он добавлен
+func GetChatsByUserID(userID int) ([]database_auth.Chat, error) {
+	var chats []database_auth.Chat
+	result := db.Where("course_id IN (SELECT id FROM courses WHERE trainer_id = ?)", userID).
+		Or("course_id IN (SELECT course_id FROM enrollments WHERE client_id = ? AND status = ?)", userID, course.EnrollmentActive).
+		Or("course_id = 0 AND id IN (SELECT chat_id FROM chat_users WHERE user_id = ?)", userID).
+		Order("id").Find(&chats)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return chats, nil
+}
+
 type CreateMessageDto struct {
 	Message        Message
 	AttachableId   *int
@@ -134,6 +154,13 @@ func CreateMessage(dto *CreateMessageDto) (*Message, error) {
 		return nil, err
 	}
 
+	if dto.AttachableType == nil || dto.AttachableId == nil {
+		if err := tx.Commit().Error; err != nil {
+			return nil, err
+		}
+		return &dto.Message, nil
+	}
+
 	attachment := &Attachment{
 		AttachableType: *dto.AttachableType,
 		AttachableId:   *dto.AttachableId,
@@ -163,12 +190,9 @@ func GetMessagesByChatID(chatID int) ([]Message, error) {
 	return messages, nil
 }
 
-func GetChatByCourseAndUser(courseID int, userID int) (*database_auth.Chat, error) {
+func GetChatByCourseID(courseID int) (*database_auth.Chat, error) {
 	var chat database_auth.Chat
-	err := db.Joins("JOIN chat_users ON chat_users.chat_id = chats.id").
-		Joins("JOIN courses ON courses.trainer_id = chat_users.user_id").
-		Where("chat_users.user_id = ? AND courses.id = ?", userID, courseID).
-		First(&chat).Error
+	err := db.Where("course_id = ?", courseID).Order("id").First(&chat).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil // Возвращаем nil, если чат не найден
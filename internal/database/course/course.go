@@ -3,6 +3,8 @@ package course
 import (
 	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"time"
 
 	"github.com/niazlv/sport-plus-LCT/internal/config"
@@ -19,20 +21,25 @@ const (
 
 // Course модель курса
 type Course struct {
-	Id                int       `gorm:"primaryKey" json:"id"`
-	Title             string    `json:"title"`
-	Description       string    `json:"description"`
-	Difficulty        string    `json:"difficulty"`
-	DifficultyNumeric int       `json:"difficulty_numeric"`
-	Direction         string    `json:"direction"`
-	TrainerID         int       `json:"trainer_id"`
-	Cost              float64   `json:"cost"`
-	ParticipantsCount int       `json:"participants_count"`
-	Rating            float64   `json:"rating"`
-	RequiredTools     string    `json:"required_tools"`
-	CreatedAt         time.Time `json:"created_at"`
-	UpdatedAt         time.Time `json:"updated_at"`
-	Classes           []Class   `json:"classes" gorm:"foreignKey:CourseID"`
+	Id                int        `gorm:"primaryKey" json:"id"`
+	Title             string     `json:"title"`
+	Description       string     `json:"description"`
+	Difficulty        string     `json:"difficulty"`
+	DifficultyNumeric int        `json:"difficulty_numeric"`
+	Direction         string     `json:"direction"`
+	TrainerID         int        `json:"trainer_id"`
+	Cost              float64    `json:"cost"`
+	ParticipantsCount int        `json:"participants_count"` // активные записи, см. Enrollment
+	Capacity          int        `json:"capacity"`           // 0 - без ограничений
+	Rating            float64    `json:"rating"`             // байесовское среднее отзывов, см. review.BayesianAverage
+	ReviewsCount      int        `json:"reviews_count"`      // считается по отзывам
+	RequiredTools     string     `json:"required_tools"`
+	Status            string     `gorm:"index;not null;default:draft" json:"status"` // CourseDraft, CoursePublished, CourseUnpublished
+	CurrentVersion    int        `gorm:"not null;default:0" json:"current_version"`  // последняя опубликованная версия, 0 - не публиковался
+	PublishedAt       *time.Time `json:"published_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	Classes           []Class    `json:"classes" gorm:"foreignKey:CourseID"` // у тренера - черновик, у клиента - его версия
 }
 
 // Class модель занятия
@@ -142,6 +149,24 @@ func InitDB() (*gorm.DB, error) {
 		return nil, err
 	}
 
+	if err := migrateEnrollments(db); err != nil {
+		return nil, err
+	}
+	if err := migrateVersions(db); err != nil {
+		return nil, err
+	}
+
+	// Индексы для полнотекстового поиска по каталогу, см. SearchCourses:
+	// каталог ищет по опубликованным версиям, тренер по своим - по черновикам
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_courses_search ON courses USING GIN (" + searchVector + ")").Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_course_versions_search ON course_versions USING GIN (" + searchVector + ")").Error
+	if err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
@@ -167,23 +192,35 @@ func GetLessonByID(id int) (*Lesson, error) {
 	return &lesson, nil
 }
 
+// UpdateLesson сохраняет урок черновика. Если переданы упражнения, связи урока
+// с ними пересоздаются: опубликованные версии хранят свой снимок урока, а
+// прогресс при переходе на новую версию переносится по упражнениям.
 func UpdateLesson(lesson *Lesson) error {
-	result := db.Model(&Lesson{}).Where("id = ?", lesson.Id).Updates(lesson)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound
-	}
-
-	// Обновление упражнений
-	db.Where("lesson_id = ?", lesson.Id).Delete(&LessonExercise{})
-	for _, exercise := range lesson.Exercises {
-		exercise.LessonID = lesson.Id
-		db.Create(&exercise)
-	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Lesson{}).Where("id = ?", lesson.Id).
+			UpdateColumns(map[string]interface{}{"duration_seconds": lesson.DurationSeconds, "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if lesson.Exercises == nil {
+			return nil
+		}
 
-	return nil
+		if err := tx.Where("lesson_id = ?", lesson.Id).Delete(&LessonExercise{}).Error; err != nil {
+			return err
+		}
+		for i := range lesson.Exercises {
+			lesson.Exercises[i].Id = 0
+			lesson.Exercises[i].LessonID = lesson.Id
+		}
+		if len(lesson.Exercises) == 0 {
+			return nil
+		}
+		return tx.Omit("Exercise").Create(&lesson.Exercises).Error
+	})
 }
 
 func DeleteLesson(id int) error {
@@ -371,8 +408,11 @@ func GetClientProgressByClientAndCourseID(clientID int, courseID int) (*ClientPr
 }
 
 func EnsureFullStructure(clientID int, courseID int, progress *ClientProgress) {
-	var course Course
-	db.Preload("Classes.Lessons.Exercises").First(&course, courseID)
+	// Структура берется из версии курса, которую проходит клиент
+	course := Course{Id: courseID}
+	if version, err := FindClientVersion(courseID, clientID); err == nil && version != nil {
+		course.Classes = version.Classes
+	}
 
 	for _, class := range course.Classes {
 		classFound := false
@@ -431,9 +471,19 @@ func EnsureFullStructure(clientID int, courseID int, progress *ClientProgress) {
 	}
 }
 
+// EnsureFullClientStructure дозаводит статусы по курсам, на которые клиент
+// записан, по структуре его версии курса, в том числе после перехода на новую
 func EnsureFullClientStructure(clientID int, progress *ClientProgress) {
-	var courses []Course
-	db.Preload("Classes.Lessons.Exercises").Find(&courses)
+	versions, err := clientVersions(db, clientID, true)
+	if err != nil {
+		log.Println("ERROR EnsureFullClientStructure(): ", err)
+		return
+	}
+	courses := make([]Course, 0, len(versions))
+	for courseID, version := range versions {
+		courses = append(courses, Course{Id: courseID, Classes: version.Classes})
+	}
+	sort.Slice(courses, func(i, j int) bool { return courses[i].Id < courses[j].Id })
 
 	for _, course := range courses {
 		var courseStatus *CourseStatus
@@ -528,6 +578,23 @@ func EnsureFullClientStructure(clientID int, progress *ClientProgress) {
 	}
 }
 
+// EnsureClientProgress возвращает прогресс клиента, при необходимости
+// создавая его вместе со статусами по курсам, на которые он записан
+func EnsureClientProgress(clientID int) (*ClientProgress, error) {
+	progress, err := GetClientProgressByClientID(clientID)
+	if err == gorm.ErrRecordNotFound {
+		progress, err = CreateClientProgress(&ClientProgress{
+			ClientID: clientID,
+			Courses:  []CourseStatus{},
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	EnsureFullClientStructure(clientID, progress)
+	return progress, nil
+}
+
 func UpdateClientProgress(progress *ClientProgress) error {
 	result := db.Save(progress)
 	if result.Error != nil {
@@ -548,69 +615,45 @@ func DeleteClientProgress(id int) error {
 }
 
 func UpdateCourseStatus(clientID int, courseID int, newStatus string) error {
-	var courseStatus CourseStatus
-	result := db.Where("client_id = ? AND course_id = ?", clientID, courseID).First(&courseStatus)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			newCourseStatus := CourseStatus{
-				ClientID: clientID,
-				CourseID: courseID,
-				Status:   newStatus,
-				Classes:  []ClassStatus{},
-			}
-			return db.Create(&newCourseStatus).Error
-		}
-		return result.Error
+	courseStatus, err := findCourseStatus(clientID, courseID)
+	if err != nil {
+		return err
 	}
 	courseStatus.Status = newStatus
-	return db.Save(&courseStatus).Error
+	return db.Save(courseStatus).Error
 }
 
+// Статусы ниже курса ищутся только внутри его CourseStatus клиента, родитель
+// у них - ID строки статуса, а не ID занятия или урока.
 func UpdateClassStatus(clientID int, courseID int, classID int, newStatus string) error {
-	var classStatus ClassStatus
-	result := db.Where("course_id = ? AND class_id = ?", courseID, classID).First(&classStatus)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			newClassStatus := ClassStatus{
-				CourseID: courseID,
-				ClassID:  classID,
-				Status:   newStatus,
-				Lessons:  []LessonStatus{},
-			}
-			return db.Create(&newClassStatus).Error
-		}
-		return result.Error
+	classStatus, err := findClassStatus(clientID, courseID, classID)
+	if err != nil {
+		return err
 	}
 	classStatus.Status = newStatus
-	return db.Save(&classStatus).Error
+	return db.Save(classStatus).Error
 }
 
-func UpdateLessonStatus(clientID int, classID int, lessonID int, newStatus string) error {
-	var lessonStatus LessonStatus
-	result := db.Where("class_id = ? AND lesson_id = ?", classID, lessonID).First(&lessonStatus)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			newLessonStatus := LessonStatus{
-				ClassID:   classID,
-				LessonID:  lessonID,
-				Status:    newStatus,
-				Exercises: []ExerciseStatus{},
-			}
-			return db.Create(&newLessonStatus).Error
-		}
-		return result.Error
+func UpdateLessonStatus(clientID int, courseID int, classID int, lessonID int, newStatus string) error {
+	lessonStatus, err := findLessonStatus(clientID, courseID, classID, lessonID)
+	if err != nil {
+		return err
 	}
 	lessonStatus.Status = newStatus
-	return db.Save(&lessonStatus).Error
+	return db.Save(lessonStatus).Error
 }
 
-func UpdateExerciseStatus(clientID int, lessonID int, exerciseID int, newStatus string) error {
+func UpdateExerciseStatus(clientID int, courseID int, classID int, lessonID int, exerciseID int, newStatus string) error {
+	lessonStatus, err := findLessonStatus(clientID, courseID, classID, lessonID)
+	if err != nil {
+		return err
+	}
 	var exerciseStatus ExerciseStatus
-	result := db.Where("lesson_id = ? AND exercise_id = ?", lessonID, exerciseID).First(&exerciseStatus)
+	result := db.Where("lesson_id = ? AND exercise_id = ?", lessonStatus.Id, exerciseID).First(&exerciseStatus)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			newExerciseStatus := ExerciseStatus{
-				LessonID:   lessonID,
+				LessonID:   lessonStatus.Id,
 				ExerciseID: exerciseID,
 				Status:     newStatus,
 			}
@@ -621,6 +664,70 @@ func UpdateExerciseStatus(clientID int, lessonID int, exerciseID int, newStatus
 	exerciseStatus.Status = newStatus
 	return db.Save(&exerciseStatus).Error
 }
+
+// findCourseStatus возвращает статус курса клиента, создавая его при необходимости
+func findCourseStatus(clientID int, courseID int) (*CourseStatus, error) {
+	var courseStatus CourseStatus
+	result := db.Where("client_id = ? AND course_id = ?", clientID, courseID).First(&courseStatus)
+	if result.Error == gorm.ErrRecordNotFound {
+		courseStatus = CourseStatus{
+			ClientID: clientID,
+			CourseID: courseID,
+			Status:   StatusNotStarted,
+			Classes:  []ClassStatus{},
+		}
+		return &courseStatus, db.Create(&courseStatus).Error
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &courseStatus, nil
+}
+
+func findClassStatus(clientID int, courseID int, classID int) (*ClassStatus, error) {
+	courseStatus, err := findCourseStatus(clientID, courseID)
+	if err != nil {
+		return nil, err
+	}
+	var classStatus ClassStatus
+	result := db.Where("course_id = ? AND class_id = ?", courseStatus.Id, classID).First(&classStatus)
+	if result.Error == gorm.ErrRecordNotFound {
+		classStatus = ClassStatus{
+			CourseID: courseStatus.Id,
+			ClassID:  classID,
+			Status:   StatusNotStarted,
+			Lessons:  []LessonStatus{},
+		}
+		return &classStatus, db.Create(&classStatus).Error
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &classStatus, nil
+}
+
+func findLessonStatus(clientID int, courseID int, classID int, lessonID int) (*LessonStatus, error) {
+	classStatus, err := findClassStatus(clientID, courseID, classID)
+	if err != nil {
+		return nil, err
+	}
+	var lessonStatus LessonStatus
+	result := db.Where("class_id = ? AND lesson_id = ?", classStatus.Id, lessonID).First(&lessonStatus)
+	if result.Error == gorm.ErrRecordNotFound {
+		lessonStatus = LessonStatus{
+			ClassID:   classStatus.Id,
+			LessonID:  lessonID,
+			Status:    StatusNotStarted,
+			Exercises: []ExerciseStatus{},
+		}
+		return &lessonStatus, db.Create(&lessonStatus).Error
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &lessonStatus, nil
+}
+
 func GetClientProgressByClientID(clientID int) (*ClientProgress, error) {
 	var progress ClientProgress
 	result := db.Preload("Courses.Classes.Lessons.Exercises").Where("client_id = ?", clientID).First(&progress)
@@ -632,3 +739,90 @@ func GetClientProgressByClientID(clientID int) (*ClientProgress, error) {
 	}
 	return &progress, nil
 }
+
+// CountCompletedLessons считает завершенные клиентом уроки во всех курсах
+func CountCompletedLessons(clientID int) (int, error) {
+	var statuses []CourseStatus
+	result := db.Preload("Classes.Lessons").Where("client_id = ?", clientID).Find(&statuses)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	count := 0
+	for _, courseStatus := range statuses {
+		for _, classStatus := range courseStatus.Classes {
+			for _, lessonStatus := range classStatus.Lessons {
+				if lessonStatus.Status == StatusCompleted {
+					count++
+				}
+			}
+		}
+	}
+	return count, nil
+}
+
+// CourseProgressSummary - сводка прогресса клиента по одному курсу
+type CourseProgressSummary struct {
+	CourseID         int    `json:"course_id"`
+	Title            string `json:"title"`
+	Status           string `json:"status"`
+	CompletedLessons int    `json:"completed_lessons"`
+	TotalLessons     int    `json:"total_lessons"`
+}
+
+// ClientCourseSummaries возвращает сводку по всем курсам, которые начинал клиент
+func ClientCourseSummaries(clientID int) ([]CourseProgressSummary, error) {
+	var statuses []CourseStatus
+	result := db.Preload("Classes.Lessons").Where("client_id = ?", clientID).Order("id").Find(&statuses)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(statuses) == 0 {
+		return []CourseProgressSummary{}, nil
+	}
+
+	// Название и число уроков - из версии, которую клиент проходит или проходил последней
+	versions, err := clientVersions(db, clientID, false)
+	if err != nil {
+		return nil, err
+	}
+	titles := make(map[int]string, len(versions))
+	totals := make(map[int]int, len(versions))
+	var missing []int
+	for _, courseStatus := range statuses {
+		if version, ok := versions[courseStatus.CourseID]; ok {
+			titles[courseStatus.CourseID] = version.Title
+			totals[courseStatus.CourseID] = version.LessonsCount
+		} else {
+			missing = append(missing, courseStatus.CourseID)
+		}
+	}
+	if len(missing) > 0 {
+		var courses []Course
+		if err := db.Select("id", "title").Where("id IN ?", missing).Find(&courses).Error; err != nil {
+			return nil, err
+		}
+		for _, crs := range courses {
+			titles[crs.Id] = crs.Title
+		}
+	}
+
+	summaries := make([]CourseProgressSummary, 0, len(statuses))
+	for _, courseStatus := range statuses {
+		summary := CourseProgressSummary{
+			CourseID:     courseStatus.CourseID,
+			Title:        titles[courseStatus.CourseID],
+			Status:       courseStatus.Status,
+			TotalLessons: totals[courseStatus.CourseID],
+		}
+		for _, classStatus := range courseStatus.Classes {
+			for _, lessonStatus := range classStatus.Lessons {
+				if lessonStatus.Status == StatusCompleted {
+					summary.CompletedLessons++
+				}
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
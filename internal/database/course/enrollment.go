@@ -0,0 +1,349 @@
+package course
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Статусы записи на курс
+const (
+	EnrollmentActive     = "active"
+	EnrollmentWaitlisted = "waitlisted" // мест нет, запись активируется, когда место освободится
+	EnrollmentCancelled  = "cancelled"
+)
+
+var (
+	ErrAlreadyEnrolled = errors.New("already enrolled in this course")
+	ErrNotEnrolled     = errors.New("not enrolled in this course")
+)
+
+// Enrollment - запись клиента на курс. Доступ к урокам, чату и прогрессу
+// курса есть только у активной записи; ParticipantsCount курса - число
+// активных записей. Клиент проходит версию курса, действовавшую при
+// активации записи, пока сам не перейдет на новую (UpgradeEnrollment).
+type Enrollment struct {
+	ID               int        `gorm:"primaryKey" json:"id"`
+	CourseID         int        `gorm:"index" json:"course_id"`
+	ClientID         int        `gorm:"index" json:"client_id"`
+	Status           string     `gorm:"index" json:"status"`
+	Version          int        `gorm:"not null;default:0" json:"version"`    // версия курса, которую проходит клиент
+	WaitlistPosition int        `gorm:"-" json:"waitlist_position,omitempty"` // с 1, только у waitlisted
+	CreatedAt        time.Time  `json:"created_at"`
+	ActivatedAt      *time.Time `json:"activated_at,omitempty"`
+	CancelledAt      *time.Time `json:"cancelled_at,omitempty"`
+}
+
+// migrateEnrollments создает таблицу записей. При первом запуске записывает на
+// курсы клиентов, которые уже отмечали в них прогресс, и пересчитывает
+// ParticipantsCount, который раньше вводил тренер.
+func migrateEnrollments(db *gorm.DB) error {
+	firstRun := !db.Migrator().HasTable(&Enrollment{})
+	if err := db.AutoMigrate(&Enrollment{}); err != nil {
+		return err
+	}
+	// Одна незакрытая запись клиента на курс
+	err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_enrollments_course_client " +
+		"ON enrollments (course_id, client_id) WHERE status <> 'cancelled'").Error
+	if err != nil {
+		return err
+	}
+	if !firstRun {
+		return nil
+	}
+
+	// EnsureFullClientStructure раньше заводил статусы по всем курсам подряд,
+	// поэтому записанными считаем только тех, кто что-то начал
+	result := db.Exec(`INSERT INTO enrollments (course_id, client_id, status, created_at, activated_at)
+		SELECT DISTINCT cs.course_id, cs.client_id, ?, NOW(), NOW() FROM course_statuses cs
+		JOIN courses ON courses.id = cs.course_id
+		WHERE cs.status <> ? OR EXISTS (
+			SELECT 1 FROM class_statuses cl WHERE cl.course_id = cs.id AND cl.status <> ?)`,
+		EnrollmentActive, StatusNotStarted, StatusNotStarted)
+	if result.Error != nil {
+		return result.Error
+	}
+	log.Printf("course: %d enrollments created from existing progress", result.RowsAffected)
+
+	return db.Exec("UPDATE courses SET participants_count = " +
+		"(SELECT COUNT(*) FROM enrollments e WHERE e.course_id = courses.id AND e.status = 'active')").Error
+}
+
+// FindEnrollment возвращает незакрытую запись клиента на курс или nil
+func FindEnrollment(courseID int, clientID int) (*Enrollment, error) {
+	var enrollment Enrollment
+	result := db.Where("course_id = ? AND client_id = ? AND status <> ?", courseID, clientID, EnrollmentCancelled).
+		First(&enrollment)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	if err := fillWaitlistPosition(db, &enrollment); err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+// IsEnrolled - у клиента активная запись на курс
+func IsEnrolled(courseID int, clientID int) (bool, error) {
+	var count int64
+	err := db.Model(&Enrollment{}).
+		Where("course_id = ? AND client_id = ? AND status = ?", courseID, clientID, EnrollmentActive).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// HasTakenCourse - клиент проходит или проходил курс: запись хоть раз была активной
+func HasTakenCourse(courseID int, clientID int) (bool, error) {
+	var count int64
+	err := db.Model(&Enrollment{}).
+		Where("course_id = ? AND client_id = ? AND activated_at IS NOT NULL", courseID, clientID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// FindEnrollmentsByCourseID возвращает участников и лист ожидания курса в порядке записи
+func FindEnrollmentsByCourseID(courseID int) ([]Enrollment, error) {
+	var enrollments []Enrollment
+	result := db.Where("course_id = ? AND status <> ?", courseID, EnrollmentCancelled).
+		Order("status, created_at, id").Find(&enrollments)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	position := 0
+	for i := range enrollments {
+		if enrollments[i].Status == EnrollmentWaitlisted {
+			position++
+			enrollments[i].WaitlistPosition = position
+		}
+	}
+	return enrollments, nil
+}
+
+// FindEnrollmentsByClientID возвращает незакрытые записи клиента, новые первыми
+func FindEnrollmentsByClientID(clientID int) ([]Enrollment, error) {
+	var enrollments []Enrollment
+	result := db.Where("client_id = ? AND status <> ?", clientID, EnrollmentCancelled).
+		Order("created_at DESC, id DESC").Find(&enrollments)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for i := range enrollments {
+		if err := fillWaitlistPosition(db, &enrollments[i]); err != nil {
+			return nil, err
+		}
+	}
+	return enrollments, nil
+}
+
+// Enroll записывает клиента на курс. Если мест нет, запись попадает в лист ожидания.
+func Enroll(courseID int, clientID int) (*Enrollment, error) {
+	enrollment := &Enrollment{CourseID: courseID, ClientID: clientID}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Блокируем курс: одновременные записи не превысят вместимость
+		crs, err := lockCourse(tx, courseID)
+		if err != nil {
+			return err
+		}
+		if crs.Status != CoursePublished {
+			return ErrCourseNotPublished
+		}
+
+		var count int64
+		err = tx.Model(&Enrollment{}).
+			Where("course_id = ? AND client_id = ? AND status <> ?", courseID, clientID, EnrollmentCancelled).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyEnrolled
+		}
+
+		active, err := countActive(tx, courseID)
+		if err != nil {
+			return err
+		}
+		if crs.Capacity > 0 && active >= int64(crs.Capacity) {
+			enrollment.Status = EnrollmentWaitlisted
+		} else {
+			now := time.Now()
+			enrollment.Status = EnrollmentActive
+			enrollment.ActivatedAt = &now
+		}
+		enrollment.Version = crs.CurrentVersion
+		if err := tx.Create(enrollment).Error; err != nil {
+			return err
+		}
+		if err := fillWaitlistPosition(tx, enrollment); err != nil {
+			return err
+		}
+		return recountParticipants(tx, courseID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+// Unenroll закрывает запись клиента. Освободившееся место получает первый из
+// листа ожидания, его запись возвращается в promoted.
+func Unenroll(courseID int, clientID int) (cancelled *Enrollment, promoted []Enrollment, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		cancelled, promoted, err = unenroll(tx, courseID, clientID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cancelled, promoted, nil
+}
+
+// CancelClientEnrollments закрывает все записи клиента внутри транзакции tx
+// (удаление аккаунта), места отдаются листам ожидания
+func CancelClientEnrollments(tx *gorm.DB, clientID int) error {
+	var courseIDs []int
+	err := tx.Model(&Enrollment{}).Where("client_id = ? AND status <> ?", clientID, EnrollmentCancelled).
+		Order("course_id").Pluck("course_id", &courseIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, courseID := range courseIDs {
+		if _, _, err := unenroll(tx, courseID, clientID); err != nil && err != ErrNotEnrolled {
+			return err
+		}
+	}
+	return nil
+}
+
+func unenroll(tx *gorm.DB, courseID int, clientID int) (*Enrollment, []Enrollment, error) {
+	crs, err := lockCourse(tx, courseID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var enrollment Enrollment
+	result := tx.Where("course_id = ? AND client_id = ? AND status <> ?", courseID, clientID, EnrollmentCancelled).
+		First(&enrollment)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil, ErrNotEnrolled
+		}
+		return nil, nil, result.Error
+	}
+	now := time.Now()
+	enrollment.Status = EnrollmentCancelled
+	enrollment.CancelledAt = &now
+	if err := tx.Save(&enrollment).Error; err != nil {
+		return nil, nil, err
+	}
+
+	promoted, err := promoteWaitlist(tx, crs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := recountParticipants(tx, courseID); err != nil {
+		return nil, nil, err
+	}
+	return &enrollment, promoted, nil
+}
+
+// SetCourseCapacity меняет вместимость курса (0 - без ограничений). При
+// увеличении места получает лист ожидания; уже записанные при уменьшении
+// остаются.
+func SetCourseCapacity(courseID int, capacity int) ([]Enrollment, error) {
+	var promoted []Enrollment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		crs, err := lockCourse(tx, courseID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&Course{}).Where("id = ?", courseID).UpdateColumn("capacity", capacity).Error; err != nil {
+			return err
+		}
+		crs.Capacity = capacity
+		if promoted, err = promoteWaitlist(tx, crs); err != nil {
+			return err
+		}
+		return recountParticipants(tx, courseID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return promoted, nil
+}
+
+func lockCourse(tx *gorm.DB, courseID int) (*Course, error) {
+	var crs Course
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&crs, courseID).Error; err != nil {
+		return nil, err
+	}
+	return &crs, nil
+}
+
+func countActive(tx *gorm.DB, courseID int) (int64, error) {
+	var count int64
+	err := tx.Model(&Enrollment{}).Where("course_id = ? AND status = ?", courseID, EnrollmentActive).Count(&count).Error
+	return count, err
+}
+
+// promoteWaitlist активирует записи из листа ожидания на свободные места
+func promoteWaitlist(tx *gorm.DB, crs *Course) ([]Enrollment, error) {
+	query := tx.Where("course_id = ? AND status = ?", crs.Id, EnrollmentWaitlisted).Order("created_at, id")
+	if crs.Capacity > 0 {
+		active, err := countActive(tx, crs.Id)
+		if err != nil {
+			return nil, err
+		}
+		free := int64(crs.Capacity) - active
+		if free <= 0 {
+			return nil, nil
+		}
+		query = query.Limit(int(free))
+	}
+
+	var promoted []Enrollment
+	if err := query.Find(&promoted).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range promoted {
+		promoted[i].Status = EnrollmentActive
+		promoted[i].ActivatedAt = &now
+		promoted[i].Version = crs.CurrentVersion
+		if err := tx.Save(&promoted[i]).Error; err != nil {
+			return nil, err
+		}
+	}
+	return promoted, nil
+}
+
+func recountParticipants(tx *gorm.DB, courseID int) error {
+	active, err := countActive(tx, courseID)
+	if err != nil {
+		return err
+	}
+	return tx.Model(&Course{}).Where("id = ?", courseID).UpdateColumn("participants_count", active).Error
+}
+
+// fillWaitlistPosition считает место записи в листе ожидания
+func fillWaitlistPosition(tx *gorm.DB, enrollment *Enrollment) error {
+	if enrollment.Status != EnrollmentWaitlisted {
+		return nil
+	}
+	var ahead int64
+	err := tx.Model(&Enrollment{}).
+		Where("course_id = ? AND status = ? AND (created_at, id) < (?, ?)",
+			enrollment.CourseID, EnrollmentWaitlisted, enrollment.CreatedAt, enrollment.ID).
+		Count(&ahead).Error
+	if err != nil {
+		return err
+	}
+	enrollment.WaitlistPosition = int(ahead) + 1
+	return nil
+}
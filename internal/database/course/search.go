@@ -0,0 +1,279 @@
+package course
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Сортировка каталога курсов
+const (
+	SortNewest         = "newest"    // по умолчанию без поискового запроса
+	SortRelevance      = "relevance" // по умолчанию с поисковым запросом
+	SortRating         = "rating"
+	SortPopularity     = "popularity"
+	SortCostAsc        = "cost_asc"
+	SortCostDesc       = "cost_desc"
+	SortDifficultyAsc  = "difficulty_asc"
+	SortDifficultyDesc = "difficulty_desc"
+)
+
+// Полнотекстовый поиск по названию и описанию. Выражение совпадает с индексами
+// idx_courses_search и idx_course_versions_search, иначе Postgres их не использует.
+const (
+	searchVector = "to_tsvector('russian', coalesce(title, '') || ' ' || coalesce(description, ''))"
+	searchQuery  = "websearch_to_tsquery('russian', ?)"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CourseFilter - параметры поиска по каталогу. Пустые поля не фильтруют.
+type CourseFilter struct {
+	Query         string // полнотекстовый поиск по названию и описанию
+	Direction     string
+	Difficulty    string
+	MinDifficulty *int // по difficulty_numeric
+	MaxDifficulty *int
+	MinCost       *float64
+	MaxCost       *float64
+	TrainerID     int
+	MinRating     *float64
+	RequiredTools string // подстрока required_tools
+	Unpublished   bool   // показывать черновики и снятые курсы, для тренера по своим курсам
+	Sort          string
+	Cursor        string // NextCursor предыдущей страницы
+	Limit         int
+}
+
+// FacetCount - сколько найденных курсов с таким значением поля
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// CourseFacets - разбивка найденных курсов для фильтров в каталоге
+type CourseFacets struct {
+	Directions   []FacetCount `json:"directions"`
+	Difficulties []FacetCount `json:"difficulties"`
+}
+
+// CoursePage - страница каталога. Total и Facets считаются по всем найденным
+// курсам, а не по странице; NextCursor пустой на последней странице.
+type CoursePage struct {
+	Courses    []Course
+	Total      int64
+	NextCursor string
+	Facets     CourseFacets
+}
+
+// sortKey - выражение, по которому сортируется и продолжается страница.
+// К нему всегда добавляется id, чтобы порядок был однозначным.
+type sortKey struct {
+	expr  string
+	desc  bool
+	value func(c *Course) interface{} // nil - значение считает база (relevance)
+}
+
+var sortKeys = map[string]sortKey{
+	SortNewest:         {"created_at", true, func(c *Course) interface{} { return c.CreatedAt }},
+	SortRelevance:      {"ts_rank(" + searchVector + ", " + searchQuery + ")", true, nil},
+	SortRating:         {"rating", true, func(c *Course) interface{} { return c.Rating }},
+	SortPopularity:     {"participants_count", true, func(c *Course) interface{} { return c.ParticipantsCount }},
+	SortCostAsc:        {"cost", false, func(c *Course) interface{} { return c.Cost }},
+	SortCostDesc:       {"cost", true, func(c *Course) interface{} { return c.Cost }},
+	SortDifficultyAsc:  {"difficulty_numeric", false, func(c *Course) interface{} { return c.DifficultyNumeric }},
+	SortDifficultyDesc: {"difficulty_numeric", true, func(c *Course) interface{} { return c.DifficultyNumeric }},
+}
+
+// ValidCourseSort - известный способ сортировки
+func ValidCourseSort(sort string) bool {
+	_, ok := sortKeys[sort]
+	return ok
+}
+
+// courseCursor - позиция последнего курса страницы
+type courseCursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	ID    int         `json:"id"`
+}
+
+func encodeCursor(cursor courseCursor) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor разбирает курсор и приводит значение к типу колонки сортировки
+func decodeCursor(s string, sort string) (*courseCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor courseCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+
+	switch v := cursor.Value.(type) {
+	case string:
+		if sort != SortNewest {
+			return nil, ErrInvalidCursor
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		cursor.Value = t
+	case float64:
+		switch sort {
+		case SortNewest:
+			return nil, ErrInvalidCursor
+		case SortPopularity, SortDifficultyAsc, SortDifficultyDesc:
+			cursor.Value = int64(v)
+		}
+	default:
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// catalog - откуда искать: каталог видит опубликованные версии курсов,
+// тренер по своим курсам - черновики
+func catalog(filter CourseFilter) *gorm.DB {
+	if filter.Unpublished {
+		return db.Model(&Course{})
+	}
+	return PublishedCourses(db)
+}
+
+func applyCourseFilter(query *gorm.DB, filter CourseFilter) *gorm.DB {
+	if filter.Query != "" {
+		query = query.Where(searchVector+" @@ "+searchQuery, filter.Query)
+	}
+	if filter.Direction != "" {
+		query = query.Where("LOWER(direction) = LOWER(?)", filter.Direction)
+	}
+	if filter.Difficulty != "" {
+		query = query.Where("LOWER(difficulty) = LOWER(?)", filter.Difficulty)
+	}
+	if filter.MinDifficulty != nil {
+		query = query.Where("difficulty_numeric >= ?", *filter.MinDifficulty)
+	}
+	if filter.MaxDifficulty != nil {
+		query = query.Where("difficulty_numeric <= ?", *filter.MaxDifficulty)
+	}
+	if filter.MinCost != nil {
+		query = query.Where("cost >= ?", *filter.MinCost)
+	}
+	if filter.MaxCost != nil {
+		query = query.Where("cost <= ?", *filter.MaxCost)
+	}
+	if filter.TrainerID != 0 {
+		query = query.Where("trainer_id = ?", filter.TrainerID)
+	}
+	if filter.MinRating != nil {
+		query = query.Where("rating >= ?", *filter.MinRating)
+	}
+	if filter.RequiredTools != "" {
+		query = query.Where("required_tools ILIKE ?", "%"+auth.EscapeLike(filter.RequiredTools)+"%")
+	}
+	return query
+}
+
+// SearchCourses возвращает страницу каталога. Страницы продолжаются по
+// курсору (keyset), поэтому новые курсы не сдвигают уже показанные.
+func SearchCourses(filter CourseFilter) (*CoursePage, error) {
+	if filter.Sort == "" {
+		filter.Sort = SortNewest
+		if filter.Query != "" {
+			filter.Sort = SortRelevance
+		}
+	}
+	key, ok := sortKeys[filter.Sort]
+	if !ok {
+		return nil, errors.New("unknown sort: " + filter.Sort)
+	}
+	var keyArgs []interface{}
+	if filter.Sort == SortRelevance {
+		keyArgs = []interface{}{filter.Query}
+	}
+
+	page := &CoursePage{}
+	if err := applyCourseFilter(catalog(filter), filter).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+	var err error
+	if page.Facets.Directions, err = courseFacet("direction", filter); err != nil {
+		return nil, err
+	}
+	if page.Facets.Difficulties, err = courseFacet("difficulty", filter); err != nil {
+		return nil, err
+	}
+
+	direction, compare := " ASC", ">"
+	if key.desc {
+		direction, compare = " DESC", "<"
+	}
+	query := applyCourseFilter(catalog(filter), filter)
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor, filter.Sort)
+		if err != nil {
+			return nil, err
+		}
+		args := append(append([]interface{}{}, keyArgs...), cursor.Value, cursor.ID)
+		query = query.Where("("+key.expr+", id) "+compare+" (?, ?)", args...)
+	}
+	query = query.Order(clause.OrderBy{Expression: clause.Expr{
+		SQL:  key.expr + direction + ", id" + direction,
+		Vars: keyArgs,
+	}})
+
+	// Лишняя запись показывает, что есть следующая страница
+	if err := query.Limit(filter.Limit + 1).Find(&page.Courses).Error; err != nil {
+		return nil, err
+	}
+	if len(page.Courses) <= filter.Limit {
+		return page, nil
+	}
+	page.Courses = page.Courses[:filter.Limit]
+
+	last := &page.Courses[len(page.Courses)-1]
+	cursor := courseCursor{Sort: filter.Sort, ID: last.Id}
+	if key.value != nil {
+		cursor.Value = key.value(last)
+	} else {
+		var rank float64
+		err := catalog(filter).Select(key.expr, keyArgs...).Where("id = ?", last.Id).Scan(&rank).Error
+		if err != nil {
+			return nil, err
+		}
+		cursor.Value = rank
+	}
+	if page.NextCursor, err = encodeCursor(cursor); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// courseFacet считает найденные курсы по значениям колонки
+func courseFacet(column string, filter CourseFilter) ([]FacetCount, error) {
+	facets := []FacetCount{}
+	err := applyCourseFilter(catalog(filter), filter).
+		Select(column + " AS value, COUNT(*) AS count").
+		Where(column + " <> ''").
+		Group(column).
+		Order("count DESC, value").
+		Scan(&facets).Error
+	if err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
@@ -0,0 +1,530 @@
+package course
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Статусы публикации курса. Тренер правит черновик: строки courses, classes,
+// lessons и lesson_exercises. Публикация снимает с черновика неизменяемую
+// версию, клиенты проходят ту версию, на которой записались.
+const (
+	CourseDraft       = "draft"       // еще не публиковался, виден только тренеру
+	CoursePublished   = "published"   // в каталоге, открыт для записи
+	CourseUnpublished = "unpublished" // снят с каталога, записанные проходят свою версию
+)
+
+var (
+	ErrCourseNotPublished = errors.New("course is not published")
+	ErrEmptyCourse        = errors.New("course has no classes to publish")
+	ErrNoUpgrade          = errors.New("already on the latest course version")
+)
+
+// CourseVersion - опубликованная версия курса. Занятия, уроки, упражнения и
+// картинки хранятся снимком и после публикации не меняются.
+type CourseVersion struct {
+	ID                int          `gorm:"primaryKey" json:"id"`
+	CourseID          int          `gorm:"uniqueIndex:idx_course_versions_course_version" json:"course_id"`
+	Version           int          `gorm:"uniqueIndex:idx_course_versions_course_version" json:"version"`
+	Title             string       `json:"title"`
+	Description       string       `json:"description"`
+	Difficulty        string       `json:"difficulty"`
+	DifficultyNumeric int          `json:"difficulty_numeric"`
+	Direction         string       `json:"direction"`
+	RequiredTools     string       `json:"required_tools"`
+	Classes           []Class      `gorm:"serializer:json" json:"classes,omitempty"`
+	Images            []ClassImage `gorm:"serializer:json" json:"images,omitempty"`
+	LessonsCount      int          `json:"lessons_count"`
+	PublishedBy       int          `json:"published_by"`
+	PublishedAt       time.Time    `json:"published_at"`
+}
+
+// publishedCoursesTable - опубликованные курсы в том виде, в каком их видит
+// каталог: поля карточки из текущей версии, а цена, места, рейтинг и счетчики -
+// живые из courses. Правки черновика не видны, пока их не опубликуют.
+const publishedCoursesTable = `(SELECT courses.id, v.title, v.description, v.difficulty,
+	v.difficulty_numeric, v.direction, courses.trainer_id, courses.cost, courses.participants_count,
+	courses.capacity, courses.rating, courses.reviews_count, v.required_tools, courses.status,
+	courses.current_version, courses.published_at, courses.created_at, courses.updated_at
+	FROM courses JOIN course_versions v ON v.course_id = courses.id AND v.version = courses.current_version
+	WHERE courses.status = 'published') AS courses`
+
+// PublishedCourses - запрос по опубликованным курсам с карточками из текущих
+// версий, колонки как у Course
+func PublishedCourses(tx *gorm.DB) *gorm.DB {
+	return tx.Table(publishedCoursesTable)
+}
+
+// PublishedCard возвращает курс с карточкой из текущей опубликованной версии.
+// Неопубликованный курс возвращается как есть.
+func PublishedCard(crs *Course) (*Course, error) {
+	if crs.CurrentVersion == 0 {
+		return crs, nil
+	}
+	var version CourseVersion
+	result := db.Omit("Classes", "Images").
+		Where("course_id = ? AND version = ?", crs.Id, crs.CurrentVersion).Limit(1).Find(&version)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return crs, nil
+	}
+	view := version.CourseView(crs)
+	view.Classes = crs.Classes
+	return &view, nil
+}
+
+// CourseView - курс в том виде, в каком его видит клиент этой версии
+func (v *CourseVersion) CourseView(crs *Course) Course {
+	view := *crs
+	view.Title = v.Title
+	view.Description = v.Description
+	view.Difficulty = v.Difficulty
+	view.DifficultyNumeric = v.DifficultyNumeric
+	view.Direction = v.Direction
+	view.RequiredTools = v.RequiredTools
+	view.Classes = v.Classes
+	if view.Classes == nil {
+		view.Classes = []Class{}
+	}
+	return view
+}
+
+// FindClass возвращает занятие версии или nil
+func (v *CourseVersion) FindClass(classID int) *Class {
+	for i := range v.Classes {
+		if v.Classes[i].Id == classID {
+			return &v.Classes[i]
+		}
+	}
+	return nil
+}
+
+// FindLesson возвращает урок занятия версии или nil
+func (v *CourseVersion) FindLesson(classID int, lessonID int) *Lesson {
+	class := v.FindClass(classID)
+	if class == nil {
+		return nil
+	}
+	for i := range class.Lessons {
+		if class.Lessons[i].Id == lessonID {
+			return &class.Lessons[i]
+		}
+	}
+	return nil
+}
+
+// FindImage возвращает картинку урока версии или nil
+func (v *CourseVersion) FindImage(lessonID int, imageID int) *ClassImage {
+	for i := range v.Images {
+		if v.Images[i].Id == imageID && v.Images[i].LessonID == lessonID {
+			return &v.Images[i]
+		}
+	}
+	return nil
+}
+
+// migrateVersions создает таблицу версий. При первом запуске публикует все
+// существующие курсы версией 1: до версий они уже были видны клиентам.
+func migrateVersions(db *gorm.DB) error {
+	firstRun := !db.Migrator().HasTable(&CourseVersion{})
+	if err := db.AutoMigrate(&CourseVersion{}); err != nil {
+		return err
+	}
+	if !firstRun {
+		return nil
+	}
+
+	var courses []Course
+	if err := db.Select("id", "trainer_id").Order("id").Find(&courses).Error; err != nil {
+		return err
+	}
+	for _, crs := range courses {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := publish(tx, crs.Id, crs.TrainerID, false)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := db.Exec("UPDATE enrollments SET version = 1 WHERE version = 0").Error; err != nil {
+		return err
+	}
+	log.Printf("course: %d existing courses published as version 1", len(courses))
+	return nil
+}
+
+// snapshot собирает версию из текущего черновика курса
+func snapshot(tx *gorm.DB, crs *Course) (*CourseVersion, error) {
+	var classes []Class
+	err := tx.Preload("Lessons", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Lessons.Exercises", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Lessons.Exercises.Exercise.Photos").
+		Where("course_id = ?", crs.Id).Order("id").Find(&classes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var images []ClassImage
+	lessonIDs := tx.Model(&Lesson{}).Select("id").Where("course_id = ?", crs.Id)
+	if err := tx.Where("lesson_id IN (?)", lessonIDs).Order("id").Find(&images).Error; err != nil {
+		return nil, err
+	}
+
+	version := &CourseVersion{
+		CourseID:          crs.Id,
+		Title:             crs.Title,
+		Description:       crs.Description,
+		Difficulty:        crs.Difficulty,
+		DifficultyNumeric: crs.DifficultyNumeric,
+		Direction:         crs.Direction,
+		RequiredTools:     crs.RequiredTools,
+		Classes:           classes,
+		Images:            images,
+	}
+	for _, class := range classes {
+		version.LessonsCount += len(class.Lessons)
+	}
+	return version, nil
+}
+
+// sameContent - совпадает ли содержимое двух версий без учета номера и даты
+func sameContent(a *CourseVersion, b *CourseVersion) bool {
+	content := func(v *CourseVersion) []byte {
+		data, _ := json.Marshal([]interface{}{v.Title, v.Description, v.Difficulty, v.DifficultyNumeric,
+			v.Direction, v.RequiredTools, v.Classes, v.Images})
+		return data
+	}
+	return string(content(a)) == string(content(b))
+}
+
+// PublishCourse публикует черновик курса. Если черновик не менялся с прошлой
+// публикации, новая версия не создается: курс просто возвращается в каталог.
+func PublishCourse(courseID int, userID int) (*CourseVersion, error) {
+	var version *CourseVersion
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		version, err = publish(tx, courseID, userID, true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func publish(tx *gorm.DB, courseID int, userID int, requireClasses bool) (*CourseVersion, error) {
+	crs, err := lockCourse(tx, courseID)
+	if err != nil {
+		return nil, err
+	}
+	version, err := snapshot(tx, crs)
+	if err != nil {
+		return nil, err
+	}
+	if requireClasses && len(version.Classes) == 0 {
+		return nil, ErrEmptyCourse
+	}
+
+	if crs.CurrentVersion > 0 {
+		current, err := findVersion(tx, crs.Id, crs.CurrentVersion)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil && sameContent(current, version) {
+			version = current
+		}
+	}
+	now := time.Now()
+	if version.ID == 0 {
+		version.Version = crs.CurrentVersion + 1
+		version.PublishedBy = userID
+		version.PublishedAt = now
+		if err := tx.Create(version).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Model(&Course{}).Where("id = ?", crs.Id).UpdateColumns(map[string]interface{}{
+		"status":          CoursePublished,
+		"current_version": version.Version,
+		"published_at":    now,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// UnpublishCourse снимает курс с каталога. Новых записей нет, записанные
+// клиенты продолжают проходить свои версии.
+func UnpublishCourse(courseID int) error {
+	result := db.Model(&Course{}).Where("id = ? AND status = ?", courseID, CoursePublished).
+		UpdateColumn("status", CourseUnpublished)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCourseNotPublished
+	}
+	return nil
+}
+
+func findVersion(tx *gorm.DB, courseID int, version int) (*CourseVersion, error) {
+	var found CourseVersion
+	result := tx.Where("course_id = ? AND version = ?", courseID, version).First(&found)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &found, nil
+}
+
+// FindCourseVersion возвращает версию курса или nil
+func FindCourseVersion(courseID int, version int) (*CourseVersion, error) {
+	return findVersion(db, courseID, version)
+}
+
+// FindCourseVersions возвращает версии курса без содержимого, новые первыми
+func FindCourseVersions(courseID int) ([]CourseVersion, error) {
+	var versions []CourseVersion
+	result := db.Omit("Classes", "Images").Where("course_id = ?", courseID).Order("version DESC").Find(&versions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return versions, nil
+}
+
+// FindClientVersion возвращает версию, которую проходит клиент с активной записью, или nil
+func FindClientVersion(courseID int, clientID int) (*CourseVersion, error) {
+	var enrollment Enrollment
+	result := db.Where("course_id = ? AND client_id = ? AND status = ?", courseID, clientID, EnrollmentActive).
+		Limit(1).Find(&enrollment)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return findVersion(db, courseID, enrollment.Version)
+}
+
+// clientVersions возвращает версии курсов, на которые клиент записан сейчас
+// или был записан последним, по ID курса
+func clientVersions(tx *gorm.DB, clientID int, activeOnly bool) (map[int]*CourseVersion, error) {
+	query := tx.Model(&Enrollment{}).Select("DISTINCT ON (course_id) course_id, version").
+		Where("client_id = ? AND version > 0", clientID)
+	if activeOnly {
+		query = query.Where("status = ?", EnrollmentActive)
+	}
+	var pinned []struct {
+		CourseID int
+		Version  int
+	}
+	if err := query.Order("course_id, id DESC").Scan(&pinned).Error; err != nil {
+		return nil, err
+	}
+	versions := make(map[int]*CourseVersion, len(pinned))
+	if len(pinned) == 0 {
+		return versions, nil
+	}
+
+	pairs := make([][]interface{}, 0, len(pinned))
+	for _, p := range pinned {
+		pairs = append(pairs, []interface{}{p.CourseID, p.Version})
+	}
+	var found []CourseVersion
+	if err := tx.Where("(course_id, version) IN ?", pairs).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for i := range found {
+		versions[found[i].CourseID] = &found[i]
+	}
+	return versions, nil
+}
+
+// UpgradeEnrollment переводит клиента на последнюю версию курса. Прогресс
+// переносится по занятиям, урокам и упражнениям, которые остались в новой
+// версии; статусы удаленных частей удаляются, новые части заводятся при
+// следующем чтении прогресса.
+func UpgradeEnrollment(courseID int, clientID int) (*Enrollment, error) {
+	var enrollment Enrollment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		crs, err := lockCourse(tx, courseID)
+		if err != nil {
+			return err
+		}
+		result := tx.Where("course_id = ? AND client_id = ? AND status = ?", courseID, clientID, EnrollmentActive).
+			First(&enrollment)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return ErrNotEnrolled
+			}
+			return result.Error
+		}
+		if enrollment.Version >= crs.CurrentVersion {
+			return ErrNoUpgrade
+		}
+		from, err := findVersion(tx, courseID, enrollment.Version)
+		if err != nil {
+			return err
+		}
+		version, err := findVersion(tx, courseID, crs.CurrentVersion)
+		if err != nil {
+			return err
+		}
+		if version == nil {
+			return gorm.ErrRecordNotFound
+		}
+
+		var statuses []CourseStatus
+		err = tx.Preload("Classes.Lessons.Exercises").
+			Where("client_id = ? AND course_id = ?", clientID, courseID).Find(&statuses).Error
+		if err != nil {
+			return err
+		}
+		for i := range statuses {
+			if err := mapProgress(tx, &statuses[i], from, version); err != nil {
+				return err
+			}
+		}
+
+		enrollment.Version = version.Version
+		return tx.Model(&enrollment).UpdateColumn("version", version.Version).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+// mapProgress переносит статусы курса со старой версии на новую. Упражнения
+// сопоставляются по ID связи с уроком, а если урок пересобран - по упражнению
+// из библиотеки. Завершенные урок, занятие и курс, в которых появились новые
+// части, возвращаются в статус "В процессе".
+func mapProgress(tx *gorm.DB, courseStatus *CourseStatus, from *CourseVersion, to *CourseVersion) error {
+	keptClasses, courseChanged := 0, false
+	for _, classStatus := range courseStatus.Classes {
+		class := to.FindClass(classStatus.ClassID)
+		if class == nil {
+			if err := deleteClassStatus(tx, &classStatus); err != nil {
+				return err
+			}
+			continue
+		}
+		keptClasses++
+
+		keptLessons, classChanged := 0, false
+		for _, lessonStatus := range classStatus.Lessons {
+			lesson := to.FindLesson(class.Id, lessonStatus.LessonID)
+			if lesson == nil {
+				if err := deleteLessonStatus(tx, &lessonStatus); err != nil {
+					return err
+				}
+				continue
+			}
+			keptLessons++
+
+			var old *Lesson
+			if from != nil {
+				old = from.FindLesson(class.Id, lesson.Id)
+			}
+			changed, err := mapExercises(tx, &lessonStatus, old, lesson)
+			if err != nil {
+				return err
+			}
+			classChanged = classChanged || changed
+		}
+
+		classChanged = classChanged || keptLessons < len(class.Lessons)
+		if err := reopenStatus(tx, &ClassStatus{}, classStatus.Id, classStatus.Status, classChanged); err != nil {
+			return err
+		}
+		courseChanged = courseChanged || classChanged
+	}
+
+	courseChanged = courseChanged || keptClasses < len(to.Classes)
+	return reopenStatus(tx, &CourseStatus{}, courseStatus.Id, courseStatus.Status, courseChanged)
+}
+
+// mapExercises переносит статусы упражнений урока. Возвращает, появились ли в уроке новые упражнения.
+func mapExercises(tx *gorm.DB, lessonStatus *LessonStatus, old *Lesson, lesson *Lesson) (bool, error) {
+	current := map[int]bool{}
+	byLibrary := map[int][]int{} // упражнение из библиотеки -> ID связей с уроком
+	for _, exercise := range lesson.Exercises {
+		current[exercise.Id] = true
+		byLibrary[exercise.ExerciseID] = append(byLibrary[exercise.ExerciseID], exercise.Id)
+	}
+	oldLibrary := map[int]int{}
+	if old != nil {
+		for _, exercise := range old.Exercises {
+			oldLibrary[exercise.Id] = exercise.ExerciseID
+		}
+	}
+
+	// Сначала занимаем связи, совпавшие по ID, потом переносим остальные по упражнению
+	used := map[int]bool{}
+	var rest []ExerciseStatus
+	for _, status := range lessonStatus.Exercises {
+		if current[status.ExerciseID] && !used[status.ExerciseID] {
+			used[status.ExerciseID] = true
+			continue
+		}
+		rest = append(rest, status)
+	}
+	for _, status := range rest {
+		target := 0
+		for _, candidate := range byLibrary[oldLibrary[status.ExerciseID]] {
+			if !used[candidate] {
+				target = candidate
+				break
+			}
+		}
+		if target == 0 {
+			if err := tx.Delete(&ExerciseStatus{}, status.Id).Error; err != nil {
+				return false, err
+			}
+			continue
+		}
+		used[target] = true
+		err := tx.Model(&ExerciseStatus{}).Where("id = ?", status.Id).UpdateColumn("exercise_id", target).Error
+		if err != nil {
+			return false, err
+		}
+	}
+
+	changed := len(used) < len(lesson.Exercises)
+	if err := reopenStatus(tx, &LessonStatus{}, lessonStatus.Id, lessonStatus.Status, changed); err != nil {
+		return false, err
+	}
+	return changed, nil
+}
+
+// reopenStatus возвращает завершенную часть курса в работу, если в ней появилось новое
+func reopenStatus(tx *gorm.DB, model interface{}, id int, status string, changed bool) error {
+	if status != StatusCompleted || !changed {
+		return nil
+	}
+	return tx.Model(model).Where("id = ?", id).UpdateColumn("status", StatusInProgress).Error
+}
+
+func deleteLessonStatus(tx *gorm.DB, lessonStatus *LessonStatus) error {
+	if err := tx.Where("lesson_id = ?", lessonStatus.Id).Delete(&ExerciseStatus{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&LessonStatus{}, lessonStatus.Id).Error
+}
+
+func deleteClassStatus(tx *gorm.DB, classStatus *ClassStatus) error {
+	for i := range classStatus.Lessons {
+		if err := deleteLessonStatus(tx, &classStatus.Lessons[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Delete(&ClassStatus{}, classStatus.Id).Error
+}
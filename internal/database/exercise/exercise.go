@@ -22,6 +22,7 @@ type Exercise struct {
 	Equipment        string    `json:"equipment"`
 	Difficulty       string    `json:"difficulty"`
 	Duration         int       `json:"duration"`
+	AuthorID         int       `json:"author_id"` // ID тренера, добавившего упражнение
 	Photos           []Photo   `json:"photos" gorm:"foreignKey:ExerciseID"`
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
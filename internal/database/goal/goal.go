@@ -0,0 +1,179 @@
+package goal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Типы целей
+const (
+	TypeLoseWeight     = "lose_weight"     // снизить вес до Target, кг
+	TypeGainMuscle     = "gain_muscle"     // набрать вес до Target, кг
+	TypeRunDistance    = "run_distance"    // пробежать Target км начиная с создания цели
+	TypeAttendSessions = "attend_sessions" // посетить Target тренировок или уроков
+)
+
+// Статусы целей
+const (
+	StatusOnTrack  = "on_track"
+	StatusAtRisk   = "at_risk"
+	StatusAchieved = "achieved"
+)
+
+// Goal - цель пользователя. Baseline - значение показателя при создании цели,
+// Current и Progress пересчитываются по замерам, тренировкам и прогрессу в курсах.
+type Goal struct {
+	ID         int                `gorm:"primaryKey" json:"id"`
+	UserID     int                `gorm:"index" json:"userId"`
+	Type       string             `json:"type"`
+	Title      string             `json:"title"`
+	Unit       string             `json:"unit"`
+	Target     float64            `json:"target"`
+	Baseline   float64            `json:"baseline"`
+	Current    float64            `json:"current"`
+	Progress   float64            `json:"progress"` // от 0 до 1
+	Status     string             `json:"status"`
+	StartedAt  time.Time          `json:"startedAt"`
+	Deadline   time.Time          `json:"deadline"`
+	AchievedAt *time.Time         `json:"achievedAt"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+	History    []GoalStatusChange `json:"history" gorm:"foreignKey:GoalID"`
+}
+
+// GoalStatusChange - смена статуса цели, по ним тренер видит, когда клиент начал отставать
+type GoalStatusChange struct {
+	ID         int       `gorm:"primaryKey" json:"id"`
+	GoalID     int       `gorm:"index" json:"goalId"`
+	FromStatus string    `json:"from"`
+	ToStatus   string    `json:"to"`
+	Progress   float64   `json:"progress"`
+	ChangedAt  time.Time `json:"changedAt"`
+}
+
+var db *gorm.DB
+
+func InitDB() (*gorm.DB, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
+		cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort)
+
+	for i := 0; i < 5; i++ {
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			time.Sleep(5 * time.Second)
+		} else {
+			break
+		}
+	}
+
+	if db == nil {
+		return nil, errors.New("failed to connect to database")
+	}
+
+	err = db.AutoMigrate(&Goal{}, &GoalStatusChange{})
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func CreateGoal(goal *Goal) (*Goal, error) {
+	result := db.Create(goal)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return goal, nil
+}
+
+func FindGoalByID(id int) (*Goal, error) {
+	var goal Goal
+	result := db.Preload("History", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("changed_at, id")
+	}).Where("id = ?", id).First(&goal)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &goal, nil
+}
+
+func FindGoalsByUserID(userID int) ([]Goal, error) {
+	var goals []Goal
+	result := db.Preload("History", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("changed_at, id")
+	}).Where("user_id = ?", userID).Order("deadline, id").Find(&goals)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return goals, nil
+}
+
+// UpdateGoal сохраняет то, что меняет пользователь: название, цель и срок
+func UpdateGoal(goal *Goal) error {
+	result := db.Model(&Goal{}).Where("id = ?", goal.ID).Updates(map[string]interface{}{
+		"title":    goal.Title,
+		"target":   goal.Target,
+		"deadline": goal.Deadline,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SaveProgress сохраняет пересчитанный прогресс и, если статус изменился,
+// записывает смену статуса в историю
+func SaveProgress(goal *Goal, previousStatus string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Goal{}).Where("id = ?", goal.ID).Updates(map[string]interface{}{
+			"current":     goal.Current,
+			"progress":    goal.Progress,
+			"status":      goal.Status,
+			"achieved_at": goal.AchievedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if goal.Status == previousStatus {
+			return nil
+		}
+
+		change := GoalStatusChange{
+			GoalID:     goal.ID,
+			FromStatus: previousStatus,
+			ToStatus:   goal.Status,
+			Progress:   goal.Progress,
+			ChangedAt:  time.Now(),
+		}
+		if err := tx.Create(&change).Error; err != nil {
+			return err
+		}
+		goal.History = append(goal.History, change)
+		return nil
+	})
+}
+
+func DeleteGoal(id int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("goal_id = ?", id).Delete(&GoalStatusChange{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Goal{}, id).Error
+	})
+}
@@ -0,0 +1,141 @@
+package healthimport
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+	"github.com/niazlv/sport-plus-LCT/internal/healthimport"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Статусы задачи импорта
+const (
+	StatusPending   = "pending"   // файл принят, разбор еще не начался
+	StatusParsing   = "parsing"   // читаем выгрузку
+	StatusImporting = "importing" // сохраняем замеры, Processed растет
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+)
+
+// ImportJob - фоновый импорт выгрузки Apple Health или Google Fit.
+// Total известен после разбора файла, Progress - доля обработанных записей.
+type ImportJob struct {
+	ID         int                         `gorm:"primaryKey" json:"id"`
+	UserID     int                         `gorm:"index" json:"userId"`
+	FileName   string                      `json:"fileName"`
+	Source     string                      `json:"source"` // apple_health или google_fit
+	Status     string                      `json:"status"`
+	Total      int                         `json:"total"`
+	Processed  int                         `json:"processed"`
+	Imported   int                         `json:"imported"`
+	Skipped    int                         `json:"skipped"`
+	Progress   float64                     `json:"progress"` // от 0 до 1
+	Error      string                      `json:"error,omitempty"`
+	Report     []healthimport.SkippedGroup `json:"report" gorm:"serializer:json"`
+	CreatedAt  time.Time                   `json:"createdAt"`
+	UpdatedAt  time.Time                   `json:"updatedAt"`
+	FinishedAt *time.Time                  `json:"finishedAt"`
+}
+
+// Статусы, при которых задача еще выполняется
+var activeStatuses = []string{StatusPending, StatusParsing, StatusImporting}
+
+var db *gorm.DB
+
+func InitDB() (*gorm.DB, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
+		cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort)
+
+	for i := 0; i < 5; i++ {
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			time.Sleep(5 * time.Second)
+		} else {
+			break
+		}
+	}
+
+	if db == nil {
+		return nil, errors.New("failed to connect to database")
+	}
+
+	err = db.AutoMigrate(&ImportJob{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Задачи выполняются в памяти процесса, после перезапуска их уже никто не продолжит
+	result := db.Model(&ImportJob{}).
+		Where("status IN ?", activeStatuses).
+		Updates(map[string]interface{}{
+			"status":      StatusFailed,
+			"error":       "import was interrupted by server restart, upload the file again",
+			"finished_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("health import: %d interrupted jobs marked as failed", result.RowsAffected)
+	}
+
+	return db, nil
+}
+
+func CreateJob(job *ImportJob) (*ImportJob, error) {
+	result := db.Create(job)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return job, nil
+}
+
+func FindJobByID(id int) (*ImportJob, error) {
+	var job ImportJob
+	result := db.Where("id = ?", id).First(&job)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &job, nil
+}
+
+// FindJobsByUserID возвращает задачи пользователя, новые первыми
+func FindJobsByUserID(userID int) ([]ImportJob, error) {
+	var jobs []ImportJob
+	result := db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&jobs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return jobs, nil
+}
+
+// FindActiveJob возвращает выполняющуюся задачу пользователя или nil
+func FindActiveJob(userID int) (*ImportJob, error) {
+	var job ImportJob
+	result := db.Where("user_id = ? AND status IN ?", userID, activeStatuses).
+		First(&job)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &job, nil
+}
+
+// SaveJob сохраняет состояние задачи целиком: статус, счетчики и отчет
+func SaveJob(job *ImportJob) error {
+	return db.Save(job).Error
+}
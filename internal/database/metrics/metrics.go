@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// MetricSnapshot - показатели пользователя на момент пересчета. Новая запись
+// появляется, когда после нового замера или правки профиля что-то изменилось,
+// так тренер видит динамику.
+type MetricSnapshot struct {
+	ID            int       `gorm:"primaryKey" json:"id"`
+	UserID        int       `gorm:"index" json:"userId"`
+	ComputedAt    time.Time `gorm:"index" json:"computedAt"`
+	Trigger       string    `json:"trigger"` // тип замера или profile
+	BMI           *float64  `json:"bmi"`
+	BMR           *float64  `json:"bmr"`
+	TDEE          *float64  `json:"tdee"`
+	MaxHR         *float64  `json:"maxHr"`
+	RestingHR     *float64  `json:"restingHr"`
+	ActivityLevel string    `json:"activityLevel"`
+}
+
+// SameValues - в снимках одинаковые показатели, новый сохранять незачем
+func (s *MetricSnapshot) SameValues(other *MetricSnapshot) bool {
+	return equal(s.BMI, other.BMI) && equal(s.BMR, other.BMR) && equal(s.TDEE, other.TDEE) &&
+		equal(s.MaxHR, other.MaxHR) && equal(s.RestingHR, other.RestingHR) && s.ActivityLevel == other.ActivityLevel
+}
+
+func equal(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+var db *gorm.DB
+
+func InitDB() (*gorm.DB, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
+		cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort)
+
+	for i := 0; i < 5; i++ {
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			time.Sleep(5 * time.Second)
+		} else {
+			break
+		}
+	}
+
+	if db == nil {
+		return nil, errors.New("failed to connect to database")
+	}
+
+	err = db.AutoMigrate(&MetricSnapshot{})
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func CreateSnapshot(snapshot *MetricSnapshot) (*MetricSnapshot, error) {
+	result := db.Create(snapshot)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return snapshot, nil
+}
+
+// FindLastSnapshot возвращает последний снимок пользователя, nil - если их еще нет
+func FindLastSnapshot(userID int) (*MetricSnapshot, error) {
+	var snapshot MetricSnapshot
+	result := db.Where("user_id = ?", userID).Order("computed_at DESC, id DESC").First(&snapshot)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &snapshot, nil
+}
+
+// FindSnapshots возвращает снимки пользователя за [from, to) по возрастанию времени
+func FindSnapshots(userID int, from, to time.Time) ([]MetricSnapshot, error) {
+	var snapshots []MetricSnapshot
+	result := db.Where("user_id = ? AND computed_at >= ? AND computed_at < ?", userID, from, to).
+		Order("computed_at, id").Find(&snapshots)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,260 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Статусы заказа
+const (
+	OrderPending  = "pending"  // ждем оплату у провайдера
+	OrderPaid     = "paid"     // оплачен; клиент записан на курс, когда заполнен FulfilledAt
+	OrderCanceled = "canceled" // платеж отменен или не прошел
+	OrderRefunded = "refunded"
+)
+
+// Виды чеков
+const (
+	ReceiptPayment = "payment"
+	ReceiptRefund  = "refund"
+)
+
+// Order - покупка курса клиентом. Суммы в копейках.
+type Order struct {
+	ID              int        `gorm:"primaryKey" json:"id"`
+	UserID          int        `gorm:"index" json:"userId"`
+	CourseID        int        `gorm:"index" json:"courseId"`
+	Amount          int64      `json:"amount"`
+	Currency        string     `json:"currency"`
+	Status          string     `gorm:"index" json:"status"`
+	Provider        string     `json:"provider"`
+	PaymentID       string     `gorm:"index" json:"paymentId,omitempty"`
+	ConfirmationURL string     `json:"confirmationUrl,omitempty"` // страница оплаты, пока заказ не оплачен
+	RefundID        string     `json:"refundId,omitempty"`
+	RefundReason    string     `json:"refundReason,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+	PaidAt          *time.Time `json:"paidAt,omitempty"`
+	CanceledAt      *time.Time `json:"canceledAt,omitempty"`
+	RefundedAt      *time.Time `json:"refundedAt,omitempty"`
+	// FulfilledAt - клиент записан на курс или деньги возвращены. Оплаченный
+	// заказ без него дозаписывается при следующей сверке.
+	FulfilledAt *time.Time `gorm:"index" json:"fulfilledAt,omitempty"`
+}
+
+// Receipt - чек об оплате или возврате. Данные покупателя и курса
+// копируются на момент операции и потом не меняются.
+type Receipt struct {
+	ID          int       `gorm:"primaryKey" json:"id"`
+	OrderID     int       `gorm:"index" json:"orderId"`
+	UserID      int       `gorm:"index" json:"userId"`
+	Kind        string    `json:"kind"`
+	Amount      int64     `json:"amount"`
+	Currency    string    `json:"currency"`
+	Description string    `json:"description"`
+	BuyerName   string    `json:"buyerName"`
+	BuyerEmail  string    `json:"buyerEmail"`
+	Provider    string    `json:"provider"`
+	PaymentID   string    `json:"paymentId"` // у возврата - ID возврата
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Number - номер чека для покупателя
+func (r *Receipt) Number() string {
+	return fmt.Sprintf("SP-%06d", r.ID)
+}
+
+var db *gorm.DB
+
+func InitDB() (*gorm.DB, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
+		cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort)
+
+	for i := 0; i < 5; i++ {
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			time.Sleep(5 * time.Second)
+		} else {
+			break
+		}
+	}
+
+	if db == nil {
+		return nil, errors.New("failed to connect to database")
+	}
+
+	firstRun := !db.Migrator().HasColumn(&Order{}, "fulfilled_at")
+	err = db.AutoMigrate(&Order{}, &Receipt{})
+	if err != nil {
+		return nil, err
+	}
+	// Заказы до появления FulfilledAt записывали клиента сразу при оплате
+	if firstRun {
+		err = db.Exec("UPDATE orders SET fulfilled_at = paid_at WHERE paid_at IS NOT NULL AND fulfilled_at IS NULL").Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+func CreateOrder(order *Order) (*Order, error) {
+	result := db.Create(order)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return order, nil
+}
+
+func FindOrderByID(id int) (*Order, error) {
+	var order Order
+	result := db.Where("id = ?", id).First(&order)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &order, nil
+}
+
+// FindOrderByPaymentID находит заказ по платежу провайдера
+func FindOrderByPaymentID(provider string, paymentID string) (*Order, error) {
+	var order Order
+	result := db.Where("provider = ? AND payment_id = ?", provider, paymentID).First(&order)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &order, nil
+}
+
+// FindPendingOrder возвращает неоплаченный заказ клиента на курс или nil
+func FindPendingOrder(userID int, courseID int) (*Order, error) {
+	var order Order
+	result := db.Where("user_id = ? AND course_id = ? AND status = ?", userID, courseID, OrderPending).
+		Order("id DESC").First(&order)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &order, nil
+}
+
+// FindOrdersByUserID возвращает заказы клиента, новые первыми
+func FindOrdersByUserID(userID int) ([]Order, error) {
+	var orders []Order
+	result := db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&orders)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return orders, nil
+}
+
+// SetOrderPayment запоминает платеж, созданный у провайдера
+func SetOrderPayment(order *Order, paymentID string, confirmationURL string) error {
+	result := db.Model(&Order{}).Where("id = ?", order.ID).
+		Updates(map[string]interface{}{"payment_id": paymentID, "confirmation_url": confirmationURL})
+	if result.Error != nil {
+		return result.Error
+	}
+	order.PaymentID = paymentID
+	order.ConfirmationURL = confirmationURL
+	return nil
+}
+
+// TransitionOrder переводит заказ из статуса from в to. Возвращает false, если
+// заказ уже не в статусе from: так повторное уведомление провайдера не
+// запишет клиента и не выдаст чек второй раз.
+func TransitionOrder(order *Order, from string, to string, updates map[string]interface{}) (bool, error) {
+	fields := map[string]interface{}{"status": to, "updated_at": time.Now()}
+	for key, value := range updates {
+		fields[key] = value
+	}
+	result := db.Model(&Order{}).Where("id = ? AND status = ?", order.ID, from).Updates(fields)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	if err := db.First(order, order.ID).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MarkOrderFulfilled отмечает, что клиент записан на оплаченный курс
+func MarkOrderFulfilled(order *Order) error {
+	now := time.Now()
+	result := db.Model(&Order{}).Where("id = ? AND fulfilled_at IS NULL", order.ID).
+		Updates(map[string]interface{}{"fulfilled_at": now, "updated_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	order.FulfilledAt = &now
+	return nil
+}
+
+// FindUnfulfilledOrders возвращает оплаченные заказы, по которым клиента не
+// удалось записать на курс
+func FindUnfulfilledOrders(provider string) ([]Order, error) {
+	var orders []Order
+	result := db.Where("provider = ? AND status = ? AND fulfilled_at IS NULL", provider, OrderPaid).
+		Order("id").Find(&orders)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return orders, nil
+}
+
+// HasReceipt - по заказу уже выдан чек вида kind
+func HasReceipt(orderID int, kind string) (bool, error) {
+	var count int64
+	err := db.Model(&Receipt{}).Where("order_id = ? AND kind = ?", orderID, kind).Count(&count).Error
+	return count > 0, err
+}
+
+func CreateReceipt(receipt *Receipt) (*Receipt, error) {
+	result := db.Create(receipt)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return receipt, nil
+}
+
+func FindReceiptByID(id int) (*Receipt, error) {
+	var receipt Receipt
+	result := db.Where("id = ?", id).First(&receipt)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &receipt, nil
+}
+
+func FindReceiptsByOrderID(orderID int) ([]Receipt, error) {
+	var receipts []Receipt
+	result := db.Where("order_id = ?", orderID).Order("id").Find(&receipts)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return receipts, nil
+}
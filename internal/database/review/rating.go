@@ -0,0 +1,211 @@
+package review
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/database/course"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Рейтинг - байесовское среднее общей оценки: к отзывам добавляется
+// RatingPriorWeight воображаемых оценок RatingPrior, поэтому одна пятерка
+// не поднимает новый курс выше курса с сотней хороших отзывов.
+const (
+	RatingPrior       = 3.0
+	RatingPriorWeight = 5
+)
+
+// TrainerRating - рейтинг тренера по всем отзывам о нем
+type TrainerRating struct {
+	TrainerID    int       `gorm:"primaryKey;autoIncrement:false" json:"trainer_id"`
+	Rating       float64   `json:"rating"`
+	ReviewsCount int       `json:"reviews_count"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// BayesianAverage считает рейтинг по сумме и числу оценок, 0 без отзывов
+func BayesianAverage(total float64, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	rating := (RatingPrior*RatingPriorWeight + total) / float64(RatingPriorWeight+count)
+	return math.Round(rating*100) / 100
+}
+
+type ratingAggregate struct {
+	ID    int
+	Count int
+	Total float64
+}
+
+// migrateRatings создает таблицы отзывов и рейтингов. При первом запуске
+// привязывает старые отзывы к курсам и заменяет рейтинги, которые тренеры
+// вписывали в курсы сами, на посчитанные по отзывам.
+func migrateRatings(db *gorm.DB) error {
+	firstRun := !db.Migrator().HasTable(&TrainerRating{})
+	if err := db.AutoMigrate(&Review{}, &TrainerRating{}); err != nil {
+		return err
+	}
+	if !firstRun {
+		return nil
+	}
+
+	err := db.Exec("UPDATE reviews SET course_id = classes.course_id FROM classes " +
+		"WHERE classes.id = reviews.class_id AND reviews.course_id = 0").Error
+	if err != nil {
+		return err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return rebuildRatings(tx)
+	})
+	if err != nil {
+		return err
+	}
+	log.Println("review: course and trainer ratings rebuilt from reviews")
+	return nil
+}
+
+// migrateUniqueReviews заводит уникальные индексы: один отзыв клиента на
+// занятие и один отзыв без курса на тренера. Старые повторы не удаляются -
+// если они есть, индекс не создается, а новые повторы отсекает CreateReview.
+// При первом запуске рейтинги пересчитываются по клиентам.
+func migrateUniqueReviews(db *gorm.DB) error {
+	firstRun := !db.Migrator().HasIndex(&Review{}, "idx_reviews_client_class")
+	// Индекс на курс мешал оставлять отзывы о разных занятиях курса
+	if err := db.Exec("DROP INDEX IF EXISTS idx_reviews_client_course").Error; err != nil {
+		return err
+	}
+	indexes := []struct {
+		name    string
+		columns string
+		where   string
+	}{
+		{"idx_reviews_client_class", "client_id, class_id", "class_id <> 0"},
+		{"idx_reviews_client_trainer", "client_id, trainer_id", "course_id = 0"},
+	}
+	for _, index := range indexes {
+		if db.Migrator().HasIndex(&Review{}, index.name) {
+			continue
+		}
+		var duplicates int64
+		repeated := db.Model(&Review{}).Select(index.columns).Where(index.where).
+			Group(index.columns).Having("COUNT(*) > 1")
+		err := db.Table("(?) AS repeated", repeated).Count(&duplicates).Error
+		if err != nil {
+			return err
+		}
+		if duplicates > 0 {
+			log.Printf("review: %s not created, %d clients have repeated reviews", index.name, duplicates)
+			continue
+		}
+		err = db.Exec("CREATE UNIQUE INDEX " + index.name + " ON reviews (" + index.columns + ") WHERE " + index.where).Error
+		if err != nil {
+			return err
+		}
+	}
+	if !firstRun {
+		return nil
+	}
+	// Рейтинги теперь считаются по одному голосу на клиента
+	return db.Transaction(func(tx *gorm.DB) error {
+		return rebuildRatings(tx)
+	})
+}
+
+// perClient - средняя оценка каждого клиента по курсу или тренеру (column),
+// чтобы отзывы одного клиента о разных занятиях считались в рейтинге одним голосом
+func perClient(tx *gorm.DB, column string) *gorm.DB {
+	return tx.Model(&Review{}).
+		Select(column + " AS id, client_id, AVG(overall_rating) AS rating").
+		Group(column + ", client_id")
+}
+
+func aggregateRatings(tx *gorm.DB, reviews *gorm.DB, dest interface{}) error {
+	return tx.Table("(?) AS per_client", reviews).
+		Select("id, COUNT(*) AS count, COALESCE(SUM(rating), 0) AS total").
+		Group("id").Scan(dest).Error
+}
+
+// rebuildRatings пересчитывает рейтинги всех курсов и тренеров
+func rebuildRatings(tx *gorm.DB) error {
+	err := tx.Model(&course.Course{}).Where("1 = 1").
+		UpdateColumns(map[string]interface{}{"rating": 0, "reviews_count": 0}).Error
+	if err != nil {
+		return err
+	}
+	var courses []ratingAggregate
+	err = aggregateRatings(tx, perClient(tx, "course_id").Where("course_id <> 0"), &courses)
+	if err != nil {
+		return err
+	}
+	for _, agg := range courses {
+		if err := saveCourseRating(tx, agg.ID, agg); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Where("1 = 1").Delete(&TrainerRating{}).Error; err != nil {
+		return err
+	}
+	var trainers []ratingAggregate
+	err = aggregateRatings(tx, perClient(tx, "trainer_id").Where("trainer_id <> 0"), &trainers)
+	if err != nil {
+		return err
+	}
+	for _, agg := range trainers {
+		if err := saveTrainerRating(tx, agg.ID, agg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recomputeRatings пересчитывает рейтинг курса и тренера после изменения отзыва.
+// Число отзывов в рейтинге - число оценивших клиентов.
+func recomputeRatings(tx *gorm.DB, courseID int, trainerID int) error {
+	if courseID != 0 {
+		var agg ratingAggregate
+		err := aggregateRatings(tx, perClient(tx, "course_id").Where("course_id = ?", courseID), &agg)
+		if err != nil {
+			return err
+		}
+		if err := saveCourseRating(tx, courseID, agg); err != nil {
+			return err
+		}
+	}
+	if trainerID != 0 {
+		var agg ratingAggregate
+		err := aggregateRatings(tx, perClient(tx, "trainer_id").Where("trainer_id = ?", trainerID), &agg)
+		if err != nil {
+			return err
+		}
+		if err := saveTrainerRating(tx, trainerID, agg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveCourseRating не трогает updated_at: курс от новых отзывов не меняется
+func saveCourseRating(tx *gorm.DB, courseID int, agg ratingAggregate) error {
+	return tx.Model(&course.Course{}).Where("id = ?", courseID).UpdateColumns(map[string]interface{}{
+		"rating":        BayesianAverage(agg.Total, agg.Count),
+		"reviews_count": agg.Count,
+	}).Error
+}
+
+func saveTrainerRating(tx *gorm.DB, trainerID int, agg ratingAggregate) error {
+	rating := TrainerRating{
+		TrainerID:    trainerID,
+		Rating:       BayesianAverage(agg.Total, agg.Count),
+		ReviewsCount: agg.Count,
+		UpdatedAt:    time.Now(),
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "trainer_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rating", "reviews_count", "updated_at"}),
+	}).Create(&rating).Error
+}
@@ -14,8 +14,9 @@ import (
 type Review struct {
 	Id               int       `gorm:"primaryKey" json:"id"`
 	ClassID          int       `json:"class_id"`
+	CourseID         int       `gorm:"index;not null;default:0" json:"course_id"` // курс занятия, 0 - отзыв только о тренере
 	ClientID         int       `json:"client_id"`
-	TrainerID        int       `json:"trainer_id"`
+	TrainerID        int       `gorm:"index" json:"trainer_id"`
 	DifficultyRating int       `json:"difficulty_rating"`
 	WellBeingRating  int       `json:"well_being_rating"`
 	OverallRating    int       `json:"overall_rating"`
@@ -48,20 +49,42 @@ func InitDB() (*gorm.DB, error) {
 		return nil, errors.New("failed to connect to database")
 	}
 
-	err = db.AutoMigrate(&Review{})
-	if err != nil {
+	if err := migrateRatings(db); err != nil {
+		return nil, err
+	}
+	if err := migrateUniqueReviews(db); err != nil {
 		return nil, err
 	}
 
 	return db, nil
 }
 
-// CRUD функции для модели Review
+// CRUD функции для модели Review. Изменение отзыва пересчитывает рейтинги
+// курса и тренера в той же транзакции.
+
+// ErrReviewExists - клиент уже оставил отзыв о занятии или тренере
+var ErrReviewExists = errors.New("you have already reviewed this, update your review instead")
 
 func CreateReview(review *Review) (*Review, error) {
-	result := db.Create(review)
-	if result.Error != nil {
-		return nil, result.Error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		query := tx.Model(&Review{}).Where("client_id = ? AND class_id = ?", review.ClientID, review.ClassID)
+		if review.ClassID == 0 {
+			query = tx.Model(&Review{}).Where("client_id = ? AND course_id = 0 AND trainer_id = ?", review.ClientID, review.TrainerID)
+		}
+		if err := query.Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrReviewExists
+		}
+		if err := tx.Create(review).Error; err != nil {
+			return err
+		}
+		return recomputeRatings(tx, review.CourseID, review.TrainerID)
+	})
+	if err != nil {
+		return nil, err
 	}
 	return review, nil
 }
@@ -88,17 +111,24 @@ func GetReviewsByClassID(classID int) ([]Review, error) {
 }
 
 func UpdateReview(review *Review) error {
-	result := db.Save(review)
-	if result.Error != nil {
-		return result.Error
-	}
-	return nil
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(review).Error; err != nil {
+			return err
+		}
+		return recomputeRatings(tx, review.CourseID, review.TrainerID)
+	})
 }
 
 func DeleteReview(id int) error {
-	result := db.Delete(&Review{}, id)
-	if result.Error != nil {
-		return result.Error
-	}
-	return nil
+	return db.Transaction(func(tx *gorm.DB) error {
+		var review Review
+		result := tx.Where("id = ?", id).Limit(1).Find(&review)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if err := tx.Delete(&Review{}, id).Error; err != nil {
+			return err
+		}
+		return recomputeRatings(tx, review.CourseID, review.TrainerID)
+	})
 }
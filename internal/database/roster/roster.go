@@ -0,0 +1,324 @@
+package roster
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Статусы связи тренер-клиент
+const (
+	StatusActive = "active"
+	StatusEnded  = "ended"
+)
+
+// Статусы приглашений
+const (
+	InviteStatusPending  = "pending"
+	InviteStatusAccepted = "accepted"
+	InviteStatusDeclined = "declined"
+	InviteStatusRevoked  = "revoked"
+)
+
+var (
+	ErrInviteNotPending = errors.New("invite is no longer valid")
+	ErrAlreadyClient    = errors.New("you are already a client of this trainer")
+)
+
+// Relationship - клиент занимается у тренера. По активным связям решается,
+// кто из тренеров видит профиль, замеры, календарь и прогресс клиента.
+type Relationship struct {
+	ID        int        `gorm:"primaryKey" json:"id"`
+	TrainerID int        `gorm:"index" json:"trainerId"`
+	ClientID  int        `gorm:"index" json:"clientId"`
+	Status    string     `gorm:"index" json:"status"`
+	InviteID  int        `json:"inviteId,omitempty"` // 0 - связь перенесена из календаря и тренировок
+	StartedAt time.Time  `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+	EndedBy   int        `json:"endedBy,omitempty"`
+}
+
+// Invite - приглашение тренера. Личное (ClientID != 0) принимает или
+// отклоняет один клиент; по ссылке (ClientID == 0) может присоединиться
+// любой клиент, пока ссылка не отозвана и не истекла.
+type Invite struct {
+	ID          int        `gorm:"primaryKey" json:"id"`
+	TrainerID   int        `gorm:"index" json:"trainerId"`
+	ClientID    int        `gorm:"index" json:"clientId,omitempty"`
+	Code        string     `gorm:"uniqueIndex" json:"code"`
+	Message     string     `json:"message"`
+	Status      string     `json:"status"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	RespondedAt *time.Time `json:"respondedAt,omitempty"`
+}
+
+// Personal - приглашение адресовано конкретному клиенту
+func (i *Invite) Personal() bool {
+	return i.ClientID != 0
+}
+
+// Usable - приглашение еще можно принять
+func (i *Invite) Usable(now time.Time) bool {
+	return i.Status == InviteStatusPending && now.Before(i.ExpiresAt)
+}
+
+var db *gorm.DB
+
+func InitDB() (*gorm.DB, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
+		cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort)
+
+	for i := 0; i < 5; i++ {
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			time.Sleep(5 * time.Second)
+		} else {
+			break
+		}
+	}
+
+	if db == nil {
+		return nil, errors.New("failed to connect to database")
+	}
+
+	firstRun := !db.Migrator().HasTable(&Relationship{})
+	err = db.AutoMigrate(&Relationship{}, &Invite{})
+	if err != nil {
+		return nil, err
+	}
+	if firstRun {
+		if err := seedRelationships(db); err != nil {
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// seedRelationships переносит связи, которые раньше выводились из общих
+// занятий в календаре и тренировок, чтобы тренеры не потеряли доступ к клиентам
+func seedRelationships(db *gorm.DB) error {
+	var sources []string
+	if db.Migrator().HasTable("schedules") {
+		sources = append(sources, "SELECT coach_id, client_id FROM schedules WHERE client_id <> 0 AND NOT is_global")
+	}
+	if db.Migrator().HasTable("trains") {
+		sources = append(sources, "SELECT trainer_id, client_id FROM trains WHERE client_id <> 0 AND trainer_id <> client_id")
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO relationships (trainer_id, client_id, status, invite_id, started_at) " +
+		"SELECT DISTINCT p.trainer_id, p.client_id, ?, 0, NOW() FROM ("
+	for i, source := range sources {
+		if i > 0 {
+			query += " UNION "
+		}
+		query += source
+	}
+	// Только пары, где первая сторона действительно тренер
+	query += ") AS p(trainer_id, client_id) JOIN users u ON u.id = p.trainer_id AND u.role = 1"
+
+	result := db.Exec(query, StatusActive)
+	if result.Error != nil {
+		return result.Error
+	}
+	log.Printf("roster: %d trainer-client relationships created from schedules and trains", result.RowsAffected)
+	return nil
+}
+
+// HasClient - у тренера есть активная связь с клиентом
+func HasClient(trainerID int, clientID int) (bool, error) {
+	var count int64
+	err := db.Model(&Relationship{}).
+		Where("trainer_id = ? AND client_id = ? AND status = ?", trainerID, clientID, StatusActive).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// HasTrained - тренер ведет или раньше вел клиента
+func HasTrained(trainerID int, clientID int) (bool, error) {
+	var count int64
+	err := db.Model(&Relationship{}).
+		Where("trainer_id = ? AND client_id = ?", trainerID, clientID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// FindActiveRelationship возвращает активную связь или nil
+func FindActiveRelationship(trainerID int, clientID int) (*Relationship, error) {
+	var relationship Relationship
+	result := db.Where("trainer_id = ? AND client_id = ? AND status = ?", trainerID, clientID, StatusActive).
+		First(&relationship)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &relationship, nil
+}
+
+// FindClients возвращает активные связи тренера, давние клиенты первыми
+func FindClients(trainerID int) ([]Relationship, error) {
+	var relationships []Relationship
+	result := db.Where("trainer_id = ? AND status = ?", trainerID, StatusActive).
+		Order("started_at, id").Find(&relationships)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return relationships, nil
+}
+
+// FindTrainers возвращает активные связи клиента
+func FindTrainers(clientID int) ([]Relationship, error) {
+	var relationships []Relationship
+	result := db.Where("client_id = ? AND status = ?", clientID, StatusActive).
+		Order("started_at, id").Find(&relationships)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return relationships, nil
+}
+
+// EndRelationship завершает связь, endedBy - тренер или клиент
+func EndRelationship(relationship *Relationship, endedBy int) error {
+	now := time.Now()
+	result := db.Model(&Relationship{}).
+		Where("id = ? AND status = ?", relationship.ID, StatusActive).
+		Updates(map[string]interface{}{"status": StatusEnded, "ended_at": now, "ended_by": endedBy})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	relationship.Status = StatusEnded
+	relationship.EndedAt = &now
+	relationship.EndedBy = endedBy
+	return nil
+}
+
+func CreateInvite(invite *Invite) (*Invite, error) {
+	result := db.Create(invite)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return invite, nil
+}
+
+func FindInviteByCode(code string) (*Invite, error) {
+	var invite Invite
+	result := db.Where("code = ?", code).First(&invite)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &invite, nil
+}
+
+// FindInvitesByTrainerID возвращает приглашения тренера, новые первыми
+func FindInvitesByTrainerID(trainerID int) ([]Invite, error) {
+	var invites []Invite
+	result := db.Where("trainer_id = ?", trainerID).Order("created_at DESC, id DESC").Find(&invites)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return invites, nil
+}
+
+// FindPendingInvitesForClient возвращает действующие личные приглашения клиента
+func FindPendingInvitesForClient(clientID int) ([]Invite, error) {
+	var invites []Invite
+	result := db.Where("client_id = ? AND status = ? AND expires_at > ?", clientID, InviteStatusPending, time.Now()).
+		Order("created_at DESC, id DESC").Find(&invites)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return invites, nil
+}
+
+// AcceptInvite создает связь клиента с тренером. Личное приглашение после
+// этого закрывается, приглашение по ссылке остается действующим.
+func AcceptInvite(invite *Invite, clientID int) (*Relationship, error) {
+	relationship := &Relationship{
+		TrainerID: invite.TrainerID,
+		ClientID:  clientID,
+		Status:    StatusActive,
+		InviteID:  invite.ID,
+		StartedAt: time.Now(),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Блокируем приглашение: два одновременных принятия не создадут две связи
+		var current Invite
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, invite.ID).Error; err != nil {
+			return err
+		}
+		if !current.Usable(time.Now()) {
+			return ErrInviteNotPending
+		}
+
+		var count int64
+		err := tx.Model(&Relationship{}).
+			Where("trainer_id = ? AND client_id = ? AND status = ?", invite.TrainerID, clientID, StatusActive).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyClient
+		}
+		if err := tx.Create(relationship).Error; err != nil {
+			return err
+		}
+
+		if current.Personal() {
+			return respond(tx, invite, InviteStatusAccepted)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return relationship, nil
+}
+
+// DeclineInvite отклоняет личное приглашение
+func DeclineInvite(invite *Invite) error {
+	return respond(db, invite, InviteStatusDeclined)
+}
+
+// RevokeInvite отзывает приглашение тренером
+func RevokeInvite(invite *Invite) error {
+	return respond(db, invite, InviteStatusRevoked)
+}
+
+func respond(tx *gorm.DB, invite *Invite, status string) error {
+	now := time.Now()
+	result := tx.Model(&Invite{}).Where("id = ? AND status = ?", invite.ID, InviteStatusPending).
+		Updates(map[string]interface{}{"status": status, "responded_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInviteNotPending
+	}
+	invite.Status = status
+	invite.RespondedAt = &now
+	return nil
+}
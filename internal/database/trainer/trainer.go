@@ -0,0 +1,222 @@
+package trainer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+	"github.com/niazlv/sport-plus-LCT/internal/database/auth"
+	"github.com/niazlv/sport-plus-LCT/internal/database/course"
+	"github.com/niazlv/sport-plus-LCT/internal/database/review"
+	"github.com/niazlv/sport-plus-LCT/internal/database/roster"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Пакет строит каталог тренеров поверх таблиц users, reviews, courses и
+// relationships. Своих таблиц у пакета нет.
+
+// Сортировка каталога
+const (
+	SortRating     = "rating"
+	SortPopularity = "popularity"
+)
+
+// Filter - параметры поиска тренеров. Пустые поля не фильтруют.
+type Filter struct {
+	TrainerID      int    // один тренер, для публичного профиля
+	Gym            string // подстрока названия зала
+	Specialization string // подстрока специализации
+	Direction      string // направление хотя бы одного курса тренера
+	MinRating      *float64
+	MinPrice       *float64
+	MaxPrice       *float64
+	Sort           string // SortRating (по умолчанию) или SortPopularity
+	Offset         int
+	Limit          int
+}
+
+// Stats - агрегаты тренера: рейтинг из review.TrainerRating, средние оценки
+// по reviews.trainer_id, популярность - активные клиенты плюс участники его курсов
+type Stats struct {
+	TrainerID        int     `json:"-"`
+	Rating           float64 `json:"rating"` // байесовское среднее общей оценки, 0 без отзывов
+	DifficultyRating float64 `json:"difficultyRating"`
+	WellBeingRating  float64 `json:"wellBeingRating"`
+	ReviewsCount     int     `json:"reviewsCount"`
+	ClientsCount     int     `json:"clientsCount"`
+	Participants     int     `json:"participants"`
+	Popularity       int     `json:"popularity"`
+}
+
+// Trainer - тренер из каталога со статистикой и курсами
+type Trainer struct {
+	User    auth.User
+	Stats   Stats
+	Courses []course.Course // опубликованные, без занятий и уроков
+}
+
+var db *gorm.DB
+
+func InitDB() (*gorm.DB, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
+		cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort)
+
+	for i := 0; i < 5; i++ {
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			time.Sleep(5 * time.Second)
+		} else {
+			break
+		}
+	}
+
+	if db == nil {
+		return nil, errors.New("failed to connect to database")
+	}
+
+	return db, nil
+}
+
+// statsQuery - активные тренеры с агрегатами, по строке на тренера
+func statsQuery() *gorm.DB {
+	reviews := db.Model(&review.Review{}).
+		Select("trainer_id, AVG(difficulty_rating) AS difficulty_rating, AVG(well_being_rating) AS well_being_rating").
+		Group("trainer_id")
+	clients := db.Model(&roster.Relationship{}).
+		Select("trainer_id, COUNT(*) AS clients_count").
+		Where("status = ?", roster.StatusActive).
+		Group("trainer_id")
+	participants := db.Model(&course.Course{}).
+		Select("trainer_id, SUM(participants_count) AS participants").
+		Group("trainer_id")
+
+	return db.Table("users AS u").
+		Select("u.id AS trainer_id, "+
+			"COALESCE(tr.rating, 0) AS rating, "+
+			"COALESCE(r.difficulty_rating, 0) AS difficulty_rating, "+
+			"COALESCE(r.well_being_rating, 0) AS well_being_rating, "+
+			"COALESCE(tr.reviews_count, 0) AS reviews_count, "+
+			"COALESCE(cl.clients_count, 0) AS clients_count, "+
+			"COALESCE(p.participants, 0) AS participants, "+
+			"COALESCE(cl.clients_count, 0) + COALESCE(p.participants, 0) AS popularity").
+		Joins("LEFT JOIN trainer_ratings AS tr ON tr.trainer_id = u.id").
+		Joins("LEFT JOIN (?) AS r ON r.trainer_id = u.id", reviews).
+		Joins("LEFT JOIN (?) AS cl ON cl.trainer_id = u.id", clients).
+		Joins("LEFT JOIN (?) AS p ON p.trainer_id = u.id", participants).
+		Where("u.role = ? AND u.suspended_at IS NULL", auth.RoleTrainer)
+}
+
+// Search возвращает страницу тренеров и общее число найденных
+func Search(filter Filter) ([]Trainer, int64, error) {
+	query := statsQuery()
+	if filter.TrainerID != 0 {
+		query = query.Where("u.id = ?", filter.TrainerID)
+	}
+	if filter.Gym != "" {
+		query = query.Where("u.gym_name ILIKE ?", "%"+auth.EscapeLike(filter.Gym)+"%")
+	}
+	if filter.Specialization != "" {
+		query = query.Where("u.specialization ILIKE ?", "%"+auth.EscapeLike(filter.Specialization)+"%")
+	}
+	if filter.Direction != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM courses JOIN course_versions v "+
+			"ON v.course_id = courses.id AND v.version = courses.current_version "+
+			"WHERE courses.trainer_id = u.id AND courses.status = ? AND v.direction ILIKE ?)",
+			course.CoursePublished, "%"+auth.EscapeLike(filter.Direction)+"%")
+	}
+	if filter.MinRating != nil {
+		query = query.Where("COALESCE(tr.rating, 0) >= ?", *filter.MinRating)
+	}
+	if filter.MinPrice != nil {
+		query = query.Where("u.session_price >= ?", *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		query = query.Where("u.session_price <= ?", *filter.MaxPrice)
+	}
+
+	var total int64
+	if err := db.Table("(?) AS t", query).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	switch filter.Sort {
+	case SortPopularity:
+		query = query.Order("popularity DESC, rating DESC, u.id")
+	default:
+		query = query.Order("rating DESC, reviews_count DESC, u.id")
+	}
+	if filter.Limit > 0 {
+		query = query.Offset(filter.Offset).Limit(filter.Limit)
+	}
+
+	var stats []Stats
+	if err := query.Scan(&stats).Error; err != nil {
+		return nil, 0, err
+	}
+	if len(stats) == 0 {
+		return []Trainer{}, total, nil
+	}
+
+	trainers, err := load(stats)
+	if err != nil {
+		return nil, 0, err
+	}
+	return trainers, total, nil
+}
+
+// FindTrainer возвращает тренера из каталога или nil
+func FindTrainer(trainerID int) (*Trainer, error) {
+	trainers, _, err := Search(Filter{TrainerID: trainerID, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(trainers) == 0 {
+		return nil, nil
+	}
+	return &trainers[0], nil
+}
+
+// load подгружает профили и курсы тренеров, сохраняя порядок stats
+func load(stats []Stats) ([]Trainer, error) {
+	ids := make([]int, 0, len(stats))
+	for _, s := range stats {
+		ids = append(ids, s.TrainerID)
+	}
+
+	var users []auth.User
+	if err := db.Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	usersByID := make(map[int]auth.User, len(users))
+	for _, user := range users {
+		usersByID[user.Id] = user
+	}
+
+	// В профиле только курсы из каталога в опубликованном виде, черновики видит лишь сам тренер
+	var courses []course.Course
+	err := course.PublishedCourses(db).Where("trainer_id IN ?", ids).Order("id").Find(&courses).Error
+	if err != nil {
+		return nil, err
+	}
+	coursesByTrainer := make(map[int][]course.Course, len(ids))
+	for _, c := range courses {
+		coursesByTrainer[c.TrainerID] = append(coursesByTrainer[c.TrainerID], c)
+	}
+
+	trainers := make([]Trainer, 0, len(stats))
+	for _, s := range stats {
+		trainers = append(trainers, Trainer{
+			User:    usersByID[s.TrainerID],
+			Stats:   s,
+			Courses: coursesByTrainer[s.TrainerID],
+		})
+	}
+	return trainers, nil
+}
@@ -0,0 +1,72 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// File - загруженный пользователем файл. Запись нужна, чтобы знать владельца:
+// файлы попадают в выгрузку данных и удаляются вместе с аккаунтом.
+type File struct {
+	Id        int       `gorm:"primaryKey" json:"id"`
+	UserID    int       `gorm:"index" json:"user_id"`
+	Name      string    `json:"name"` // исходное имя файла
+	Path      string    `json:"-"`    // путь на диске
+	URL       string    `json:"url"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var db *gorm.DB
+
+func InitDB() (*gorm.DB, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
+		cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort)
+
+	for i := 0; i < 5; i++ {
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			time.Sleep(5 * time.Second)
+		} else {
+			break
+		}
+	}
+
+	if db == nil {
+		return nil, errors.New("failed to connect to database")
+	}
+
+	err = db.AutoMigrate(&File{})
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func CreateFile(file *File) (*File, error) {
+	result := db.Create(file)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return file, nil
+}
+
+func FindFilesByUserID(userID int) ([]File, error) {
+	var files []File
+	result := db.Where("user_id = ?", userID).Order("id").Find(&files)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return files, nil
+}
@@ -0,0 +1,142 @@
+package healthimport
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+)
+
+const appleTimeLayout = "2006-01-02 15:04:05 -0700"
+
+type appleMapping struct {
+	measurementType string
+	units           conversion
+}
+
+var appleLength = conversion{"cm": 1, "m": 100, "mm": 0.1, "in": 2.54, "ft": 30.48}
+
+// Типы записей Apple Health, у которых есть аналог в нашем реестре замеров
+var appleTypes = map[string]appleMapping{
+	"HKQuantityTypeIdentifierBodyMass":               {database.TypeWeight, conversion{"kg": 1, "g": 0.001, "lb": 0.45359237, "st": 6.35029318}},
+	"HKQuantityTypeIdentifierHeight":                 {database.TypeHeight, appleLength},
+	"HKQuantityTypeIdentifierDietaryWater":           {database.TypeWater, conversion{"L": 1, "mL": 0.001, "fl_oz_us": 0.0295735295625, "cup_us": 0.2365882365}},
+	"HKQuantityTypeIdentifierBodyFatPercentage":      {database.TypeBodyFat, conversion{"%": 100}}, // Apple хранит долю: 0.21 при единице "%"
+	"HKQuantityTypeIdentifierWaistCircumference":     {database.TypeWaist, appleLength},
+	"HKQuantityTypeIdentifierRestingHeartRate":       {database.TypeRestingHR, conversion{"count/min": 1}},
+	"HKQuantityTypeIdentifierStepCount":              {database.TypeSteps, conversion{"count": 1}},
+	"HKQuantityTypeIdentifierBloodPressureSystolic":  {database.TypeSystolicBP, conversion{"mmHg": 1}},
+	"HKQuantityTypeIdentifierBloodPressureDiastolic": {database.TypeDiastolicBP, conversion{"mmHg": 1}},
+}
+
+const appleSleep = "HKCategoryTypeIdentifierSleepAnalysis"
+
+// applePriority - приоритет источника суммируемых данных по sourceName:
+// часы носят весь день и ночь, телефон пропускает шаги без себя в кармане
+func applePriority(sourceName string) int {
+	switch {
+	case strings.Contains(sourceName, "Watch"):
+		return 3
+	case strings.Contains(sourceName, "iPhone"):
+		return 2
+	}
+	return 1
+}
+
+// Сном считаем только фазы сна, "в кровати" и "бодрствование" - нет
+var appleAsleep = map[string]bool{
+	"HKCategoryValueSleepAnalysisAsleep":            true,
+	"HKCategoryValueSleepAnalysisAsleepUnspecified": true,
+	"HKCategoryValueSleepAnalysisAsleepCore":        true,
+	"HKCategoryValueSleepAnalysisAsleepDeep":        true,
+	"HKCategoryValueSleepAnalysisAsleepREM":         true,
+}
+
+// parseAppleXML читает export.xml потоком: файл за несколько лет весит гигабайты
+func parseAppleXML(r io.Reader, c *collector) error {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	rootSeen := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid Apple Health export: %w", err)
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !rootSeen {
+			if element.Name.Local != "HealthData" {
+				return ErrUnknownFormat
+			}
+			rootSeen = true
+		}
+		if element.Name.Local == "Record" {
+			parseAppleRecord(element.Attr, c)
+		}
+	}
+	if !rootSeen {
+		return ErrUnknownFormat
+	}
+	return nil
+}
+
+func parseAppleRecord(attrs []xml.Attr, c *collector) {
+	values := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		values[attr.Name.Local] = attr.Value
+	}
+	recordType := values["type"]
+
+	if recordType == appleSleep {
+		start, errStart := time.Parse(appleTimeLayout, values["startDate"])
+		end, errEnd := time.Parse(appleTimeLayout, values["endDate"])
+		if errStart != nil || errEnd != nil || end.Before(start) {
+			c.report.Skip(recordType, ReasonInvalidValue, start)
+			return
+		}
+		if !appleAsleep[values["value"]] {
+			c.report.Skip(recordType+" "+strings.TrimPrefix(values["value"], "HKCategoryValueSleepAnalysis"), ReasonUnsupportedType, start)
+			return
+		}
+		// Сон относим ко дню пробуждения, как и сами приложения здоровья
+		record := Record{Type: database.TypeSleep, Value: end.Sub(start).Hours(), MeasuredAt: end}
+		c.addSummed(record, recordType, values["sourceName"], applePriority(values["sourceName"]))
+		return
+	}
+
+	mapping, ok := appleTypes[recordType]
+	if !ok {
+		c.report.Skip(recordType, ReasonUnsupportedType, time.Time{})
+		return
+	}
+	measuredAt, err := time.Parse(appleTimeLayout, values["startDate"])
+	if err != nil {
+		c.report.Skip(recordType, ReasonInvalidValue, time.Time{})
+		return
+	}
+	value, err := strconv.ParseFloat(values["value"], 64)
+	if err != nil {
+		c.report.Skip(recordType, ReasonInvalidValue, measuredAt)
+		return
+	}
+	value, ok = mapping.units.convert(value, values["unit"])
+	if !ok {
+		c.report.Skip(recordType+" ("+values["unit"]+")", ReasonUnknownUnit, measuredAt)
+		return
+	}
+	record := Record{Type: mapping.measurementType, Value: value, MeasuredAt: measuredAt}
+	if summedTypes[record.Type] {
+		c.addSummed(record, recordType, values["sourceName"], applePriority(values["sourceName"]))
+		return
+	}
+	c.add(record, recordType)
+}
@@ -0,0 +1,145 @@
+package healthimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+)
+
+// googleFile - файл из Takeout/Fit/All Data: точки одного источника данных
+type googleFile struct {
+	DataSource string        `json:"Data Source"`
+	DataPoints []googlePoint `json:"Data Points"`
+}
+
+type googlePoint struct {
+	DataTypeName   string `json:"dataTypeName"`
+	StartTimeNanos int64  `json:"startTimeNanos"`
+	EndTimeNanos   int64  `json:"endTimeNanos"`
+	FitValue       []struct {
+		Value struct {
+			FpVal  *float64 `json:"fpVal"`
+			IntVal *int64   `json:"intVal"`
+		} `json:"value"`
+	} `json:"fitValue"`
+}
+
+// value возвращает i-е значение точки: Google Fit пишет дробные в fpVal, целые в intVal
+func (p *googlePoint) value(i int) (float64, bool) {
+	if i >= len(p.FitValue) {
+		return 0, false
+	}
+	switch v := p.FitValue[i].Value; {
+	case v.FpVal != nil:
+		return *v.FpVal, true
+	case v.IntVal != nil:
+		return float64(*v.IntVal), true
+	}
+	return 0, false
+}
+
+// Типы данных Google Fit с одним значением и множитель до единиц хранения
+var googleTypes = map[string]struct {
+	measurementType string
+	factor          float64
+}{
+	"com.google.weight":                 {database.TypeWeight, 1},   // кг
+	"com.google.height":                 {database.TypeHeight, 100}, // м
+	"com.google.hydration":              {database.TypeWater, 1},    // л
+	"com.google.body.fat.percentage":    {database.TypeBodyFat, 1},  // %
+	"com.google.step_count.delta":       {database.TypeSteps, 1},
+	"com.google.heart_rate.resting":     {database.TypeRestingHR, 1},
+	"com.google.heart_rate.resting.bpm": {database.TypeRestingHR, 1},
+}
+
+// Типы данных суммируемых замеров: их берем из слитого потока
+var googleSummed = map[string]bool{
+	"com.google.step_count.delta": true,
+	"com.google.hydration":        true,
+	googleSleepSegment:            true,
+}
+
+const (
+	googleBloodPressure = "com.google.blood_pressure"
+	googleSleepSegment  = "com.google.sleep.segment"
+)
+
+// Типы сегментов сна: 1 - бодрствование, 3 - вне кровати, остальное - сон
+var googleAsleep = map[int]bool{2: true, 4: true, 5: true, 6: true}
+
+func parseGoogleJSON(r io.Reader, c *collector) error {
+	var file googleFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return fmt.Errorf("invalid Google Fit export: %w", err)
+	}
+	if file.DataSource == "" && file.DataPoints == nil {
+		return ErrUnknownFormat
+	}
+
+	// derived-источники - это слияние raw-источников того же файла. Обычные
+	// замеры берем из raw, а шаги, воду и сон - из слитого потока merge: в нем
+	// Google Fit уже убрал пересечения часов и телефона. raw остаются запасным
+	// вариантом на дни, где слитого потока нет.
+	derived := strings.HasPrefix(file.DataSource, "derived:")
+	merged := derived && strings.Contains(file.DataSource, "merge")
+	priority := 1
+	if merged {
+		priority = 2
+	}
+	add := func(record Record, sourceType string) {
+		if summedTypes[record.Type] {
+			c.addSummed(record, sourceType, file.DataSource, priority)
+			return
+		}
+		c.add(record, sourceType)
+	}
+	for i := range file.DataPoints {
+		point := &file.DataPoints[i]
+		start := time.Unix(0, point.StartTimeNanos).UTC()
+		end := time.Unix(0, point.EndTimeNanos).UTC()
+		if derived && !(merged && googleSummed[point.DataTypeName]) {
+			c.report.Skip(point.DataTypeName, ReasonDerivedSource, start)
+			continue
+		}
+
+		switch point.DataTypeName {
+		case googleBloodPressure:
+			systolic, okSystolic := point.value(0)
+			diastolic, okDiastolic := point.value(1)
+			if !okSystolic || !okDiastolic {
+				c.report.Skip(point.DataTypeName, ReasonInvalidValue, start)
+				continue
+			}
+			add(Record{Type: database.TypeSystolicBP, Value: systolic, MeasuredAt: start}, point.DataTypeName)
+			add(Record{Type: database.TypeDiastolicBP, Value: diastolic, MeasuredAt: start}, point.DataTypeName)
+		case googleSleepSegment:
+			segment, ok := point.value(0)
+			if !ok || end.Before(start) {
+				c.report.Skip(point.DataTypeName, ReasonInvalidValue, start)
+				continue
+			}
+			if !googleAsleep[int(segment)] {
+				c.report.Skip(fmt.Sprintf("%s %d", point.DataTypeName, int(segment)), ReasonUnsupportedType, start)
+				continue
+			}
+			add(Record{Type: database.TypeSleep, Value: end.Sub(start).Hours(), MeasuredAt: end}, point.DataTypeName)
+		default:
+			mapping, ok := googleTypes[point.DataTypeName]
+			if !ok {
+				c.report.Skip(point.DataTypeName, ReasonUnsupportedType, start)
+				continue
+			}
+			value, ok := point.value(0)
+			if !ok {
+				c.report.Skip(point.DataTypeName, ReasonInvalidValue, start)
+				continue
+			}
+			add(Record{Type: mapping.measurementType, Value: value * mapping.factor, MeasuredAt: start}, point.DataTypeName)
+		}
+	}
+	return nil
+}
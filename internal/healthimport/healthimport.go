@@ -0,0 +1,318 @@
+// Package healthimport разбирает выгрузки телефонных приложений здоровья -
+// Apple Health (export.xml в zip) и Google Fit из Google Takeout (JSON) - в
+// замеры наших типов. Значения сразу переводятся в единицы хранения типа,
+// все, что не удалось сопоставить, попадает в отчет о пропущенных записях.
+package healthimport
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+)
+
+// Источники выгрузок
+const (
+	SourceAppleHealth = "apple_health"
+	SourceGoogleFit   = "google_fit"
+)
+
+// Причины пропуска записей, которые выставляет разбор
+const (
+	ReasonUnsupportedType = "unsupported record type"
+	ReasonUnknownUnit     = "unknown unit"
+	ReasonInvalidValue    = "invalid value or date"
+	ReasonDerivedSource   = "derived data source, duplicates raw data"
+	// ReasonOtherSource - суммируемый замер за день уже взят из другого
+	// источника: часы и телефон считают одни и те же шаги и сон
+	ReasonOtherSource = "summed data from another source, would be counted twice"
+	// ReasonDuplicate - запись уже есть в выгрузке или была импортирована раньше
+	ReasonDuplicate = "duplicate"
+	// ReasonOutOfRange - значение вне допустимого диапазона типа замера
+	ReasonOutOfRange = "value out of range"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format, expected Apple Health export.xml (zip) or Google Takeout Fit JSON")
+
+// Record - замер из выгрузки: тип из реестра замеров и значение в его единицах хранения
+type Record struct {
+	Type       string
+	Value      float64
+	MeasuredAt time.Time
+}
+
+// Key совпадает у записей с одним типом, моментом и значением - так ловим
+// дубли внутри выгрузки и уже импортированные ранее замеры
+func (r Record) Key() string {
+	value := strconv.FormatFloat(math.Round(r.Value*1000)/1000, 'f', -1, 64)
+	return r.Type + "|" + strconv.FormatInt(r.MeasuredAt.Unix(), 10) + "|" + value
+}
+
+// SkippedGroup - записи одного вида из выгрузки, пропущенные по одной причине
+type SkippedGroup struct {
+	Record string     `json:"record"` // тип записи в выгрузке
+	Reason string     `json:"reason"`
+	Count  int        `json:"count"`
+	First  *time.Time `json:"first,omitempty"`
+	Last   *time.Time `json:"last,omitempty"`
+}
+
+// Report собирает пропущенные записи по группам: в выгрузке Apple Health их
+// бывают миллионы, построчно хранить незачем
+type Report struct {
+	groups map[[2]string]*SkippedGroup
+	total  int
+}
+
+func NewReport() *Report {
+	return &Report{groups: map[[2]string]*SkippedGroup{}}
+}
+
+// Skip записывает пропущенную запись, at может быть нулевым
+func (r *Report) Skip(record, reason string, at time.Time) {
+	r.total++
+	key := [2]string{record, reason}
+	group, ok := r.groups[key]
+	if !ok {
+		group = &SkippedGroup{Record: record, Reason: reason}
+		r.groups[key] = group
+	}
+	group.Count++
+	if at.IsZero() {
+		return
+	}
+	at = at.UTC()
+	if group.First == nil || at.Before(*group.First) {
+		first := at
+		group.First = &first
+	}
+	if group.Last == nil || at.After(*group.Last) {
+		last := at
+		group.Last = &last
+	}
+}
+
+// Total - сколько всего записей пропущено
+func (r *Report) Total() int {
+	return r.total
+}
+
+// Groups возвращает группы, самые крупные первыми
+func (r *Report) Groups() []SkippedGroup {
+	groups := make([]SkippedGroup, 0, len(r.groups))
+	for _, group := range r.groups {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Record+groups[i].Reason < groups[j].Record+groups[j].Reason
+	})
+	return groups
+}
+
+// ParseFile разбирает выгрузку: zip Apple Health или Google Takeout, отдельный
+// export.xml или отдельный JSON из Takeout/Fit/All Data. Возвращает источник
+// и замеры в порядке следования в файле (суммируемые - в конце), дубли
+// внутри выгрузки уже убраны.
+func ParseFile(filename string, report *Report) (string, []Record, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	head = bytes.TrimLeft(head[:n], "\xef\xbb\xbf \t\r\n")
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", nil, err
+	}
+
+	c := newCollector(report)
+	var source string
+	switch {
+	case bytes.HasPrefix(head, []byte("PK")):
+		source, err = parseZip(filename, c)
+	case bytes.HasPrefix(head, []byte("<")):
+		source, err = SourceAppleHealth, parseAppleXML(bufio.NewReader(file), c)
+	case bytes.HasPrefix(head, []byte("{")):
+		source, err = SourceGoogleFit, parseGoogleJSON(file, c)
+	default:
+		err = ErrUnknownFormat
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	c.finish()
+	return source, c.records, nil
+}
+
+func parseZip(filename string, c *collector) (string, error) {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+
+	for _, entry := range archive.File {
+		if path.Base(entry.Name) == "export.xml" {
+			return SourceAppleHealth, parseZipEntry(entry, func(r io.Reader) error {
+				return parseAppleXML(bufio.NewReader(r), c)
+			})
+		}
+	}
+
+	found := false
+	for _, entry := range archive.File {
+		if !isGoogleFitEntry(entry.Name) {
+			continue
+		}
+		found = true
+		if err := parseZipEntry(entry, func(r io.Reader) error { return parseGoogleJSON(r, c) }); err != nil {
+			return "", err
+		}
+	}
+	if !found {
+		return "", ErrUnknownFormat
+	}
+	return SourceGoogleFit, nil
+}
+
+// В Takeout нужные файлы лежат в Takeout/Fit/All Data (или "Все данные" в
+// русской локали), остальное - агрегаты и сессии, которые мы не импортируем
+func isGoogleFitEntry(name string) bool {
+	return strings.HasSuffix(name, ".json") && strings.Contains(name, "Fit/") &&
+		(strings.HasPrefix(path.Base(name), "raw_") || strings.HasPrefix(path.Base(name), "derived_"))
+}
+
+func parseZipEntry(entry *zip.File, parse func(io.Reader) error) error {
+	r, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return parse(r)
+}
+
+// Суммируемые типы: для них пересекающиеся интервалы разных источников
+// нельзя просто сложить, за каждый день берется один источник
+var summedTypes = map[string]bool{
+	database.TypeWater: true,
+	database.TypeSteps: true,
+	database.TypeSleep: true,
+}
+
+// collector копит замеры и отбрасывает повторы внутри выгрузки: одно и то же
+// значение часто пишут несколько источников (часы и телефон)
+type collector struct {
+	report  *Report
+	records []Record
+	seen    map[string]bool
+	// суммируемые замеры по типу и дню, источник выбирается в finish
+	summed map[[2]string]map[string]*sourceRecords
+}
+
+// sourceRecords - суммируемые замеры одного источника за день
+type sourceRecords struct {
+	priority int
+	records  []Record
+	types    []string // тип записи в выгрузке для отчета
+}
+
+func newCollector(report *Report) *collector {
+	return &collector{report: report, seen: map[string]bool{}, summed: map[[2]string]map[string]*sourceRecords{}}
+}
+
+func (c *collector) add(record Record, sourceType string) {
+	key := record.Key()
+	if c.seen[key] {
+		c.report.Skip(sourceType, ReasonDuplicate, record.MeasuredAt)
+		return
+	}
+	c.seen[key] = true
+	c.records = append(c.records, record)
+}
+
+// addSummed откладывает замер суммируемого типа до выбора источника.
+// Из источников за день побеждает тот, у кого выше priority, при равенстве -
+// у кого больше записей.
+func (c *collector) addSummed(record Record, sourceType string, source string, priority int) {
+	day := [2]string{record.Type, record.MeasuredAt.Format("2006-01-02")}
+	sources, ok := c.summed[day]
+	if !ok {
+		sources = map[string]*sourceRecords{}
+		c.summed[day] = sources
+	}
+	group, ok := sources[source]
+	if !ok {
+		group = &sourceRecords{priority: priority}
+		sources[source] = group
+	}
+	group.records = append(group.records, record)
+	group.types = append(group.types, sourceType)
+}
+
+// finish добавляет суммируемые замеры выбранных источников, остальные
+// попадают в отчет
+func (c *collector) finish() {
+	days := make([][2]string, 0, len(c.summed))
+	for day := range c.summed {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i][1] < days[j][1] || days[i][1] == days[j][1] && days[i][0] < days[j][0]
+	})
+
+	for _, day := range days {
+		sources := c.summed[day]
+		names := make([]string, 0, len(sources))
+		for name := range sources {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			a, b := sources[names[i]], sources[names[j]]
+			if a.priority != b.priority {
+				return a.priority > b.priority
+			}
+			if len(a.records) != len(b.records) {
+				return len(a.records) > len(b.records)
+			}
+			return names[i] < names[j]
+		})
+
+		for i, name := range names {
+			group := sources[name]
+			for j, record := range group.records {
+				if i == 0 {
+					c.add(record, group.types[j])
+				} else {
+					c.report.Skip(group.types[j], ReasonOtherSource, record.MeasuredAt)
+				}
+			}
+		}
+	}
+	c.summed = map[[2]string]map[string]*sourceRecords{}
+}
+
+// conversion - перевод единицы выгрузки в единицу хранения: value * factor
+type conversion map[string]float64
+
+func (c conversion) convert(value float64, unit string) (float64, bool) {
+	factor, ok := c[unit]
+	if !ok {
+		return 0, false
+	}
+	return value * factor, true
+}
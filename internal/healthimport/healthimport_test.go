@@ -0,0 +1,101 @@
+package healthimport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	database "github.com/niazlv/sport-plus-LCT/internal/database/auth"
+)
+
+func parseString(t *testing.T, name string, content string) ([]Record, *Report) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	report := NewReport()
+	_, records, err := ParseFile(filename, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records, report
+}
+
+func sumOf(records []Record, measurementType string) float64 {
+	total := 0.0
+	for _, record := range records {
+		if record.Type == measurementType {
+			total += record.Value
+		}
+	}
+	return total
+}
+
+// Часы и телефон пишут шаги пересекающимися интервалами с разными значениями
+func TestAppleOverlappingSources(t *testing.T) {
+	records, report := parseString(t, "export.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<HealthData locale="ru_RU">
+ <Record type="HKQuantityTypeIdentifierStepCount" sourceName="iPhone (Ivan)" unit="count" startDate="2024-05-01 10:00:00 +0300" endDate="2024-05-01 10:10:00 +0300" value="900"/>
+ <Record type="HKQuantityTypeIdentifierStepCount" sourceName="Apple Watch (Ivan)" unit="count" startDate="2024-05-01 10:02:00 +0300" endDate="2024-05-01 10:12:00 +0300" value="1000"/>
+ <Record type="HKQuantityTypeIdentifierStepCount" sourceName="Apple Watch (Ivan)" unit="count" startDate="2024-05-01 18:00:00 +0300" endDate="2024-05-01 18:10:00 +0300" value="500"/>
+ <Record type="HKQuantityTypeIdentifierStepCount" sourceName="iPhone (Ivan)" unit="count" startDate="2024-05-02 09:00:00 +0300" endDate="2024-05-02 09:10:00 +0300" value="700"/>
+ <Record type="HKCategoryTypeIdentifierSleepAnalysis" sourceName="iPhone (Ivan)" value="HKCategoryValueSleepAnalysisAsleepUnspecified" startDate="2024-05-01 00:00:00 +0300" endDate="2024-05-01 07:00:00 +0300"/>
+ <Record type="HKCategoryTypeIdentifierSleepAnalysis" sourceName="Apple Watch (Ivan)" value="HKCategoryValueSleepAnalysisAsleepCore" startDate="2024-05-01 00:30:00 +0300" endDate="2024-05-01 06:30:00 +0300"/>
+ <Record type="HKQuantityTypeIdentifierBodyMass" sourceName="Scale" unit="kg" startDate="2024-05-01 08:00:00 +0300" endDate="2024-05-01 08:00:00 +0300" value="80"/>
+</HealthData>`)
+
+	// 1 мая - только часы, 2 мая часов нет - телефон
+	if got := sumOf(records, database.TypeSteps); got != 2200 {
+		t.Errorf("steps = %v, want 2200", got)
+	}
+	if got := sumOf(records, database.TypeSleep); got != 6 {
+		t.Errorf("sleep = %v, want 6", got)
+	}
+	if got := sumOf(records, database.TypeWeight); got != 80 {
+		t.Errorf("weight = %v, want 80", got)
+	}
+	if report.Total() != 2 {
+		t.Errorf("skipped = %d, want 2: %+v", report.Total(), report.Groups())
+	}
+}
+
+// Слитый поток Google Fit уже без пересечений, raw-источники не добавляются к нему
+func TestGoogleMergedStream(t *testing.T) {
+	const raw = `{"Data Source": "raw:com.google.step_count.delta:com.xiaomi:watch", "Data Points": [
+  {"dataTypeName": "com.google.step_count.delta", "startTimeNanos": 1714550400000000000, "endTimeNanos": 1714551000000000000, "fitValue": [{"value": {"intVal": 1000}}]},
+  {"dataTypeName": "com.google.step_count.delta", "startTimeNanos": 1714640400000000000, "endTimeNanos": 1714641000000000000, "fitValue": [{"value": {"intVal": 300}}]}
+]}`
+	const phone = `{"Data Source": "raw:com.google.step_count.delta:com.google.android.gms:phone", "Data Points": [
+  {"dataTypeName": "com.google.step_count.delta", "startTimeNanos": 1714550500000000000, "endTimeNanos": 1714551100000000000, "fitValue": [{"value": {"intVal": 900}}]}
+]}`
+	const merged = `{"Data Source": "derived:com.google.step_count.delta:com.google.android.gms:merge_step_deltas", "Data Points": [
+  {"dataTypeName": "com.google.step_count.delta", "startTimeNanos": 1714550400000000000, "endTimeNanos": 1714551100000000000, "fitValue": [{"value": {"intVal": 1100}}]}
+]}`
+
+	c := newCollector(NewReport())
+	for _, content := range []string{raw, phone, merged} {
+		filename := filepath.Join(t.TempDir(), "fit.json")
+		if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = parseGoogleJSON(file, c)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.finish()
+
+	// 1 мая - слитый поток, 2 мая его нет - raw
+	if got := sumOf(c.records, database.TypeSteps); got != 1400 {
+		t.Errorf("steps = %v, want 1400", got)
+	}
+	if c.report.Total() != 2 {
+		t.Errorf("skipped = %d, want 2: %+v", c.report.Total(), c.report.Groups())
+	}
+}
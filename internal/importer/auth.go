@@ -1,6 +1,7 @@
 package importer
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,8 +15,13 @@ type AuthResponse struct {
 
 // Authenticate выполняет авторизацию и возвращает токен
 func Authenticate(apiBaseURL, login, password string) (string, error) {
-	authURL := fmt.Sprintf("%s/auth/signin?login=%s&password=%s", apiBaseURL, login, password)
-	resp, err := http.Get(authURL)
+	authURL := fmt.Sprintf("%s/auth/signin", apiBaseURL)
+	payload, err := json.Marshal(map[string]string{"login": login, "password": password})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Post(authURL, "application/json", bytes.NewBuffer(payload))
 	if err != nil {
 		return "", err
 	}
@@ -35,7 +35,13 @@ type Photo struct {
 	URL string `json:"url"`
 }
 
-func ImportExercisesFromJSON(filePath string, apiBaseURL string) error {
+// ImportExercisesFromJSON загружает упражнения от имени владельца apiKey.
+// Ключу нужны scope exercise:write и upload:write.
+func ImportExercisesFromJSON(filePath string, apiBaseURL string, apiKey string) error {
+	if apiKey == "" {
+		return errors.New("api key is required")
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
@@ -54,12 +60,6 @@ func ImportExercisesFromJSON(filePath string, apiBaseURL string) error {
 
 	imagesFolder := filepath.Dir(filePath)
 
-	// Аутентификация
-	token, err := Authenticate(apiBaseURL, "aboba", "passwd")
-	if err != nil {
-		return err
-	}
-
 	for _, exercise := range exercises {
 		// Убедитесь, что все обязательные поля заполнены
 		if exercise.OriginalUri == "" {
@@ -82,7 +82,7 @@ func ImportExercisesFromJSON(filePath string, apiBaseURL string) error {
 		var urls []string
 		for _, photo := range exercise.Photos {
 			photoPath := filepath.Join(imagesFolder, photo)
-			uploadedImageURL, err := uploadImage(apiBaseURL, photoPath, token)
+			uploadedImageURL, err := uploadImage(apiBaseURL, photoPath, apiKey)
 			if err != nil {
 				return err
 			}
@@ -94,7 +94,7 @@ func ImportExercisesFromJSON(filePath string, apiBaseURL string) error {
 		exercise.Photos = urls
 
 		// Создаем упражнение с загруженными фотографиями
-		_, err := createExercise(apiBaseURL, exercise, token)
+		_, err := createExercise(apiBaseURL, exercise, apiKey)
 		if err != nil {
 			return err
 		}
@@ -103,7 +103,7 @@ func ImportExercisesFromJSON(filePath string, apiBaseURL string) error {
 	return nil
 }
 
-func createExercise(apiBaseURL string, exercise Exercise, token string) (int, error) {
+func createExercise(apiBaseURL string, exercise Exercise, apiKey string) (int, error) {
 	exerciseURL := apiBaseURL + "/exercise"
 	exerciseData, err := json.Marshal(exercise)
 	if err != nil {
@@ -115,7 +115,7 @@ func createExercise(apiBaseURL string, exercise Exercise, token string) (int, er
 		return 0, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-API-Key", apiKey)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -140,7 +140,7 @@ func createExercise(apiBaseURL string, exercise Exercise, token string) (int, er
 	return createExerciseResponse.ID, nil
 }
 
-func uploadImage(apiBaseURL, imagePath, token string) (string, error) {
+func uploadImage(apiBaseURL, imagePath, apiKey string) (string, error) {
 	file, err := os.Open(imagePath)
 	if err != nil {
 		return "", err
@@ -165,7 +165,7 @@ func uploadImage(apiBaseURL, imagePath, token string) (string, error) {
 		return "", err
 	}
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-API-Key", apiKey)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -192,7 +192,7 @@ func uploadImage(apiBaseURL, imagePath, token string) (string, error) {
 	return uploadImageResponse.URL, nil
 }
 
-func addPhotoToExercise(apiBaseURL string, exerciseID int, photo Photo, token string) error {
+func addPhotoToExercise(apiBaseURL string, exerciseID int, photo Photo, apiKey string) error {
 	photoURL := apiBaseURL + "/exercise/" + strconv.Itoa(exerciseID) + "/photos"
 	photoData, err := json.Marshal(photo)
 	if err != nil {
@@ -204,7 +204,7 @@ func addPhotoToExercise(apiBaseURL string, exerciseID int, photo Photo, token st
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-API-Key", apiKey)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -0,0 +1,197 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Key - ключ подписи или проверки токенов. У ключей, оставленных только для
+// проверки (после ротации), Private пустой.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+// KeySet - набор ключей: одним подписываем, всеми активными проверяем.
+// Нужный ключ выбирается по заголовку kid токена.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewHMAC - набор из одного общего секрета HS256, только для dev.
+// Такой ключ в JWKS не публикуется.
+func NewHMAC(secret []byte) *KeySet {
+	key := &Key{ID: "hs256", Method: jwt.SigningMethodHS256, Private: secret, Public: secret}
+	return &KeySet{signing: key, keys: map[string]*Key{key.ID: key}}
+}
+
+// LoadDir читает ключи из файлов <kid>.pem в каталоге dir.
+// Файл с приватным ключом (RSA или Ed25519) может подписывать, файл с публичным
+// ключом - только проверять. Подписывает ключ signingKID, а если он не задан -
+// последний по имени приватный ключ, поэтому удобно называть файлы по дате.
+func LoadDir(dir string, signingKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	set := &KeySet{keys: map[string]*Key{}}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		set.keys[kid] = key
+
+		if key.Private != nil && (signingKID == "" || signingKID == kid) {
+			set.signing = key
+		}
+	}
+
+	if set.signing == nil {
+		if signingKID != "" {
+			return nil, fmt.Errorf("signing key %q not found in %s", signingKID, dir)
+		}
+		return nil, fmt.Errorf("no private keys found in %s", dir)
+	}
+	return set, nil
+}
+
+func parseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY", "RSA PRIVATE KEY":
+		var private interface{}
+		var err error
+		if block.Type == "RSA PRIVATE KEY" {
+			private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		} else {
+			private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch k := private.(type) {
+		case *rsa.PrivateKey:
+			return &Key{ID: kid, Method: jwt.SigningMethodRS256, Private: k, Public: &k.PublicKey}, nil
+		case ed25519.PrivateKey:
+			return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, Private: k, Public: k.Public()}, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", private)
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := public.(type) {
+		case *rsa.PublicKey:
+			return &Key{ID: kid, Method: jwt.SigningMethodRS256, Public: k}, nil
+		case ed25519.PublicKey:
+			return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, Public: k}, nil
+		}
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// SigningKeyID возвращает kid ключа, которым подписываются новые токены
+func (s *KeySet) SigningKeyID() string {
+	return s.signing.ID
+}
+
+// Sign подписывает claims текущим ключом и проставляет kid в заголовок
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.Private)
+}
+
+// Parse проверяет подпись токена ключом из его kid. Алгоритм токена должен
+// совпадать с алгоритмом ключа, иначе RS256-ключ можно подсунуть как HMAC-секрет.
+func (s *KeySet) Parse(tokenStr string) (*jwt.Token, error) {
+	return jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.Public, nil
+	})
+}
+
+// JWK - публичный ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS возвращает публичные части всех асимметричных ключей набора
+func (s *KeySet) JWKS() *JWKS {
+	ids := make([]string, 0, len(s.keys))
+	for kid := range s.keys {
+		ids = append(ids, kid)
+	}
+	sort.Strings(ids)
+
+	enc := base64.RawURLEncoding
+	out := &JWKS{Keys: []JWK{}}
+	for _, kid := range ids {
+		key := s.keys[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.Method.Alg()}
+		switch k := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = enc.EncodeToString(k.N.Bytes())
+			jwk.E = enc.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = enc.EncodeToString(k)
+		default:
+			continue
+		}
+		out.Keys = append(out.Keys, jwk)
+	}
+	return out
+}
@@ -0,0 +1,215 @@
+// Package metrics считает производные показатели здоровья по данным профиля:
+// индекс массы тела, основной обмен (Миффлин - Сан Жеор), суточный расход
+// энергии с учетом активности и пульсовые зоны по формуле Карвонена.
+package metrics
+
+import (
+	"math"
+	"strings"
+)
+
+// Уровни активности пользователя (User.ActivityLevel) и коэффициенты к BMR
+const (
+	ActivitySedentary  = "sedentary"   // сидячий образ жизни
+	ActivityLight      = "light"       // 1-3 тренировки в неделю
+	ActivityModerate   = "moderate"    // 3-5 тренировок в неделю
+	ActivityActive     = "active"      // 6-7 тренировок в неделю
+	ActivityVeryActive = "very_active" // тяжелые тренировки или физическая работа
+)
+
+var activityFactors = map[string]float64{
+	ActivitySedentary:  1.2,
+	ActivityLight:      1.375,
+	ActivityModerate:   1.55,
+	ActivityActive:     1.725,
+	ActivityVeryActive: 1.9,
+}
+
+// ValidActivityLevel проверяет уровень активности
+func ValidActivityLevel(level string) bool {
+	_, ok := activityFactors[level]
+	return ok
+}
+
+// Названия исходных данных для Result.Missing
+const (
+	FieldGender        = "gender"
+	FieldAge           = "age"
+	FieldHeight        = "height"
+	FieldWeight        = "weight"
+	FieldRestingHR     = "resting_hr"
+	FieldActivityLevel = "activityLevel"
+)
+
+// Input - данные для расчета. Нулевое значение означает, что данных нет.
+type Input struct {
+	Gender        string
+	Age           int
+	HeightCm      float64
+	WeightKg      float64
+	RestingHR     float64
+	ActivityLevel string
+}
+
+// Zone - пульсовая зона: доля резерва пульса и соответствующий пульс
+type Zone struct {
+	Zone         int    `json:"zone"`
+	Name         string `json:"name"`
+	MinIntensity int    `json:"minIntensity"` // % резерва пульса
+	MaxIntensity int    `json:"maxIntensity"`
+	MinHR        int    `json:"minHr"`
+	MaxHR        int    `json:"maxHr"`
+}
+
+// Result - посчитанные показатели. Поле пустое, если для него не хватает данных,
+// а чего именно не хватает - перечислено в Missing.
+type Result struct {
+	BMI         *float64 `json:"bmi,omitempty"`
+	BMICategory string   `json:"bmiCategory,omitempty"`
+	BMR         *float64 `json:"bmr,omitempty"`  // ккал в сутки
+	TDEE        *float64 `json:"tdee,omitempty"` // ккал в сутки
+	MaxHR       *float64 `json:"maxHr,omitempty"`
+	RestingHR   *float64 `json:"restingHr,omitempty"`
+	Zones       []Zone   `json:"zones,omitempty"`
+	Missing     []string `json:"missing,omitempty"`
+}
+
+// Compute считает все показатели, для которых хватает данных
+func Compute(in Input) Result {
+	var result Result
+	missing := map[string]bool{}
+
+	male, genderKnown := ParseGender(in.Gender)
+	if !genderKnown {
+		missing[FieldGender] = true
+	}
+	if in.Age <= 0 {
+		missing[FieldAge] = true
+	}
+	if in.HeightCm <= 0 {
+		missing[FieldHeight] = true
+	}
+	if in.WeightKg <= 0 {
+		missing[FieldWeight] = true
+	}
+	if in.RestingHR <= 0 {
+		missing[FieldRestingHR] = true
+	}
+	if !ValidActivityLevel(in.ActivityLevel) {
+		missing[FieldActivityLevel] = true
+	}
+
+	if !missing[FieldHeight] && !missing[FieldWeight] {
+		bmi := round(BMI(in.WeightKg, in.HeightCm))
+		result.BMI = &bmi
+		result.BMICategory = BMICategory(bmi)
+	}
+	if genderKnown && !missing[FieldAge] && !missing[FieldHeight] && !missing[FieldWeight] {
+		bmr := BMR(male, in.Age, in.HeightCm, in.WeightKg)
+		result.BMR = ptr(round(bmr))
+		if !missing[FieldActivityLevel] {
+			result.TDEE = ptr(round(TDEE(bmr, in.ActivityLevel)))
+		}
+	}
+	if !missing[FieldRestingHR] {
+		result.RestingHR = ptr(round(in.RestingHR))
+	}
+	if !missing[FieldAge] {
+		maxHR := MaxHR(in.Age)
+		result.MaxHR = &maxHR
+		if !missing[FieldRestingHR] && in.RestingHR < maxHR {
+			result.Zones = KarvonenZones(maxHR, in.RestingHR)
+		}
+	}
+
+	for _, field := range []string{FieldGender, FieldAge, FieldHeight, FieldWeight, FieldRestingHR, FieldActivityLevel} {
+		if missing[field] {
+			result.Missing = append(result.Missing, field)
+		}
+	}
+	return result
+}
+
+// ParseGender понимает пол в свободной форме, как его вводят в онбординге.
+// Второе значение false, если пол не удалось определить.
+func ParseGender(gender string) (male bool, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(gender)) {
+	case "male", "m", "man", "м", "муж", "мужской", "мужчина":
+		return true, true
+	case "female", "f", "woman", "ж", "жен", "женский", "женщина":
+		return false, true
+	}
+	return false, false
+}
+
+// BMI - индекс массы тела, кг/м²
+func BMI(weightKg float64, heightCm float64) float64 {
+	heightM := heightCm / 100
+	return weightKg / (heightM * heightM)
+}
+
+// BMICategory - категория ИМТ по классификации ВОЗ
+func BMICategory(bmi float64) string {
+	switch {
+	case bmi < 18.5:
+		return "underweight"
+	case bmi < 25:
+		return "normal"
+	case bmi < 30:
+		return "overweight"
+	default:
+		return "obese"
+	}
+}
+
+// BMR - основной обмен по формуле Миффлина - Сан Жеора, ккал в сутки
+func BMR(male bool, age int, heightCm float64, weightKg float64) float64 {
+	bmr := 10*weightKg + 6.25*heightCm - 5*float64(age)
+	if male {
+		return bmr + 5
+	}
+	return bmr - 161
+}
+
+// TDEE - суточный расход энергии: BMR с коэффициентом активности
+func TDEE(bmr float64, activityLevel string) float64 {
+	factor, ok := activityFactors[activityLevel]
+	if !ok {
+		factor = activityFactors[ActivitySedentary]
+	}
+	return bmr * factor
+}
+
+// MaxHR - максимальный пульс по возрасту (220 - возраст)
+func MaxHR(age int) float64 {
+	return float64(220 - age)
+}
+
+var zoneNames = []string{"recovery", "endurance", "aerobic", "threshold", "maximum"}
+
+// KarvonenZones - пять зон по 10% резерва пульса начиная с 50%:
+// пульс = покой + (максимум - покой) * интенсивность
+func KarvonenZones(maxHR float64, restingHR float64) []Zone {
+	reserve := maxHR - restingHR
+	zones := make([]Zone, 0, len(zoneNames))
+	for i, name := range zoneNames {
+		low, high := 50+10*i, 60+10*i
+		zones = append(zones, Zone{
+			Zone:         i + 1,
+			Name:         name,
+			MinIntensity: low,
+			MaxIntensity: high,
+			MinHR:        int(math.Round(restingHR + reserve*float64(low)/100)),
+			MaxHR:        int(math.Round(restingHR + reserve*float64(high)/100)),
+		})
+	}
+	return zones
+}
+
+func round(value float64) float64 {
+	return math.Round(value*10) / 10
+}
+
+func ptr(value float64) *float64 {
+	return &value
+}
@@ -0,0 +1,100 @@
+package payment
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// FakeProvider хранит платежи в памяти и ничего не списывает. Оплату
+// имитирует уведомление {"paymentId": "...", "status": "succeeded"} на
+// адрес вебхука: провайдер запоминает статус, как если бы клиент оплатил.
+// После перезапуска процесса платежи теряются. Вебхук такого провайдера
+// может подделать кто угодно, поэтому он разрешен только при APP_ENV=dev.
+type FakeProvider struct {
+	mu       sync.Mutex
+	payments map[string]*Payment
+	refunds  int
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{payments: map[string]*Payment{}}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) CreatePayment(req *CreateRequest) (*Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Повтор с тем же ключом возвращает тот же платеж, как у настоящих провайдеров
+	id := "fake-" + req.IdempotenceKey
+	if payment, ok := p.payments[id]; ok {
+		result := *payment
+		return &result, nil
+	}
+	payment := &Payment{
+		ID:              id,
+		Status:          StatusPending,
+		ConfirmationURL: withQuery(req.ReturnURL, "fake_payment", id),
+	}
+	p.payments[id] = payment
+	result := *payment
+	return &result, nil
+}
+
+func (p *FakeProvider) GetPayment(paymentID string) (*Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[paymentID]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	result := *payment
+	return &result, nil
+}
+
+func (p *FakeProvider) Refund(paymentID string, amount int64, currency string, idempotenceKey string) (*Refund, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[paymentID]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	if payment.Status != StatusSucceeded {
+		return nil, fmt.Errorf("payment %s is %s, only succeeded payments can be refunded", paymentID, payment.Status)
+	}
+	p.refunds++
+	return &Refund{ID: fmt.Sprintf("fake-refund-%d", p.refunds), Status: StatusSucceeded}, nil
+}
+
+type fakeNotification struct {
+	PaymentID string `json:"paymentId"`
+	Status    string `json:"status"`
+}
+
+func (p *FakeProvider) ParseWebhook(r *http.Request) (string, error) {
+	var notification fakeNotification
+	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+		return "", fmt.Errorf("invalid notification: %w", err)
+	}
+	if notification.Status != StatusSucceeded && notification.Status != StatusCanceled {
+		return "", fmt.Errorf("invalid notification status: %s", notification.Status)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	payment, ok := p.payments[notification.PaymentID]
+	if !ok {
+		return "", ErrPaymentNotFound
+	}
+	if payment.Status == StatusPending {
+		payment.Status = notification.Status
+	}
+	return payment.ID, nil
+}
@@ -0,0 +1,103 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/niazlv/sport-plus-LCT/internal/config"
+)
+
+// Статусы платежа у провайдера
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusCanceled  = "canceled"
+)
+
+// CurrencyRUB - валюта, в которой продаются курсы
+const CurrencyRUB = "RUB"
+
+var ErrPaymentNotFound = errors.New("payment not found")
+
+// CreateRequest - платеж за заказ. Amount в копейках.
+type CreateRequest struct {
+	OrderID     int
+	Amount      int64
+	Currency    string
+	Description string
+	ReturnURL   string // куда провайдер вернет клиента после оплаты
+	// IdempotenceKey защищает от двойного списания при повторе запроса
+	IdempotenceKey string
+}
+
+// Payment - платеж глазами провайдера
+type Payment struct {
+	ID              string
+	Status          string
+	ConfirmationURL string // страница оплаты, на нее перенаправляется клиент
+}
+
+// Refund - возврат платежа
+type Refund struct {
+	ID     string
+	Status string
+}
+
+// PaymentProvider принимает оплату. Реализации: YooKassaProvider и
+// FakeProvider (для разработки и тестов).
+type PaymentProvider interface {
+	Name() string
+	CreatePayment(req *CreateRequest) (*Payment, error)
+	GetPayment(paymentID string) (*Payment, error)
+	Refund(paymentID string, amount int64, currency string, idempotenceKey string) (*Refund, error)
+	// ParseWebhook возвращает ID платежа из уведомления. Статусу из
+	// уведомления верить нельзя, его перечитывают через GetPayment.
+	ParseWebhook(r *http.Request) (string, error)
+}
+
+// New выбирает реализацию по PAYMENT_PROVIDER: "yookassa" или "fake".
+// Fake ничего не списывает и разрешен только при APP_ENV=dev.
+func New(cfg *config.Config) (PaymentProvider, error) {
+	switch cfg.PaymentProvider {
+	case "yookassa":
+		apiURL := cfg.YooKassaAPIURL
+		if apiURL == "" {
+			apiURL = "https://api.yookassa.ru/v3"
+		}
+		return NewYooKassaProvider(apiURL, cfg.YooKassaShopID, cfg.YooKassaSecretKey), nil
+	case "fake", "":
+		if !cfg.IsDev() {
+			return nil, fmt.Errorf("APP_ENV=%s: PAYMENT_PROVIDER=fake is allowed only in dev, set PAYMENT_PROVIDER=yookassa", cfg.AppEnv)
+		}
+		return NewFakeProvider(), nil
+	}
+	return nil, fmt.Errorf("unknown PAYMENT_PROVIDER: %s", cfg.PaymentProvider)
+}
+
+// FormatAmount переводит копейки в строку вида 990.00
+func FormatAmount(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// ToMinor переводит сумму в рублях в копейки с округлением
+func ToMinor(amount float64) int64 {
+	if amount < 0 {
+		return -ToMinor(-amount)
+	}
+	return int64(amount*100 + 0.5)
+}
+
+// withQuery добавляет параметр к URL с учетом уже имеющейся строки запроса
+func withQuery(rawURL string, key string, value string) string {
+	if strings.Contains(rawURL, "?") {
+		return rawURL + "&" + key + "=" + value
+	}
+	return rawURL + "?" + key + "=" + value
+}
@@ -1,12 +1,15 @@
 package routes
 
 import (
+	"github.com/niazlv/sport-plus-LCT/internal/api/admin"
 	"github.com/niazlv/sport-plus-LCT/internal/api/auth"
 	"github.com/niazlv/sport-plus-LCT/internal/api/calendar"
 	"github.com/niazlv/sport-plus-LCT/internal/api/chat"
 	"github.com/niazlv/sport-plus-LCT/internal/api/course"
 	"github.com/niazlv/sport-plus-LCT/internal/api/exercise"
+	"github.com/niazlv/sport-plus-LCT/internal/api/payment"
 	"github.com/niazlv/sport-plus-LCT/internal/api/review"
+	"github.com/niazlv/sport-plus-LCT/internal/api/trainer"
 	"github.com/niazlv/sport-plus-LCT/internal/api/upload"
 	"github.com/niazlv/sport-plus-LCT/internal/api/user"
 	"github.com/niazlv/sport-plus-LCT/internal/api/webrtc"
@@ -19,6 +22,7 @@ func Setup(f *fizz.Fizz) {
 
 	// Настраиваем маршруты для авторизации и пользователей
 	auth.Setup(api)
+	auth.SetupWellKnown(f)
 	user.Setup(api)
 	course.Setup(api)
 	calendar.Setup(api)
@@ -27,4 +31,7 @@ func Setup(f *fizz.Fizz) {
 	webrtc.Setup(api)
 	exercise.Setup(api)
 	review.Setup(api)
+	trainer.Setup(api)
+	payment.Setup(api)
+	admin.Setup(api)
 }
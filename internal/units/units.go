@@ -0,0 +1,55 @@
+// Package units переводит замеры между метрической и имперской системами.
+// В базе значения всегда хранятся в метрических единицах, имперские - только
+// для отображения и ввода.
+package units
+
+const (
+	Metric   = "metric"
+	Imperial = "imperial"
+)
+
+// imperialOf - имперский аналог метрической единицы: imperial = metric * factor.
+// Единицы, которых здесь нет (%, bpm, mmHg, шаги...), в обеих системах одинаковы.
+var imperialOf = map[string]struct {
+	unit   string
+	factor float64
+}{
+	"cm": {"in", 1 / 2.54},
+	"kg": {"lb", 2.20462262185},
+	"l":  {"fl oz", 33.8140227018},
+	"km": {"mi", 0.621371192237},
+}
+
+// Valid проверяет название системы единиц
+func Valid(system string) bool {
+	return system == Metric || system == Imperial
+}
+
+// Unit возвращает единицу, в которой пользователь с системой system видит metricUnit
+func Unit(metricUnit string, system string) string {
+	if imperial, ok := imperialOf[metricUnit]; ok && system == Imperial {
+		return imperial.unit
+	}
+	return metricUnit
+}
+
+// FromMetric переводит значение из metricUnit в единицу системы system
+func FromMetric(value float64, metricUnit string, system string) float64 {
+	if imperial, ok := imperialOf[metricUnit]; ok && system == Imperial {
+		return value * imperial.factor
+	}
+	return value
+}
+
+// ToMetric переводит значение, введенное в unit, в metricUnit. Пустой unit
+// означает metricUnit. Возвращает false, если unit не metricUnit и не его
+// имперский аналог.
+func ToMetric(value float64, unit string, metricUnit string) (float64, bool) {
+	if unit == "" || unit == metricUnit {
+		return value, true
+	}
+	if imperial, ok := imperialOf[metricUnit]; ok && unit == imperial.unit {
+		return value / imperial.factor, true
+	}
+	return 0, false
+}